@@ -0,0 +1,96 @@
+package igcserver
+
+import (
+	"crypto/subtle"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+// basicCredentials is a username and password pair used for basic auth
+type basicCredentials struct {
+	username string
+	password string
+}
+
+// isReadOnly checks if the method of a request never modifies the state of the
+// server
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// validAPIKey checks if the key equals one of the keys, in constant time to
+// not leak the keys through timing
+func validAPIKey(keys []string, key string) bool {
+	valid := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// validBasicAuth checks if the username and password equal the credentials,
+// where both are always compared in constant time to not leak either of them
+// through timing
+func validBasicAuth(creds basicCredentials, username, password string) bool {
+	validUser := subtle.ConstantTimeCompare([]byte(creds.username), []byte(username))
+	validPass := subtle.ConstantTimeCompare([]byte(creds.password), []byte(password))
+	return validUser&validPass == 1
+}
+
+// authMiddleware requires requests to have an `Authorization: Bearer <key>`
+// header with one of the keys, or an `Authorization: Basic` header with the
+// basic credentials if they are given. Read-only requests are let through
+// unless lockReads is set, and shared tracks are always let through as their
+// token grants access. Requests without credentials are rejected with 401,
+// and requests with invalid credentials with 403.
+func authMiddleware(keys []string, basic *basicCredentials, lockReads bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !lockReads && isReadOnly(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if route := mux.CurrentRoute(r); route != nil && route.GetName() == sharedTrackRoute {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := newReqLogger(r)
+
+			auth := r.Header.Get("Authorization")
+			switch {
+			case len(keys) > 0 && strings.HasPrefix(auth, "Bearer "):
+				if !validAPIKey(keys, strings.TrimPrefix(auth, "Bearer ")) {
+					logger.Info("request has invalid api key")
+					writeJSONError(w, http.StatusForbidden, "invalid api key")
+					return
+				}
+			case basic != nil && strings.HasPrefix(auth, "Basic "):
+				username, password, ok := r.BasicAuth()
+				if !ok || !validBasicAuth(*basic, username, password) {
+					logger.Info("request has invalid basic credentials")
+					writeJSONError(w, http.StatusForbidden, "invalid credentials")
+					return
+				}
+			default:
+				logger.Info("request is missing credentials")
+				if len(keys) > 0 {
+					w.Header().Add("WWW-Authenticate", "Bearer")
+				}
+				if basic != nil {
+					w.Header().Add("WWW-Authenticate", `Basic realm="paragliding"`)
+				}
+				msg := "missing api key"
+				if basic != nil {
+					msg = "missing credentials"
+				}
+				writeJSONError(w, http.StatusUnauthorized, msg)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
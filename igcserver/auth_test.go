@@ -0,0 +1,131 @@
+package igcserver
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test that requests modifying the server require a valid api key
+func TestAuthWriteRequests(t *testing.T) {
+	server, fileserver := makeTestServers(WithAPIKeys("secret", "other"))
+	defer fileserver.Close()
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	for _, data := range []struct {
+		method string
+		uri    string
+		body   string
+		auth   string
+		code   int
+	}{
+		{"POST", "/track", body, "", 401},
+		{"POST", "/track", body, "Bearer wrong", 403},
+		{"POST", "/track", body, "secret", 401},
+		{"POST", "/track", body, "Bearer other", 200},
+		{"DELETE", "/track/1232", "", "", 401},
+		{"DELETE", "/track/1232", "", "Bearer secret", 404},
+		{"POST", "/webhook/new_track", "{}", "Bearer wrong", 403},
+		{"GET", "/track", "", "", 200},
+		{"GET", "/track", "", "Bearer wrong", 200},
+	} {
+		req := httptest.NewRequest(data.method, data.uri, strings.NewReader(data.body))
+		if data.auth != "" {
+			req.Header.Set("Authorization", data.auth)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `%s %s` with auth '%s' to return '%d', got '%d'", data.method, data.uri, data.auth, data.code, code)
+		}
+	}
+}
+
+// Test that reads require a valid api key when they are locked
+func TestAuthReadRequests(t *testing.T) {
+	server, fileserver := makeTestServers(WithAPIKeys("secret"), WithReadAuth(true))
+	defer fileserver.Close()
+
+	for auth, expt := range map[string]int{
+		"":              401,
+		"Bearer wrong":  403,
+		"Bearer secret": 200,
+	} {
+		req := httptest.NewRequest("GET", "/track", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != expt {
+			t.Errorf("expected `GET /track` with auth '%s' to return '%d', got '%d'", auth, expt, code)
+		}
+	}
+}
+
+// Test that requests modifying the server can be authorized using basic auth,
+// either alone or in addition to api keys
+func TestAuthBasic(t *testing.T) {
+	for _, data := range []struct {
+		opts     []Option
+		username string
+		password string
+		bearer   string
+		code     int
+	}{
+		{[]Option{WithBasicAuth("pilot", "glider")}, "pilot", "glider", "", 404},
+		{[]Option{WithBasicAuth("pilot", "glider")}, "pilot", "wrong", "", 403},
+		{[]Option{WithBasicAuth("pilot", "glider")}, "wrong", "glider", "", 403},
+		{[]Option{WithBasicAuth("pilot", "glider")}, "", "", "", 401},
+		{[]Option{WithBasicAuth("pilot", "glider")}, "", "", "secret", 401},
+		{[]Option{WithBasicAuth("pilot", "glider"), WithAPIKeys("secret")}, "pilot", "glider", "", 404},
+		{[]Option{WithBasicAuth("pilot", "glider"), WithAPIKeys("secret")}, "", "", "secret", 404},
+		{[]Option{WithBasicAuth("pilot", "glider"), WithAPIKeys("secret")}, "pilot", "secret", "", 403},
+		{[]Option{WithAPIKeys("secret")}, "pilot", "glider", "", 401},
+	} {
+		webhooks := NewWebhooksMap()
+		server := NewServer(nil, nil, nil, &webhooks, data.opts...)
+
+		req := httptest.NewRequest("DELETE", "/webhook/new_track/1232", nil)
+		if data.username != "" {
+			req.SetBasicAuth(data.username, data.password)
+		} else if data.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+data.bearer)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `%s` with credentials '%s:%s' and key '%s' to return '%d', got '%d'", req.Header.Get("Authorization"), data.username, data.password, data.bearer, data.code, code)
+		}
+		if data.code == 401 && len(res.Header()["Www-Authenticate"]) == 0 {
+			t.Errorf("expected 401 to have a `WWW-Authenticate` header")
+		}
+	}
+}
+
+// Test that basic credentials are only valid if both parts match
+func TestValidBasicAuth(t *testing.T) {
+	creds := basicCredentials{"pilot", "glider"}
+	for _, data := range []struct {
+		username string
+		password string
+		expt     bool
+	}{
+		{"pilot", "glider", true},
+		{"pilot", "", false},
+		{"", "glider", false},
+		{"pilot", "glider2", false},
+		{"glider", "pilot", false},
+	} {
+		if actual := validBasicAuth(creds, data.username, data.password); actual != data.expt {
+			t.Errorf("expected '%s:%s' to give '%t', got '%t'", data.username, data.password, data.expt, actual)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package igcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ClockSinkType decides the shape of the summaries posted to a clock sink
+type ClockSinkType string
+
+const (
+	// ClockSinkDiscord posts summaries as discord messages, ie. as text in
+	// the `content` field
+	ClockSinkDiscord ClockSinkType = "discord"
+	// ClockSinkSlack posts summaries as slack messages, ie. as text in the
+	// `text` field
+	ClockSinkSlack ClockSinkType = "slack"
+	// ClockSinkGeneric posts summaries as a ClockSummary
+	ClockSinkGeneric ClockSinkType = "generic"
+)
+
+// ClockSink is a webhook which the clock posts summaries of new tracks to
+type ClockSink struct {
+	Type ClockSinkType
+	URL  string
+}
+
+// ClockSummary is the summary of new tracks posted to generic clock sinks
+type ClockSummary struct {
+	Latest     time.Time `json:"latest"`
+	Tracks     []TrackID `json:"tracks"`
+	Processing int64     `json:"processing"`
+}
+
+// SlackMsg is a webhook message that can be sent to slack
+type SlackMsg struct {
+	Text string `json:"text"`
+}
+
+// clockSinkMsg formats the summary of the new tracks in the shape of the sink
+func clockSinkMsg(sinkType ClockSinkType, latest time.Time, ids []TrackID, processing time.Duration) (interface{}, error) {
+	switch sinkType {
+	case ClockSinkDiscord:
+		return NewDiscordMsg(latest, ids, processing), nil
+	case ClockSinkSlack:
+		return SlackMsg{NewDiscordMsg(latest, ids, processing).Content}, nil
+	case ClockSinkGeneric:
+		return ClockSummary{latest, ids, int64(processing / time.Millisecond)}, nil
+	default:
+		return nil, fmt.Errorf("unknown clock sink type '%s'", sinkType)
+	}
+}
+
+// Clock periodically checks if new tracks have been added since the last tick
+// and, if so, posts a summary of the new tracks to its sinks (eg. Discord)
+type Clock struct {
+	httpClient *http.Client
+	ticker     Ticker
+	sinks      []ClockSink
+	interval   time.Duration
+
+	// tickMutex serializes ticks, such that a manual tick running at the same
+	// time as a scheduled tick doesn't send the same summary twice
+	tickMutex sync.Mutex
+
+	mutex    sync.Mutex
+	last     *time.Time
+	lastTick *time.Time
+	nextTick time.Time
+	fired    int
+}
+
+// ClockState describes the state of a clock
+//
+// {
+// "last_tick": <time of the last tick, null before the first tick>,
+// "next_tick": <time of the next tick>,
+// "triggers_fired": <amount of summaries sent>,
+// "tracks_since_last": <amount of tracks added since the last summary>
+// }
+type ClockState struct {
+	LastTick        *time.Time `json:"last_tick"`
+	NextTick        time.Time  `json:"next_tick"`
+	TriggersFired   int        `json:"triggers_fired"`
+	TracksSinceLast int        `json:"tracks_since_last"`
+}
+
+// ClockTick is the result of a tick of a clock
+//
+// {
+// "fired": <whether a summary was sent to any sink>,
+// "new_tracks": <amount of tracks added since the last summary>
+// }
+type ClockTick struct {
+	Fired     bool `json:"fired"`
+	NewTracks int  `json:"new_tracks"`
+}
+
+// NewClock creates a new clock which notifies the discord webhook url about
+// new tracks reported by the ticker on the given interval
+func NewClock(httpClient *http.Client, ticker Ticker, webhookURL string, interval time.Duration) *Clock {
+	return NewClockWithSinks(httpClient, ticker, interval, ClockSink{ClockSinkDiscord, webhookURL})
+}
+
+// NewClockWithSinks creates a new clock which notifies all the sinks about
+// new tracks reported by the ticker on the given interval
+func NewClockWithSinks(httpClient *http.Client, ticker Ticker, interval time.Duration, sinks ...ClockSink) *Clock {
+	return &Clock{
+		httpClient: httpClient,
+		ticker:     ticker,
+		sinks:      sinks,
+		interval:   interval,
+	}
+}
+
+// Start starts ticking in a new goroutine until the context is cancelled.
+// Only tracks which are added after the clock is started will be reported.
+func (c *Clock) Start(ctx context.Context) {
+	c.mutex.Lock()
+	c.last = c.ticker.Latest()
+	c.nextTick = time.Now().Add(c.interval)
+	c.mutex.Unlock()
+
+	go func() {
+		timer := time.NewTicker(c.interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("stopping clock")
+				return
+			case now := <-timer.C:
+				c.mutex.Lock()
+				c.nextTick = now.Add(c.interval)
+				c.mutex.Unlock()
+
+				c.Tick()
+			}
+		}
+	}()
+}
+
+// Tick notifies the sinks if new tracks have been added since the last
+// summary, and returns whether a summary was sent to any of them along with
+// the amount of new tracks. Sinks which fail are skipped, such that they
+// don't prevent notifying the others. The clock ticks by itself once started,
+// but Tick can also be called to tick immediately, which is safe to do while
+// the clock is running.
+func (c *Clock) Tick() ClockTick {
+	c.tickMutex.Lock()
+	defer c.tickMutex.Unlock()
+
+	start := time.Now()
+
+	c.mutex.Lock()
+	c.lastTick = &start
+	last := c.last
+	c.mutex.Unlock()
+
+	latest := c.ticker.Latest()
+	if latest == nil || (last != nil && !latest.After(*last)) {
+		log.Debug("clock found no new tracks")
+		return ClockTick{}
+	}
+
+	after := time.Unix(0, 0)
+	if last != nil {
+		after = *last
+	}
+	report, err := c.ticker.GetReportAfter(after, 0)
+	if err != nil {
+		log.WithField("error", err).Warn("clock was unable to get report of new tracks")
+		return ClockTick{}
+	}
+	result := ClockTick{NewTracks: len(report.Tracks)}
+
+	processing := time.Since(start)
+
+	var wg sync.WaitGroup
+	delivered := make(chan bool, len(c.sinks))
+	for _, sink := range c.sinks {
+		wg.Add(1)
+		go func(sink ClockSink) {
+			defer wg.Done()
+			clocklog := log.WithFields(log.Fields{
+				"url":  sink.URL,
+				"type": sink.Type,
+			})
+			msg, err := clockSinkMsg(sink.Type, report.Latest, report.Tracks, processing)
+			if err != nil {
+				clocklog.WithField("error", err).Error("clock was unable to format summary")
+				return
+			}
+			clocklog.WithField("msg", msg).Info("clock sending summary of new tracks")
+			if err := sendWebhookMsg(c.httpClient, sink.URL, "", msg); err != nil {
+				clocklog.WithField("error", err).Warn("clock was unable to deliver summary")
+				return
+			}
+			delivered <- true
+		}(sink)
+	}
+	wg.Wait()
+	if len(delivered) == 0 {
+		return result
+	}
+
+	c.mutex.Lock()
+	c.last = latest
+	c.fired++
+	c.mutex.Unlock()
+
+	result.Fired = true
+	return result
+}
+
+// Fired returns the amount of times the clock has sent a summary
+func (c *Clock) Fired() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.fired
+}
+
+// State returns the current state of the clock, where the amount of tracks
+// added since the last summary is fetched from the ticker
+func (c *Clock) State() (ClockState, error) {
+	c.mutex.Lock()
+	state := ClockState{
+		LastTick:      c.lastTick,
+		NextTick:      c.nextTick,
+		TriggersFired: c.fired,
+	}
+	after := time.Unix(0, 0)
+	if c.last != nil {
+		after = *c.last
+	}
+	c.mutex.Unlock()
+
+	report, err := c.ticker.GetReportAfter(after, 0)
+	if err == ErrNoTracksFound {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	state.TracksSinceLast = len(report.Tracks)
+	return state, nil
+}
+
+// clockHandler responds with the state of the clock, or with no content if
+// the clock is disabled
+func (server *Server) clockHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get state of clock")
+
+	if server.clock == nil {
+		logger.Info("clock is disabled")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	state, err := server.clock.State()
+	if err != nil {
+		logger.WithField("error", err).Error("unable to get state of clock")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	logger.WithField("state", state).Info("responding with state of clock")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// adminClockTickHandler makes the clock tick immediately instead of waiting
+// for the next scheduled tick, eg. to test the sinks of the clock, and
+// responds with the result of the tick in the following structure
+//
+// ```json
+// {
+//   "fired": <true or false>,
+//   "new_tracks": <amount of new tracks>
+// }
+// ```
+func (server *Server) adminClockTickHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to tick clock")
+
+	if server.clock == nil {
+		logger.Info("clock is disabled")
+		writeJSONError(w, http.StatusNotFound, "clock is disabled")
+		return
+	}
+
+	result := server.clock.Tick()
+
+	logger.WithField("tick", result).Info("ticked clock manually")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,357 @@
+package igcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that the clock only sends a summary on ticks where tracks were added
+func TestClockNotifiesOnNewTracks(t *testing.T) {
+	received := make(chan DiscordMsg, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg DiscordMsg
+		json.NewDecoder(r.Body).Decode(&msg)
+		received <- msg
+	}))
+	defer receiver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+
+	clock := NewClock(receiver.Client(), &ticker, receiver.URL, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock.Start(ctx)
+
+	select {
+	case msg := <-received:
+		t.Fatalf("expected no summary without new tracks, got '%s'", msg.Content)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	meta := TrackMeta{
+		ID:        NewTrackID([]byte("clock")),
+		Timestamp: time.Now(),
+	}
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	ticker.Reporter(meta.Timestamp)
+
+	select {
+	case msg := <-received:
+		if msg.Content == "" {
+			t.Errorf("expected summary to have content")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a summary after adding a track")
+	}
+
+	select {
+	case msg := <-received:
+		t.Fatalf("expected no summary when nothing changed, got '%s'", msg.Content)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if fired := clock.Fired(); fired != 1 {
+		t.Errorf("expected clock to have fired once, got '%d'", fired)
+	}
+}
+
+// Test that the clock started by the server posts a summary to all its sinks
+// in their own shape, where a failing sink doesn't prevent notifying the others
+func TestClockNotifiesAllSinks(t *testing.T) {
+	received := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		msg := map[string]interface{}{"sink": r.URL.Path}
+		json.NewDecoder(r.Body).Decode(&msg)
+		received <- msg
+	}))
+	defer receiver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+
+	server := NewServer(receiver.Client(), &trackMetasMap, &ticker, nil, WithClockSinks(
+		10*time.Millisecond,
+		ClockSink{ClockSinkGeneric, receiver.URL + "/failing"},
+		ClockSink{ClockSinkDiscord, receiver.URL + "/discord"},
+		ClockSink{ClockSinkSlack, receiver.URL + "/slack"},
+	))
+	defer server.Close()
+
+	meta := TrackMeta{
+		ID:        NewTrackID([]byte("clock")),
+		Timestamp: time.Now(),
+	}
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	ticker.Reporter(meta.Timestamp)
+
+	msgs := make(map[string]map[string]interface{})
+	for len(msgs) < 2 {
+		select {
+		case msg := <-received:
+			msgs[msg["sink"].(string)] = msg
+		case <-time.After(time.Second):
+			t.Fatalf("expected both working sinks to receive a summary, got '%v'", msgs)
+		}
+	}
+	if content, ok := msgs["/discord"]["content"].(string); !ok || content == "" {
+		t.Errorf("expected discord sink to receive a summary as 'content', got '%v'", msgs["/discord"])
+	}
+	if text, ok := msgs["/slack"]["text"].(string); !ok || text == "" {
+		t.Errorf("expected slack sink to receive a summary as 'text', got '%v'", msgs["/slack"])
+	}
+	// The summary is counted once all sinks have been notified
+	deadline := time.Now().Add(time.Second)
+	for server.clock.Fired() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fired := server.clock.Fired(); fired != 1 {
+		t.Errorf("expected clock to have fired once, got '%d'", fired)
+	}
+}
+
+// Test that summaries are formatted in the shape of the sink
+func TestClockSinkMsg(t *testing.T) {
+	latest := time.Date(2018, time.October, 15, 12, 0, 0, 0, time.UTC)
+	ids := []TrackID{1, 2}
+
+	msg, err := clockSinkMsg(ClockSinkGeneric, latest, ids, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unable to format generic summary: %s", err)
+	}
+	if expt := (ClockSummary{latest, ids, 1500}); !cmp.Equal(msg, expt) {
+		t.Errorf("expected generic summary to be '%v', got '%v'", expt, msg)
+	}
+
+	discord, _ := clockSinkMsg(ClockSinkDiscord, latest, ids, time.Second)
+	slack, _ := clockSinkMsg(ClockSinkSlack, latest, ids, time.Second)
+	if discord.(DiscordMsg).Content != slack.(SlackMsg).Text {
+		t.Errorf("expected discord and slack summaries to have the same text, got '%v' and '%v'", discord, slack)
+	}
+
+	if _, err := clockSinkMsg("teams", latest, ids, time.Second); err == nil {
+		t.Errorf("expected unknown sink type to be rejected")
+	}
+}
+
+// Convenience function to get the state of the clock using GET /clock
+func getClockState(t *testing.T, server *Server) ClockState {
+	req := httptest.NewRequest("GET", "/clock", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `GET /clock` to return 200, got '%d'", code)
+	}
+	var state ClockState
+	if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	return state
+}
+
+// Test that GET /clock reports the state of a running clock
+func TestClockState(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A slow clock doesn't tick while tracks are added
+	slow := NewClock(receiver.Client(), &ticker, receiver.URL, time.Hour)
+	slow.Start(ctx)
+	fast := NewClock(receiver.Client(), &ticker, receiver.URL, 10*time.Millisecond)
+	fast.Start(ctx)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := trackMetasMap.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+		ticker.Reporter(meta.Timestamp)
+	}
+
+	server := NewServer(nil, &trackMetasMap, &ticker, nil, WithClock(slow))
+	state := getClockState(t, &server)
+	if state.LastTick != nil {
+		t.Errorf("expected no last tick before the clock has ticked, got '%s'", state.LastTick)
+	}
+	if !state.NextTick.After(time.Now()) {
+		t.Errorf("expected next tick to be in the future, got '%s'", state.NextTick)
+	}
+	if state.TriggersFired != 0 {
+		t.Errorf("expected clock to not have fired, got '%d'", state.TriggersFired)
+	}
+	if state.TracksSinceLast != len(testTrackMetas) {
+		t.Errorf("expected '%d' tracks since the clock started, got '%d'", len(testTrackMetas), state.TracksSinceLast)
+	}
+
+	// Poll until the fast clock has sent a summary of the tracks
+	server = NewServer(nil, &trackMetasMap, &ticker, nil, WithClock(fast))
+	deadline := time.Now().Add(time.Second)
+	for state = getClockState(t, &server); state.TriggersFired == 0; state = getClockState(t, &server) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected clock to fire after adding tracks, got state %+v", state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if state.TriggersFired != 1 {
+		t.Errorf("expected clock to have fired once, got '%d'", state.TriggersFired)
+	}
+	if state.TracksSinceLast != 0 {
+		t.Errorf("expected no tracks since the last summary, got '%d'", state.TracksSinceLast)
+	}
+	if state.LastTick == nil || !state.NextTick.After(*state.LastTick) {
+		t.Errorf("expected next tick to be after last tick, got state %+v", state)
+	}
+}
+
+// Test that GET /clock has no content when the clock is disabled
+func TestClockStateDisabled(t *testing.T) {
+	server := NewServer(nil, nil, nil, nil)
+
+	req := httptest.NewRequest("GET", "/clock", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 204 {
+		t.Errorf("expected `GET /clock` to return 204, got '%d'", code)
+	}
+}
+
+// Convenience function to tick the clock using POST /admin/clock/tick
+func postClockTick(t *testing.T, server *Server) ClockTick {
+	req := httptest.NewRequest("POST", "/admin/clock/tick", nil)
+	req.Header.Set("Authorization", "Bearer key")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `POST /admin/clock/tick` to return 200, got '%d'", code)
+	}
+	var result ClockTick
+	if err := json.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	return result
+}
+
+// Test that POST /admin/clock/tick makes the clock tick immediately, and that
+// concurrent ticks only send a summary of the same tracks once
+func TestClockManualTick(t *testing.T) {
+	received := make(chan ClockSummary, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary ClockSummary
+		json.NewDecoder(r.Body).Decode(&summary)
+		received <- summary
+	}))
+	defer receiver.Close()
+
+	// The clock never ticks by itself during the test
+	server, fileserver := makeTestServers(
+		WithAPIKeys("key"),
+		WithClockSinks(time.Hour, ClockSink{ClockSinkGeneric, receiver.URL}),
+	)
+	defer fileserver.Close()
+	defer server.Close()
+
+	req := httptest.NewRequest("POST", "/admin/clock/tick", nil)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if code := res.Result().StatusCode; code != 401 {
+		t.Errorf("expected manual tick without credentials to return 401, got '%d'", code)
+	}
+
+	if result := postClockTick(t, &server); result.Fired || result.NewTracks != 0 {
+		t.Errorf("expected manual tick without tracks to not fire, got '%v'", result)
+	}
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req = httptest.NewRequest("POST", "/track", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer key")
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	// The ticker is notified of the track in the background
+	deadline := time.Now().Add(time.Second)
+	for server.ticker.Latest() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if result := postClockTick(t, &server); !result.Fired || result.NewTracks != 1 {
+		t.Fatalf("expected manual tick to fire with one new track, got '%v'", result)
+	}
+	select {
+	case summary := <-received:
+		if !cmp.Equal(summary.Tracks, []TrackID{data["id"]}) {
+			t.Errorf("expected summary of track '%d', got '%v'", data["id"], summary.Tracks)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected sink to receive a summary")
+	}
+	if result := postClockTick(t, &server); result.Fired {
+		t.Errorf("expected manual tick without new tracks to not fire, got '%v'", result)
+	}
+
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	server.ticker.Reporter(meta.Timestamp)
+	deadline = time.Now().Add(time.Second)
+	for latest := server.ticker.Latest(); !latest.Equal(meta.Timestamp) && time.Now().Before(deadline); latest = server.ticker.Latest() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan ClockTick, 5)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- server.clock.Tick()
+		}()
+	}
+	wg.Wait()
+	close(results)
+	fired := 0
+	for result := range results {
+		if result.Fired {
+			fired++
+		}
+	}
+	if fired != 1 {
+		t.Errorf("expected one of the concurrent ticks to fire, got '%d'", fired)
+	}
+	if fired := server.clock.Fired(); fired != 2 {
+		t.Errorf("expected clock to have fired twice, got '%d'", fired)
+	}
+}
@@ -0,0 +1,76 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// TrackComparison is the difference between two tracks, where the
+// differences are the values of the first track minus those of the second
+type TrackComparison struct {
+	A            TrackID  `json:"a"`
+	B            TrackID  `json:"b"`
+	LengthDiff   float64  `json:"length_diff"`
+	DurationDiff int64    `json:"duration_diff"`
+	Longer       *TrackID `json:"longer"`
+}
+
+// compareTracks compares the length and duration of two tracks, where the
+// longer track is nil if the tracks are equally long
+func compareTracks(a, b TrackMeta) TrackComparison {
+	comparison := TrackComparison{
+		A:            a.ID,
+		B:            b.ID,
+		LengthDiff:   a.TrackLength - b.TrackLength,
+		DurationDiff: a.Duration - b.Duration,
+	}
+	if a.TrackLength > b.TrackLength {
+		comparison.Longer = &comparison.A
+	} else if b.TrackLength > a.TrackLength {
+		comparison.Longer = &comparison.B
+	}
+	return comparison
+}
+
+// trackCompareHandler compares the tracks with the ids given by the query
+// parameters `a` and `b`
+func (server *Server) trackCompareHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to compare tracks")
+
+	var metas [2]TrackMeta
+	for i, param := range []string{"a", "b"} {
+		idStr := r.URL.Query().Get(param)
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			logger.WithField(param, idStr).Info("id must be a valid number")
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid id '%s'", param))
+			return
+		}
+		idlog := logger.WithField(param, id)
+		metas[i], err = server.tracks.Get(TrackID(id))
+		if err == ErrTrackNotFound {
+			idlog.Info("unable to find metadata of id")
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("track '%s' not found", param))
+			return
+		} else if err != nil {
+			idlog.WithField("error", err).Info("error when getting metadata of id")
+			writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+			return
+		}
+	}
+
+	comparison := compareTracks(metas[0], metas[1])
+
+	logger.WithFields(log.Fields{
+		"a": comparison.A,
+		"b": comparison.B,
+	}).Info("responding with comparison of tracks")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
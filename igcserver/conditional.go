@@ -0,0 +1,91 @@
+package igcserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefersXML checks if the `Accept` header of the request prefers xml over
+// json, where json is preferred if both are equally acceptable
+func prefersXML(r *http.Request) bool {
+	var jsonQ, xmlQ float64
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/xml", "text/xml":
+			xmlQ = math.Max(xmlQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = math.Max(jsonQ, q)
+		}
+	}
+	return xmlQ > jsonQ
+}
+
+// newETag creates a strong entity tag of the content
+func newETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches checks if the entity tag matches any of the entity tags in the
+// `If-None-Match` header, using the weak comparison required by rfc 7232
+// 3.2
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedSince checks if the content was modified after the date in the
+// `If-Modified-Since` header. Dates in the future are accepted to tolerate
+// clients with clocks which are ahead of the server.
+func modifiedSince(ifModifiedSince string, lastModified time.Time) bool {
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return true
+	}
+	// Http dates only have a precision of seconds
+	return lastModified.Truncate(time.Second).After(since)
+}
+
+// notModified sets the entity tag and the time of the last modification of
+// the response, and responds with 304 (not modified) if the client already
+// has the same version of the content. The `If-Modified-Since` header is only
+// used if there is no `If-None-Match` header (rfc 7232 3.3). The caller should
+// not write anything more to the response if this returns true.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	} else if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		if !modifiedSince(ifModifiedSince, lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
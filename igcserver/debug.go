@@ -0,0 +1,97 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// DebugState is a dump of the internal state of the server, which leaves out
+// secrets such as api keys and the paths of webhook urls
+type DebugState struct {
+	Tracks         int            `json:"tracks"`
+	PointsCached   int            `json:"points_cached"`
+	RawsStored     int            `json:"raws_stored"`
+	PendingRetries int            `json:"pending_retries"`
+	StreamClients  int            `json:"stream_clients"`
+	APIKeys        int            `json:"api_keys"`
+	Webhooks       []DebugWebhook `json:"webhooks"`
+	Clock          *ClockState    `json:"clock"`
+}
+
+// DebugWebhook describes a registered webhook, where only the host of the url
+// is included as the rest of it may contain a token (eg. Discord webhooks)
+type DebugWebhook struct {
+	ID          WebhookID `json:"id"`
+	Host        string    `json:"host"`
+	TriggerRate uint      `json:"min_trigger_value"`
+}
+
+// WithDebugState enables `GET /debug/state` which responds with a dump of the
+// internal state of the server. This is disabled by default, in which case the
+// route doesn't exist.
+func WithDebugState(enabled bool) Option {
+	return func(srv *Server) {
+		srv.debugState = enabled
+	}
+}
+
+// debugStateHandler responds with a dump of the internal state of the server
+func (server *Server) debugStateHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get debug state")
+
+	state := DebugState{
+		StreamClients: server.hub.Len(),
+		APIKeys:       len(server.apiKeys),
+		Webhooks:      []DebugWebhook{},
+	}
+	if server.tracks != nil {
+		n, err := server.tracks.Len()
+		if err != nil {
+			logger.WithField("error", err).Error("unable to get amount of tracks")
+			writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+			return
+		}
+		state.Tracks = n
+	}
+	if server.points != nil {
+		state.PointsCached = server.points.Len()
+	}
+	if server.raws != nil {
+		state.RawsStored = server.raws.Len()
+	}
+	if server.retryQueue != nil {
+		state.PendingRetries = server.retryQueue.Len()
+	}
+	if server.webhooks != nil {
+		webhooks, err := server.webhooks.GetAll()
+		if err != nil {
+			logger.WithField("error", err).Error("unable to get all webhooks")
+			writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+			return
+		}
+		for _, webhook := range webhooks {
+			var host string
+			if webhookURL, err := url.Parse(webhook.URLstr); err == nil {
+				host = webhookURL.Host
+			}
+			state.Webhooks = append(state.Webhooks, DebugWebhook{webhook.ID, host, webhook.TriggerRate})
+		}
+	}
+	if server.clock != nil {
+		clockState, err := server.clock.State()
+		if err != nil {
+			logger.WithField("error", err).Error("unable to get state of clock")
+			writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+			return
+		}
+		state.Clock = &clockState
+	}
+
+	logger.WithField("state", state).Info("responding with debug state")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,80 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test that GET /debug/state dumps the state without secrets when enabled
+func TestDebugState(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	webhooks := NewWebhooksMap()
+	server := NewServer(nil, &trackMetasMap, nil, &webhooks,
+		WithDebugState(true), WithAPIKeys("hunter2"), WithRawTrackStorage(true))
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := trackMetasMap.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	server.raws.Set(testTrackMetas[0].ID, []byte("igc"))
+	webhook := WebhookInfo{
+		ID:          NewWebhookID([]byte("debug")),
+		URLstr:      "https://discordapp.com/api/webhooks/1234/token-which-is-secret",
+		TriggerRate: 2,
+	}
+	if err := webhooks.Append(webhook); err != nil {
+		t.Fatalf("unable to add webhook: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", "/debug/state", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `GET /debug/state` to return 200, got '%d'", code)
+	}
+	for _, secret := range []string{"hunter2", "token-which-is-secret", "1234"} {
+		if strings.Contains(res.Body.String(), secret) {
+			t.Errorf("expected debug state to not contain '%s', got '%s'", secret, res.Body)
+		}
+	}
+
+	var state DebugState
+	if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	expt := DebugState{
+		Tracks:     len(testTrackMetas),
+		RawsStored: 1,
+		APIKeys:    1,
+		Webhooks:   []DebugWebhook{{webhook.ID, "discordapp.com", 2}},
+	}
+	if !cmp.Equal(state, expt) {
+		t.Errorf("unexpected debug state: %s", cmp.Diff(expt, state))
+	}
+}
+
+// Test that GET /debug/state doesn't exist when disabled (default)
+func TestDebugStateDisabled(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithDebugState(false)}} {
+		server := NewServer(nil, nil, nil, nil, opts...)
+
+		for _, method := range []string{"GET", "OPTIONS"} {
+			req := httptest.NewRequest(method, "/debug/state", nil)
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			if code := res.Result().StatusCode; code != 404 {
+				t.Errorf("expected `%s /debug/state` to return 404, got '%d'", method, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package igcserver
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gzipResponseWriter buffers the response until it reaches the threshold,
+// after which the rest of the response is compressed. Responses which never
+// reach the threshold are written uncompressed when closed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	threshold int
+	status    int
+	buf       []byte
+	gz        *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	gw.status = status
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) < gw.threshold || gw.Header().Get("Content-Encoding") != "" {
+		return len(b), nil
+	}
+	if err := gw.start(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// start writes the header and starts compressing the response, beginning with
+// the buffered part of it
+func (gw *gzipResponseWriter) start() error {
+	header := gw.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	if _, err := gw.gz.Write(gw.buf); err != nil {
+		return err
+	}
+	gw.buf = nil
+	return nil
+}
+
+// Flush sends the compressed response so far to the client. Streamed responses
+// are compressed even if they haven't reached the threshold yet, as they can't
+// be buffered until they are done.
+func (gw *gzipResponseWriter) Flush() {
+	// Responses which are already encoded by the handler are passed through
+	// as is when closed
+	if gw.Header().Get("Content-Encoding") != "" && gw.gz == nil {
+		return
+	}
+	if gw.gz == nil {
+		if err := gw.start(); err != nil {
+			return
+		}
+	}
+	gw.gz.Flush()
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close flushes the response, and has to be called when the handler is done
+func (gw *gzipResponseWriter) Close() error {
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+	_, err := gw.ResponseWriter.Write(gw.buf)
+	return err
+}
+
+// writeGzip writes the content compressed with gzip
+func writeGzip(w io.Writer, content []byte) error {
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(content); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// acceptsGzip checks if the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipMiddleware compresses responses of at least `threshold` bytes if the
+// client accepts it
+func gzipMiddleware(threshold int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgraded connections, eg. websockets, can't be compressed
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, threshold: threshold, status: http.StatusOK}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,36 @@
+package igcserver
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headResponseWriter discards the body of a response while counting its
+// length, such that the headers of a response can be sent without the body
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (hw *headResponseWriter) WriteHeader(status int) {
+	hw.status = status
+}
+
+func (hw *headResponseWriter) Write(b []byte) (int, error) {
+	hw.length += len(b)
+	return len(b), nil
+}
+
+// headHandler responds to HEAD requests with the same headers and status as
+// the GET handler would respond with, but without the body
+func headHandler(get http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hw := &headResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		get(hw, r)
+		if hw.Header().Get("Content-Length") == "" {
+			hw.Header().Set("Content-Length", strconv.Itoa(hw.length))
+		}
+		w.WriteHeader(hw.status)
+	}
+}
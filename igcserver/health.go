@@ -0,0 +1,32 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HealthHandler responds with `{"status":"ok"}` as long as the process is able
+// to handle requests, without checking any backends
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+// ReadyHandler creates a handler which responds with `{"status":"ok"}` if all
+// the checks succeed, eg. pinging the databases used by the server. If any
+// check fails the handler responds with 503 (service unavailable).
+func ReadyHandler(checks ...func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range checks {
+			if err := check(); err != nil {
+				logger := newReqLogger(r)
+				logger.WithField("error", err).Warn("readiness check failed")
+				writeJSONError(w, http.StatusServiceUnavailable, "service unavailable")
+				return
+			}
+		}
+		HealthHandler(w, r)
+	})
+}
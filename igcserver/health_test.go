@@ -0,0 +1,49 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test that the health check always responds with ok
+func TestHealthHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/health", nil)
+	res := httptest.NewRecorder()
+
+	HealthHandler(res, req)
+
+	var data map[string]string
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if code := res.Result().StatusCode; code != 200 || data["status"] != "ok" {
+		t.Errorf("expected health check to be ok, got '%d' with status '%s'", code, data["status"])
+	}
+}
+
+// Test that the readiness check fails if any of the checks fails
+func TestReadyHandler(t *testing.T) {
+	ok := func() error { return nil }
+	fail := func() error { return errors.New("unreachable") }
+
+	for _, data := range []struct {
+		checks []func() error
+		code   int
+	}{
+		{nil, 200},
+		{[]func() error{ok}, 200},
+		{[]func() error{ok, fail}, 503},
+	} {
+		req := httptest.NewRequest("GET", "/ready", nil)
+		res := httptest.NewRecorder()
+
+		ReadyHandler(data.checks...).ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected readiness check to return '%d', got '%d'", data.code, code)
+		}
+	}
+}
@@ -1,68 +1,666 @@
 package igcserver
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/barskern/paragliding/isodur"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// Version is the version of the api reported in the metadata. It can be
+// changed at build time using
+// `-ldflags "-X github.com/barskern/paragliding/igcserver.Version=<version>"`.
+var Version = "v1"
+
 // Server distributes request to a pool of worker gorutines
 type Server struct {
 	startupTime time.Time
 	httpClient  *http.Client
 	router      *mux.Router
+	handler     http.Handler
 	ticker      Ticker
 	tracks      TrackMetas
 	webhooks    Webhooks
+	allowClear  bool
+	debugState  bool
+	clock       *Clock
+	shareSecret []byte
+	clockSinks  []ClockSink
+	clockEvery  time.Duration
+	stopClock   context.CancelFunc
+	raws        *rawTracks
+	points      *trackPoints
+	hub         *trackHub
+	publisher   TrackPublisher
+	readOnly    *int32
+	retryQueue  *retryQueue
+	logger      *log.Logger
+
+	tickerPageSize   int
+	maxTrackSize     int64
+	maxBodySize      int64
+	maxRedirects     int
+	checkContentType bool
+	fetchTimeout     time.Duration
+	proxyURL         string
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	idleTimeout      time.Duration
+	parseTimeout     time.Duration
+	maxPoints        int
+	fetchSlots       chan struct{}
+	fetchSlotWait    time.Duration
+	allowedOrigins   []string
+	corsCredentials  bool
+	corsMaxAge       time.Duration
+	allowedHosts     []string
+	blockPrivate     bool
+	gzipThreshold    int
+	apiKeys          []string
+	basicAuth        *basicCredentials
+	lockReads        bool
+	dedupContent     bool
+	duplicatePolicy  DuplicatePolicy
+	duplicateWindow  time.Duration
+	trackIDSeed      string
+	prefix           string
+	publicURL        string
+	fetchRetries     int
+	retryBackoff     time.Duration
+	earliestDate     time.Time
+	maxDateAhead     time.Duration
+}
+
+// Option configures optional behaviour of a Server
+type Option func(*Server)
+
+// WithTrackClearing enables `DELETE /track` which removes ALL registered
+// tracks. This is disabled by default to prevent accidentally exposing it in
+// production.
+func WithTrackClearing(enabled bool) Option {
+	return func(srv *Server) {
+		srv.allowClear = enabled
+	}
+}
+
+// WithTickerPageSize sets the maximum amount of tracks in a ticker report
+// (defaults to 5)
+func WithTickerPageSize(pageSize int) Option {
+	return func(srv *Server) {
+		srv.tickerPageSize = pageSize
+	}
+}
+
+// WithMaxTrackSize sets the maximum amount of bytes fetched from the url of a
+// registered track, larger files are rejected (defaults to 10 MiB)
+func WithMaxTrackSize(size int64) Option {
+	return func(srv *Server) {
+		srv.maxTrackSize = size
+	}
+}
+
+// WithMaxBodySize sets the maximum amount of bytes in the body of requests
+// which register or modify tracks or webhooks, where larger bodies are
+// rejected (defaults to 64 KiB)
+func WithMaxBodySize(size int64) Option {
+	return func(srv *Server) {
+		srv.maxBodySize = size
+	}
+}
+
+// WithMaxRedirects sets the maximum amount of redirects followed when fetching
+// the igc file of a registered track (defaults to 10)
+func WithMaxRedirects(redirects int) Option {
+	return func(srv *Server) {
+		srv.maxRedirects = redirects
+	}
+}
+
+// WithContentTypeCheck makes the server reject tracks which are not served
+// with a `text/*` Content-Type
+func WithContentTypeCheck(enabled bool) Option {
+	return func(srv *Server) {
+		srv.checkContentType = enabled
+	}
+}
+
+// WithFetchTimeout sets the maximum duration of fetching the igc file of a
+// registered track (defaults to 30 seconds)
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(srv *Server) {
+		srv.fetchTimeout = timeout
+	}
+}
+
+// WithProxy routes the fetches of igc files through the HTTP proxy at the url,
+// eg. `http://proxy.example.com:3128`. It only has an effect if no http client
+// is given to NewServer, as the transport of a given client is used as is. An
+// invalid proxy url is logged, and the proxies given by the `HTTP_PROXY` and
+// `HTTPS_PROXY` envvars are then used like for other clients.
+func WithProxy(proxyURL string) Option {
+	return func(srv *Server) {
+		srv.proxyURL = proxyURL
+	}
+}
+
+// WithServerTimeouts sets the read, write and idle timeouts of the http server
+// created by NewHTTPServer and ListenAndServe, which protect against clients
+// which hold connections open by sending or receiving slowly. A timeout of 0
+// means that there is no limit. The defaults are 15 seconds for reading a
+// request, 60 seconds for writing a response and 120 seconds for keeping idle
+// connections open.
+func WithServerTimeouts(read, write, idle time.Duration) Option {
+	return func(srv *Server) {
+		srv.readTimeout = read
+		srv.writeTimeout = write
+		srv.idleTimeout = idle
+	}
+}
+
+// WithParseTimeout sets the maximum duration of parsing the igc file of a
+// registered track, where tracks taking longer are rejected with 400. A
+// timeout of 0 means that there is no limit, which is the default.
+func WithParseTimeout(timeout time.Duration) Option {
+	return func(srv *Server) {
+		srv.parseTimeout = timeout
+	}
+}
+
+// WithMaxTrackPoints caps the amount of points of a track which are processed,
+// where tracks with more points are downsampled to evenly spaced points. A cap
+// of 0 means that all points are processed, which is the default.
+func WithMaxTrackPoints(max int) Option {
+	return func(srv *Server) {
+		srv.maxPoints = max
+	}
+}
+
+// WithFetchConcurrency limits the amount of igc files fetched at the same time
+// when registering tracks. Registrations beyond the limit wait up to `wait`
+// for another fetch to finish, and are otherwise rejected with 503. A limit of
+// 0 or less means that there is no limit, which is the default.
+func WithFetchConcurrency(limit int, wait time.Duration) Option {
+	return func(srv *Server) {
+		if limit > 0 {
+			srv.fetchSlots = make(chan struct{}, limit)
+		} else {
+			srv.fetchSlots = nil
+		}
+		srv.fetchSlotWait = wait
+	}
+}
+
+// WithFetchRetries makes the server retry fetching the igc file of a track in
+// the background if the remote host is unavailable, instead of responding with
+// an error. The fetch is retried up to `retries` times, where the delay before
+// each retry starts at `backoff` and is doubled for every attempt. The server
+// has to be closed using Close to stop retrying.
+func WithFetchRetries(retries int, backoff time.Duration) Option {
+	return func(srv *Server) {
+		srv.fetchRetries = retries
+		srv.retryBackoff = backoff
+	}
+}
+
+// WithDateRange sets the range of dates which tracks can have, where tracks
+// dated before `earliest` or more than `ahead` into the future are rejected
+// (defaults to the start of 1970 and one day)
+func WithDateRange(earliest time.Time, ahead time.Duration) Option {
+	return func(srv *Server) {
+		srv.earliestDate = earliest
+		srv.maxDateAhead = ahead
+	}
+}
+
+// WithAllowedHosts restricts the hosts which igc files are fetched from to the
+// given hosts and their subdomains, where tracks from other hosts are rejected.
+// All hosts are allowed if none are given.
+func WithAllowedHosts(hosts ...string) Option {
+	return func(srv *Server) {
+		srv.allowedHosts = hosts
+	}
+}
+
+// WithPrivateHostBlocking makes the server reject tracks with urls which
+// resolve to private, loopback or link-local addresses. The address is also
+// checked when connecting, unless tracks are fetched through WithProxy, in
+// which case the proxy connects to the host.
+func WithPrivateHostBlocking(enabled bool) Option {
+	return func(srv *Server) {
+		srv.blockPrivate = enabled
+	}
+}
+
+// WithAllowedOrigins enables CORS for requests from the given origins, where
+// `*` allows requests from any origin
+func WithAllowedOrigins(origins ...string) Option {
+	return func(srv *Server) {
+		srv.allowedOrigins = origins
+	}
+}
+
+// WithCORSCredentials makes the server allow browsers to send credentials,
+// eg. cookies, with requests from the allowed origins. Credentials can't be
+// combined with the `*` origin, in which case credentials are not allowed.
+func WithCORSCredentials(enabled bool) Option {
+	return func(srv *Server) {
+		srv.corsCredentials = enabled
+	}
+}
+
+// WithCORSMaxAge sets how long browsers may cache the response to preflight
+// requests (defaults to 10 minutes), where 0 leaves it to the browser
+func WithCORSMaxAge(maxAge time.Duration) Option {
+	return func(srv *Server) {
+		srv.corsMaxAge = maxAge
+	}
+}
+
+// ErrCORSCredentialsWildcard is returned if credentials are allowed from any
+// origin, which browsers refuse
+var ErrCORSCredentialsWildcard = errors.New("cors credentials can't be allowed from the '*' origin")
+
+// ValidateCORS checks that the allowed origins can be combined with allowing
+// credentials
+func ValidateCORS(origins []string, credentials bool) error {
+	if !credentials {
+		return nil
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			return ErrCORSCredentialsWildcard
+		}
+	}
+	return nil
+}
+
+// WithGzipThreshold sets the minimum size in bytes of responses which are
+// compressed if the client accepts it (defaults to 1 KiB)
+func WithGzipThreshold(threshold int) Option {
+	return func(srv *Server) {
+		srv.gzipThreshold = threshold
+	}
+}
+
+// WithRawTrackStorage makes the server keep the original igc file of every
+// registered track in memory, instead of fetching it again for
+// `GET /track/<id>/raw`
+func WithRawTrackStorage(enabled bool) Option {
+	return func(srv *Server) {
+		if enabled {
+			srv.raws = newRawTracks()
+		} else {
+			srv.raws = nil
+		}
+	}
+}
+
+// WithPointRetention makes the server keep the parsed points of every track in
+// memory, which is required for `GET /track/<id>/points`. This is enabled by
+// default, and disabling it bounds the memory used by the server at the cost
+// of fetching the igc file again for every request which needs the points.
+func WithPointRetention(enabled bool) Option {
+	return func(srv *Server) {
+		if enabled {
+			srv.points = newTrackPoints()
+		} else {
+			srv.points = nil
+		}
+	}
+}
+
+// WithAPIKeys makes requests which modify the state of the server require one
+// of the keys in an `Authorization: Bearer <key>` header
+func WithAPIKeys(keys ...string) Option {
+	return func(srv *Server) {
+		srv.apiKeys = keys
+	}
+}
+
+// WithBasicAuth makes requests which modify the state of the server require
+// the username and password in an `Authorization: Basic` header. If api keys
+// are given as well, either of them is accepted.
+func WithBasicAuth(username, password string) Option {
+	return func(srv *Server) {
+		srv.basicAuth = &basicCredentials{username, password}
+	}
+}
+
+// WithReadAuth makes all requests, not only those modifying the state of the
+// server, require an api key or basic credentials. It has no effect unless
+// they are given using WithAPIKeys or WithBasicAuth.
+func WithReadAuth(enabled bool) Option {
+	return func(srv *Server) {
+		srv.lockReads = enabled
+	}
+}
+
+// WithContentDedup makes the server reject tracks with the same content as an
+// already registered track, even if they are fetched from different urls
+func WithContentDedup(enabled bool) Option {
+	return func(srv *Server) {
+		srv.dedupContent = enabled
+	}
+}
+
+// DuplicatePolicy decides how the server handles a track registered from the
+// same url as an already registered track
+type DuplicatePolicy string
+
+const (
+	// DuplicateReject rejects the track with 403, which is the default
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateUpsert fetches the track again and overwrites the metadata of
+	// the existing track, which keeps its id and the time it was registered
+	DuplicateUpsert DuplicatePolicy = "upsert"
+)
+
+// WithDuplicatePolicy sets how tracks from the url of an already registered
+// track are handled (defaults to DuplicateReject)
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(srv *Server) {
+		srv.duplicatePolicy = policy
+	}
+}
+
+// WithDuplicateWindow only rejects tracks from the url of an already
+// registered track if the latest track from the url was registered within the
+// window. Later tracks from the url are registered as new tracks. A window of
+// zero rejects duplicates forever, which is the default. The window has no
+// effect with DuplicateUpsert.
+func WithDuplicateWindow(window time.Duration) Option {
+	return func(srv *Server) {
+		srv.duplicateWindow = window
+	}
+}
+
+// WithTrackIDSeed mixes the seed into the ids of new tracks, such that
+// deployments with different seeds give different ids to tracks from the same
+// url. The ids are stable as long as the seed is unchanged, and the default
+// empty seed gives the same ids as earlier versions.
+func WithTrackIDSeed(seed string) Option {
+	return func(srv *Server) {
+		srv.trackIDSeed = seed
+	}
+}
+
+// WithPrefix mounts all routes of the server under the prefix, eg.
+// `/paragliding/api`, where requests outside of the prefix give 404
+func WithPrefix(prefix string) Option {
+	return func(srv *Server) {
+		srv.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithPublicURL makes `GET /track/<id>` include absolute links to the track,
+// built from the public base url and the prefix of the server, eg.
+// `https://example.com`. This is needed behind reverse proxies, where the
+// host of the request isn't the public host. Links are left out by default.
+func WithPublicURL(base string) Option {
+	return func(srv *Server) {
+		srv.publicURL = strings.TrimSuffix(base, "/")
+	}
+}
+
+// WithShareSecret enables `GET /track/<id>/share`, which creates links to the
+// metadata of a track which expire and can be used without credentials. The
+// links are signed using the secret, hence changing it invalidates all links.
+func WithShareSecret(secret string) Option {
+	return func(srv *Server) {
+		srv.shareSecret = []byte(secret)
+	}
+}
+
+// WithReadOnly starts the server in read-only mode, where requests which
+// modify the server are rejected with 503. The mode can be toggled while the
+// server is running using `POST /admin/readonly`, which is only mounted if
+// api keys or basic credentials are given.
+func WithReadOnly(enabled bool) Option {
+	return func(srv *Server) {
+		if enabled {
+			atomic.StoreInt32(srv.readOnly, 1)
+		} else {
+			atomic.StoreInt32(srv.readOnly, 0)
+		}
+	}
+}
+
+// WithPublisher makes the server publish a message to the publisher whenever
+// a track is registered. Messages are published in the background, and
+// failures are only logged. Nothing is published by default.
+func WithPublisher(publisher TrackPublisher) Option {
+	return func(srv *Server) {
+		if publisher != nil {
+			srv.publisher = publisher
+		}
+	}
+}
+
+// WithLogger makes the server log to the given logger instead of the standard
+// logger of logrus
+func WithLogger(logger *log.Logger) Option {
+	return func(srv *Server) {
+		if logger != nil {
+			srv.logger = logger
+		}
+	}
+}
+
+// WithClock makes the server report the state of the given clock in the
+// metadata about the api
+func WithClock(clock *Clock) Option {
+	return func(srv *Server) {
+		srv.clock = clock
+	}
+}
+
+// WithClockSinks makes the server start a clock which posts summaries of new
+// tracks to all the sinks on the given interval, where each sink receives the
+// summary in the shape of its type. The clock uses the http client given to
+// NewServer, and is stopped when the server is closed.
+func WithClockSinks(interval time.Duration, sinks ...ClockSink) Option {
+	return func(srv *Server) {
+		srv.clockEvery = interval
+		srv.clockSinks = sinks
+	}
 }
 
 // NewServer creates a new server which handles requests to the igc api
-func NewServer(httpClient *http.Client, trackMetas TrackMetas, ticker Ticker, webhooks Webhooks) (srv Server) {
+func NewServer(httpClient *http.Client, trackMetas TrackMetas, ticker Ticker, webhooks Webhooks, opts ...Option) (srv Server) {
 	srv = Server{
-		time.Now(),
-		httpClient,
-		mux.NewRouter(),
-		ticker,
-		trackMetas,
-		webhooks,
+		startupTime: time.Now(),
+		httpClient:  httpClient,
+		router:      mux.NewRouter(),
+		ticker:      ticker,
+		tracks:      trackMetas,
+		webhooks:    webhooks,
+		points:      newTrackPoints(),
+		publisher:   noopPublisher{},
+		readOnly:    new(int32),
+		logger:      log.StandardLogger(),
+
+		tickerPageSize: 5,
+		maxTrackSize:   10 << 20,
+		maxBodySize:    64 << 10,
+		maxRedirects:   10,
+		fetchTimeout:   30 * time.Second,
+		corsMaxAge:     10 * time.Minute,
+		readTimeout:    15 * time.Second,
+		writeTimeout:   60 * time.Second,
+		idleTimeout:    120 * time.Second,
+		gzipThreshold:  1 << 10,
+		earliestDate:   time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		maxDateAhead:   24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(&srv)
+	}
+	srv.hub = newTrackHub(srv.logger)
+	if err := ValidateCORS(srv.allowedOrigins, srv.corsCredentials); err != nil {
+		srv.logger.WithField("error", err).Error("rejecting cors credentials")
+		srv.corsCredentials = false
+	}
+	if srv.httpClient == nil && srv.proxyURL != "" {
+		srv.httpClient = newProxyClient(srv.logger, srv.proxyURL)
+	}
+	// Copy the client to check redirects without affecting other users of it
+	if srv.httpClient != nil {
+		fetchClient := *srv.httpClient
+		fetchClient.CheckRedirect = srv.checkRedirect
+		if srv.blockPrivate && srv.proxyURL == "" {
+			guardPrivateDials(srv.logger, &fetchClient)
+		}
+		srv.httpClient = &fetchClient
+	}
+	// Tracks evicted by a storage with a capacity are forgotten like deleted
+	// tracks, such that their points and raw content don't use up memory
+	if evicting, ok := srv.tracks.(evictingTrackMetas); ok {
+		evicting.OnEvict(srv.forgetTrack)
+	}
+	if srv.fetchRetries > 0 {
+		srv.retryQueue = newRetryQueue(srv.fetchRetries, srv.retryBackoff)
+		go srv.retryFetches(srv.retryQueue)
+	}
+	if len(srv.clockSinks) > 0 {
+		clockClient := httpClient
+		if clockClient == nil {
+			clockClient = http.DefaultClient
+		}
+		var ctx context.Context
+		ctx, srv.stopClock = context.WithCancel(context.Background())
+		srv.clock = NewClockWithSinks(clockClient, srv.ticker, srv.clockEvery, srv.clockSinks...)
+		srv.clock.Start(ctx)
+	}
+
+	srv.handler = loggingMiddleware(
+		srv.logger,
+		corsMiddleware(
+			srv.allowedOrigins, srv.corsCredentials, len(srv.apiKeys) > 0 || srv.basicAuth != nil, srv.corsMaxAge, srv.allowedMethods,
+			gzipMiddleware(srv.gzipThreshold, prettyMiddleware(srv.router)),
+		),
+	)
+	srv.router.Use(metricsMiddleware(srv.prefix))
+	if len(srv.apiKeys) > 0 || srv.basicAuth != nil {
+		srv.router.Use(authMiddleware(srv.apiKeys, srv.basicAuth, srv.lockReads))
 	}
+	srv.router.Use(readOnlyMiddleware(srv.readOnly))
 
-	srv.router.Use(loggingMiddleware)
+	// All routes are mounted under the prefix
+	api := srv.router
+	if srv.prefix != "" {
+		api = srv.router.PathPrefix(srv.prefix).Subrouter()
+	}
+
+	// Metrics API
+	api.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
 
 	// Webhook API
-	srv.router.HandleFunc("/webhook/new_track", srv.webhookRegHandler).Methods(http.MethodPost)
-	srv.router.HandleFunc("/webhook/new_track/{webhookID}", srv.webhookGetHandler).Methods(http.MethodGet)
-	srv.router.HandleFunc("/webhook/new_track/{webhookID}", srv.webhookDeleteHandler).Methods(http.MethodDelete)
+	api.HandleFunc("/webhook/new_track", srv.webhookRegHandler).Methods(http.MethodPost)
+	api.HandleFunc("/webhook/new_track/{webhookID}", srv.webhookGetHandler).Methods(http.MethodGet)
+	api.HandleFunc("/webhook/new_track/{webhookID}", srv.webhookDeleteHandler).Methods(http.MethodDelete)
+
+	// Stream API
+	api.HandleFunc("/ws/tracks", srv.trackStreamHandler).Methods(http.MethodGet)
 
 	// Ticker API
-	srv.router.HandleFunc("/ticker", srv.tickerHandler).Methods(http.MethodGet)
-	srv.router.HandleFunc("/ticker/latest", srv.tickerLatestHandler).Methods(http.MethodGet)
-	srv.router.HandleFunc("/ticker/{timestamp}", srv.tickerAfterHandler).Methods(http.MethodGet)
+	api.HandleFunc("/ticker", srv.tickerHandler).Methods(http.MethodGet)
+	api.HandleFunc("/ticker/latest", srv.tickerLatestHandler).Methods(http.MethodGet)
+	api.HandleFunc("/ticker/{timestamp}", srv.tickerAfterHandler).Methods(http.MethodGet)
+
+	// Clock API
+	api.HandleFunc("/clock", srv.clockHandler).Methods(http.MethodGet)
+
+	// Stats API
+	api.HandleFunc("/stats", srv.statsHandler).Methods(http.MethodGet)
+
+	// Admin API, which is only mounted if it is guarded by authentication
+	if len(srv.apiKeys) > 0 || srv.basicAuth != nil {
+		api.HandleFunc("/admin/readonly", srv.adminReadOnlyHandler).Methods(http.MethodPost).Name(adminReadOnlyRoute)
+		api.HandleFunc("/admin/clock/tick", srv.adminClockTickHandler).Methods(http.MethodPost)
+	}
+
+	// Share API, which is only mounted if links can be signed
+	if len(srv.shareSecret) > 0 {
+		api.HandleFunc("/s/{token}", srv.sharedTrackHandler).Methods(http.MethodGet).Name(sharedTrackRoute)
+	}
+
+	// Debug API, which is only mounted if enabled to hide its existence
+	if srv.debugState {
+		api.HandleFunc("/debug/state", srv.debugStateHandler).Methods(http.MethodGet)
+	}
 
 	// Igc track API
-	srv.router.HandleFunc("/", srv.metaHandler).Methods(http.MethodGet)
-	srv.router.HandleFunc("/track", srv.trackRegHandler).Methods(http.MethodPost)
-	srv.router.HandleFunc("/track", srv.trackGetAllHandler).Methods(http.MethodGet)
-	srv.router.HandleFunc(
-		"/track/{id}",
-		srv.trackGetHandler,
-	).Methods(http.MethodGet)
-	srv.router.HandleFunc(
-		"/track/{id}/{field}",
-		srv.trackGetFieldHandler,
-	).Methods(http.MethodGet)
+	api.HandleFunc("/", srv.metaHandler).Methods(http.MethodGet)
+	api.HandleFunc("/", headHandler(srv.metaHandler)).Methods(http.MethodHead)
+
+	// Routes with literal paths are registered before routes with ids, such
+	// that eg. `/track/count` isn't handled as a track with the id `count`
+	trackRoutes := []trackRoute{
+		{"", http.MethodPost, srv.trackRegHandler},
+		{"", http.MethodGet, srv.trackGetAllHandler},
+		{"", http.MethodHead, headHandler(srv.trackGetAllHandler)},
+		{".csv", http.MethodGet, srv.trackGetCSVHandler},
+		{"/all", http.MethodGet, srv.trackGetNDJSONHandler},
+		{"/search", http.MethodGet, srv.trackSearchHandler},
+		{"/compare", http.MethodGet, srv.trackCompareHandler},
+		{"/count", http.MethodGet, srv.trackCountHandler},
+		{"/by-glider/{gliderID}", http.MethodGet, srv.trackByGliderHandler},
+		{"/pending/{job}", http.MethodGet, srv.trackPendingHandler},
+		{"/{id}.json.gz", http.MethodGet, srv.trackGetGzipHandler},
+		{"/{id}", http.MethodGet, srv.trackGetHandler},
+		{"/{id}", http.MethodHead, headHandler(srv.trackGetHandler)},
+		{"/{id}", http.MethodDelete, srv.trackDeleteHandler},
+		{"/{id}", http.MethodPatch, srv.trackPatchHandler},
+		{"/{id}/fields", http.MethodGet, srv.trackGetFieldsHandler},
+		{"/{id}/raw", http.MethodGet, srv.trackGetRawHandler},
+		{"/{id}/gpx", http.MethodGet, srv.trackGetGPXHandler},
+		{"/{id}/map", http.MethodGet, srv.trackGetMapHandler},
+		{"/{id}/takeoff", http.MethodGet, srv.trackGetTakeoffHandler},
+		{"/{id}/landing", http.MethodGet, srv.trackGetLandingHandler},
+		{"/{id}/points", http.MethodGet, srv.trackGetPointsHandler},
+		{"/{id}/{field}", http.MethodGet, srv.trackGetFieldHandler},
+	}
+	if srv.allowClear {
+		trackRoutes = append(trackRoutes, trackRoute{"", http.MethodDelete, srv.trackClearHandler})
+	}
+	// The route must come before `/{id}/{field}` to not be taken as a field
+	if len(srv.shareSecret) > 0 {
+		trackRoutes = append([]trackRoute{{"/{id}/share", http.MethodGet, srv.trackShareHandler}}, trackRoutes...)
+	}
+	for _, alias := range trackRouteAliases {
+		for _, route := range trackRoutes {
+			api.HandleFunc(alias+route.path, route.handler).Methods(route.method)
+		}
+	}
 
 	srv.router.MethodNotAllowedHandler =
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger := newReqLogger(r)
-			logger.Info("received request with disallowed method")
 
 			// A 405 MUST generate "Allow" header in the header (rfc 7231 6.5.5)
-			w.Header().Add("Allow", "GET POST")
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			allowed := srv.allowedMethods(r)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+			// None of the routes handle OPTIONS themselves, hence all OPTIONS
+			// requests to existing routes end up here
+			if r.Method == http.MethodOptions {
+				logger.WithField("allowed", allowed).Info("responding with allowed methods")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			logger.Info("received request with disallowed method")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		})
 
 	srv.router.NotFoundHandler =
@@ -70,33 +668,194 @@ func NewServer(httpClient *http.Client, trackMetas TrackMetas, ticker Ticker, we
 			logger := newReqLogger(r)
 			logger.Info("received request which didn't match any paths")
 
-			http.Error(w, "content not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "content not found")
 		})
 
 	return
 }
 
+// trackRouteAliases contains the paths which the track api is mounted at,
+// where `/igc` is kept for clients following the original specification
+var trackRouteAliases = []string{"/track", "/igc"}
+
+// trackRoute is a route of the track api, where the path is relative to the
+// alias the route is mounted at
+type trackRoute struct {
+	path    string
+	method  string
+	handler http.HandlerFunc
+}
+
+// routeMethods contains the methods which routes can be registered with
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// allowedMethods returns the methods which the route matching the path of the
+// request allows, which always includes OPTIONS
+func (server *Server) allowedMethods(r *http.Request) []string {
+	allowed := make([]string, 0, len(routeMethods)+1)
+	for _, method := range routeMethods {
+		req := *r
+		req.Method = method
+		var match mux.RouteMatch
+		if server.router.Match(&req, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return append(allowed, http.MethodOptions)
+}
+
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	server.router.ServeHTTP(w, r)
+	server.handler.ServeHTTP(w, r)
+}
+
+// Close stops the background work of the server, where tracks which are
+// waiting to be retried are abandoned
+func (server *Server) Close() {
+	if server.retryQueue != nil {
+		server.retryQueue.Close()
+	}
+	if server.stopClock != nil {
+		server.stopClock()
+	}
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
+// requestIDHeader is the header used to pass the id of a request between
+// clients and the server
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the id of a request
+type requestIDKey struct{}
+
+// loggerKey is the context key of the logger of a request
+type loggerKey struct{}
+
+// newRequestID generates a random id for a request
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// loggingMiddleware gives every request an id, which is reused if the client
+// already sent one, and logs the outcome of the request. The logger is passed
+// on to the handlers through the context of the request.
+func loggingMiddleware(logger *log.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger := newReqLogger(r)
-		logger.Info("received request")
-		next.ServeHTTP(w, r)
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		r = r.WithContext(context.WithValue(ctx, loggerKey{}, logger))
+
+		reqlog := newReqLogger(r)
+		reqlog.Info("received request")
+
+		start := time.Now()
+		rec := &statusRecorder{w, http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		reqlog.WithFields(log.Fields{
+			"status":   rec.status,
+			"duration": time.Since(start),
+		}).Info("handled request")
 	})
 }
 
 func newReqLogger(r *http.Request) *log.Entry {
-	return log.WithFields(log.Fields{
+	fields := log.Fields{
 		"method": r.Method,
 		"path":   r.URL.Path,
 		"addr":   r.RemoteAddr,
+	}
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		fields["request_id"] = id
+	}
+	logger, ok := r.Context().Value(loggerKey{}).(*log.Logger)
+	if !ok {
+		logger = log.StandardLogger()
+	}
+	return logger.WithFields(fields)
+}
+
+// corsMiddleware adds CORS headers to requests from the allowed origins and
+// responds to preflight requests, which browsers may cache for the max age.
+// If credentials are allowed, the specific origin is always echoed. The
+// `Authorization` header is allowed if credentials are allowed or if `auth` is
+// set, ie. the server accepts api keys or basic auth. Preflight requests are
+// responded to with the methods of the route, as given by `allowedMethods`.
+func corsMiddleware(allowedOrigins []string, credentials bool, auth bool, maxAge time.Duration, allowedMethods func(*http.Request) []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowedOrigin := ""
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || allowed == origin {
+				allowedOrigin = allowed
+				break
+			}
+		}
+		if origin == "" || allowedOrigin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Total-Count")
+		allowedHeaders := "Content-Type, X-Request-ID"
+		if credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if credentials || auth {
+			allowedHeaders += ", Authorization"
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			logger := newReqLogger(r)
+			logger.WithField("origin", origin).Info("responding to preflight request")
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods(r), ", "))
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			if maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
-// metaHandler returns the metadata about the api endpoint
+// writeJSONError responds with an error in the following structure
+//
+// ```json
+// {
+//   "error": <msg>,
+//   "status": <status>
+// }
+// ```
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  msg,
+		"status": status,
+	})
+}
+
+// metaHandler returns the metadata about the api endpoint, where the uptime is
+// calculated from the time the server was created
 func (server *Server) metaHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
@@ -105,7 +864,19 @@ func (server *Server) metaHandler(w http.ResponseWriter, r *http.Request) {
 	metadata := map[string]interface{}{
 		"uptime":  isodur.FormatAsISO8601(time.Since(server.startupTime)),
 		"info":    "Service for Paragliding tracks.",
-		"version": "v1",
+		"version": Version,
+	}
+	if server.tracks != nil {
+		count, err := server.tracks.Len()
+		if err != nil {
+			logger.WithField("error", err).Error("unable to get amount of tracks")
+			writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+			return
+		}
+		metadata["track_count"] = count
+	}
+	if server.clock != nil {
+		metadata["clock_triggers"] = server.clock.Fired()
 	}
 
 	logger.WithFields(log.Fields(metadata)).Info("responding with metadata")
@@ -1,43 +1,79 @@
 package igcserver
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"github.com/marni/goigc"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"io"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 // Convenience function to create a simple igc-file hosting server which hosts
 // two files, one valid 'test.igc' and an invalid 'invalid.igc'
+// igcTestDates maps paths of the igc file server to the date which replaces
+// the date of 'test.igc' in the served file
+var igcTestDates = map[string]string{
+	"/ancient.igc": "311269",
+	"/future.igc":  "010168",
+}
+
 func makeIgcFileServer() *httptest.Server {
 	return httptest.NewUnstartedServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.RequestURI == "/test.igc" {
+			if r.RequestURI == "/test.igc" || r.RequestURI == "/copy.igc" {
 				f, err := os.Open("../assets/test.igc")
 				if err != nil {
-					fmt.Printf("error when trying to read 'test.igc': %s", err)
+					log.WithField("error", err).Error("unable to read 'test.igc'")
 				}
 				_, err = io.Copy(w, f)
 				if err != nil {
-					fmt.Printf("error when trying to write file contents to response: %s", err)
+					log.WithField("error", err).Error("unable to write file contents to response")
+				}
+				log.WithField("path", r.RequestURI).Debug("wrote valid igc content to response")
+			} else if date, ok := igcTestDates[r.RequestURI]; ok {
+				content, err := ioutil.ReadFile("../assets/test.igc")
+				if err != nil {
+					log.WithField("error", err).Error("unable to read 'test.igc'")
 				}
-				fmt.Println("wrote valid igc content to response")
+				w.Write(bytes.Replace(content, []byte("HFDTE190216"), []byte("HFDTE"+date), 1))
+				log.WithField("path", r.RequestURI).Debug("wrote igc content with changed date to response")
 			} else if r.RequestURI == "/invalid.igc" {
 				invalidIGC := "asljdkfjaøsljfølwer jfølvjasdløkv aøljsgødl v"
 				w.Write([]byte(invalidIGC))
-				fmt.Println("wrote invalid igc content to response")
+				log.WithField("path", r.RequestURI).Debug("wrote invalid igc content to response")
+			} else if r.RequestURI == "/large.igc" {
+				w.Write(bytes.Repeat([]byte("B"), 4096))
+				log.WithField("path", r.RequestURI).Debug("wrote large igc content to response")
+			} else if r.RequestURI == "/binary.igc" {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte{0x00, 0x01, 0x02})
+				log.WithField("path", r.RequestURI).Debug("wrote binary content to response")
 			} else {
 				http.Error(w, "not found", http.StatusNotFound)
-				fmt.Println("wrote not found to response")
+				log.WithField("path", r.RequestURI).Debug("wrote not found to response")
 			}
 		}),
 	)
@@ -50,12 +86,14 @@ func makeWebhooksTestData() []WebhookInfo {
 			NewWebhookID([]byte("asd")),
 			"http://unique.com",
 			1,
+			"",
 			time.Now(),
 		},
 		{
 			NewWebhookID([]byte("dsa")),
 			"http://unique2.com",
 			2,
+			"",
 			time.Now(),
 		},
 	}
@@ -73,6 +111,13 @@ func makeIGCTestData(serverURL string) []TrackMeta {
 			"MGI2",
 			1200,
 			serverURL + "/aladin.igc",
+			350,
+			5400,
+			800,
+			"aladin",
+			BoundingBox{59.5, 10.25, 60.75, 11.5},
+			[]string{"magic", "xc"},
+			0,
 		},
 		{
 			NewTrackID([]byte("dsa")),
@@ -83,11 +128,18 @@ func makeIGCTestData(serverURL string) []TrackMeta {
 			"BG7",
 			10,
 			serverURL + "/boeng.igc",
+			0,
+			0,
+			0,
+			"boeng",
+			BoundingBox{},
+			nil,
+			0,
 		},
 	}
 }
 
-func makeTestServers() (server Server, igcFileServer *httptest.Server) {
+func makeTestServers(opts ...Option) (server Server, igcFileServer *httptest.Server) {
 	// Setup a simple igc-file hosting server
 	igcFileServer = makeIgcFileServer()
 	igcFileServer.Start()
@@ -96,13 +148,13 @@ func makeTestServers() (server Server, igcFileServer *httptest.Server) {
 	trackMetasMap := NewTrackMetasMap()
 
 	// Setup a dummy ticker
-	ticker := NewTickerDummy(2)
+	ticker := NewTickerDummy(2, &trackMetasMap)
 
 	// Setup a in-memory webhooks
 	webhooks := NewWebhooksMap()
 
 	// Initialize main API server
-	server = NewServer(igcFileServer.Client(), &trackMetasMap, &ticker, &webhooks)
+	server = NewServer(igcFileServer.Client(), &trackMetasMap, &ticker, &webhooks, opts...)
 	return
 }
 
@@ -128,6 +180,92 @@ func TestIgcServerGetMetaValid(t *testing.T) {
 	}
 }
 
+// Test that the uptime in GET / increases with time since the server was created
+func TestIgcServerGetMetaUptime(t *testing.T) {
+	server := NewServer(nil, nil, nil, nil)
+
+	getUptime := func() interface{} {
+		req := httptest.NewRequest("GET", "/", nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		return data["uptime"]
+	}
+
+	if uptime := getUptime(); uptime != "PT0S" {
+		t.Fatalf("expected uptime of a new server to be 'PT0S', got '%v'", uptime)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if uptime := getUptime(); uptime != "PT1S" {
+		t.Fatalf("expected uptime to have increased to 'PT1S', got '%v'", uptime)
+	}
+}
+
+// Test GET / reports the build-injectable version
+func TestIgcServerGetMetaVersion(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "v1.2.3-test"
+
+	server := NewServer(nil, nil, nil, nil)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if data["version"] != Version {
+		t.Errorf("expected version to be '%s', got '%v'", Version, data["version"])
+	}
+}
+
+// Test GET / with track count and clock triggers
+func TestIgcServerGetMetaCounts(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	clock := NewClock(nil, &ticker, "", time.Hour)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil, WithClock(clock))
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	for _, field := range []string{"uptime", "info", "version"} {
+		if data[field] == nil {
+			t.Errorf("'%s' was not found in fields ", field)
+		}
+	}
+	if count, _ := data["track_count"].(float64); int(count) != len(testTrackMetas) {
+		t.Errorf("expected 'track_count' to be '%d', got '%v'", len(testTrackMetas), data["track_count"])
+	}
+	if triggers, ok := data["clock_triggers"].(float64); !ok || triggers != 0 {
+		t.Errorf("expected 'clock_triggers' to be '0', got '%v'", data["clock_triggers"])
+	}
+}
+
 // Test bad POST /track
 func TestIgcServerPostTrackBad(t *testing.T) {
 	server, fileserver := makeTestServers()
@@ -155,298 +293,3025 @@ func TestIgcServerPostTrackBad(t *testing.T) {
 	}
 }
 
-// Test valid POST /track
-func TestIgcServerPostTrackValid(t *testing.T) {
+// Test that invalid bodies of POST /track are rejected with an error naming
+// the offending field
+func TestIgcServerPostTrackBadBody(t *testing.T) {
 	server, fileserver := makeTestServers()
 	defer fileserver.Close()
 
-	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
-	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
-	res := httptest.NewRecorder()
+	for _, data := range []struct {
+		body string
+		msg  string
+	}{
+		{"{}", "missing field 'url'"},
+		{"{\"url\":null}", "missing field 'url'"},
+		{"{\"url\":\"\"}", "missing field 'url'"},
+		{"{\"tags\":[\"xc\"]}", "missing field 'url'"},
+		{fmt.Sprintf("{\"l\":\"%s\"}", fileserver.URL+"/test.igc"), "unknown field 'l'"},
+		{fmt.Sprintf("{\"url\":\"%s\",\"pilot\":\"John\"}", fileserver.URL+"/test.igc"), "unknown field 'pilot'"},
+		{"{\"url\":42}", "invalid type of field 'url', expected string"},
+		{fmt.Sprintf("{\"url\":\"%s\",\"tags\":\"xc\"}", fileserver.URL+"/test.igc"), "invalid type of field 'tags', expected []string"},
+		{fmt.Sprintf("\"l\":\"%s\"}", fileserver.URL+"/test.igc"), "invalid json object"},
+		{"", "invalid json object"},
+	} {
+		req := httptest.NewRequest("POST", "/track", strings.NewReader(data.body))
+		res := httptest.NewRecorder()
 
-	server.ServeHTTP(res, req)
+		server.ServeHTTP(res, req)
 
-	var data map[string]TrackID
-	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
-		t.Errorf("received response body: '%s'", res.Body)
-		t.Fatalf("failed when trying to decode body as json")
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected '%s' to return 400 (bad request), got '%d'", data.body, code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body["error"] != data.msg {
+			t.Errorf("expected '%s' to be rejected with '%s', got '%s'", data.body, data.msg, res.Body)
+		}
+	}
+}
+
+// Test that bodies larger than the maximum size are rejected
+func TestIgcServerMaxBodySize(t *testing.T) {
+	server, fileserver := makeTestServers(WithMaxBodySize(256))
+	defer fileserver.Close()
+
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
 	}
 
-	req = httptest.NewRequest("GET", "/track", nil)
-	res = httptest.NewRecorder()
+	// Padding with whitespace keeps the json valid
+	padding := strings.Repeat(" ", 512)
+	for _, data := range []struct {
+		method string
+		uri    string
+		body   string
+	}{
+		{"POST", "/track", fmt.Sprintf("{\"url\":\"%s\"%s}", fileserver.URL+"/test.igc", padding)},
+		{"PATCH", fmt.Sprintf("/track/%d", meta.ID), fmt.Sprintf("{\"pilot\":\"Ola\"%s}", padding)},
+		{"POST", "/webhook/new_track", fmt.Sprintf("{\"webhookURL\":\"%s\"%s}", fileserver.URL+"/hook", padding)},
+	} {
+		req := httptest.NewRequest(data.method, data.uri, strings.NewReader(data.body))
+		res := httptest.NewRecorder()
 
-	server.ServeHTTP(res, req)
+		server.ServeHTTP(res, req)
 
-	var respData []TrackID
-	if err := json.Unmarshal(res.Body.Bytes(), &respData); err != nil {
-		t.Errorf("received response body: '%s'", res.Body)
-		t.Fatalf("failed when trying to decode body as json")
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected oversized `%s %s` to return 400, got '%d'", data.method, data.uri, code)
+		}
+
+		// The same body without padding is accepted
+		req = httptest.NewRequest(data.method, data.uri, strings.NewReader(strings.Replace(data.body, padding, "", 1)))
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code >= 400 {
+			t.Errorf("expected `%s %s` to succeed, got '%d': %s", data.method, data.uri, code, res.Body)
+		}
 	}
+}
 
-	for _, gotID := range respData {
-		if gotID == data["id"] {
-			return
+// Test that POST /track only fetches tracks from allowed hosts
+func TestIgcServerPostTrackAllowedHosts(t *testing.T) {
+	for _, data := range []struct {
+		hosts []string
+		code  int
+	}{
+		{nil, 200},
+		{[]string{"127.0.0.1"}, 200},
+		{[]string{"example.com", "127.0.0.1"}, 200},
+		{[]string{"example.com"}, 403},
+		{[]string{"127.0.0.2"}, 403},
+	} {
+		server, fileserver := makeTestServers(WithAllowedHosts(data.hosts...))
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected allowed hosts %v to return '%d', got '%d': %s", data.hosts, data.code, code, res.Body)
 		}
+		if data.code == 403 && !strings.Contains(res.Body.String(), "127.0.0.1") {
+			t.Errorf("expected error to name the disallowed host, got '%s'", res.Body)
+		}
+		fileserver.Close()
 	}
-	t.Fatalf("id of inserted track ('%d') was not found in ids returned from `GET /track` ('%d')", data["id"], respData)
 }
 
-// Test valid POST /track
-func TestIgcServerPostTrackValidDuplicate(t *testing.T) {
+// Test that POST /track only accepts json bodies
+func TestIgcServerPostTrackContentType(t *testing.T) {
 	server, fileserver := makeTestServers()
 	defer fileserver.Close()
 
-	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
-	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
-	res := httptest.NewRecorder()
-
-	server.ServeHTTP(res, req)
+	for _, data := range []struct {
+		contentType string
+		code        int
+	}{
+		{"text/plain", 415},
+		{"application/x-www-form-urlencoded", 415},
+		{"asdf;;", 415},
+		{"application/json; charset=utf-8", 200},
+		{"", 200},
+	} {
+		server.tracks.Clear()
 
-	var data map[string]TrackID
-	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
-		t.Errorf("received response body: '%s'", res.Body)
-		t.Fatalf("failed when trying to decode body as json")
-	}
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		if data.contentType != "" {
+			req.Header.Set("Content-Type", data.contentType)
+		}
+		res := httptest.NewRecorder()
 
-	req = httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
-	res = httptest.NewRecorder()
-	server.ServeHTTP(res, req)
+		server.ServeHTTP(res, req)
 
-	code := res.Result().StatusCode
-	if code != 403 {
-		t.Fatalf("expected attempt to register same file twice to result in 403, got '%d'", code)
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected content type '%s' to return '%d', got '%d'", data.contentType, data.code, code)
+		}
 	}
-
 }
 
-// Test GET /track
-func TestIgcServerGetTrack(t *testing.T) {
-	trackMetasMap := NewTrackMetasMap()
-	server := NewServer(nil, &trackMetasMap, nil, nil)
+// Test that POST /track rejects files which are too large or not served as text
+func TestIgcServerPostTrackRejected(t *testing.T) {
+	server, fileserver := makeTestServers(WithMaxTrackSize(1024), WithContentTypeCheck(true))
+	defer fileserver.Close()
 
-	testTrackMetas := makeIGCTestData("localhost")
-	ids := make([]TrackID, 0, len(testTrackMetas))
-	for _, trackMeta := range testTrackMetas {
-		err := server.tracks.Append(trackMeta)
-		if err != nil {
-			t.Errorf("unable to add metadata: %s", err)
-			continue
+	for _, file := range []string{
+		"/large.igc",
+		"/binary.igc",
+	} {
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+file)
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != 400 {
+			t.Errorf("expected '%s' to return 400 (bad request), got '%d'", file, code)
 		}
-		ids = append(ids, trackMeta.ID)
 	}
 
-	req := httptest.NewRequest("GET", "/track", nil)
+	// Check that the limit is exceeded and not reached
+	server, fileserver = makeTestServers(WithMaxTrackSize(4096))
+	defer fileserver.Close()
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/large.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
 	res := httptest.NewRecorder()
 
 	server.ServeHTTP(res, req)
 
-	var data []TrackID
-	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
-		t.Errorf("received response body: '%s'", res.Body)
-		t.Fatalf("failed when trying to decode body as json")
+	if !strings.Contains(res.Body.String(), "parse") {
+		t.Errorf("expected file of exactly maximum size to be parsed, got '%s'", res.Body)
 	}
+}
 
-outer:
-	for _, exptID := range ids {
-		for _, gotID := range data {
-			if TrackID(gotID) == exptID {
-				continue outer
-			}
+// Test that POST /track rejects tracks with dates outside of the valid range
+func TestIgcServerPostTrackDate(t *testing.T) {
+	for _, data := range []struct {
+		opts []Option
+		file string
+		code int
+	}{
+		{nil, "/test.igc", 200},
+		{nil, "/ancient.igc", 400},
+		{nil, "/future.igc", 400},
+		{[]Option{WithDateRange(time.Date(1960, time.January, 1, 0, 0, 0, 0, time.UTC), 24*time.Hour)}, "/ancient.igc", 200},
+		{[]Option{WithDateRange(time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC), 24*time.Hour)}, "/test.igc", 400},
+	} {
+		server, fileserver := makeTestServers(data.opts...)
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+data.file)
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected '%s' to return '%d', got '%d': %s", data.file, data.code, code, res.Body)
 		}
-		t.Errorf("id of inserted track ('%d') was not found in ids returned from `GET /track` ('%d')", exptID, data)
+		fileserver.Close()
 	}
 }
 
-// Test valid GET /track/<id>
-func TestIgcServerGetTrackByIdValid(t *testing.T) {
-	trackMetasMap := NewTrackMetasMap()
-	server := NewServer(nil, &trackMetasMap, nil, nil)
+// Test that errors are returned as json objects containing the status code
+func TestIgcServerErrorJSON(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
 
-	testTrackMetas := makeIGCTestData("localhost")
-	ids := make([]TrackID, 0, len(testTrackMetas))
-	for _, trackMeta := range testTrackMetas {
-		err := server.tracks.Append(trackMeta)
-		if err != nil {
-			t.Errorf("unable to add metadata: %s", err)
-			continue
-		}
-		ids = append(ids, trackMeta.ID)
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
 	}
 
-	for i, id := range ids {
-		uri := fmt.Sprintf("/track/%d", id)
-		req := httptest.NewRequest("GET", uri, nil)
+	for _, data := range []struct {
+		method string
+		uri    string
+		body   string
+		code   int
+	}{
+		{"POST", "/track", "{\"url\":null}", 400},
+		{"GET", "/track?limit=asdf", "", 400},
+		{"GET", "/track/asdf", "", 400},
+		{"GET", "/track/1232", "", 404},
+		{"PATCH", fmt.Sprintf("/track/%d", meta.ID), "{\"id\":12}", 400},
+		{"GET", fmt.Sprintf("/track/%d/asdf", meta.ID), "", 400},
+	} {
+		req := httptest.NewRequest(data.method, data.uri, strings.NewReader(data.body))
 		res := httptest.NewRecorder()
 
 		server.ServeHTTP(res, req)
 
-		var data TrackMeta
-		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		if contentType := res.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("expected `%s %s` to return json, got '%s'", data.method, data.uri, contentType)
+		}
+		var respData struct {
+			Error  string `json:"error"`
+			Status int    `json:"status"`
+		}
+		if err := json.Unmarshal(res.Body.Bytes(), &respData); err != nil {
 			t.Errorf("received response body: '%s'", res.Body)
 			t.Fatalf("failed when trying to decode body as json")
 		}
-		expt, _ := json.MarshalIndent(testTrackMetas[i], "", "  ")
-		got, _ := json.MarshalIndent(data, "", "  ")
-		if !cmp.Equal(expt, got) {
-			t.Errorf("returned track was not equal to inserted track:\n\nrequested id: %d\nexpected:\n%s\n\nreturned:\n%s", id, expt, got)
+		if respData.Error == "" {
+			t.Errorf("expected `%s %s` to return an error message", data.method, data.uri)
+		}
+		if code := res.Result().StatusCode; code != data.code || respData.Status != data.code {
+			t.Errorf("expected `%s %s` to return '%d', got '%d' with status '%d' in body", data.method, data.uri, data.code, code, respData.Status)
 		}
 	}
 }
 
-// Test bad GET /track/<id>
-func TestIgcServerGetTrackByIdBad(t *testing.T) {
-	trackMetasMap := NewTrackMetasMap()
-	server := NewServer(nil, &trackMetasMap, nil, nil)
+// Test that the original igc file of a track is returned, both when it is
+// stored and when it has to be fetched again
+func TestIgcServerGetTrackRaw(t *testing.T) {
+	expt, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
 
-	for _, badID := range []struct {
-		int
-		string
-	}{
-		{400, "aaaabbbb"},
-		{400, "aaaabbbb/asdfa"},
-		{400, "bad"},
-		{400, "aøaskdljflkasdjfløjsdaf"},
-		{400, "12312o3123"},
-		{400, "--asdf--"},
-		{400, "a"},
-		{404, "1232"},
-		{404, "99999"},
-	} {
-		req := httptest.NewRequest("GET", "/track/"+badID.string, nil)
+	for _, stored := range []bool{true, false} {
+		server, fileserver := makeTestServers(WithRawTrackStorage(stored))
+		defer fileserver.Close()
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
 		res := httptest.NewRecorder()
 
 		server.ServeHTTP(res, req)
 
-		code := res.Result().StatusCode
-		if code != badID.int {
-			t.Errorf("expected `GET /track/%s` to return '%d', got '%d'", badID.string, badID.int, code)
+		var data map[string]TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
 		}
-	}
-}
 
-// Test valid GET /track/<id>/<field>
-func TestIgcServerGetTrackFieldValid(t *testing.T) {
-	trackMetasMap := NewTrackMetasMap()
-	server := NewServer(nil, &trackMetasMap, nil, nil)
+		// The file should not be fetched again when it is stored
+		if stored {
+			fileserver.Close()
+		}
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d/raw", data["id"]), nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected raw track to be returned (stored: %t), got '%d'", stored, code)
+		}
+		if contentType := res.Header().Get("Content-Type"); contentType != "application/octet-stream" {
+			t.Errorf("expected content type to be 'application/octet-stream', got '%s'", contentType)
+		}
+		if !bytes.Equal(res.Body.Bytes(), expt) {
+			t.Errorf("expected raw track to equal the original file (stored: %t)", stored)
+		}
+
+		req = httptest.NewRequest("GET", "/track/1232/raw", nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 404 {
+			t.Errorf("expected unknown id to return 404, got '%d'", code)
+		}
+	}
+}
+
+// Test that a track is exported as gpx containing all points of the track
+func TestIgcServerGetTrackGPX(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
+	track, err := igc.Parse(string(content))
+	if err != nil {
+		t.Fatalf("unable to parse 'test.igc': %s", err)
+	}
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	// Tracks without points should give an empty segment
+	empty := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(empty); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	server.points.Set(empty.ID, nil)
+
+	for id, expt := range map[TrackID]int{
+		data["id"]: len(track.Points),
+		empty.ID:   0,
+	} {
+		req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d/gpx", id), nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected gpx of '%d' to be returned, got '%d'", id, code)
+		}
+		var gpx GPX
+		if err := xml.Unmarshal(res.Body.Bytes(), &gpx); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as xml")
+		}
+		if gpx.Version != "1.1" {
+			t.Errorf("expected gpx version to be '1.1', got '%s'", gpx.Version)
+		}
+		if len(gpx.Track.Segment.Points) != expt {
+			t.Errorf("expected gpx of '%d' to have '%d' points, got '%d'", id, expt, len(gpx.Track.Segment.Points))
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/track/1232/gpx", nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 {
+		t.Errorf("expected unknown id to return 404, got '%d'", code)
+	}
+}
+
+// Test that the takeoff and landing are the first and last point of a track
+func TestIgcServerGetTrackTakeoffLanding(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
+	track, err := igc.Parse(string(content))
+	if err != nil {
+		t.Fatalf("unable to parse 'test.igc': %s", err)
+	}
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	for field, expt := range map[string]igc.Point{
+		"takeoff": track.Points[0],
+		"landing": track.Points[len(track.Points)-1],
+	} {
+		req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d/%s", data["id"], field), nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected %s to be returned, got '%d'", field, code)
+		}
+		var got TrackPoint
+		if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(got, TrackPointFrom(expt)) {
+			t.Errorf("expected %s to be '%v', got '%v'", field, TrackPointFrom(expt), got)
+		}
+	}
+}
+
+// Test that the points of a track are returned in pages
+func TestIgcServerGetTrackPoints(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
+	track, err := igc.Parse(string(content))
+	if err != nil {
+		t.Fatalf("unable to parse 'test.igc': %s", err)
+	}
+	points := trackPointsEleFrom(track.Points)
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	for _, page := range []struct {
+		query string
+		expt  []TrackPointEle
+	}{
+		{"", points},
+		{"?limit=2", points[:2]},
+		{"?limit=2&offset=1", points[1:3]},
+		{fmt.Sprintf("?offset=%d", len(points)-1), points[len(points)-1:]},
+		{fmt.Sprintf("?offset=%d", len(points)), []TrackPointEle{}},
+	} {
+		uri := fmt.Sprintf("/track/%d/points%s", data["id"], page.query)
+		req = httptest.NewRequest("GET", uri, nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET %s` to return 200, got '%d'", uri, code)
+		}
+		var got []TrackPointEle
+		if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(got, page.expt) {
+			t.Errorf("expected `GET %s` to return '%v', got '%v'", uri, page.expt, got)
+		}
+	}
+
+	for _, bad := range []struct {
+		uri  string
+		code int
+	}{
+		{fmt.Sprintf("/track/%d/points?limit=-1", data["id"]), 400},
+		{fmt.Sprintf("/track/%d/points?offset=asdf", data["id"]), 400},
+		{"/track/asdf/points", 400},
+		{"/track/1232/points", 404},
+	} {
+		req = httptest.NewRequest("GET", bad.uri, nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != bad.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", bad.uri, bad.code, code)
+		}
+	}
+}
+
+// Test that a track is returned as GeoJSON with the positions ordered as
+// longitude followed by latitude
+func TestIgcServerGetTrackMap(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
+	track, err := igc.Parse(string(content))
+	if err != nil {
+		t.Fatalf("unable to parse 'test.igc': %s", err)
+	}
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	uri := fmt.Sprintf("/track/%d/map", data["id"])
+	req = httptest.NewRequest("GET", uri, nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `GET %s` to return 200, got '%d'", uri, code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/geo+json" {
+		t.Errorf("expected content type to be 'application/geo+json', got '%s'", contentType)
+	}
+
+	// Decode generically to validate the structure rather than the types
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &collection); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 3 {
+		t.Fatalf("expected a collection of three features, got '%s' with '%d' features", collection.Type, len(collection.Features))
+	}
+
+	lonLat := func(p igc.Point) [2]float64 {
+		return [2]float64{p.Lng.Degrees(), p.Lat.Degrees()}
+	}
+	first, last := track.Points[0], track.Points[len(track.Points)-1]
+	if lonLat(first)[0] == lonLat(first)[1] {
+		t.Fatalf("expected the longitude and latitude of the takeoff to differ")
+	}
+
+	line := collection.Features[0]
+	var positions [][2]float64
+	if err := json.Unmarshal(line.Geometry.Coordinates, &positions); err != nil {
+		t.Fatalf("expected line coordinates to be a list of positions, got '%s'", line.Geometry.Coordinates)
+	}
+	if line.Type != "Feature" || line.Geometry.Type != "LineString" {
+		t.Errorf("expected the first feature to be a 'LineString', got '%s'", line.Geometry.Type)
+	}
+	if len(positions) != len(track.Points) {
+		t.Errorf("expected line to have '%d' positions, got '%d'", len(track.Points), len(positions))
+	} else if positions[0] != lonLat(first) || positions[len(positions)-1] != lonLat(last) {
+		t.Errorf("expected positions to be ordered as [lon, lat], got '%v' for '%v'", positions[0], lonLat(first))
+	}
+	if pilot := line.Properties["pilot"]; pilot != track.Pilot {
+		t.Errorf("expected pilot of line to be '%s', got '%v'", track.Pilot, pilot)
+	}
+
+	for i, expt := range []struct {
+		name  string
+		point igc.Point
+	}{
+		{"takeoff", first},
+		{"landing", last},
+	} {
+		feature := collection.Features[i+1]
+		var position [2]float64
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &position); err != nil {
+			t.Fatalf("expected %s coordinates to be a position, got '%s'", expt.name, feature.Geometry.Coordinates)
+		}
+		if feature.Geometry.Type != "Point" || feature.Properties["name"] != expt.name {
+			t.Errorf("expected feature to be the %s point, got '%s' named '%v'", expt.name, feature.Geometry.Type, feature.Properties["name"])
+		}
+		if position != lonLat(expt.point) {
+			t.Errorf("expected %s to be '%v', got '%v'", expt.name, lonLat(expt.point), position)
+		}
+	}
+
+	for _, bad := range []struct {
+		uri  string
+		code int
+	}{
+		{"/track/asdf/map", 400},
+		{"/track/1232/map", 404},
+	} {
+		req = httptest.NewRequest("GET", bad.uri, nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != bad.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", bad.uri, bad.code, code)
+		}
+	}
+}
+
+// Test that the points of tracks are unavailable if they aren't retained,
+// while the other routes which use the points still work
+func TestIgcServerGetTrackPointsNotRetained(t *testing.T) {
+	server, fileserver := makeTestServers(WithPointRetention(false))
+	defer fileserver.Close()
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	uri := fmt.Sprintf("/track/%d/points", data["id"])
+	req = httptest.NewRequest("GET", uri, nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 {
+		t.Fatalf("expected `GET %s` to return 404 when points aren't retained, got '%d'", uri, code)
+	}
+	if !strings.Contains(res.Body.String(), "not retained") {
+		t.Errorf("expected `GET %s` to explain that points aren't retained, got '%s'", uri, res.Body)
+	}
+
+	uri = fmt.Sprintf("/track/%d/map", data["id"])
+	req = httptest.NewRequest("GET", uri, nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 || !strings.Contains(res.Body.String(), "not retained") {
+		t.Errorf("expected `GET %s` to return 404 explaining that points aren't retained, got '%d': '%s'", uri, code, res.Body)
+	}
+
+	uri = fmt.Sprintf("/track/%d/takeoff", data["id"])
+	req = httptest.NewRequest("GET", uri, nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Errorf("expected `GET %s` to return 200 when points aren't retained, got '%d'", uri, code)
+	}
+}
+
+// Test that tracks are searched by pilot, glider and glider id
+func TestIgcServerSearchTracks(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := trackMetasMap.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	aladin, john := testTrackMetas[0].ID, testTrackMetas[1].ID
+
+	for _, data := range []struct {
+		query string
+		expt  []TrackID
+	}{
+		{"carpet", []TrackID{aladin}},
+		{"MAGICAL", []TrackID{aladin}},
+		{"bg7", []TrackID{john}},
+		{"n", []TrackID{john, aladin}},
+		{"normal special", []TrackID{aladin, john}},
+		{"john a", []TrackID{john, aladin}},
+		{"paraglider", []TrackID{}},
+	} {
+		req := httptest.NewRequest("GET", "/track/search?q="+url.QueryEscape(data.query), nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var ids []TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &ids); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(ids, data.expt) {
+			t.Errorf("expected search for '%s' to return '%v', got '%v'", data.query, data.expt, ids)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/track/search?q=carpet&scores=true", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var matches []TrackMatch
+	if err := json.Unmarshal(res.Body.Bytes(), &matches); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if len(matches) != 1 || matches[0].ID != aladin || matches[0].Score <= 0 {
+		t.Errorf("expected search with scores to return a positive score for '%d', got '%v'", aladin, matches)
+	}
+
+	for _, query := range []string{"", "?q=", "?q=%20%20"} {
+		req := httptest.NewRequest("GET", "/track/search"+query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected empty search '%s' to return 400, got '%d'", query, code)
+		}
+	}
+}
+
+// Test that two tracks are compared by length and duration, and that missing
+// or invalid ids are reported
+func TestIgcServerCompareTracks(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := trackMetasMap.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	aladin, john := testTrackMetas[0].ID, testTrackMetas[1].ID
+
+	for _, data := range []struct {
+		a, b TrackID
+		expt TrackComparison
+	}{
+		{aladin, john, TrackComparison{aladin, john, 1190, 5400, &aladin}},
+		{john, aladin, TrackComparison{john, aladin, -1190, -5400, &aladin}},
+		{john, john, TrackComparison{john, john, 0, 0, nil}},
+	} {
+		uri := fmt.Sprintf("/track/compare?a=%d&b=%d", data.a, data.b)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET %s` to return 200, got '%d'", uri, code)
+		}
+		var comparison TrackComparison
+		if err := json.Unmarshal(res.Body.Bytes(), &comparison); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(comparison, data.expt) {
+			t.Errorf("expected `GET %s` to return '%v', got '%v'", uri, data.expt, comparison)
+		}
+	}
+
+	for _, data := range []struct {
+		query string
+		code  int
+		msg   string
+	}{
+		{fmt.Sprintf("?a=%d&b=1", aladin), 404, "track 'b' not found"},
+		{fmt.Sprintf("?a=1&b=%d", john), 404, "track 'a' not found"},
+		{fmt.Sprintf("?b=%d", john), 400, "invalid id 'a'"},
+		{fmt.Sprintf("?a=%d&b=john", aladin), 400, "invalid id 'b'"},
+	} {
+		req := httptest.NewRequest("GET", "/track/compare"+data.query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `GET /track/compare%s` to return '%d', got '%d'", data.query, data.code, code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body["error"] != data.msg {
+			t.Errorf("expected `GET /track/compare%s` to report '%s', got '%s'", data.query, data.msg, res.Body)
+		}
+	}
+}
+
+// Test that all tracks are exported as csv with escaped fields
+func TestIgcServerGetTrackCSV(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	getRecords := func() [][]string {
+		req := httptest.NewRequest("GET", "/track.csv", nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if contentType := res.Header().Get("Content-Type"); contentType != "text/csv" {
+			t.Errorf("expected content type to be 'text/csv', got '%s'", contentType)
+		}
+		records, err := csv.NewReader(res.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("failed when trying to decode body as csv: %s", err)
+		}
+		return records
+	}
+
+	if records := getRecords(); len(records) != 1 || records[0][0] != "id" {
+		t.Fatalf("expected only a header row for an empty store, got '%v'", records)
+	}
+
+	testTrackMetas := makeIGCTestData("localhost")
+	testTrackMetas[0].Pilot = "Aladin, \"The Magnificent\""
+	for _, meta := range testTrackMetas {
+		if err := trackMetasMap.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	records := getRecords()
+	if len(records) != len(testTrackMetas)+1 {
+		t.Fatalf("expected '%d' rows, got '%d'", len(testTrackMetas)+1, len(records))
+	}
+	for i, meta := range testTrackMetas {
+		record := records[i+1]
+		if record[0] != strconv.Itoa(int(meta.ID)) || record[2] != meta.Pilot || record[3] != meta.Glider {
+			t.Errorf("expected row to contain '%v', got '%v'", meta, record)
+		}
+	}
+}
+
+// Test that preflight requests from allowed origins are answered
+func TestIgcServerCORSPreflight(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil, WithAllowedOrigins("http://allowed.com"))
+
+	req := httptest.NewRequest("OPTIONS", "/track", nil)
+	req.Header.Set("Origin", "http://allowed.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 204 {
+		t.Fatalf("expected preflight request to return 204 (no content), got '%d'", code)
+	}
+	for header, expt := range map[string]string{
+		"Access-Control-Allow-Origin":  "http://allowed.com",
+		"Access-Control-Allow-Methods": "GET, HEAD, POST, OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type, X-Request-ID",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := res.Header().Get(header); got != expt {
+			t.Errorf("expected header '%s' to be '%s', got '%s'", header, expt, got)
+		}
+	}
+
+	// Requests from other origins should not get any CORS headers
+	req = httptest.NewRequest("GET", "/track", nil)
+	req.Header.Set("Origin", "http://other.com")
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no allowed origin for unknown origin, got '%s'", got)
+	}
+}
+
+// Test that preflight requests allow the methods of the route, and allow the
+// `Authorization` header when the server accepts api keys or basic auth
+func TestIgcServerCORSPreflightAuth(t *testing.T) {
+	for _, opt := range []Option{WithAPIKeys("hunter2"), WithBasicAuth("admin", "hunter2")} {
+		trackMetasMap := NewTrackMetasMap()
+		server := NewServer(nil, &trackMetasMap, nil, nil, WithAllowedOrigins("http://allowed.com"), opt)
+
+		req := httptest.NewRequest("OPTIONS", "/track/1", nil)
+		req.Header.Set("Origin", "http://allowed.com")
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+		req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 204 {
+			t.Fatalf("expected preflight request to return 204 (no content), got '%d'", code)
+		}
+		for header, expt := range map[string]string{
+			"Access-Control-Allow-Methods":     "GET, HEAD, PATCH, DELETE, OPTIONS",
+			"Access-Control-Allow-Headers":     "Content-Type, X-Request-ID, Authorization",
+			"Access-Control-Allow-Credentials": "",
+		} {
+			if got := res.Header().Get(header); got != expt {
+				t.Errorf("expected header '%s' to be '%s', got '%s'", header, expt, got)
+			}
+		}
+	}
+}
+
+// Test that a wildcard allows requests from any origin
+func TestIgcServerCORSWildcard(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil, WithAllowedOrigins("*"))
+
+	req := httptest.NewRequest("GET", "/track", nil)
+	req.Header.Set("Origin", "http://any.com")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected request to return 200, got '%d'", code)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected allowed origin to be '*', got '%s'", got)
+	}
+}
+
+// Test that credentialed preflight requests echo the specific origin, and that
+// the preflight can be cached for the configured max age
+func TestIgcServerCORSCredentials(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil,
+		WithAllowedOrigins("http://a.com", "http://allowed.com"),
+		WithCORSCredentials(true),
+		WithCORSMaxAge(time.Hour),
+	)
+
+	req := httptest.NewRequest("OPTIONS", "/track", nil)
+	req.Header.Set("Origin", "http://allowed.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 204 {
+		t.Fatalf("expected preflight request to return 204 (no content), got '%d'", code)
+	}
+	for header, expt := range map[string]string{
+		"Access-Control-Allow-Origin":      "http://allowed.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Headers":     "Content-Type, X-Request-ID, Authorization",
+		"Access-Control-Max-Age":           "3600",
+	} {
+		if got := res.Header().Get(header); got != expt {
+			t.Errorf("expected header '%s' to be '%s', got '%s'", header, expt, got)
+		}
+	}
+
+	// Actual requests also allow credentials
+	req = httptest.NewRequest("GET", "/track", nil)
+	req.Header.Set("Origin", "http://a.com")
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "http://a.com" {
+		t.Errorf("expected allowed origin to be 'http://a.com', got '%s'", got)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("expected credentials to be allowed, got '%s'", got)
+	}
+
+	// Requests from other origins should not get any CORS headers
+	req = httptest.NewRequest("GET", "/track", nil)
+	req.Header.Set("Origin", "http://other.com")
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if got := res.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("expected credentials to not be allowed for unknown origin, got '%s'", got)
+	}
+}
+
+// Test that credentials are rejected together with the wildcard origin, and
+// that the max age of preflights can be left to the browser
+func TestIgcServerCORSConfig(t *testing.T) {
+	if err := ValidateCORS([]string{"http://a.com", "*"}, true); err != ErrCORSCredentialsWildcard {
+		t.Errorf("expected credentials with wildcard to be rejected, got '%v'", err)
+	}
+	if err := ValidateCORS([]string{"*"}, false); err != nil {
+		t.Errorf("expected wildcard without credentials to be valid, got '%v'", err)
+	}
+
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil,
+		WithAllowedOrigins("*"),
+		WithCORSCredentials(true),
+		WithCORSMaxAge(0),
+	)
+
+	req := httptest.NewRequest("OPTIONS", "/track", nil)
+	req.Header.Set("Origin", "http://any.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if got := res.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected allowed origin to be '*', got '%s'", got)
+	}
+	if got := res.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("expected credentials to be rejected with wildcard origin, got '%s'", got)
+	}
+	if got, ok := res.Header()["Access-Control-Max-Age"]; ok {
+		t.Errorf("expected no max age of preflight, got '%s'", got)
+	}
+}
+
+// Test that large responses are compressed and small responses are not
+func TestIgcServerGzip(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil, WithGzipThreshold(256))
+
+	appendTimedTracks(t, &trackMetasMap, time.Now(), 50)
+
+	req := httptest.NewRequest("GET", "/track", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	expt := res.Body.String()
+
+	req = httptest.NewRequest("GET", "/track", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip")
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if encoding := res.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("expected response to be gzip encoded, got '%s'", encoding)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatalf("unable to read gzip encoded response: %s", err)
+	}
+	got, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("unable to decompress response: %s", err)
+	}
+	if string(got) != expt {
+		t.Errorf("expected decompressed response to be '%s', got '%s'", expt, got)
+	}
+
+	req = httptest.NewRequest("GET", "/track/1232", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if encoding := res.Header().Get("Content-Encoding"); encoding != "" {
+		t.Errorf("expected small response to not be encoded, got '%s'", encoding)
+	}
+	if code := res.Result().StatusCode; code != 404 {
+		t.Errorf("expected small response to keep status '404', got '%d'", code)
+	}
+}
+
+// Test that json responses are indented if requested, while their content is
+// the same as the compact responses
+func TestIgcServerPrettyJSON(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		return res
+	}
+
+	compact := get(fmt.Sprintf("/track/%d", meta.ID))
+	pretty := get(fmt.Sprintf("/track/%d?pretty=true", meta.ID))
+
+	if compact.Code != 200 || pretty.Code != 200 {
+		t.Fatalf("expected both responses to return 200, got '%d' and '%d'", compact.Code, pretty.Code)
+	}
+	if strings.Contains(compact.Body.String(), "\n  ") {
+		t.Errorf("expected compact response to not be indented, got '%s'", compact.Body)
+	}
+	var expt bytes.Buffer
+	if err := json.Indent(&expt, compact.Body.Bytes(), "", "  "); err != nil {
+		t.Fatalf("unable to indent compact response: %s", err)
+	}
+	if got := pretty.Body.String(); got != expt.String() {
+		t.Errorf("expected pretty response to be '%s', got '%s'", expt.String(), got)
+	}
+	if !strings.HasPrefix(pretty.Body.String(), "{\n  \"") {
+		t.Errorf("expected pretty response to be indented with two spaces, got '%s'", pretty.Body)
+	}
+
+	// Errors are json as well, while other responses are left as is
+	if res := get("/track/abc?pretty=true"); res.Code != 400 || !strings.HasPrefix(res.Body.String(), "{\n  \"") {
+		t.Errorf("expected pretty error response, got '%d': '%s'", res.Code, res.Body)
+	}
+	plain := get(fmt.Sprintf("/track/%d/pilot", meta.ID))
+	prettyPlain := get(fmt.Sprintf("/track/%d/pilot?pretty=true", meta.ID))
+	if plain.Body.String() != prettyPlain.Body.String() {
+		t.Errorf("expected plain text response to be unchanged, got '%s' and '%s'", plain.Body, prettyPlain.Body)
+	}
+}
+
+// Test that every response has a request id, and that the id of the client is
+// reused
+func TestIgcServerRequestID(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if id := res.Header().Get("X-Request-ID"); id == "" {
+		t.Errorf("expected response to have a generated request id")
+	}
+
+	req = httptest.NewRequest("GET", "/rubbish", nil)
+	req.Header.Set("X-Request-ID", "client-id")
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if id := res.Header().Get("X-Request-ID"); id != "client-id" {
+		t.Errorf("expected request id to be 'client-id', got '%s'", id)
+	}
+}
+
+// Convenience function to scrape the value of a metric from GET /metrics
+func scrapeMetric(t *testing.T, server Server, name string) float64 {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	for _, line := range strings.Split(res.Body.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == name {
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				t.Fatalf("unable to parse value of metric '%s': %s", name, err)
+			}
+			return value
+		}
+	}
+	t.Fatalf("metric '%s' was not found in response '%s'", name, res.Body)
+	return 0
+}
+
+// Test that registering a track is counted in the metrics
+func TestIgcServerMetricsTracksRegistered(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	before := scrapeMetric(t, server, "paragliding_tracks_registered_total")
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected track to be registered, got '%d'", code)
+	}
+
+	after := scrapeMetric(t, server, "paragliding_tracks_registered_total")
+	if after != before+1 {
+		t.Errorf("expected registered tracks to increase from '%v' to '%v', got '%v'", before, before+1, after)
+	}
+}
+
+// Test that POST /track gives up on files which are served too slowly
+func TestIgcServerPostTrackTimeout(t *testing.T) {
+	slowserver := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Hang until the client gives up
+			<-r.Context().Done()
+		}),
+	)
+	defer slowserver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(slowserver.Client(), &trackMetasMap, nil, nil, WithFetchTimeout(50*time.Millisecond))
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", slowserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	code := res.Result().StatusCode
+	if code != http.StatusGatewayTimeout {
+		t.Fatalf("expected slow download to return 504 (gateway timeout), got '%d'", code)
+	}
+}
+
+// Test that registrations beyond the limit of concurrent fetches wait for a
+// free slot, and are rejected if no slot is freed in time
+func TestIgcServerPostTrackFetchConcurrency(t *testing.T) {
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slowserver := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Hang until the test releases the fetch
+			fetching <- struct{}{}
+			<-release
+			w.Write(content)
+		}),
+	)
+	defer slowserver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(2, &trackMetasMap)
+	webhooks := NewWebhooksMap()
+	server := NewServer(slowserver.Client(), &trackMetasMap, &ticker, &webhooks,
+		WithFetchConcurrency(1, 200*time.Millisecond))
+
+	post := func(file string) int {
+		body := fmt.Sprintf("{\"url\":\"%s\"}", slowserver.URL+file)
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		return res.Result().StatusCode
+	}
+
+	// Saturate the limit with a fetch which hangs
+	first := make(chan int)
+	go func() { first <- post("/first.igc") }()
+	<-fetching
+
+	if code := post("/second.igc"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected fetch beyond the limit to return 503, got '%d'", code)
+	}
+
+	// A fetch waiting for a slot gets it when the hanging fetch finishes
+	third := make(chan int)
+	go func() { third <- post("/third.igc") }()
+	close(release)
+	if code := <-first; code != 200 {
+		t.Errorf("expected fetch within the limit to return 200, got '%d'", code)
+	}
+	<-fetching
+	if code := <-third; code != 200 {
+		t.Errorf("expected fetch waiting for a slot to return 200, got '%d'", code)
+	}
+}
+
+// Test that tracks with the same content are only rejected if content based
+// deduplication is enabled
+func TestIgcServerPostTrackDuplicateContent(t *testing.T) {
+	for _, dedup := range []bool{true, false} {
+		server, fileserver := makeTestServers(WithContentDedup(dedup))
+		defer fileserver.Close()
+
+		var ids []TrackID
+		for _, file := range []string{"/test.igc", "/copy.igc"} {
+			body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+file)
+			req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			var data map[string]interface{}
+			if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+				t.Errorf("received response body: '%s'", res.Body)
+				t.Fatalf("failed when trying to decode body as json")
+			}
+			if code := res.Result().StatusCode; code == 200 {
+				ids = append(ids, TrackID(data["id"].(float64)))
+			} else if code != 403 || !dedup {
+				t.Fatalf("expected '%s' to be registered or rejected as duplicate, got '%d'", file, code)
+			} else if TrackID(data["id"].(float64)) != ids[0] {
+				t.Errorf("expected id of existing track '%d' in response, got '%v'", ids[0], data["id"])
+			}
+		}
+
+		if expt := map[bool]int{true: 1, false: 2}[dedup]; len(ids) != expt {
+			t.Errorf("expected '%d' tracks to be registered (dedup: %t), got '%d'", expt, dedup, len(ids))
+		}
+	}
+}
+
+// Test valid POST /track
+func TestIgcServerPostTrackValid(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	req = httptest.NewRequest("GET", "/track", nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var respData []TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &respData); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	for _, gotID := range respData {
+		if gotID == data["id"] {
+			return
+		}
+	}
+	t.Fatalf("id of inserted track ('%d') was not found in ids returned from `GET /track` ('%d')", data["id"], respData)
+}
+
+// Test valid POST /track
+func TestIgcServerPostTrackValidDuplicate(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	req = httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+
+	code := res.Result().StatusCode
+	if code != 403 {
+		t.Fatalf("expected attempt to register same file twice to result in 403, got '%d'", code)
+	}
+
+}
+
+// Test POST /track of the same url twice with each duplicate policy
+func TestIgcServerPostTrackDuplicatePolicy(t *testing.T) {
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read 'test.igc': %s", err)
+	}
+	// The file is changed after the first fetch
+	changed := bytes.Replace(content, []byte("HFDTE190216"), []byte("HFDTE010317"), 1)
+
+	for _, data := range []struct {
+		policy   DuplicatePolicy
+		code     int
+		exptDate time.Time
+	}{
+		{"", http.StatusForbidden, time.Date(2016, 2, 19, 0, 0, 0, 0, time.UTC)},
+		{DuplicateReject, http.StatusForbidden, time.Date(2016, 2, 19, 0, 0, 0, 0, time.UTC)},
+		{DuplicateUpsert, http.StatusOK, time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		fetches := 0
+		fileserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fetches++; fetches == 1 {
+				w.Write(content)
+			} else {
+				w.Write(changed)
+			}
+		}))
+		defer fileserver.Close()
+
+		trackMetasMap := NewTrackMetasMap()
+		ticker := NewTickerDummy(2, &trackMetasMap)
+		webhooks := NewWebhooksMap()
+		server := NewServer(fileserver.Client(), &trackMetasMap, &ticker, &webhooks, WithDuplicatePolicy(data.policy))
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		var ids []TrackID
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			if i == 0 && res.Code != http.StatusOK {
+				t.Fatalf("expected first registration to succeed, got '%d'", res.Code)
+			} else if i == 1 && res.Code != data.code {
+				t.Errorf("expected second registration with policy '%s' to give '%d', got '%d'", data.policy, data.code, res.Code)
+			}
+			if res.Code == http.StatusOK {
+				var resp map[string]TrackID
+				if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+					t.Errorf("received response body: '%s'", res.Body)
+					t.Fatalf("failed when trying to decode body as json")
+				}
+				ids = append(ids, resp["id"])
+			}
+		}
+		if len(ids) == 2 && ids[0] != ids[1] {
+			t.Errorf("expected upsert to respond with existing id '%d', got '%d'", ids[0], ids[1])
+		}
+
+		if n, _ := trackMetasMap.Len(); n != 1 {
+			t.Errorf("expected a single track with policy '%s', got '%d'", data.policy, n)
+		}
+		meta, err := trackMetasMap.Get(ids[0])
+		if err != nil {
+			t.Fatalf("unable to get registered track: %s", err)
+		}
+		if !meta.Date.Equal(data.exptDate) {
+			t.Errorf("expected date of track with policy '%s' to be '%s', got '%s'", data.policy, data.exptDate, meta.Date)
+		}
+	}
+}
+
+// Test that the same url is only rejected within the duplicate window, and
+// registered as a new track after it
+func TestIgcServerPostTrackDuplicateWindow(t *testing.T) {
+	window := 100 * time.Millisecond
+	server, fileserver := makeTestServers(WithDuplicateWindow(window))
+	defer fileserver.Close()
+
+	srcURL := fileserver.URL + "/test.igc"
+	register := func(srcURL string) (int, TrackID) {
+		body := fmt.Sprintf("{\"url\":\"%s\"}", srcURL)
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var data map[string]TrackID
+		if res.Code == http.StatusOK {
+			if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+				t.Errorf("received response body: '%s'", res.Body)
+				t.Fatalf("failed when trying to decode body as json")
+			}
+		}
+		return res.Code, data["id"]
+	}
+
+	seen := make(map[TrackID]bool)
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(window + 20*time.Millisecond)
+		}
+		code, id := register(srcURL)
+		if code != http.StatusOK {
+			t.Fatalf("expected registration after the window to give 200, got '%d'", code)
+		}
+		if seen[id] {
+			t.Errorf("expected registration after the window to give a new id, got '%d' again", id)
+		}
+		seen[id] = true
+
+		meta, err := server.tracks.Get(id)
+		if err != nil {
+			t.Fatalf("unable to get registered track: %s", err)
+		}
+		if meta.TrackSrcURL != srcURL {
+			t.Errorf("expected source url of registration '%d' to be '%s', got '%s'", i+1, srcURL, meta.TrackSrcURL)
+		}
+
+		// Re-uploads within the window are suppressed
+		if code, _ := register(srcURL); code != http.StatusForbidden {
+			t.Errorf("expected registration within the window to give 403, got '%d'", code)
+		}
+	}
+
+	// Urls which happen to look like a later registration are unrelated
+	if code, id := register(srcURL + "#2"); code != http.StatusOK || seen[id] {
+		t.Errorf("expected url with a fragment to be registered as a new track, got '%d' with id '%d'", code, id)
+	}
+	if n, _ := server.tracks.Len(); n != 4 {
+		t.Errorf("expected four tracks, got '%d'", n)
+	}
+}
+
+// Test GET /track
+func TestIgcServerGetTrack(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	ids := make([]TrackID, 0, len(testTrackMetas))
+	for _, trackMeta := range testTrackMetas {
+		err := server.tracks.Append(trackMeta)
+		if err != nil {
+			t.Errorf("unable to add metadata: %s", err)
+			continue
+		}
+		ids = append(ids, trackMeta.ID)
+	}
+
+	req := httptest.NewRequest("GET", "/track", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data []TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+outer:
+	for _, exptID := range ids {
+		for _, gotID := range data {
+			if TrackID(gotID) == exptID {
+				continue outer
+			}
+		}
+		t.Errorf("id of inserted track ('%d') was not found in ids returned from `GET /track` ('%d')", exptID, data)
+	}
+}
+
+// Test GET /track?limit=<limit>&offset=<offset>
+func TestIgcServerGetTrackPaginated(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	const trackCount = 5
+	start := time.Now()
+	ids := make([]TrackID, trackCount)
+	for i := range ids {
+		meta := TrackMeta{
+			ID:        NewTrackID([]byte(strconv.Itoa(i))),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}
+		if err := server.tracks.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+		ids[i] = meta.ID
+	}
+
+	for _, page := range []struct {
+		query string
+		expt  []TrackID
+	}{
+		{"", ids},
+		{"?limit=2", ids[:2]},
+		{"?limit=2&offset=2", ids[2:4]},
+		{"?limit=2&offset=4", ids[4:]},
+		{"?offset=3", ids[3:]},
+		{"?offset=5", []TrackID{}},
+		{"?limit=3&offset=100", []TrackID{}},
+	} {
+		req := httptest.NewRequest("GET", "/track"+page.query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var data []TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if data == nil {
+			t.Errorf("expected `GET /track%s` to return an array, got null", page.query)
+		}
+		if !cmp.Equal(page.expt, data) {
+			t.Errorf("unexpected ids when `GET /track%s`, expected '%d' but got '%d'", page.query, page.expt, data)
+		}
+		total := res.Result().Header.Get("X-Total-Count")
+		if total != strconv.Itoa(trackCount) {
+			t.Errorf("expected `X-Total-Count` to be '%d', got '%s'", trackCount, total)
+		}
+	}
+
+	for _, query := range []string{"?limit=a", "?offset=-1", "?limit=-5&offset=1"} {
+		req := httptest.NewRequest("GET", "/track"+query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected `GET /track%s` to return 400, got '%d'", query, code)
+		}
+	}
+}
+
+// Test GET /track?pilot=<pilot>&glider=<glider>
+func TestIgcServerGetTrackFiltered(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for i, trackMeta := range testTrackMetas {
+		// Make sure the order of the tracks is deterministic
+		trackMeta.Timestamp = trackMeta.Timestamp.Add(time.Duration(i) * time.Second)
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	aladin, john := testTrackMetas[0].ID, testTrackMetas[1].ID
+
+	for _, data := range []struct {
+		query string
+		expt  []TrackID
+	}{
+		{"", []TrackID{aladin, john}},
+		{"?pilot=", []TrackID{aladin, john}},
+		{"?pilot=john", []TrackID{john}},
+		{"?pilot=ALADIN", []TrackID{aladin}},
+		{"?pilot=lad", []TrackID{aladin}},
+		{"?glider=carpet", []TrackID{aladin}},
+		{"?glider=boeng", []TrackID{john}},
+		{"?pilot=n&glider=Boeng", []TrackID{john}},
+		{"?pilot=john&glider=carpet", []TrackID{}},
+		{"?pilot=nobody", []TrackID{}},
+		{"?tag=xc", []TrackID{aladin}},
+		{"?tag=XC", []TrackID{}},
+		{"?tag=x", []TrackID{}},
+		{"?tag=magic&pilot=aladin", []TrackID{aladin}},
+		{"?tag=magic&pilot=john", []TrackID{}},
+	} {
+		req := httptest.NewRequest("GET", "/track"+data.query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var ids []TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &ids); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if ids == nil {
+			t.Errorf("expected `GET /track%s` to return an array, got null", data.query)
+		}
+		if !cmp.Equal(data.expt, ids) {
+			t.Errorf("unexpected ids when `GET /track%s`, expected '%d' but got '%d'", data.query, data.expt, ids)
+		}
+	}
+}
+
+// Test GET /track?since=<timestamp>
+func TestIgcServerGetTrackSince(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	testTrackMetas := []TrackMeta{
+		{ID: 10, Timestamp: start, Pilot: "John"},
+		{ID: 20, Timestamp: start.Add(time.Millisecond), Pilot: "Jane"},
+		{ID: 30, Timestamp: start.Add(2 * time.Millisecond), Pilot: "John"},
+	}
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	ms := start.UnixNano() / int64(time.Millisecond)
+
+	for _, data := range []struct {
+		query string
+		expt  []TrackID
+	}{
+		{fmt.Sprintf("?since=%d", ms-1), []TrackID{10, 20, 30}},
+		{fmt.Sprintf("?since=%d", ms), []TrackID{20, 30}},
+		{fmt.Sprintf("?since=%d", ms+1), []TrackID{30}},
+		{fmt.Sprintf("?since=%d", ms+2), []TrackID{}},
+		{fmt.Sprintf("?since=%d&pilot=john", ms), []TrackID{30}},
+		{"?since=" + start.Format(time.RFC3339), []TrackID{20, 30}},
+	} {
+		req := httptest.NewRequest("GET", "/track"+data.query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var ids []TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &ids); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(data.expt, ids) {
+			t.Errorf("unexpected ids when `GET /track%s`, expected '%d' but got '%d'", data.query, data.expt, ids)
+		}
+	}
+
+	for _, query := range []string{"?since=abc", "?since=12.5", "?since=2018-10-15"} {
+		req := httptest.NewRequest("GET", "/track"+query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("expected status '%d' when `GET /track%s`, got '%d'", http.StatusBadRequest, query, res.Code)
+		}
+	}
+}
+
+// Test GET /track?sort=<field>&order=<order>
+func TestIgcServerGetTrackSorted(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	testTrackMetas := []TrackMeta{
+		{ID: 10, Timestamp: start, Date: start.AddDate(0, 0, -1), Pilot: "John", TrackLength: 50},
+		{ID: 20, Timestamp: start.Add(time.Second), Date: start.AddDate(0, 0, -3), Pilot: "Jane", TrackLength: 150},
+		{ID: 30, Timestamp: start.Add(2 * time.Second), Date: start.AddDate(0, 0, -2), Pilot: "John", TrackLength: 100},
+		{ID: 5, Timestamp: start.Add(3 * time.Second), Date: start.AddDate(0, 0, -4), Pilot: "Jane", TrackLength: 100},
+	}
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	for _, data := range []struct {
+		query string
+		expt  []TrackID
+	}{
+		{"?sort=track_length", []TrackID{10, 5, 30, 20}},
+		{"?sort=track_length&order=asc", []TrackID{10, 5, 30, 20}},
+		{"?sort=track_length&order=desc", []TrackID{20, 5, 30, 10}},
+		{"?sort=H_date", []TrackID{5, 20, 30, 10}},
+		{"?sort=timestamp&order=desc", []TrackID{5, 30, 20, 10}},
+		{"?sort=track_length&order=desc&pilot=john", []TrackID{30, 10}},
+		{"?sort=track_length&limit=2&offset=1", []TrackID{5, 30}},
+	} {
+		req := httptest.NewRequest("GET", "/track"+data.query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var ids []TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &ids); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(data.expt, ids) {
+			t.Errorf("unexpected ids when `GET /track%s`, expected '%d' but got '%d'", data.query, data.expt, ids)
+		}
+	}
+
+	for _, query := range []string{"?sort=pilot", "?sort=asdf", "?sort=track_length&order=up"} {
+		req := httptest.NewRequest("GET", "/track"+query, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected `GET /track%s` to return 400, got '%d'", query, code)
+		}
+	}
+}
+
+// Test valid GET /track/<id>
+func TestIgcServerGetTrackByIdValid(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	ids := make([]TrackID, 0, len(testTrackMetas))
+	for _, trackMeta := range testTrackMetas {
+		err := server.tracks.Append(trackMeta)
+		if err != nil {
+			t.Errorf("unable to add metadata: %s", err)
+			continue
+		}
+		ids = append(ids, trackMeta.ID)
+	}
+
+	for i, id := range ids {
+		uri := fmt.Sprintf("/track/%d", id)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var data TrackMeta
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		expt, _ := json.MarshalIndent(testTrackMetas[i], "", "  ")
+		got, _ := json.MarshalIndent(data, "", "  ")
+		if !cmp.Equal(expt, got) {
+			t.Errorf("returned track was not equal to inserted track:\n\nrequested id: %d\nexpected:\n%s\n\nreturned:\n%s", id, expt, got)
+		}
+	}
+}
+
+// Test bad GET /track/<id>
+// Test that HEAD responds with the same status and headers as GET, but
+// without a body
+func TestIgcServerHead(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, data := range []struct {
+		uri  string
+		code int
+	}{
+		{"/", 200},
+		{"/track", 200},
+		{fmt.Sprintf("/track/%d", meta.ID), 200},
+		{"/track/1232", 404},
+	} {
+		req := httptest.NewRequest("GET", data.uri, nil)
+		get := httptest.NewRecorder()
+		server.ServeHTTP(get, req)
+
+		req = httptest.NewRequest("HEAD", data.uri, nil)
+		head := httptest.NewRecorder()
+		server.ServeHTTP(head, req)
+
+		if head.Code != data.code {
+			t.Errorf("expected `HEAD %s` to return '%d', got '%d'", data.uri, data.code, head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("expected `HEAD %s` to have an empty body, got '%s'", data.uri, head.Body)
+		}
+		if length := head.Header().Get("Content-Length"); length != strconv.Itoa(get.Body.Len()) {
+			t.Errorf("expected `HEAD %s` to have Content-Length '%d', got '%s'", data.uri, get.Body.Len(), length)
+		}
+		if contentType := head.Header().Get("Content-Type"); contentType != get.Header().Get("Content-Type") {
+			t.Errorf("expected `HEAD %s` to have Content-Type '%s', got '%s'", data.uri, get.Header().Get("Content-Type"), contentType)
+		}
+	}
+}
+
+// Test that tracks are returned as xml if the client prefers it, and as json
+// otherwise
+func TestIgcServerGetTrackByIdNegotiation(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	meta.Date = meta.Date.Truncate(time.Second)
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, data := range []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/json", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", "application/xml"},
+		{"application/xml;q=0.5, application/json", "application/json"},
+	} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d", meta.ID), nil)
+		if data.accept != "" {
+			req.Header.Set("Accept", data.accept)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if contentType := res.Header().Get("Content-Type"); contentType != data.contentType {
+			t.Errorf("expected Accept '%s' to return '%s', got '%s'", data.accept, data.contentType, contentType)
+			continue
+		}
+
+		var got TrackMeta
+		var err error
+		if data.contentType == "application/xml" {
+			err = xml.Unmarshal(res.Body.Bytes(), &got)
+		} else {
+			err = json.Unmarshal(res.Body.Bytes(), &got)
+		}
+		if err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as '%s'", data.contentType)
+		}
+		if got.Pilot != meta.Pilot || !got.Date.Equal(meta.Date) || got.TrackLength != meta.TrackLength {
+			t.Errorf("expected track '%v' as '%s', got '%v'", meta, data.contentType, got)
+		}
+	}
+}
+
+// Test that requesting a track with the returned ETag gives 304 (not modified)
+// until the track is changed
+func TestIgcServerGetTrackByIdETag(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	uri := fmt.Sprintf("/track/%d", meta.ID)
+
+	getTrack := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+		return res
+	}
+
+	res := getTrack("")
+	etag := res.Header().Get("ETag")
+	if res.Result().StatusCode != 200 || etag == "" {
+		t.Fatalf("expected track to be returned with an ETag, got '%d' with ETag '%s'", res.Result().StatusCode, etag)
+	}
+
+	for _, data := range []struct {
+		ifNoneMatch string
+		code        int
+	}{
+		{etag, 304},
+		{"W/" + etag, 304},
+		{"\"other\", " + etag, 304},
+		{"*", 304},
+		{"\"other\"", 200},
+	} {
+		res := getTrack(data.ifNoneMatch)
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected If-None-Match '%s' to return '%d', got '%d'", data.ifNoneMatch, data.code, code)
+		}
+		if data.code == 304 && res.Body.Len() != 0 {
+			t.Errorf("expected 304 response to have no body, got '%s'", res.Body)
+		}
+	}
+
+	trackMetasMap.Update(meta.ID, func(meta *TrackMeta) {
+		meta.Pilot = "Jasmine"
+	})
+	if code := getTrack(etag).Result().StatusCode; code != 200 {
+		t.Errorf("expected changed track to return 200, got '%d'", code)
+	}
+}
+
+// Test that requesting a track with If-Modified-Since gives 304 (not modified)
+// if the track was registered before the given time
+func TestIgcServerGetTrackByIdLastModified(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	meta.Timestamp = time.Date(2018, 10, 15, 12, 0, 0, 500, time.UTC)
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, data := range []struct {
+		ifModifiedSince string
+		code            int
+	}{
+		{"", 200},
+		{"Mon, 15 Oct 2018 11:59:59 GMT", 200},
+		{"Mon, 15 Oct 2018 12:00:00 GMT", 304},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 304},
+		{"invalid date", 200},
+	} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d", meta.ID), nil)
+		if data.ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", data.ifModifiedSince)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected If-Modified-Since '%s' to return '%d', got '%d'", data.ifModifiedSince, data.code, code)
+		}
+		if lastModified := res.Header().Get("Last-Modified"); lastModified != "Mon, 15 Oct 2018 12:00:00 GMT" {
+			t.Errorf("expected Last-Modified to be the time the track was registered, got '%s'", lastModified)
+		}
+	}
+}
+
+func TestIgcServerGetTrackByIdBad(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	for _, badID := range []struct {
+		int
+		string
+	}{
+		{400, "aaaabbbb"},
+		{400, "aaaabbbb/asdfa"},
+		{400, "bad"},
+		{400, "aøaskdljflkasdjfløjsdaf"},
+		{400, "12312o3123"},
+		{400, "--asdf--"},
+		{400, "a"},
+		{404, "1232"},
+		{404, "99999"},
+	} {
+		req := httptest.NewRequest("GET", "/track/"+badID.string, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != badID.int {
+			t.Errorf("expected `GET /track/%s` to return '%d', got '%d'", badID.string, badID.int, code)
+		}
+	}
+}
+
+// Test valid GET /track/<id>/<field>
+func TestIgcServerGetTrackFieldValid(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	ids := make([]TrackID, 0, len(testTrackMetas))
+	for _, trackMeta := range testTrackMetas {
+		err := server.tracks.Append(trackMeta)
+		if err != nil {
+			t.Errorf("unable to add metadata: %s", err)
+			continue
+		}
+		ids = append(ids, trackMeta.ID)
+	}
+
+	for i, id := range ids {
+		// Encode and decode struct to make indexing easier
+		exptJSON, _ := json.Marshal(testTrackMetas[i])
+		var expt map[string]interface{}
+		json.Unmarshal(exptJSON, &expt)
+
+		for _, field := range []string{
+			"pilot",
+			"glider",
+			"glider_id",
+			"track_src_url",
+		} {
+			uri := fmt.Sprintf("/track/%d/%s", id, field)
+			req := httptest.NewRequest("GET", uri, nil)
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			got, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("error when reading body: %s", err)
+			}
+			if string(got) != expt[field] {
+				t.Errorf("unexpected field when `GET /track/%d/%s`, got '%s' but expected '%s'", id, field, got, expt[field])
+			}
+
+		}
+		for _, field := range []string{
+			"H_date",
+			"track_length",
+			"max_altitude_gain",
+			"duration",
+			"avg_speed",
+		} {
+			uri := fmt.Sprintf("/track/%d/%s", id, field)
+			req := httptest.NewRequest("GET", uri, nil)
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			got, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("error when reading body: %s", err)
+			}
+			if got == nil {
+				t.Errorf("empty field when `GET /track/%d/%s`", id, field)
+			}
+			if string(got) == "" {
+				t.Errorf("empty string when `GET /track/%d/%s`", id, field)
+			}
+		}
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d/avg_speed", id), nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if expt := strconv.FormatFloat(testTrackMetas[i].AvgSpeed, 'f', -1, 64); res.Body.String() != expt {
+			t.Errorf("unexpected average speed when `GET /track/%d/avg_speed`, got '%s' but expected '%s'", id, res.Body, expt)
+		}
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d/bbox", id), nil)
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var bbox BoundingBox
+		if err := json.Unmarshal(res.Body.Bytes(), &bbox); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if bbox != testTrackMetas[i].BBox {
+			t.Errorf("unexpected bounding box when `GET /track/%d/bbox`, got '%v' but expected '%v'", id, bbox, testTrackMetas[i].BBox)
+		}
+	}
+}
+
+// Test that all json fields of a track are listed, and that all the listed
+// fields can be fetched
+func TestIgcServerGetTrackFields(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d/fields", meta.ID), nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var fields []string
+	if err := json.Unmarshal(res.Body.Bytes(), &fields); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	typ := reflect.TypeOf(TrackMeta{})
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		found := false
+		for _, field := range fields {
+			found = found || field == tag
+		}
+		if !found {
+			t.Errorf("expected field '%s' to be listed in '%v'", tag, fields)
+		}
+	}
+
+	for _, field := range fields {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d/%s", meta.ID, field), nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Errorf("expected listed field '%s' to return 200, got '%d'", field, code)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/track/1232/fields", nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 {
+		t.Errorf("expected unknown id to return 404, got '%d'", code)
+	}
+}
+
+// Test that unknown ids are checked before unknown fields
+func TestIgcServerGetTrackFieldStatus(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	server.points.Set(meta.ID, []igc.Point{igc.NewPoint()})
+
+	for _, data := range []struct {
+		id    TrackID
+		field string
+		code  int
+	}{
+		{meta.ID, "pilot", 200},
+		{meta.ID, "asdf", 400},
+		{meta.ID, "takeoff", 204},
+		{meta.ID, "landing", 204},
+		{1232, "pilot", 404},
+		{1232, "asdf", 404},
+		{1232, "takeoff", 404},
+		{1232, "landing", 404},
+	} {
+		uri := fmt.Sprintf("/track/%d/%s", data.id, data.field)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", uri, data.code, code)
+		}
+	}
+}
+
+// Test that the track length can be returned in different units
+func TestIgcServerGetTrackLengthUnit(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, data := range []struct {
+		query string
+		code  int
+		expt  string
+	}{
+		{"", 200, "1200"},
+		{"?unit=km", 200, "1200"},
+		{"?unit=m", 200, "1200000"},
+		{"?unit=mi", 400, ""},
+	} {
+		uri := fmt.Sprintf("/track/%d/track_length%s", meta.ID, data.query)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != data.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", uri, data.code, code)
+		} else if code == 200 && res.Body.String() != data.expt {
+			t.Errorf("expected `GET %s` to return '%s', got '%s'", uri, data.expt, res.Body)
+		}
+	}
+}
+
+// Test POST /track with tags, which are returned by GET /track/<id>/tags and
+// can be used to filter GET /track
+func TestIgcServerPostTrackTags(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	tooLong := strings.Repeat("a", maxTagLength+1)
+	tooMany := strings.TrimSuffix(strings.Repeat(`"a",`, maxTrackTags+1), ",")
+	for _, tags := range []string{
+		`[""]`,
+		`["xc", "  "]`,
+		`["` + tooLong + `"]`,
+		`[` + tooMany + `]`,
+		`"xc"`,
+	} {
+		body := fmt.Sprintf(`{"url":"%s","tags":%s}`, fileserver.URL+"/test.igc", tags)
+		req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("expected tags '%s' to be rejected with '%d', got '%d'", tags, http.StatusBadRequest, res.Code)
+		}
+	}
+
+	var ids []TrackID
+	for _, data := range []struct {
+		file string
+		tags string
+	}{
+		{"/test.igc", `["comp2024", " xc", "xc", "` + strings.Repeat("b", maxTagLength) + `"]`},
+		{"/copy.igc", `null`},
+	} {
+		body := fmt.Sprintf(`{"url":"%s","tags":%s}`, fileserver.URL+data.file, data.tags)
+		req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var resp map[string]TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		ids = append(ids, resp["id"])
+	}
+
+	for i, expt := range [][]string{
+		{"comp2024", "xc", strings.Repeat("b", maxTagLength)},
+		{},
+	} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d/tags", ids[i]), nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var tags []string
+		if err := json.Unmarshal(res.Body.Bytes(), &tags); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(tags, expt) {
+			t.Errorf("expected tags of '%d' to be '%v', got '%v'", ids[i], expt, tags)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/track?tag=xc", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var tagged []TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &tagged); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if expt := ids[:1]; !cmp.Equal(tagged, expt) {
+		t.Errorf("expected tracks tagged 'xc' to be '%v', got '%v'", expt, tagged)
+	}
+}
+
+// Test GET /track/<id>/H_date?format=<format>
+func TestIgcServerGetTrackDateFormat(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	meta.Date = time.Date(2016, 2, 19, 0, 0, 0, 0, time.UTC)
+	if err := trackMetasMap.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, data := range []struct {
+		query string
+		code  int
+		expt  string
+	}{
+		{"", 200, "2016-02-19T00:00:00Z"},
+		{"?format=rfc3339", 200, "2016-02-19T00:00:00Z"},
+		{"?format=date", 200, "2016-02-19"},
+		{"?format=unix", 200, "1455840000"},
+		{"?format=RFC3339", 400, ""},
+		{"?format=iso", 400, ""},
+	} {
+		uri := fmt.Sprintf("/track/%d/H_date%s", meta.ID, data.query)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != data.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", uri, data.code, code)
+		} else if code == 200 && res.Body.String() != data.expt {
+			t.Errorf("expected `GET %s` to return '%s', got '%s'", uri, data.expt, res.Body)
+		}
+	}
+}
+
+// Test bad GET /track/<id>/<field>
+func TestIgcServerGetTrackFieldBad(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	ids := make([]TrackID, 0, len(testTrackMetas))
+	for _, trackMeta := range testTrackMetas {
+		err := server.tracks.Append(trackMeta)
+		if err != nil {
+			t.Errorf("unable to add metadata: %s", err)
+			continue
+		}
+		ids = append(ids, trackMeta.ID)
+	}
+
+	var unknownID TrackID
+
+	// It is a rare occurrence, but make sure that the unknown id NEVER can be
+	// equal to a generated id
+outer:
+	for {
+		unknownID = TrackID(rand.Int())
+		for _, id := range ids {
+			if unknownID == id {
+				continue outer
+			}
+		}
+		break
+	}
+
+	for _, data := range []struct {
+		code  int
+		field string
+		id    TrackID
+	}{
+		{400, "asdlfkjaksl", ids[0]},
+		{400, "aasdf90123", ids[0]},
+		{400, "12312", ids[1]},
+		{400, "--..s.a", ids[1]},
+		{404, "asdf", unknownID},
+	} {
+		uri := fmt.Sprintf("/track/%d/%s", data.id, data.field)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != data.code {
+			t.Fatalf("expected `GET /track/%d/%s` to return '%d', got '%d'", data.id, data.field, data.code, code)
+		}
+	}
+}
+
+// Test GET /track/count
+func TestIgcServerGetTrackCount(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	count := func() int {
+		req := httptest.NewRequest("GET", "/track/count", nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET /track/count` to return 200, got '%d'", code)
+		}
+		var data map[string]int
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		return data["count"]
+	}
+
+	if n := count(); n != 0 {
+		t.Errorf("expected '0' tracks initially, got '%d'", n)
+	}
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for i, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+		if n := count(); n != i+1 {
+			t.Errorf("expected '%d' tracks after appending, got '%d'", i+1, n)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/track/%d", testTrackMetas[0].ID), nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if n := count(); n != len(testTrackMetas)-1 {
+		t.Errorf("expected '%d' tracks after deleting, got '%d'", len(testTrackMetas)-1, n)
+	}
+
+	// Numeric ids are still routed to the track
+	req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d", testTrackMetas[1].ID), nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Errorf("expected `GET /track/<id>` to return 200, got '%d'", code)
+	}
+}
+
+// Test DELETE /track when clearing is enabled
+func TestIgcServerClearTracks(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil, WithTrackClearing(true))
 
 	testTrackMetas := makeIGCTestData("localhost")
-	ids := make([]TrackID, 0, len(testTrackMetas))
 	for _, trackMeta := range testTrackMetas {
-		err := server.tracks.Append(trackMeta)
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", "/track", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]int
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if data["deleted"] != len(testTrackMetas) {
+		t.Errorf("expected '%d' deleted tracks, got '%d'", len(testTrackMetas), data["deleted"])
+	}
+
+	ids, _ := server.tracks.GetAllIDs()
+	if len(ids) != 0 {
+		t.Errorf("expected no tracks after clearing, got '%d'", ids)
+	}
+}
+
+// Test DELETE /track when clearing is disabled (default) -> 405
+func TestIgcServerClearTracksDisabled(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", "/track", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 405 {
+		t.Fatalf("expected `DELETE /track` to return 405 when clearing is disabled, got '%d'", code)
+	}
+	ids, _ := server.tracks.GetAllIDs()
+	if len(ids) != len(testTrackMetas) {
+		t.Errorf("expected tracks to be untouched when clearing is disabled, got '%d'", ids)
+	}
+}
+
+// Test valid DELETE /track/<id>
+func TestIgcServerDeleteTrackValid(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	uri := fmt.Sprintf("/track/%d", testTrackMetas[0].ID)
+	req := httptest.NewRequest("DELETE", uri, nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `DELETE %s` to return 200, got '%d'", uri, code)
+	}
+	var data TrackMeta
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	expt, _ := json.MarshalIndent(testTrackMetas[0], "", "  ")
+	got, _ := json.MarshalIndent(data, "", "  ")
+	if !cmp.Equal(expt, got) {
+		t.Errorf("returned track was not equal to deleted track:\n\nexpected:\n%s\n\nreturned:\n%s", expt, got)
+	}
+
+	req = httptest.NewRequest("GET", uri, nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 {
+		t.Fatalf("expected `GET %s` of deleted track to return 404, got '%d'", uri, code)
+	}
+}
+
+// Test that the points and raw content of a track evicted by a storage with a
+// capacity are forgotten along with the track
+func TestIgcServerEvictedTrack(t *testing.T) {
+	igcFileServer := makeIgcFileServer()
+	igcFileServer.Start()
+	defer igcFileServer.Close()
+
+	trackMetasMap := NewTrackMetasMapWithCapacity(1)
+	ticker := NewTickerDummy(2, &trackMetasMap)
+	webhooks := NewWebhooksMap()
+	server := NewServer(igcFileServer.Client(), &trackMetasMap, &ticker, &webhooks, WithRawTrackStorage(true))
+
+	ids := make([]TrackID, 0, 2)
+	for _, path := range []string{"/test.igc", "/copy.igc"} {
+		body, _ := json.Marshal(map[string]string{"url": igcFileServer.URL + path})
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader(body))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `POST /track` of '%s' to return 200, got '%d'", path, code)
+		}
+		var data struct {
+			ID TrackID `json:"id"`
+		}
+		json.Unmarshal(res.Body.Bytes(), &data)
+		ids = append(ids, data.ID)
+	}
+
+	if _, err := server.tracks.Get(ids[0]); err != ErrTrackNotFound {
+		t.Errorf("expected first track to be evicted, got '%v'", err)
+	}
+	if _, ok := server.points.Get(ids[0]); ok {
+		t.Error("expected points of evicted track to be forgotten")
+	}
+	if _, ok := server.raws.Get(ids[0]); ok {
+		t.Error("expected raw content of evicted track to be forgotten")
+	}
+	if n := server.points.Len(); n != 1 {
+		t.Errorf("expected points of '1' track to be kept, got '%d'", n)
+	}
+	if n := server.raws.Len(); n != 1 {
+		t.Errorf("expected raw content of '1' track to be kept, got '%d'", n)
+	}
+}
+
+// Test that GET /track/<id> gives the fields in the requested casing
+func TestIgcServerGetTrackByIdCase(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d%s", meta.ID, query), nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		return res
+	}
+
+	// Snake case is the default, which decodes into the track meta
+	for _, query := range []string{"", "?case=snake"} {
+		res := get(query)
+		var actual TrackMeta
+		if err := json.Unmarshal(res.Body.Bytes(), &actual); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		actual.ID = meta.ID
+		actual.Timestamp = meta.Timestamp
+		if !cmp.Equal(actual, meta) {
+			t.Errorf("expected `GET /track/<id>%s` to give the track meta: %s", query, cmp.Diff(meta, actual))
+		}
+		if !strings.Contains(res.Body.String(), `"glider_id"`) {
+			t.Errorf("expected `GET /track/<id>%s` to have snake case fields, got '%s'", query, res.Body)
+		}
+	}
+
+	// Camel case gives the same values with renamed fields
+	res := get("?case=camel")
+	var actual struct {
+		Date            time.Time `json:"HDate"`
+		Pilot           string    `json:"pilot"`
+		GliderID        string    `json:"gliderId"`
+		TrackLength     float64   `json:"trackLength"`
+		TrackSrcURL     string    `json:"trackSrcUrl"`
+		MaxAltitudeGain int64     `json:"maxAltitudeGain"`
+		AvgSpeed        float64   `json:"avgSpeed"`
+		BBox            struct {
+			MinLat float64 `json:"minLat"`
+			MaxLon float64 `json:"maxLon"`
+		} `json:"bbox"`
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &actual); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	json.Unmarshal(res.Body.Bytes(), &fields)
+	for field := range fields {
+		if strings.Contains(field, "_") {
+			t.Errorf("expected field '%s' to be in camel case", field)
+		}
+	}
+	if !actual.Date.Equal(meta.Date) ||
+		actual.Pilot != meta.Pilot ||
+		actual.GliderID != meta.GliderID ||
+		actual.TrackLength != meta.TrackLength ||
+		actual.TrackSrcURL != meta.TrackSrcURL ||
+		actual.MaxAltitudeGain != meta.MaxAltitudeGain ||
+		actual.AvgSpeed != meta.AvgSpeed ||
+		actual.BBox.MinLat != meta.BBox.MinLat ||
+		actual.BBox.MaxLon != meta.BBox.MaxLon {
+		t.Errorf("expected camel case fields to have the values of the track meta, got '%s'", res.Body)
+	}
+
+	if code := get("?case=kebab").Result().StatusCode; code != 400 {
+		t.Errorf("expected unknown case to return 400, got '%d'", code)
+	}
+}
+
+// Test GET /track/<id>.json.gz
+func TestIgcServerGetTrackGzip(t *testing.T) {
+	// Responses are compressed by the middleware as well if they are large
+	// enough, which must not compress the file twice
+	server, fileserver := makeTestServers(WithGzipThreshold(1))
+	defer fileserver.Close()
+
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, acceptGzip := range []bool{false, true} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d.json.gz", meta.ID), nil)
+		if acceptGzip {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET /track/<id>.json.gz` to return 200, got '%d'", code)
+		}
+		if encoding := res.Header().Get("Content-Encoding"); encoding != "gzip" {
+			t.Errorf("expected content encoding to be gzip, got '%s'", encoding)
+		}
+		if disposition := res.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") {
+			t.Errorf("expected response to be an attachment, got '%s'", disposition)
+		}
+
+		gz, err := gzip.NewReader(res.Body)
 		if err != nil {
-			t.Errorf("unable to add metadata: %s", err)
-			continue
+			t.Fatalf("unable to decompress body: %s", err)
+		}
+		var actual TrackMeta
+		if err := json.NewDecoder(gz).Decode(&actual); err != nil {
+			t.Fatalf("failed when trying to decode decompressed body as json: %s", err)
+		}
+		// The id and timestamp are not part of the json
+		actual.ID = meta.ID
+		actual.Timestamp = meta.Timestamp
+		if !cmp.Equal(actual, meta) {
+			t.Errorf("expected downloaded track meta to equal the track meta: %s", cmp.Diff(meta, actual))
 		}
-		ids = append(ids, trackMeta.ID)
 	}
 
-	for i, id := range ids {
-		// Encode and decode struct to make indexing easier
-		exptJSON, _ := json.Marshal(testTrackMetas[i])
-		var expt map[string]interface{}
-		json.Unmarshal(exptJSON, &expt)
+	for _, data := range []struct {
+		uri  string
+		code int
+	}{
+		{"/track/1232.json.gz", 404},
+		{"/track/asdf.json.gz", 400},
+	} {
+		req := httptest.NewRequest("GET", data.uri, nil)
+		res := httptest.NewRecorder()
 
-		for _, field := range []string{
-			"pilot",
-			"glider",
-			"glider_id",
-			"track_src_url",
-		} {
-			uri := fmt.Sprintf("/track/%d/%s", id, field)
-			req := httptest.NewRequest("GET", uri, nil)
-			res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
 
-			server.ServeHTTP(res, req)
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", data.uri, data.code, code)
+		}
+	}
+}
 
-			got, err := ioutil.ReadAll(res.Body)
-			if err != nil {
-				t.Fatalf("error when reading body: %s", err)
-			}
-			if string(got) != expt[field] {
-				t.Errorf("unexpected field when `GET /track/%d/%s`, got '%s' but expected '%s'", id, field, got, expt[field])
-			}
+// Test valid PATCH /track/<id>
+func TestIgcServerPatchTrackValid(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
 
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
 		}
-		for _, field := range []string{
-			"H_date",
-			"track_length",
-		} {
-			uri := fmt.Sprintf("/track/%d/%s", id, field)
-			req := httptest.NewRequest("GET", uri, nil)
-			res := httptest.NewRecorder()
+	}
 
-			server.ServeHTTP(res, req)
+	uri := fmt.Sprintf("/track/%d", testTrackMetas[0].ID)
+	body := "{\"pilot\":\"Jasmine\",\"glider_id\":\"MGI3\"}"
+	req := httptest.NewRequest("PATCH", uri, bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
 
-			got, err := ioutil.ReadAll(res.Body)
-			if err != nil {
-				t.Fatalf("error when reading body: %s", err)
-			}
-			if got == nil {
-				t.Errorf("empty field when `GET /track/%d/%s`", id, field)
-			}
-			if string(got) == "" {
-				t.Errorf("empty string when `GET /track/%d/%s`", id, field)
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `PATCH %s` to return 200, got '%d'", uri, code)
+	}
+
+	expt := testTrackMetas[0]
+	expt.Pilot = "Jasmine"
+	expt.GliderID = "MGI3"
+
+	got, err := server.tracks.Get(expt.ID)
+	if err != nil {
+		t.Fatalf("unable to get updated metadata: %s", err)
+	}
+	exptJSON, _ := json.MarshalIndent(expt, "", "  ")
+	gotJSON, _ := json.MarshalIndent(got, "", "  ")
+	if !cmp.Equal(exptJSON, gotJSON) {
+		t.Errorf("stored track was not updated as expected:\n\nexpected:\n%s\n\nstored:\n%s", exptJSON, gotJSON)
+	}
+
+	var data TrackMeta
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	dataJSON, _ := json.MarshalIndent(data, "", "  ")
+	if !cmp.Equal(exptJSON, dataJSON) {
+		t.Errorf("returned track was not equal to updated track:\n\nexpected:\n%s\n\nreturned:\n%s", exptJSON, dataJSON)
+	}
+}
+
+// Test bad PATCH /track/<id>
+func TestIgcServerPatchTrackBad(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := server.tracks.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	id := strconv.Itoa(int(testTrackMetas[0].ID))
+
+	for _, data := range []struct {
+		code int
+		id   string
+		body string
+	}{
+		{400, id, "{\"track_src_url\":\"http://other.com/a.igc\"}"},
+		{400, id, "{\"track_length\":10}"},
+		{400, id, "{\"pilot\":\"Jasmine\",\"track_length\":10}"},
+		{400, id, "{\"pilot\":12}"},
+		{400, id, "\"pilot\":\"Jasmine\"}"},
+		{400, "bad", "{\"pilot\":\"Jasmine\"}"},
+		{404, "1232", "{\"pilot\":\"Jasmine\"}"},
+	} {
+		req := httptest.NewRequest("PATCH", "/track/"+data.id, bytes.NewReader([]byte(data.body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `PATCH /track/%s` with '%s' to return '%d', got '%d'", data.id, data.body, data.code, code)
+		}
+	}
+
+	got, _ := server.tracks.Get(testTrackMetas[0].ID)
+	if got.Pilot != testTrackMetas[0].Pilot || got.TrackSrcURL != testTrackMetas[0].TrackSrcURL {
+		t.Errorf("expected rejected updates to leave the track untouched, got '%v'", got)
+	}
+}
+
+// Test bad DELETE /track/<id>
+func TestIgcServerDeleteTrackBad(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	for _, badID := range []struct {
+		int
+		string
+	}{
+		{400, "aaaabbbb"},
+		{400, "bad"},
+		{400, "--asdf--"},
+		{404, "1232"},
+		{404, "99999"},
+	} {
+		req := httptest.NewRequest("DELETE", "/track/"+badID.string, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != badID.int {
+			t.Errorf("expected `DELETE /track/%s` to return '%d', got '%d'", badID.string, badID.int, code)
+		}
+	}
+}
+
+// Test PUT /track/<id> -> 405 response
+func TestIgcServerPutTrackMethod(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	req := httptest.NewRequest("PUT", "/track/1232", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 405 {
+		t.Fatalf("expected `PUT /track/1232` to return a 405, got '%d'", code)
+	}
+	if allowed := res.Result().Header.Get("Allow"); allowed != "GET, HEAD, PATCH, DELETE, OPTIONS" {
+		t.Fatalf("expected `Allow` header to list the methods of the route, got '%s'", allowed)
+	}
+}
+
+// Test different rubbish urls -> 404
+// Test that all routes are mounted under the prefix when it is configured
+func TestIgcServerPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "/paragliding/api"} {
+		trackMetasMap := NewTrackMetasMap()
+		ticker := NewTickerDummy(2, &trackMetasMap)
+		webhooks := NewWebhooksMap()
+		server := NewServer(nil, &trackMetasMap, &ticker, &webhooks, WithPrefix(prefix))
+
+		for _, data := range []struct {
+			method string
+			path   string
+			code   int
+		}{
+			{"GET", prefix + "/", 200},
+			{"GET", prefix + "/track", 200},
+			{"GET", prefix + "/ticker", 404},
+			{"GET", prefix + "/webhook/new_track/asdf", 400},
+			{"GET", prefix + "/rubbish", 404},
+			{"PUT", prefix + "/track", 405},
+			{"GET", "/other" + prefix + "/track", 404},
+		} {
+			req := httptest.NewRequest(data.method, data.path, nil)
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			if code := res.Result().StatusCode; code != data.code {
+				t.Errorf("expected `%s %s` to return '%d' (prefix: '%s'), got '%d'", data.method, data.path, data.code, prefix, code)
 			}
 		}
 	}
 }
 
-// Test bad GET /track/<id>/<field>
-func TestIgcServerGetTrackFieldBad(t *testing.T) {
-	trackMetasMap := NewTrackMetasMap()
-	server := NewServer(nil, &trackMetasMap, nil, nil)
+// Test that the track routes are also available under the `/igc` alias
+func TestIgcServerTrackAlias(t *testing.T) {
+	server, fileserver := makeTestServers(WithPrefix("/paragliding/api"))
+	defer fileserver.Close()
 
-	testTrackMetas := makeIGCTestData("localhost")
-	ids := make([]TrackID, 0, len(testTrackMetas))
-	for _, trackMeta := range testTrackMetas {
-		err := server.tracks.Append(trackMeta)
-		if err != nil {
-			t.Errorf("unable to add metadata: %s", err)
-			continue
-		}
-		ids = append(ids, trackMeta.ID)
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
 	}
 
-	var unknownID TrackID
+	for _, data := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/igc", 200},
+		{"HEAD", "/igc", 200},
+		{"GET", "/igc.csv", 200},
+		{"GET", "/igc/count", 200},
+		{"GET", "/igc/search?q=aladin", 200},
+		{"GET", fmt.Sprintf("/igc/%d", meta.ID), 200},
+		{"GET", fmt.Sprintf("/igc/%d/pilot", meta.ID), 200},
+		{"GET", "/igc/1232", 404},
+		{"GET", fmt.Sprintf("/igc/%d/pilot/rubbish", meta.ID), 404},
+		{"GET", "/igcrubbish", 404},
+		{"PUT", "/igc", 405},
+	} {
+		for _, alias := range []string{"/track", "/igc"} {
+			path := "/paragliding/api" + strings.Replace(data.path, "/igc", alias, 1)
+			req := httptest.NewRequest(data.method, path, nil)
+			res := httptest.NewRecorder()
 
-	// It is a rare occurrence, but make sure that the unknown id NEVER can be
-	// equal to a generated id
-outer:
-	for {
-		unknownID = TrackID(rand.Int())
-		for _, id := range ids {
-			if unknownID == id {
-				continue outer
+			server.ServeHTTP(res, req)
+
+			if code := res.Result().StatusCode; code != data.code {
+				t.Errorf("expected `%s %s` to return '%d', got '%d'", data.method, path, data.code, code)
 			}
 		}
-		break
 	}
 
-	for _, data := range []struct {
-		code  int
-		field string
-		id    TrackID
-	}{
-		{400, "asdlfkjaksl", ids[0]},
-		{400, "aasdf90123", ids[0]},
-		{400, "12312", ids[1]},
-		{400, "--..s.a", ids[1]},
-		{404, "asdf", unknownID},
-	} {
-		uri := fmt.Sprintf("/track/%d/%s", data.id, data.field)
-		req := httptest.NewRequest("GET", uri, nil)
-		res := httptest.NewRecorder()
+	// Tracks registered through the alias are the same as through `/track`
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/paragliding/api/igc", strings.NewReader(body))
+	res := httptest.NewRecorder()
 
-		server.ServeHTTP(res, req)
+	server.ServeHTTP(res, req)
 
-		code := res.Result().StatusCode
-		if code != data.code {
-			t.Fatalf("expected `GET /track/%d/%s` to return '%d', got '%d'", data.id, data.field, data.code, code)
-		}
+	var created map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	req = httptest.NewRequest("GET", fmt.Sprintf("/paragliding/api/track/%d", created["id"]), nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Errorf("expected track registered through alias to be found, got '%d'", code)
 	}
 }
 
-// Test different rubbish urls -> 404
 func TestIgcServerGetRubbish(t *testing.T) {
 	server := NewServer(nil, nil, nil, nil)
 
@@ -482,22 +3347,449 @@ func TestIgcServerGetRubbish(t *testing.T) {
 	}
 }
 
-// Test PUT -> 405 response
-func TestIgcServerPutMethod(t *testing.T) {
-	server := NewServer(nil, nil, nil, nil)
+// Test PUT -> 405 response
+func TestIgcServerPutMethod(t *testing.T) {
+	server := NewServer(nil, nil, nil, nil)
+
+	req := httptest.NewRequest("PUT", "/", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	code := res.Result().StatusCode
+	if code != 405 {
+		t.Fatalf("expected `PUT /` to return a 405, got '%d'", code)
+	}
+	allowedMethods := res.Result().Header.Get("Allow")
+	if allowedMethods == "" {
+		t.Fatalf("expected `PUT /` to return an `Allow` header containing the allowed methods, no methods returned or missing header")
+	}
+}
+
+// Test that OPTIONS responds with the methods allowed by each route
+func TestIgcServerOptions(t *testing.T) {
+	for _, data := range []struct {
+		opts    []Option
+		uri     string
+		allowed []string
+	}{
+		{nil, "/", []string{"GET", "HEAD", "OPTIONS"}},
+		{nil, "/track", []string{"GET", "HEAD", "POST", "OPTIONS"}},
+		{[]Option{WithTrackClearing(true)}, "/track", []string{"GET", "HEAD", "POST", "DELETE", "OPTIONS"}},
+		{nil, "/track/1232", []string{"GET", "HEAD", "PATCH", "DELETE", "OPTIONS"}},
+		{nil, "/track/1232/pilot", []string{"GET", "OPTIONS"}},
+		{nil, "/track.csv", []string{"GET", "OPTIONS"}},
+		{nil, "/ticker/latest", []string{"GET", "OPTIONS"}},
+		{nil, "/webhook/new_track", []string{"POST", "OPTIONS"}},
+		{nil, "/webhook/new_track/1232", []string{"GET", "DELETE", "OPTIONS"}},
+		{[]Option{WithPrefix("/api")}, "/api/track/1232", []string{"GET", "HEAD", "PATCH", "DELETE", "OPTIONS"}},
+	} {
+		server := NewServer(nil, nil, nil, nil, data.opts...)
+
+		req := httptest.NewRequest("OPTIONS", data.uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 204 {
+			t.Errorf("expected `OPTIONS %s` to return 204, got '%d'", data.uri, code)
+		}
+		if allowed := res.Header().Get("Allow"); allowed != strings.Join(data.allowed, ", ") {
+			t.Errorf("expected `OPTIONS %s` to allow '%s', got '%s'", data.uri, strings.Join(data.allowed, ", "), allowed)
+		}
+	}
+
+	// Paths without any routes are not found
+	server := NewServer(nil, nil, nil, nil)
+	req := httptest.NewRequest("OPTIONS", "/asdf", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 {
+		t.Errorf("expected `OPTIONS /asdf` to return 404, got '%d'", code)
+	}
+}
+
+// failingTrackMetas fails to get the ids of all tracks
+type failingTrackMetas struct {
+	TrackMetas
+}
+
+func (failingTrackMetas) GetAllIDs() ([]TrackID, error) {
+	return nil, errors.New("database is down")
+}
+
+// Test that the server logs to the given logger, including errors
+func TestIgcServerLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	server := NewServer(nil, failingTrackMetas{}, nil, nil, WithLogger(logger))
+
+	req := httptest.NewRequest("GET", "/track", nil)
+	req.Header.Set("X-Request-ID", "logged")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 500 {
+		t.Fatalf("expected `GET /track` to return 500, got '%d'", code)
+	}
+
+	var errorEntry *log.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.ErrorLevel {
+			errorEntry = entry
+		}
+	}
+	if errorEntry == nil {
+		t.Fatalf("expected an error to be logged, got %d entries without errors", len(hook.AllEntries()))
+	}
+	if err, _ := errorEntry.Data["error"].(error); err == nil || err.Error() != "database is down" {
+		t.Errorf("expected the error of the storage to be logged, got '%v'", errorEntry.Data["error"])
+	}
+	if id := errorEntry.Data["request_id"]; id != "logged" {
+		t.Errorf("expected the error to be logged with the request id 'logged', got '%v'", id)
+	}
+	if last := hook.LastEntry(); last == nil || last.Message != "handled request" {
+		t.Errorf("expected the last entry to be about the handled request, got '%v'", last)
+	}
+}
+
+// Test GET /ticker/latest
+func TestTickerLatest(t *testing.T) {
+	// Use an unbuffered ticker to make sure the reported timestamp has been
+	// received before requesting the latest timestamp
+	ticker := NewTickerDummy(0, nil)
+	server := NewServer(nil, nil, &ticker, nil)
+
+	req := httptest.NewRequest("GET", "/ticker/latest", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 204 {
+		t.Fatalf("expected `GET /ticker/latest` without tracks to return 204, got '%d'", code)
+	}
+	if res.Body.Len() != 0 {
+		t.Errorf("expected `GET /ticker/latest` without tracks to have an empty body, got '%s'", res.Body)
+	}
+
+	latest := time.Date(2018, 10, 15, 12, 30, 0, 0, time.UTC)
+	server.ticker.Reporter(latest)
+
+	req = httptest.NewRequest("GET", "/ticker/latest", nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `GET /ticker/latest` to return 200, got '%d'", code)
+	}
+	if got := res.Body.String(); got != latest.Format(time.RFC3339) {
+		t.Errorf("expected latest timestamp to be '%s', got '%s'", latest.Format(time.RFC3339), got)
+	}
+}
+
+// Convenience function to add tracks with controlled timestamps, which are
+// one second apart, returning the ids in the order they were added
+func appendTimedTracks(t *testing.T, metas TrackMetas, start time.Time, count int) []TrackID {
+	ids := make([]TrackID, count)
+	for i := range ids {
+		meta := TrackMeta{
+			ID:        NewTrackID([]byte(fmt.Sprintf("timed-%d", i))),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+		ids[i] = meta.ID
+	}
+	return ids
+}
+
+// Test that the latest timestamp is recomputed when the latest track is
+// deleted, and kept when an older track is deleted
+func TestTickerLatestDeleted(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil, WithTrackClearing(true))
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	ids := appendTimedTracks(t, &trackMetasMap, start, 3)
+	ticker.Reporter(start.Add(2 * time.Second))
+
+	for _, data := range []struct {
+		method string
+		uri    string
+		code   int
+		latest string
+	}{
+		{"GET", "/ticker/latest", 200, start.Add(2 * time.Second).Format(time.RFC3339)},
+		// Deleting an older track keeps the latest timestamp
+		{"DELETE", fmt.Sprintf("/track/%d", ids[0]), 200, ""},
+		{"GET", "/ticker/latest", 200, start.Add(2 * time.Second).Format(time.RFC3339)},
+		// Deleting the latest track gives the timestamp of the next latest
+		{"DELETE", fmt.Sprintf("/track/%d", ids[2]), 200, ""},
+		{"GET", "/ticker/latest", 200, start.Add(time.Second).Format(time.RFC3339)},
+		// Deleting all tracks leaves no latest timestamp
+		{"DELETE", "/track", 200, ""},
+		{"GET", "/ticker/latest", 204, ""},
+	} {
+		req := httptest.NewRequest(data.method, data.uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Fatalf("expected `%s %s` to return '%d', got '%d'", data.method, data.uri, data.code, code)
+		}
+		if data.method == "GET" && res.Body.String() != data.latest {
+			t.Errorf("expected latest timestamp to be '%s', got '%s'", data.latest, res.Body)
+		}
+	}
+}
+
+// Test that a ticker which reads through the storage reports tracks which
+// were never reported to it, and caches the latest timestamp of storages which
+// can't be queried for it
+func TestTickerStore(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerStore(&trackMetasMap)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil, WithTickerPageSize(2))
+
+	req := httptest.NewRequest("GET", "/ticker/latest", nil)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if code := res.Result().StatusCode; code != 204 {
+		t.Errorf("expected `GET /ticker/latest` without tracks to return 204, got '%d'", code)
+	}
+
+	// The latest timestamp of tracks already in the storage is found when the
+	// ticker is created
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	ids := appendTimedTracks(t, &trackMetasMap, start, 3)
+	ticker = NewTickerStore(&trackMetasMap)
+	server = NewServer(nil, &trackMetasMap, &ticker, nil, WithTickerPageSize(2))
+
+	req = httptest.NewRequest("GET", "/ticker/latest", nil)
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if expt := start.Add(2 * time.Second).Format(time.RFC3339); res.Body.String() != expt {
+		t.Errorf("expected latest timestamp to be '%s', got '%s'", expt, res.Body)
+	}
+
+	report, err := ticker.GetReport(2)
+	if err != nil {
+		t.Fatalf("unable to get report: %s", err)
+	}
+	if !cmp.Equal(ids[:2], report.Tracks) || !report.Latest.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected report of '%d' with latest '%s', got '%v'", ids[:2], start.Add(2*time.Second), report)
+	}
+	if _, err := ticker.GetReportAfter(start.Add(2*time.Second), 0); err != ErrNoTracksFound {
+		t.Errorf("expected no tracks after the latest track, got '%v'", err)
+	}
+
+	// The cached latest timestamp is only recomputed when the latest track is
+	// deleted
+	if err := trackMetasMap.Append(TrackMeta{ID: 1, Timestamp: start.Add(time.Hour)}); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	if latest := ticker.Latest(); latest == nil || !latest.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected cached latest timestamp to be '%s', got '%v'", start.Add(2*time.Second), latest)
+	}
+	if _, err := trackMetasMap.Delete(1); err != nil {
+		t.Fatalf("unable to delete track: %s", err)
+	}
+	deleted, err := trackMetasMap.Delete(ids[2])
+	if err != nil {
+		t.Fatalf("unable to delete track: %s", err)
+	}
+	ticker.Deleted(deleted.Timestamp)
+	if latest := ticker.Latest(); latest == nil || !latest.Equal(start.Add(time.Second)) {
+		t.Errorf("expected latest timestamp after deleting the latest track to be '%s', got '%v'", start.Add(time.Second), latest)
+	}
+	ticker.Reporter(start.Add(time.Minute))
+	if latest := ticker.Latest(); latest == nil || !latest.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected reported latest timestamp to be '%s', got '%v'", start.Add(time.Minute), latest)
+	}
+}
+
+// Test GET /ticker and GET /ticker/<timestamp> paging
+func TestTickerReportPaging(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil, WithTickerPageSize(3))
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	ids := appendTimedTracks(t, &trackMetasMap, start, 7)
+	stamp := func(i int) time.Time {
+		return start.Add(time.Duration(i) * time.Second)
+	}
+
+	for _, page := range []struct {
+		uri        string
+		expt       []TrackID
+		start, end time.Time
+	}{
+		{"/ticker", ids[0:3], stamp(0), stamp(2)},
+		{"/ticker/" + stamp(2).Format(time.RFC3339), ids[3:6], stamp(3), stamp(5)},
+		{"/ticker/" + stamp(5).Format(time.RFC3339), ids[6:], stamp(6), stamp(6)},
+	} {
+		req := httptest.NewRequest("GET", page.uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var report TickerReport
+		if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(page.expt, report.Tracks) {
+			t.Errorf("unexpected tracks when `GET %s`, expected '%d' but got '%d'", page.uri, page.expt, report.Tracks)
+		}
+		if !report.Start.Equal(page.start) || !report.End.Equal(page.end) {
+			t.Errorf("unexpected page boundaries when `GET %s`, expected '%s'-'%s' but got '%s'-'%s'", page.uri, page.start, page.end, report.Start, report.End)
+		}
+		if !report.Latest.Equal(stamp(6)) {
+			t.Errorf("expected latest timestamp when `GET %s` to be '%s', got '%s'", page.uri, stamp(6), report.Latest)
+		}
+	}
+}
+
+// Test that following `t_stop` of the ticker reports reaches `t_latest` once
+// all tracks have been reported
+func TestTickerReportCatchUp(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil, WithTickerPageSize(3))
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	ids := appendTimedTracks(t, &trackMetasMap, start, 7)
+
+	getReport := func(uri string) (report TickerReport) {
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		return
+	}
+
+	var reported []TrackID
+	pages := 0
+	report := getReport("/ticker")
+	for {
+		pages++
+		reported = append(reported, report.Tracks...)
+		if report.End.Equal(report.Latest) {
+			break
+		}
+		if !report.Latest.After(report.End) {
+			t.Fatalf("expected latest timestamp '%s' to be after the end of the page '%s'", report.Latest, report.End)
+		}
+		if pages > len(ids) {
+			t.Fatal("expected to catch up with the latest timestamp")
+		}
+		report = getReport("/ticker/" + report.End.Format(time.RFC3339Nano))
+	}
+	if pages != 3 {
+		t.Errorf("expected to catch up after '3' pages, got '%d'", pages)
+	}
+	if !cmp.Equal(ids, reported) {
+		t.Errorf("expected all tracks '%d' to be reported, got '%d'", ids, reported)
+	}
+
+	// Deleting the latest track makes the previous track the latest
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/track/%d", ids[6]), nil)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	report = getReport("/ticker/" + start.Add(3*time.Second).Format(time.RFC3339))
+	if !report.End.Equal(report.Latest) || !report.Latest.Equal(start.Add(5*time.Second)) {
+		t.Errorf("expected page to end at the new latest timestamp '%s', got '%s' with latest '%s'", start.Add(5*time.Second), report.End, report.Latest)
+	}
+}
+
+// Test GET /ticker/<timestamp> with timestamps as unix milliseconds
+func TestTickerReportAfterMillis(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil)
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	ids := appendTimedTracks(t, &trackMetasMap, start, 3)
+	millis := func(i int) string {
+		stamp := start.Add(time.Duration(i) * time.Second)
+		return strconv.FormatInt(stamp.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	for _, data := range []struct {
+		timestamp string
+		expt      []TrackID
+	}{
+		{millis(-1), ids},
+		{millis(0), ids[1:]},
+		{millis(1), ids[2:]},
+		{millis(2), []TrackID{}},
+		{millis(100), []TrackID{}},
+	} {
+		req := httptest.NewRequest("GET", "/ticker/"+data.timestamp, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET /ticker/%s` to return 200, got '%d'", data.timestamp, code)
+		}
+		var report TickerReport
+		if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if report.Tracks == nil {
+			t.Errorf("expected `GET /ticker/%s` to return an array of tracks, got null", data.timestamp)
+		}
+		if !cmp.Equal(data.expt, report.Tracks) {
+			t.Errorf("unexpected tracks when `GET /ticker/%s`, expected '%d' but got '%d'", data.timestamp, data.expt, report.Tracks)
+		}
+	}
+
+	for _, bad := range []string{"abc", "12a3", "2018-13-45"} {
+		req := httptest.NewRequest("GET", "/ticker/"+bad, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected `GET /ticker/%s` to return 400, got '%d'", bad, code)
+		}
+	}
+}
+
+// Test that the ticker page size defaults to 5
+func TestTickerReportDefaultPageSize(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(0, &trackMetasMap)
+	server := NewServer(nil, &trackMetasMap, &ticker, nil)
+
+	ids := appendTimedTracks(t, &trackMetasMap, time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC), 7)
 
-	req := httptest.NewRequest("PUT", "/", nil)
+	req := httptest.NewRequest("GET", "/ticker", nil)
 	res := httptest.NewRecorder()
 
 	server.ServeHTTP(res, req)
 
-	code := res.Result().StatusCode
-	if code != 405 {
-		t.Fatalf("expected `PUT /` to return a 405, got '%d'", code)
+	var report TickerReport
+	if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
 	}
-	allowedMethods := res.Result().Header.Get("Allow")
-	if allowedMethods == "" {
-		t.Fatalf("expected `PUT /` to return an `Allow` header containing the allowed methods, no methods returned or missing header")
+	if !cmp.Equal(ids[:5], report.Tracks) {
+		t.Errorf("expected the five oldest tracks '%d', got '%d'", ids[:5], report.Tracks)
 	}
 }
 
@@ -567,6 +3859,73 @@ func TestGetWebhookByIdValid(t *testing.T) {
 	}
 }
 
+// Test valid DELETE /webhook/new_track/<id>
+func TestDeleteWebhookValid(t *testing.T) {
+	webhooksMap := NewWebhooksMap()
+	server := NewServer(nil, nil, nil, &webhooksMap)
+
+	testData := makeWebhooksTestData()
+	for _, webhook := range testData {
+		if err := server.webhooks.Append(webhook); err != nil {
+			t.Fatalf("unable to add webhook: %s", err)
+		}
+	}
+
+	uri := fmt.Sprintf("/webhook/new_track/%d", testData[0].ID)
+	req := httptest.NewRequest("DELETE", uri, nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data WebhookInfo
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	expt, _ := json.MarshalIndent(testData[0], "", "  ")
+	got, _ := json.MarshalIndent(data, "", "  ")
+	if !cmp.Equal(expt, got) {
+		t.Errorf("returned webhook was not equal to deleted webhook:\n\nexpected:\n%s\n\nreturned:\n%s", expt, got)
+	}
+
+	req = httptest.NewRequest("GET", uri, nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 404 {
+		t.Fatalf("expected `GET %s` of deleted webhook to return 404, got '%d'", uri, code)
+	}
+}
+
+// Test bad DELETE /webhook/new_track/<id>
+func TestDeleteWebhookByBadID(t *testing.T) {
+	webhooksMap := NewWebhooksMap()
+	server := NewServer(nil, nil, nil, &webhooksMap)
+
+	for _, badID := range []struct {
+		int
+		string
+	}{
+		{400, "aaaabbbb"},
+		{400, "bad"},
+		{400, "12312o3123"},
+		{400, "--asdf--"},
+		{404, "1232"},
+		{404, "99999"},
+	} {
+		req := httptest.NewRequest("DELETE", "/webhook/new_track/"+badID.string, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		code := res.Result().StatusCode
+		if code != badID.int {
+			t.Errorf("expected `DELETE /webhook/new_track/%s` to return '%d', got '%d'", badID.string, badID.int, code)
+		}
+	}
+}
+
 // Test valid POST /webhook/new_track/
 func TestRegWebhook(t *testing.T) {
 	webhooksMap := NewWebhooksMap()
@@ -630,6 +3989,11 @@ func TestRegWebhookBad(t *testing.T) {
 		{400, "{\"webhookURL\":12123}"},
 		{400, "{\"webhookURL\":aabb}"},
 		{400, "{\"webhookURl\":\"abff}"},
+		{400, "{\"webhookURL\":\"\"}"},
+		{400, "{\"webhookURL\":\"not-a-url\"}"},
+		{400, "{\"webhookURL\":\"/relative/path\"}"},
+		{400, "{\"webhookURL\":\"ftp://files.com/hook\"}"},
+		{400, "{\"webhookURL\":\"http://unique.com\",\"minTriggerValue\":0}"},
 	}
 
 	b := new(bytes.Buffer)
@@ -646,3 +4010,435 @@ func TestRegWebhookBad(t *testing.T) {
 		}
 	}
 }
+
+// Test that a webhook registered with a secret receives signed messages, and
+// that the secret is never sent back
+func TestRegWebhookSecret(t *testing.T) {
+	webhooksMap := NewWebhooksMap()
+	server := NewServer(nil, nil, nil, &webhooksMap)
+
+	type delivery struct {
+		signature string
+		body      []byte
+	}
+	received := make(chan delivery, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- delivery{r.Header.Get("X-Signature"), body}
+	}))
+	defer receiver.Close()
+
+	secret := "very secret"
+	reqBody := fmt.Sprintf("{\"webhookURL\":\"%s\",\"secret\":\"%s\"}", receiver.URL, secret)
+	req := httptest.NewRequest("POST", "/webhook/new_track", strings.NewReader(reqBody))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `POST /webhook/new_track` with secret to return 200, got '%d'", code)
+	}
+	id, err := strconv.Atoi(res.Body.String())
+	if err != nil {
+		t.Fatalf("unable to decode response as integer: %s", err)
+	}
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/webhook/new_track/%d", id), nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if strings.Contains(res.Body.String(), secret) {
+		t.Errorf("expected secret to be left out of webhook details, got '%s'", res.Body)
+	}
+
+	webhook, err := webhooksMap.Get(WebhookID(id))
+	if err != nil {
+		t.Fatalf("unable to get registered webhook: %s", err)
+	}
+	msg := NewDiscordMsg(time.Now(), []TrackID{1}, time.Millisecond)
+	if err := sendWebhookMsg(receiver.Client(), webhook.URLstr, webhook.Secret, msg); err != nil {
+		t.Fatalf("unable to deliver message to webhook: %s", err)
+	}
+
+	got := <-received
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(got.body)
+	if expt := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.signature != expt {
+		t.Errorf("expected webhook message to be signed with '%s', got '%s'", expt, got.signature)
+	}
+}
+
+// Test that the secret of a webhook never appears in the logs, in neither of
+// the log formats
+func TestWebhookSecretNotLogged(t *testing.T) {
+	secret := "very secret"
+	for _, formatter := range []log.Formatter{&log.TextFormatter{}, &log.JSONFormatter{}} {
+		var logs bytes.Buffer
+		logger := log.New()
+		logger.Out = &logs
+		logger.Formatter = formatter
+		logger.Level = log.DebugLevel
+
+		webhooksMap := NewWebhooksMap()
+		server := NewServer(nil, nil, nil, &webhooksMap, WithLogger(logger))
+
+		reqBody := fmt.Sprintf("{\"webhookURL\":\"http://localhost/hook\",\"secret\":\"%s\"}", secret)
+		var id string
+		for _, data := range []struct {
+			method string
+			body   string
+			code   int
+		}{
+			{"POST", reqBody, 200},
+			{"POST", reqBody, 403},
+			{"GET", "", 200},
+			{"DELETE", "", 200},
+		} {
+			uri := "/webhook/new_track"
+			if data.method != "POST" {
+				uri += "/" + id
+			}
+			req := httptest.NewRequest(data.method, uri, strings.NewReader(data.body))
+			res := httptest.NewRecorder()
+
+			server.ServeHTTP(res, req)
+
+			if code := res.Result().StatusCode; code != data.code {
+				t.Fatalf("expected `%s %s` to return %d, got '%d'", data.method, uri, data.code, code)
+			}
+			if id == "" {
+				id = res.Body.String()
+			}
+		}
+
+		if logs.Len() == 0 {
+			t.Fatal("expected requests to be logged")
+		}
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("expected secret of webhook to never be logged, got:\n%s", logs.String())
+		}
+	}
+}
+
+// Test that registered tracks get ids from the seed of the server
+func TestIgcServerPostTrackIDSeed(t *testing.T) {
+	for _, seed := range []string{"", "deployment"} {
+		server, fileserver := makeTestServers(WithTrackIDSeed(seed))
+
+		trackURL := fileserver.URL + "/test.igc"
+		body := fmt.Sprintf("{\"url\":\"%s\"}", trackURL)
+		req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var data map[string]TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if expt := NewTrackIDWithSeed(seed, []byte(trackURL)); data["id"] != expt {
+			t.Errorf("expected track with seed '%s' to get id '%d', got '%d'", seed, expt, data["id"])
+		}
+
+		// The seeded id has to be used when checking for duplicates
+		req = httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 403 {
+			t.Errorf("expected duplicate track with seed '%s' to return 403, got '%d'", seed, code)
+		}
+		fileserver.Close()
+	}
+}
+
+// Test that GET /track/by-glider/<glider_id> responds with the tracks flown
+// with the glider id
+func TestIgcServerGetTracksByGlider(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := trackMetasMap.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	for _, data := range []struct {
+		gliderID string
+		expt     []TrackID
+	}{
+		{"MGI2", []TrackID{testTrackMetas[0].ID}},
+		{"BG7", []TrackID{testTrackMetas[1].ID}},
+		{"unknown", []TrackID{}},
+	} {
+		uri := "/track/by-glider/" + data.gliderID
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET %s` to return 200, got '%d'", uri, code)
+		}
+		var ids []TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &ids); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(ids, data.expt) {
+			t.Errorf("expected `GET %s` to return '%v', got '%v'", uri, data.expt, ids)
+		}
+	}
+}
+
+// Test that GET /track/<id>?fields=<fields> only responds with the given fields
+func TestIgcServerGetTrackByIdFields(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	meta := makeIGCTestData("localhost")[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, data := range []struct {
+		query string
+		expt  map[string]interface{}
+	}{
+		{"?fields=pilot", map[string]interface{}{"pilot": meta.Pilot}},
+		{"?fields=pilot,track_length", map[string]interface{}{"pilot": meta.Pilot, "track_length": meta.TrackLength}},
+		{"?fields=glider_id,%20duration", map[string]interface{}{"glider_id": meta.GliderID, "duration": float64(meta.Duration)}},
+		{"?fields=glider_id&case=camel", map[string]interface{}{"gliderId": meta.GliderID}},
+	} {
+		uri := fmt.Sprintf("/track/%d%s", meta.ID, data.query)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 200 {
+			t.Fatalf("expected `GET %s` to return 200, got '%d'", uri, code)
+		}
+		var actual map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &actual); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if !cmp.Equal(actual, data.expt) {
+			t.Errorf("expected `GET %s` to give '%v', got '%v'", uri, data.expt, actual)
+		}
+	}
+
+	for _, query := range []string{"?fields=asdf", "?fields=pilot,asdf", "?fields=pilot,", "?fields=gliderId"} {
+		uri := fmt.Sprintf("/track/%d%s", meta.ID, query)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected `GET %s` to return 400, got '%d'", uri, code)
+		}
+	}
+}
+
+// Test that GET /track/<id> links to the track using the public url instead of
+// the host of the request, and only if the public url is configured
+func TestIgcServerGetTrackByIdLinks(t *testing.T) {
+	for _, publicURL := range []string{"", "https://paragliding.example.com/"} {
+		trackMetasMap := NewTrackMetasMap()
+		server := NewServer(nil, &trackMetasMap, nil, nil,
+			WithPrefix("/paragliding/api"), WithPublicURL(publicURL))
+
+		meta := makeIGCTestData("localhost")[0]
+		if err := server.tracks.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+
+		uri := fmt.Sprintf("http://internal:8080/paragliding/api/track/%d", meta.ID)
+		req := httptest.NewRequest("GET", uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		var data struct {
+			Pilot string             `json:"pilot"`
+			Links *map[string]string `json:"links"`
+		}
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if data.Pilot != meta.Pilot {
+			t.Errorf("expected track with links to keep its fields, got '%s'", res.Body)
+		}
+		if publicURL == "" {
+			if data.Links != nil {
+				t.Errorf("expected no links without a public url, got '%v'", *data.Links)
+			}
+			continue
+		}
+		expt := fmt.Sprintf("https://paragliding.example.com/paragliding/api/track/%d", meta.ID)
+		if data.Links == nil || (*data.Links)["self"] != expt {
+			t.Errorf("expected link to the track to be '%s', got '%s'", expt, res.Body)
+		}
+	}
+}
+
+// Test that GET /track/all streams all tracks as ndjson using chunked
+// transfer encoding, with or without compression
+func TestIgcServerGetTrackAllNDJSON(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	// Enough tracks to flush the stream several times
+	const count = 2*ndjsonFlushInterval + 50
+	meta := makeIGCTestData("localhost")[0]
+	for i := 0; i < count; i++ {
+		meta.ID = TrackID(i)
+		meta.TrackSrcURL = fmt.Sprintf("http://localhost/%d.igc", i)
+		if err := trackMetasMap.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	apiserver := httptest.NewServer(&server)
+	defer apiserver.Close()
+
+	for _, encoding := range []string{"identity", "gzip"} {
+		req, _ := http.NewRequest("GET", apiserver.URL+"/track/all", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		res, err := apiserver.Client().Do(req)
+		if err != nil {
+			t.Fatalf("unable to request all tracks: %s", err)
+		}
+		defer res.Body.Close()
+
+		if contentType := res.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Errorf("expected content type to be 'application/x-ndjson', got '%s'", contentType)
+		}
+		if len(res.TransferEncoding) == 0 || res.TransferEncoding[0] != "chunked" {
+			t.Errorf("expected stream with '%s' to be chunked, got '%v'", encoding, res.TransferEncoding)
+		}
+		var body io.Reader = res.Body
+		if res.Header.Get("Content-Encoding") == "gzip" {
+			body, err = gzip.NewReader(res.Body)
+			if err != nil {
+				t.Fatalf("unable to decompress stream: %s", err)
+			}
+		} else if encoding == "gzip" {
+			t.Errorf("expected stream to be compressed when accepted")
+		}
+
+		lines := bufio.NewScanner(body)
+		n := 0
+		for lines.Scan() {
+			var got ndjsonTrack
+			if err := json.Unmarshal(lines.Bytes(), &got); err != nil {
+				t.Fatalf("unable to decode line %d of stream '%s': %s", n, lines.Text(), err)
+			}
+			if got.ID != TrackID(n) {
+				t.Errorf("expected line %d to be the track '%d', got '%d'", n, n, got.ID)
+			}
+			if expt := fmt.Sprintf("http://localhost/%d.igc", n); got.TrackSrcURL != expt {
+				t.Errorf("expected line %d to be the track from '%s', got '%s'", n, expt, got.TrackSrcURL)
+			}
+			n++
+		}
+		if err := lines.Err(); err != nil {
+			t.Fatalf("unable to read stream: %s", err)
+		}
+		if n != count {
+			t.Errorf("expected stream with '%s' to contain '%d' tracks, got '%d'", encoding, count, n)
+		}
+	}
+}
+
+// Convenience function to create an igc file of a straight track with the
+// given amount of points, one second apart
+func makeSyntheticIGC(points int) string {
+	var b strings.Builder
+	b.WriteString("AXXXSYNTHETIC\nHFDTE190216\nHFPLTPILOT:Synthetic Pilot\n")
+	for i := 0; i < points; i++ {
+		fmt.Fprintf(&b, "B%02d%02d%02d%02d%05dN%03d%05dEA%05d%05d\n",
+			i/3600, i/60%60, i%60, 59, i%60000, 10, 0, 1000, 1000)
+	}
+	return b.String()
+}
+
+// Test that tracks with more points than the cap are downsampled, while the
+// track length stays roughly the same
+func TestIgcServerPostTrackMaxPoints(t *testing.T) {
+	content := makeSyntheticIGC(5000)
+	track, err := igc.Parse(content)
+	if err != nil {
+		t.Fatalf("unable to parse synthetic igc: %s", err)
+	}
+	fullLength := calcTotalDistance(track.Points)
+
+	fileserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer fileserver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(2, &trackMetasMap)
+	webhooks := NewWebhooksMap()
+	server := NewServer(fileserver.Client(), &trackMetasMap, &ticker, &webhooks, WithMaxTrackPoints(500))
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/synthetic.igc")
+	req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	points, ok := server.points.Get(data["id"])
+	if !ok || len(points) != 500 {
+		t.Errorf("expected track to be downsampled to 500 points, got '%d'", len(points))
+	}
+	meta, err := trackMetasMap.Get(data["id"])
+	if err != nil {
+		t.Fatalf("unable to get registered track: %s", err)
+	}
+	if diff := math.Abs(meta.TrackLength-fullLength) / fullLength; diff > 0.01 {
+		t.Errorf("expected downsampled track length '%f' to be within 1%% of '%f'", meta.TrackLength, fullLength)
+	}
+	if meta.Duration != track.Points[len(track.Points)-1].Time.Unix()-track.Points[0].Time.Unix() {
+		t.Errorf("expected downsampled track to keep the first and last point, got duration '%d'", meta.Duration)
+	}
+}
+
+// Test that tracks which take too long to parse are rejected
+func TestIgcServerPostTrackParseTimeout(t *testing.T) {
+	content := makeSyntheticIGC(5000)
+	fileserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer fileserver.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(fileserver.Client(), &trackMetasMap, nil, nil, WithParseTimeout(time.Nanosecond))
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/synthetic.igc")
+	req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 400 {
+		t.Errorf("expected track which takes too long to parse to return 400, got '%d'", code)
+	}
+	if n, _ := trackMetasMap.Len(); n != 0 {
+		t.Errorf("expected track which takes too long to parse to not be added, got '%d' tracks", n)
+	}
+}
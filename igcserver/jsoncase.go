@@ -0,0 +1,58 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// jsonCases maps the supported casings of json field names to functions
+// converting the snake_case names used by the structs into the casing
+var jsonCases = map[string]func(string) string{
+	"snake": func(name string) string { return name },
+	"camel": snakeToCamel,
+}
+
+// snakeToCamel converts a snake_case name to camelCase, eg. `glider_id` to
+// `gliderId`, where the first word is kept as is
+func snakeToCamel(name string) string {
+	words := strings.Split(name, "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// renameJSONKeys renames the keys of all objects in the encoded json using
+// the rename function
+func renameJSONKeys(body []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Numbers are kept as they are instead of being converted to floats
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameKeys(value, rename))
+}
+
+// renameKeys recursively renames the keys of all objects in the decoded json
+func renameKeys(value interface{}, rename func(string) string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			renamed[rename(key)] = renameKeys(inner, rename)
+		}
+		return renamed
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = renameKeys(inner, rename)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,39 @@
+package igcserver
+
+import (
+	"testing"
+)
+
+// Test that snake_case names are converted to camelCase
+func TestSnakeToCamel(t *testing.T) {
+	for _, data := range []struct {
+		name string
+		expt string
+	}{
+		{"pilot", "pilot"},
+		{"glider_id", "gliderId"},
+		{"max_altitude_gain", "maxAltitudeGain"},
+		{"H_date", "HDate"},
+		{"min_lat", "minLat"},
+		{"trailing_", "trailing"},
+		{"", ""},
+	} {
+		if actual := snakeToCamel(data.name); actual != data.expt {
+			t.Errorf("expected '%s' to be converted to '%s', got '%s'", data.name, data.expt, actual)
+		}
+	}
+}
+
+// Test that the keys of nested objects are renamed while values are unchanged
+func TestRenameJSONKeys(t *testing.T) {
+	body := []byte(`{"track_length":12.5,"bbox":{"min_lat":60},"some_list":[{"glider_id":"a_b"}],"big_int":9007199254740993}`)
+	expt := `{"bbox":{"minLat":60},"bigInt":9007199254740993,"someList":[{"gliderId":"a_b"}],"trackLength":12.5}`
+
+	actual, err := renameJSONKeys(body, snakeToCamel)
+	if err != nil {
+		t.Fatalf("unable to rename keys: %s", err)
+	}
+	if string(actual) != expt {
+		t.Errorf("expected renamed json to be '%s', got '%s'", expt, actual)
+	}
+}
@@ -0,0 +1,24 @@
+package igcserver
+
+import (
+	"net/http"
+)
+
+// NewHTTPServer creates a http server which serves the handler on the address
+// using the timeouts of the server. The handler is usually the server itself,
+// but may be a mux which mounts the server next to other routes.
+func (server *Server) NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  server.readTimeout,
+		WriteTimeout: server.writeTimeout,
+		IdleTimeout:  server.idleTimeout,
+	}
+}
+
+// ListenAndServe serves the server on the address using the timeouts of the
+// server, and blocks until the http server fails
+func (server *Server) ListenAndServe(addr string) error {
+	return server.NewHTTPServer(addr, server).ListenAndServe()
+}
@@ -0,0 +1,72 @@
+package igcserver
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that the timeouts of the server are applied to the http server, both
+// the defaults and those given as an option
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	for _, data := range []struct {
+		opts              []Option
+		read, write, idle time.Duration
+	}{
+		{nil, 15 * time.Second, 60 * time.Second, 120 * time.Second},
+		{[]Option{WithServerTimeouts(time.Second, 2*time.Second, 3*time.Second)}, time.Second, 2 * time.Second, 3 * time.Second},
+		{[]Option{WithServerTimeouts(0, 0, 0)}, 0, 0, 0},
+	} {
+		server, fileserver := makeTestServers(data.opts...)
+		fileserver.Close()
+
+		httpServer := server.NewHTTPServer(":8080", &server)
+
+		if httpServer.Addr != ":8080" {
+			t.Errorf("expected address to be ':8080', got '%s'", httpServer.Addr)
+		}
+		if httpServer.ReadTimeout != data.read {
+			t.Errorf("expected read timeout to be '%s', got '%s'", data.read, httpServer.ReadTimeout)
+		}
+		if httpServer.WriteTimeout != data.write {
+			t.Errorf("expected write timeout to be '%s', got '%s'", data.write, httpServer.WriteTimeout)
+		}
+		if httpServer.IdleTimeout != data.idle {
+			t.Errorf("expected idle timeout to be '%s', got '%s'", data.idle, httpServer.IdleTimeout)
+		}
+	}
+}
+
+// Test that a client which sends its request too slowly is disconnected by a
+// real listener
+func TestNewHTTPServerSlowClient(t *testing.T) {
+	server, fileserver := makeTestServers(WithServerTimeouts(50*time.Millisecond, time.Second, time.Second))
+	defer fileserver.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	httpServer := server.NewHTTPServer(listener.Addr().String(), &server)
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	defer conn.Close()
+
+	// Never finish sending the headers of the request
+	if _, err := conn.Write([]byte("GET /track HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatalf("unable to write request: %s", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	ioutil.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected slow client to be disconnected after the read timeout, got '%s'", elapsed)
+	}
+}
@@ -0,0 +1,126 @@
+package igcserver
+
+import (
+	"bufio"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// tracksRegistered counts the tracks which have been registered
+	tracksRegistered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "paragliding",
+		Name:      "tracks_registered_total",
+		Help:      "Amount of tracks registered using POST /track.",
+	})
+
+	// trackRegErrors counts the requests to register a track which failed
+	trackRegErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "paragliding",
+		Name:      "track_registration_errors_total",
+		Help:      "Amount of failed requests to POST /track.",
+	})
+
+	// webhookDeliveries counts the messages sent to webhooks by the result of
+	// the delivery
+	webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "paragliding",
+		Name:      "webhook_deliveries_total",
+		Help:      "Amount of messages sent to webhooks.",
+	}, []string{"result"})
+
+	// webhookDeliveryFailures counts the messages which were not delivered to
+	// webhooks after all attempts
+	webhookDeliveryFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "paragliding",
+		Name:      "webhook_delivery_failures_total",
+		Help:      "Amount of messages to webhooks given up after all attempts.",
+	})
+
+	// trackPublishFailures counts the registered tracks which were not
+	// published to the message broker
+	trackPublishFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "paragliding",
+		Name:      "track_publish_failures_total",
+		Help:      "Amount of registered tracks which failed to be published.",
+	})
+
+	// requestDuration observes the time used to handle requests to each route
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "paragliding",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time used to handle requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	prometheus.MustRegister(tracksRegistered, trackRegErrors, webhookDeliveries, webhookDeliveryFailures, trackPublishFailures, requestDuration)
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets handlers take over the connection, eg. to upgrade to a websocket
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Flush sends the buffered response to the client, eg. when streaming
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsMiddleware records the duration of every request, except requests
+// for the metrics themselves, by the route which handled it. The routes are
+// recorded without the prefix of the server.
+func metricsMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := "unknown"
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = strings.TrimPrefix(template, prefix)
+				}
+			}
+			if route == "/metrics" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			rec := &statusRecorder{w, http.StatusOK}
+			next.ServeHTTP(rec, r)
+			requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+
+			// Count errors here to cover every way registering a track can fail
+			if r.Method == http.MethodPost && rec.status >= 400 {
+				for _, alias := range trackRouteAliases {
+					if route == alias {
+						trackRegErrors.Inc()
+					}
+				}
+			}
+		})
+	}
+}
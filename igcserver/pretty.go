@@ -0,0 +1,89 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+)
+
+// prettyIndent is the indentation of each level of pretty json responses
+const prettyIndent = "  "
+
+// prettyResponseWriter buffers json responses to indent them when closed,
+// while other responses are passed through as is
+type prettyResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+	decided     bool
+}
+
+// isJSON checks if the response is json, which is decided by the content type
+// when the response is first written
+func (pw *prettyResponseWriter) isJSON() bool {
+	mediaType, _, err := mime.ParseMediaType(pw.Header().Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+func (pw *prettyResponseWriter) WriteHeader(status int) {
+	pw.status = status
+}
+
+func (pw *prettyResponseWriter) Write(b []byte) (int, error) {
+	if !pw.decided {
+		pw.decided = true
+		if !pw.isJSON() {
+			pw.passthrough = true
+			pw.ResponseWriter.WriteHeader(pw.status)
+		}
+	}
+	if pw.passthrough {
+		return pw.ResponseWriter.Write(b)
+	}
+	return pw.buf.Write(b)
+}
+
+// Flush sends the response so far to the client if it isn't json, as json
+// responses can only be indented once they are done
+func (pw *prettyResponseWriter) Flush() {
+	if !pw.passthrough {
+		return
+	}
+	if flusher, ok := pw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the indented json response, and has to be called when the
+// handler is done
+func (pw *prettyResponseWriter) Close() error {
+	if pw.passthrough {
+		return nil
+	}
+	body := pw.buf.Bytes()
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", prettyIndent); err == nil {
+		body = indented.Bytes()
+	}
+	pw.Header().Del("Content-Length")
+	pw.ResponseWriter.WriteHeader(pw.status)
+	_, err := pw.ResponseWriter.Write(body)
+	return err
+}
+
+// prettyMiddleware indents json responses if the `pretty` query parameter is
+// `true`, eg. to make them readable when debugging with curl
+func prettyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgraded connections, eg. websockets, aren't json responses
+		if r.URL.Query().Get("pretty") != "true" || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pw := &prettyResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer pw.Close()
+		next.ServeHTTP(pw, r)
+	})
+}
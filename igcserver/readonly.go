@@ -0,0 +1,79 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// readOnlyRetryAfter is the amount of seconds clients are asked to wait before
+// retrying requests which were rejected in read-only mode
+const readOnlyRetryAfter = 120
+
+// adminReadOnlyRoute is the name of the route which toggles read-only mode,
+// which is let through in read-only mode to be able to leave it
+const adminReadOnlyRoute = "admin-readonly"
+
+// readOnlyMiddleware rejects requests which modify the server with 503 while
+// the server is in read-only mode, where reads are always let through
+func readOnlyMiddleware(readOnly *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isReadOnly(r.Method) || atomic.LoadInt32(readOnly) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if route := mux.CurrentRoute(r); route != nil && route.GetName() == adminReadOnlyRoute {
+				next.ServeHTTP(w, r)
+				return
+			}
+			newReqLogger(r).Info("rejecting request as the server is in read-only mode")
+			w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+			writeJSONError(w, http.StatusServiceUnavailable, "server is in read-only mode")
+		})
+	}
+}
+
+// ReadOnlyRequest is the format of a request to toggle read-only mode
+type ReadOnlyRequest struct {
+	ReadOnly *bool `json:"read_only"`
+}
+
+// adminReadOnlyHandler takes a request in the following structure
+//
+// ```json
+// {
+//   "read_only": <true or false>
+// }
+// ```
+//
+// and enters or leaves read-only mode, after which it responds with the
+// current mode in the same structure
+func (server *Server) adminReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to toggle read-only mode")
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, server.maxBodySize))
+	dec.DisallowUnknownFields()
+
+	var req ReadOnlyRequest
+	if err := dec.Decode(&req); err != nil || req.ReadOnly == nil {
+		logger.WithField("error", err).Info("unable to decode request body")
+		writeJSONError(w, http.StatusBadRequest, "invalid json object, expected a boolean 'read_only'")
+		return
+	}
+
+	var mode int32
+	if *req.ReadOnly {
+		mode = 1
+	}
+	atomic.StoreInt32(server.readOnly, mode)
+
+	logger.WithField("read_only", *req.ReadOnly).Warn("toggled read-only mode")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReadOnlyRequest{req.ReadOnly})
+}
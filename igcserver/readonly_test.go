@@ -0,0 +1,120 @@
+package igcserver
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test that writes are rejected while the server is in read-only mode, that
+// reads still succeed, and that the mode can be toggled at runtime
+func TestReadOnlyToggle(t *testing.T) {
+	server, fileserver := makeTestServers(WithAPIKeys("secret"))
+	defer fileserver.Close()
+
+	track := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	other := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/copy.igc")
+	for i, data := range []struct {
+		method string
+		uri    string
+		body   string
+		auth   string
+		code   int
+	}{
+		{"POST", "/track", track, "Bearer secret", 200},
+		// The mode can only be toggled with valid credentials
+		{"POST", "/admin/readonly", `{"read_only":true}`, "", 401},
+		{"POST", "/admin/readonly", `{"read_only":true}`, "Bearer wrong", 403},
+		{"POST", "/admin/readonly", `{}`, "Bearer secret", 400},
+		{"POST", "/admin/readonly", `{"read_only":"yes"}`, "Bearer secret", 400},
+		{"POST", "/admin/readonly", `{"read_only":true}`, "Bearer secret", 200},
+		// Writes are rejected while reads succeed
+		{"POST", "/track", other, "Bearer secret", 503},
+		{"DELETE", "/track/1232", "", "Bearer secret", 503},
+		{"PATCH", "/track/1232", `{"pilot":"John"}`, "Bearer secret", 503},
+		{"POST", "/webhook/new_track", "{}", "Bearer secret", 503},
+		{"GET", "/track", "", "", 200},
+		{"HEAD", "/track", "", "", 200},
+		{"GET", "/track/count", "", "", 200},
+		// Leaving read-only mode accepts writes again
+		{"POST", "/admin/readonly", `{"read_only":false}`, "Bearer secret", 200},
+		{"POST", "/track", other, "Bearer secret", 200},
+	} {
+		req := httptest.NewRequest(data.method, data.uri, strings.NewReader(data.body))
+		if data.auth != "" {
+			req.Header.Set("Authorization", data.auth)
+		}
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("step %d: expected `%s %s` to return '%d', got '%d'", i, data.method, data.uri, data.code, code)
+		}
+		retryAfter := res.Header().Get("Retry-After")
+		if data.code == 503 && retryAfter != strconv.Itoa(readOnlyRetryAfter) {
+			t.Errorf("step %d: expected `Retry-After: %d` when rejected, got '%s'", i, readOnlyRetryAfter, retryAfter)
+		} else if data.code != 503 && retryAfter != "" {
+			t.Errorf("step %d: expected no `Retry-After` when not rejected, got '%s'", i, retryAfter)
+		}
+	}
+}
+
+// Test that the server can be started in read-only mode, and that the admin
+// endpoint is hidden unless it is guarded by authentication
+func TestReadOnlyStartup(t *testing.T) {
+	server, fileserver := makeTestServers(WithReadOnly(true))
+	defer fileserver.Close()
+
+	for _, data := range []struct {
+		method string
+		uri    string
+		body   string
+		code   int
+	}{
+		{"POST", "/track", fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc"), 503},
+		{"GET", "/track", "", 200},
+		{"POST", "/admin/readonly", `{"read_only":false}`, 404},
+	} {
+		req := httptest.NewRequest(data.method, data.uri, strings.NewReader(data.body))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `%s %s` to return '%d', got '%d'", data.method, data.uri, data.code, code)
+		}
+	}
+}
+
+// Test that the mode can be toggled while requests are handled concurrently
+func TestReadOnlyConcurrentToggle(t *testing.T) {
+	server, fileserver := makeTestServers(WithAPIKeys("secret"))
+	defer fileserver.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				body := fmt.Sprintf(`{"read_only":%t}`, (w+i)%2 == 0)
+				req := httptest.NewRequest("POST", "/admin/readonly", strings.NewReader(body))
+				req.Header.Set("Authorization", "Bearer secret")
+				server.ServeHTTP(httptest.NewRecorder(), req)
+
+				req = httptest.NewRequest("DELETE", "/track/1232", nil)
+				req.Header.Set("Authorization", "Bearer secret")
+				res := httptest.NewRecorder()
+				server.ServeHTTP(res, req)
+				if code := res.Result().StatusCode; code != 404 && code != 503 {
+					t.Errorf("expected delete to give 404 or 503, got '%d'", code)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
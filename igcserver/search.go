@@ -0,0 +1,95 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TrackMatch is a track matching a search, where a higher score means a more
+// relevant match
+type TrackMatch struct {
+	ID    TrackID `json:"id"`
+	Score int     `json:"score"`
+}
+
+// scoreField scores how well the field matches a single search token. Matching
+// the whole field scores highest, then matching a whole word of the field,
+// and then matching a part of the field.
+func scoreField(field, token string) int {
+	field = strings.ToLower(field)
+	switch {
+	case field == token:
+		return 3
+	case strings.Contains(field, token):
+		for _, word := range strings.Fields(field) {
+			if word == token {
+				return 2
+			}
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// searchTrackMetas scores the pilot, glider and glider id of the track metas
+// against every token in the query, and returns all tracks with a positive
+// score ordered by descending score. Ties keep the order of the track metas.
+func searchTrackMetas(metas []TrackMeta, query string) []TrackMatch {
+	tokens := strings.Fields(strings.ToLower(query))
+	matches := make([]TrackMatch, 0)
+	for _, meta := range metas {
+		score := 0
+		for _, token := range tokens {
+			for _, field := range []string{meta.Pilot, meta.Glider, meta.GliderID} {
+				score += scoreField(field, token)
+			}
+		}
+		if score > 0 {
+			matches = append(matches, TrackMatch{meta.ID, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// trackSearchHandler returns the ids of the tracks where the pilot, glider or
+// glider id matches the `q` query parameter, ordered by relevance. The scores
+// of the matches are included if the `scores` query parameter is `true`.
+func (server *Server) trackSearchHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to search for tracks")
+
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		logger.Info("search query must not be empty")
+		writeJSONError(w, http.StatusBadRequest, "missing search query")
+		return
+	}
+	qlog := logger.WithField("query", query)
+
+	matches, err := server.tracks.Search(query)
+	if err != nil {
+		qlog.WithField("error", err).Error("unable to search for tracks")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	qlog.WithField("matches", len(matches)).Info("responding with matching tracks")
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("scores") == "true" {
+		json.NewEncoder(w).Encode(matches)
+		return
+	}
+	ids := make([]TrackID, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	json.NewEncoder(w).Encode(ids)
+}
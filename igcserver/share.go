@@ -0,0 +1,167 @@
+package igcserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// sharedTrackRoute is the name of the route which serves shared tracks,
+	// which is let through without credentials as the token grants access
+	sharedTrackRoute = "shared-track"
+
+	// defaultShareTTL is the amount of seconds a share link is valid if no
+	// ttl is given
+	defaultShareTTL = 3600
+
+	// maxShareTTL is the maximum amount of seconds a share link is valid
+	maxShareTTL = 30 * 24 * 3600
+
+	// sharePayloadSize is the size of the signed payload of a share token,
+	// which is the id of the track followed by the expiry in unix seconds
+	sharePayloadSize = 4 + 8
+)
+
+var (
+	// errInvalidShareToken is returned if a share token is malformed or its
+	// signature doesn't match
+	errInvalidShareToken = errors.New("invalid share token")
+	// errExpiredShareToken is returned if a share token has expired
+	errExpiredShareToken = errors.New("expired share token")
+)
+
+// signShareToken creates a token which grants access to the track until the
+// expiry, where the payload is signed using HMAC-SHA256 with the secret
+func signShareToken(secret []byte, id TrackID, expires time.Time) string {
+	token := make([]byte, sharePayloadSize, sharePayloadSize+sha256.Size)
+	binary.BigEndian.PutUint32(token[:4], uint32(id))
+	binary.BigEndian.PutUint64(token[4:], uint64(expires.Unix()))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(token)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(token))
+}
+
+// verifyShareToken returns the id of the track the token grants access to, if
+// the signature of the token is valid and it hasn't expired at the time now
+func verifyShareToken(secret []byte, token string, now time.Time) (TrackID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != sharePayloadSize+sha256.Size {
+		return 0, errInvalidShareToken
+	}
+	payload, sig := raw[:sharePayloadSize], raw[sharePayloadSize:]
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return 0, errInvalidShareToken
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload[4:])), 0)
+	if !now.Before(expires) {
+		return 0, errExpiredShareToken
+	}
+	return TrackID(binary.BigEndian.Uint32(payload[:4])), nil
+}
+
+// trackShareHandler responds with a link which grants access to the metadata
+// of the track without credentials, until it expires after the amount of
+// seconds given by the `ttl` query parameter
+func (server *Server) trackShareHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to share track")
+
+	vars := mux.Vars(r)
+	// Should never fail because of mux
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+
+	ttl := defaultShareTTL
+	if ttlStr := r.URL.Query().Get("ttl"); ttlStr != "" {
+		ttl, err = strconv.Atoi(ttlStr)
+		if err != nil || ttl <= 0 || ttl > maxShareTTL {
+			idlog.WithField("ttl", ttlStr).Info("invalid ttl of share link")
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ttl must be a number of seconds between 1 and %d", maxShareTTL))
+			return
+		}
+	}
+
+	if _, err := server.tracks.Get(TrackID(id)); err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Info("error when getting metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(ttl) * time.Second).Truncate(time.Second)
+	token := signShareToken(server.shareSecret, TrackID(id), expires)
+
+	idlog.WithField("expires", expires).Info("responding with share link of track")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":     fmt.Sprintf("%s%s/s/%s", server.publicURL, server.prefix, token),
+		"expires": expires.UTC(),
+	})
+}
+
+// sharedTrackHandler responds with the metadata of the track the token grants
+// access to, or with 410 if the token has expired
+func (server *Server) sharedTrackHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get shared track")
+
+	vars := mux.Vars(r)
+	// Should never fail because of mux
+	token, _ := vars["token"]
+	id, err := verifyShareToken(server.shareSecret, token, time.Now())
+	if err == errExpiredShareToken {
+		logger.Info("share link has expired")
+		writeJSONError(w, http.StatusGone, "share link has expired")
+		return
+	} else if err != nil {
+		logger.WithField("error", err).Info("share link is invalid")
+		writeJSONError(w, http.StatusForbidden, "invalid share link")
+		return
+	}
+	idlog := logger.WithField("id", id)
+
+	meta, err := server.tracks.Get(id)
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of shared id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Info("error when getting metadata of shared id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	idlog.WithFields(log.Fields{
+		"trackmeta": meta,
+	}).Info("responding with track meta of shared id")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
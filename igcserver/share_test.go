@@ -0,0 +1,155 @@
+package igcserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Convenience function to create a share link of the track using
+// GET /track/<id>/share
+func getShareLink(t *testing.T, server *Server, id TrackID, query string) string {
+	req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d/share%s", id, query), nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `GET /track/%d/share%s` to return 200, got '%d'", id, query, code)
+	}
+	var data map[string]string
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	return data["url"]
+}
+
+// Test that a share link gives the metadata of the track without credentials,
+// and that tampered and expired links are rejected
+func TestIgcServerShareTrack(t *testing.T) {
+	secret := "s3cret"
+	server, fileserver := makeTestServers(WithShareSecret(secret), WithAPIKeys("key"), WithReadAuth(true))
+	defer fileserver.Close()
+
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d/share?ttl=60", meta.ID), nil)
+	req.Header.Set("Authorization", "Bearer key")
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+
+	var data map[string]string
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	link := data["url"]
+	if !strings.HasPrefix(link, "/s/") {
+		t.Fatalf("expected share link to start with '/s/', got '%s'", link)
+	}
+	expires, err := time.Parse(time.RFC3339, data["expires"])
+	if err != nil || expires.Before(time.Now().Add(50*time.Second)) || expires.After(time.Now().Add(61*time.Second)) {
+		t.Errorf("expected share link to expire in 60 seconds, got '%s'", data["expires"])
+	}
+
+	// Tamper with the id of the track while keeping the signature
+	token := strings.TrimPrefix(link, "/s/")
+	raw, _ := base64.RawURLEncoding.DecodeString(token)
+	raw[3] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(raw)
+
+	for _, data := range []struct {
+		token string
+		code  int
+	}{
+		{token, 200},
+		{tampered, 403},
+		{token[:len(token)-2], 403},
+		{"invalid", 403},
+		{signShareToken([]byte("other"), meta.ID, time.Now().Add(time.Hour)), 403},
+		{signShareToken([]byte(secret), meta.ID, time.Now().Add(-time.Second)), 410},
+		{signShareToken([]byte(secret), 1, time.Now().Add(time.Hour)), 404},
+	} {
+		req := httptest.NewRequest("GET", "/s/"+data.token, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `GET /s/%s` to return '%d', got '%d'", data.token, data.code, code)
+		}
+		if data.code != 200 {
+			continue
+		}
+		var shared TrackMeta
+		if err := json.Unmarshal(res.Body.Bytes(), &shared); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+		if shared.Pilot != meta.Pilot || shared.TrackSrcURL != meta.TrackSrcURL {
+			t.Errorf("expected shared track to be '%v', got '%v'", meta, shared)
+		}
+	}
+
+	// Creating share links still requires credentials when reads are locked
+	req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d/share", meta.ID), nil)
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if code := res.Result().StatusCode; code != 401 {
+		t.Errorf("expected share link without credentials to return 401, got '%d'", code)
+	}
+}
+
+// Test that share links are validated and hidden unless a secret is given
+func TestIgcServerShareTrackBad(t *testing.T) {
+	server, fileserver := makeTestServers(WithShareSecret("s3cret"))
+	defer fileserver.Close()
+
+	meta := makeIGCTestData(fileserver.URL)[0]
+	if err := server.tracks.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	if link := getShareLink(t, &server, meta.ID, ""); !strings.HasPrefix(link, "/s/") {
+		t.Errorf("expected share link with default ttl, got '%s'", link)
+	}
+
+	for _, data := range []struct {
+		uri  string
+		code int
+	}{
+		{fmt.Sprintf("/track/%d/share?ttl=0", meta.ID), 400},
+		{fmt.Sprintf("/track/%d/share?ttl=-60", meta.ID), 400},
+		{fmt.Sprintf("/track/%d/share?ttl=hour", meta.ID), 400},
+		{fmt.Sprintf("/track/%d/share?ttl=%d", meta.ID, maxShareTTL+1), 400},
+		{"/track/abc/share", 400},
+		{"/track/1/share", 404},
+	} {
+		req := httptest.NewRequest("GET", data.uri, nil)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected `GET %s` to return '%d', got '%d'", data.uri, data.code, code)
+		}
+	}
+
+	// The share api is hidden without a secret
+	server, fileserver = makeTestServers()
+	defer fileserver.Close()
+	token := signShareToken([]byte(""), meta.ID, time.Now().Add(time.Hour))
+	req := httptest.NewRequest("GET", "/s/"+token, nil)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if code := res.Result().StatusCode; code != 404 {
+		t.Errorf("expected `GET /s/<token>` without a secret to return 404, got '%d'", code)
+	}
+}
@@ -0,0 +1,69 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// TrackStats contains aggregates over all registered tracks, where the longest
+// track and the most frequent glider are nil if there are no tracks
+type TrackStats struct {
+	TotalTracks        int      `json:"total_tracks"`
+	TotalTrackLength   float64  `json:"total_track_length"`
+	AvgTrackLength     float64  `json:"avg_track_length"`
+	LongestTrack       *TrackID `json:"longest_track"`
+	MostFrequentGlider *string  `json:"most_frequent_glider"`
+}
+
+// calcTrackStats aggregates the metadata of the tracks in a single pass. Ties
+// are broken by the lowest id and the alphabetically first glider, such that
+// the result doesn't depend on the order of the tracks.
+func calcTrackStats(metas []TrackMeta) (stats TrackStats) {
+	var longest TrackMeta
+	gliders := make(map[string]int)
+	for i, meta := range metas {
+		stats.TotalTrackLength += meta.TrackLength
+		if i == 0 || meta.TrackLength > longest.TrackLength ||
+			(meta.TrackLength == longest.TrackLength && meta.ID < longest.ID) {
+			longest = meta
+		}
+		gliders[meta.Glider]++
+	}
+	stats.TotalTracks = len(metas)
+	if stats.TotalTracks == 0 {
+		return
+	}
+	stats.AvgTrackLength = stats.TotalTrackLength / float64(stats.TotalTracks)
+	stats.LongestTrack = &longest.ID
+
+	var glider string
+	for g, n := range gliders {
+		if stats.MostFrequentGlider == nil || n > gliders[glider] || (n == gliders[glider] && g < glider) {
+			glider = g
+			stats.MostFrequentGlider = &glider
+		}
+	}
+	return
+}
+
+// statsHandler responds with aggregates over all registered tracks
+func (server *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get stats of tracks")
+
+	// All the metadata is fetched at once, such that the aggregates are
+	// computed from a consistent snapshot of the tracks
+	metas, err := server.tracks.GetAll()
+	if err != nil {
+		logger.WithField("error", err).Error("unable to get all tracks")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	stats := calcTrackStats(metas)
+
+	logger.WithField("stats", stats).Info("responding with stats of tracks")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,88 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test that the stats of tracks are aggregated correctly
+func TestCalcTrackStats(t *testing.T) {
+	testTrackMetas := makeIGCTestData("localhost")
+	aladin, john := testTrackMetas[0], testTrackMetas[1]
+	carpet := aladin
+	carpet.ID = NewTrackID([]byte("carpet"))
+	carpet.TrackLength = 50
+
+	str := func(s string) *string { return &s }
+	id := func(id TrackID) *TrackID { return &id }
+
+	for _, data := range []struct {
+		metas []TrackMeta
+		expt  TrackStats
+	}{
+		{nil, TrackStats{}},
+		{[]TrackMeta{john}, TrackStats{1, 10, 10, id(john.ID), str("Boeng 777")}},
+		{[]TrackMeta{aladin, john}, TrackStats{2, 1210, 605, id(aladin.ID), str("Boeng 777")}},
+		{[]TrackMeta{john, aladin, carpet}, TrackStats{3, 1260, 420, id(aladin.ID), str("Magical Carpet")}},
+	} {
+		if stats := calcTrackStats(data.metas); !cmp.Equal(stats, data.expt) {
+			t.Errorf("expected stats of %d tracks to be '%+v', got '%+v'", len(data.metas), data.expt, stats)
+		}
+	}
+}
+
+// Test that GET /stats responds with the aggregates of the tracks
+func TestStats(t *testing.T) {
+	trackMetasMap := NewTrackMetasMap()
+	server := NewServer(nil, &trackMetasMap, nil, nil)
+
+	req := httptest.NewRequest("GET", "/stats", nil)
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var empty map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &empty); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	expt := map[string]interface{}{
+		"total_tracks":         0.0,
+		"total_track_length":   0.0,
+		"avg_track_length":     0.0,
+		"longest_track":        nil,
+		"most_frequent_glider": nil,
+	}
+	if !cmp.Equal(empty, expt) {
+		t.Errorf("expected stats without tracks to be '%v', got '%v'", expt, empty)
+	}
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, trackMeta := range testTrackMetas {
+		if err := trackMetasMap.Append(trackMeta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/stats", nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected `GET /stats` to return 200, got '%d'", code)
+	}
+	var stats TrackStats
+	if err := json.Unmarshal(res.Body.Bytes(), &stats); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if expt := calcTrackStats(testTrackMetas); !cmp.Equal(stats, expt) {
+		t.Errorf("expected stats to be '%+v', got '%+v'", expt, stats)
+	}
+	if stats.TotalTracks != 2 || stats.TotalTrackLength != 1210 || *stats.LongestTrack != testTrackMetas[0].ID {
+		t.Errorf("expected stats of the test data, got '%+v'", stats)
+	}
+}
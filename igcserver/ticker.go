@@ -6,6 +6,7 @@ import (
 	"github.com/gorilla/mux"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -16,9 +17,18 @@ var (
 )
 
 // Ticker is a generic interface for any type which can act as a ticker
+//
+// The latest timestamp is cached, such that getting it never scans the
+// tracks. It is updated when new timestamps are reported, and recomputed from
+// the tracks when the track with the latest timestamp is deleted. Tickers of
+// storages shared by several instances, eg. TickerStore over Redis, read it
+// from the storage instead, as the other instances don't report to them.
+//
+// A limit of 0 when getting a report means that all tracks should be included.
 type Ticker interface {
 	Latest() *time.Time
 	Reporter(latest time.Time)
+	Deleted(timestamp time.Time)
 	GetReport(limit int) (TickerReport, error)
 	GetReportAfter(timestamp time.Time, limit int) (TickerReport, error)
 }
@@ -33,30 +43,46 @@ type Ticker interface {
 // "processing": <time in ms of how long it took to process the request>
 // }
 type TickerReport struct {
-	Latest     time.Time     `json:"t_latest"`
-	Start      time.Time     `json:"t_start"`
-	End        time.Time     `json:"t_stop"`
-	Tracks     []TrackID     `json:"tracks"`
-	Processing time.Duration `json:"processing"`
+	Latest     time.Time `json:"t_latest"`
+	Start      time.Time `json:"t_start"`
+	End        time.Time `json:"t_stop"`
+	Tracks     []TrackID `json:"tracks"`
+	Processing int64     `json:"processing"`
+}
+
+// processingMillis returns the time in ms since the given start
+func processingMillis(start time.Time) int64 {
+	return int64(time.Since(start) / time.Millisecond)
+}
+
+// parseTimestamp parses a timestamp given as milliseconds since the unix epoch
+// or formatted as specified in RFC3339
+func parseTimestamp(str string) (time.Time, error) {
+	if millis, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339, str)
 }
 
 // ---------- //
 // TICKER API //
 // ---------- //
 
+// tickerHandler responds with a report of the oldest added tracks, where the
+// amount of tracks is limited by the ticker page size of the server
 func (server *Server) tickerHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
 	logger.Info("processing request to get ticker report")
 
-	report, err := server.ticker.GetReport(5)
+	report, err := server.ticker.GetReport(server.tickerPageSize)
 	if err == ErrNoTracksFound {
 		logger.WithField("error", err).Info("no tracks registered")
-		http.Error(w, "content not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "content not found")
 		return
 	} else if err != nil {
 		logger.WithField("error", err).Info("unable to build ticker report")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
 
@@ -64,34 +90,51 @@ func (server *Server) tickerHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(report)
 }
 
+// tickerAfterHandler responds with a report of the tracks added after the
+// given timestamp, where the amount of tracks is limited by the ticker page
+// size of the server
+//
+// The timestamp is either given as milliseconds since the unix epoch or
+// formatted as specified in RFC3339.
 func (server *Server) tickerAfterHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
 	logger.Info("processing request to get ticker report after timestamp")
 
+	start := time.Now()
+
 	vars := mux.Vars(r)
 	timestampStr, _ := vars["timestamp"]
-	timestamp, err := time.Parse(time.RFC3339, timestampStr)
+	timestamp, err := parseTimestamp(timestampStr)
 	if err != nil {
 		logger.WithField("error", err).Info("unable to parse as timestamp")
-		http.Error(w, "invalid timestamp", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid timestamp")
 		return
 	}
 
-	report, err := server.ticker.GetReportAfter(timestamp, 5)
+	report, err := server.ticker.GetReportAfter(timestamp, server.tickerPageSize)
 	if err == ErrNoTracksFound {
-		logger.WithField("error", err).Info("no tracks registered")
-		http.Error(w, "content not found", http.StatusNotFound)
-		return
+		// No tracks after the timestamp is a valid answer when polling for new
+		// tracks, hence respond with an empty report
+		logger.Info("no tracks registered after timestamp")
+		report = TickerReport{
+			Tracks:     []TrackID{},
+			Processing: processingMillis(start),
+		}
+		if latest := server.ticker.Latest(); latest != nil {
+			report.Latest = *latest
+		}
 	} else if err != nil {
 		logger.WithField("error", err).Info("unable to build ticker report")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(report)
 }
 
+// tickerLatestHandler responds with the timestamp of the latest added track as
+// plain text, or with no content if no tracks have been added
 func (server *Server) tickerLatestHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
@@ -100,7 +143,7 @@ func (server *Server) tickerLatestHandler(w http.ResponseWriter, r *http.Request
 	latest := server.ticker.Latest()
 	if latest == nil {
 		logger.Info("latest timestamp of request not set")
-		http.Error(w, "content not found", http.StatusNotFound)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 	logger.WithField("latest", latest).Info("responding with latest timestamp")
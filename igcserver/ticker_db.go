@@ -13,32 +13,26 @@ type TickerDB struct {
 	latest    *time.Time
 	publisher chan *time.Time
 	reporter  chan time.Time
+	deleter   chan time.Time
 }
 
 // NewTickerDB creates a new database-aware ticker instance
 func NewTickerDB(session *mgo.Session, buf int) TickerDB {
 	reporter := make(chan time.Time, buf)
 	publisher := make(chan *time.Time)
+	deleter := make(chan time.Time)
 	ticker := TickerDB{
 		session,
 		nil,
 		publisher,
 		reporter,
+		deleter,
 	}
 
 	// Initialize ticker.latest from DB on remake
-	conn := ticker.session.Copy()
-	defer conn.Close()
-	tracks := conn.DB("").C(trackCollection)
-
-	var meta TrackMeta
-	err := tracks.
-		Find(nil).
-		Sort("-timestamp").
-		One(&meta)
-
+	latest, err := ticker.queryLatest()
 	if err == nil {
-		ticker.latest = &meta.Timestamp
+		ticker.latest = latest
 	} else {
 		log.Warn("unable to get initial timestamp from database")
 	}
@@ -52,6 +46,18 @@ func NewTickerDB(session *mgo.Session, buf int) TickerDB {
 			// We received a new latest value
 			case latest := <-reporter:
 				ticker.latest = &latest
+			// A track was deleted, which requires finding the new latest
+			// value if it was the latest track
+			case deleted := <-deleter:
+				if ticker.latest == nil || deleted.Before(*ticker.latest) {
+					continue
+				}
+				latest, err := ticker.queryLatest()
+				if err != nil && err != mgo.ErrNotFound {
+					log.WithField("error", err).Warn("unable to recompute latest timestamp from database")
+					continue
+				}
+				ticker.latest = latest
 			// A user asked for the latest value so we send it
 			case publisher <- ticker.latest:
 			}
@@ -61,12 +67,37 @@ func NewTickerDB(session *mgo.Session, buf int) TickerDB {
 	return ticker
 }
 
+// queryLatest finds the latest timestamp of the tracks in the database, which
+// is nil if there are no tracks
+func (t *TickerDB) queryLatest() (*time.Time, error) {
+	conn := t.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	var meta TrackMeta
+	err := tracks.
+		Find(nil).
+		Select(bson.M{"timestamp": 1}).
+		Sort("-timestamp").
+		One(&meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta.Timestamp, nil
+}
+
 // Reporter returns a channel which expects to have timestamps sent to it and
 // it will listen to it and keep the current timestamp updated accordingly
 func (t *TickerDB) Reporter(latest time.Time) {
 	t.reporter <- latest
 }
 
+// Deleted makes the ticker recompute the latest timestamp if the deleted track
+// was the latest track, and blocks until it is recomputed
+func (t *TickerDB) Deleted(timestamp time.Time) {
+	t.deleter <- timestamp
+}
+
 // Latest returns a channel which expects send the current latest timestamp on
 // a request
 func (t *TickerDB) Latest() *time.Time {
@@ -91,27 +122,43 @@ func (t *TickerDB) GetReportAfter(timestamp time.Time, limit int) (rep TickerRep
 	err = tracks.
 		Find(bson.M{"timestamp": bson.M{"$gt": timestamp}}).
 		Limit(limit).
-		Sort("timestamp").
+		Sort("timestamp", "id").
 		All(&trackMetas)
 
 	if err == nil {
 		if len(trackMetas) < 1 {
 			err = ErrNoTracksFound
 		} else {
-			latest := t.Latest()
 			firststamp := trackMetas[0].Timestamp
 			laststamp := trackMetas[len(trackMetas)-1].Timestamp
+			// The latest timestamp is of the entire collection, while the
+			// start and stop are of the tracks in the report, such that
+			// clients have caught up when the stop equals the latest
+			var newest TrackMeta
+			err = tracks.
+				Find(nil).
+				Select(bson.M{"timestamp": 1}).
+				Sort("-timestamp").
+				One(&newest)
+			if err != nil {
+				return
+			}
+			// The tracks of the report may have been deleted since they were
+			// found
+			latest := newest.Timestamp
+			if latest.Before(laststamp) {
+				latest = laststamp
+			}
 			ids := make([]TrackID, len(trackMetas))
 			for i, meta := range trackMetas {
 				ids[i] = meta.ID
 			}
-			processing := time.Since(start)
 			rep = TickerReport{
-				*latest,
+				latest,
 				firststamp,
 				laststamp,
 				ids,
-				processing,
+				processingMillis(start),
 			}
 		}
 	}
@@ -0,0 +1,168 @@
+package igcserver
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// latestTrackMetas is a storage which can find the latest timestamp of its
+// tracks by itself, without fetching all the tracks
+type latestTrackMetas interface {
+	Latest() (*time.Time, error)
+}
+
+// Make sure that the storages which may be shared by several instances can
+// be queried for the latest timestamp
+var (
+	_ latestTrackMetas = (*TrackMetasRedis)(nil)
+	_ latestTrackMetas = (*TrackMetasPostgres)(nil)
+)
+
+// TickerStore is a ticker which reads the tracks through a TrackMetas, hence
+// it works with any storage backend
+//
+// If the storage can be queried for the latest timestamp, eg. Redis or
+// PostgreSQL, the latest timestamp is read from the storage every time. This
+// way instances sharing a storage behind a load balancer see the tracks
+// registered by each other. Otherwise the latest timestamp is cached, and only
+// recomputed from the storage when the latest track is deleted.
+type TickerStore struct {
+	tracks TrackMetas
+	mutex  *sync.Mutex
+	latest *time.Time
+}
+
+// NewTickerStore creates a new ticker which reports on the given tracks
+func NewTickerStore(tracks TrackMetas) TickerStore {
+	ticker := TickerStore{
+		tracks,
+		&sync.Mutex{},
+		nil,
+	}
+	if _, ok := tracks.(latestTrackMetas); !ok {
+		latest, err := ticker.queryLatest()
+		if err != nil {
+			log.WithField("error", err).Warn("unable to get initial timestamp from storage")
+		}
+		ticker.latest = latest
+	}
+	return ticker
+}
+
+// queryLatest finds the latest timestamp of the tracks in the storage, which
+// is nil if there are no tracks
+func (t *TickerStore) queryLatest() (*time.Time, error) {
+	if tracks, ok := t.tracks.(latestTrackMetas); ok {
+		return tracks.Latest()
+	}
+	ids, err := t.tracks.GetAllSorted("timestamp", true)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		// The track may have been deleted since the ids were fetched
+		meta, err := t.tracks.Get(id)
+		if err == nil {
+			return &meta.Timestamp, nil
+		} else if err != ErrTrackNotFound {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Reporter caches the timestamp of a new track if it is the latest timestamp
+func (t *TickerStore) Reporter(latest time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.latest == nil || latest.After(*t.latest) {
+		t.latest = &latest
+	}
+}
+
+// Deleted recomputes the cached latest timestamp from the storage if the
+// deleted track was the latest track
+func (t *TickerStore) Deleted(timestamp time.Time) {
+	if _, ok := t.tracks.(latestTrackMetas); ok {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.latest == nil || timestamp.Before(*t.latest) {
+		return
+	}
+	latest, err := t.queryLatest()
+	if err != nil {
+		log.WithField("error", err).Warn("unable to recompute latest timestamp from storage")
+		return
+	}
+	t.latest = latest
+}
+
+// Latest returns the latest timestamp of the tracks in the storage, which is
+// nil if there are no tracks
+func (t *TickerStore) Latest() *time.Time {
+	if tracks, ok := t.tracks.(latestTrackMetas); ok {
+		latest, err := tracks.Latest()
+		if err != nil {
+			log.WithField("error", err).Warn("unable to get latest timestamp from storage")
+			return nil
+		}
+		return latest
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.latest
+}
+
+// GetReport returns a report of the oldest registered timestamps with the given limit
+func (t *TickerStore) GetReport(limit int) (TickerReport, error) {
+	return t.GetReportAfter(time.Unix(0, 0), limit)
+}
+
+// GetReportAfter returns a report after a specified time with the given limit
+func (t *TickerStore) GetReportAfter(timestamp time.Time, limit int) (rep TickerReport, err error) {
+	start := time.Now()
+
+	// Build the report from a single snapshot of the tracks, such that the
+	// latest timestamp is consistent with the tracks of the report
+	all, err := t.tracks.GetAll()
+	if err != nil {
+		return
+	}
+
+	var latest time.Time
+	trackMetas := make([]TrackMeta, 0, len(all))
+	for _, meta := range all {
+		if meta.Timestamp.After(latest) {
+			latest = meta.Timestamp
+		}
+		if meta.Timestamp.After(timestamp) {
+			trackMetas = append(trackMetas, meta)
+		}
+	}
+	if len(trackMetas) < 1 {
+		err = ErrNoTracksFound
+		return
+	}
+	if err = sortTrackMetas(trackMetas, "timestamp", false); err != nil {
+		return
+	}
+	if limit > 0 && limit < len(trackMetas) {
+		trackMetas = trackMetas[:limit]
+	}
+
+	ids := make([]TrackID, len(trackMetas))
+	for i, meta := range trackMetas {
+		ids[i] = meta.ID
+	}
+	rep = TickerReport{
+		latest,
+		trackMetas[0].Timestamp,
+		trackMetas[len(trackMetas)-1].Timestamp,
+		ids,
+		processingMillis(start),
+	}
+	return
+}
@@ -4,21 +4,28 @@ import (
 	"time"
 )
 
-// TickerDummy is simple ticker instance for testing
+// TickerDummy is simple ticker instance for testing which builds its reports
+// from an in-memory storage of track metas
 type TickerDummy struct {
+	metas     *TrackMetasMap
 	latest    *time.Time
 	publisher chan *time.Time
 	reporter  chan time.Time
+	deleter   chan time.Time
 }
 
-// NewTickerDummy creates a new simple ticker
-func NewTickerDummy(buf int) TickerDummy {
+// NewTickerDummy creates a new simple ticker which reports on the given
+// metas, where nil metas results in a ticker without any tracks
+func NewTickerDummy(buf int, metas *TrackMetasMap) TickerDummy {
 	reporter := make(chan time.Time, buf)
 	publisher := make(chan *time.Time)
+	deleter := make(chan time.Time)
 	ticker := TickerDummy{
+		metas,
 		nil,
 		publisher,
 		reporter,
+		deleter,
 	}
 
 	// Handle all requests and responses to get latest ticker value. Doesn't
@@ -30,6 +37,12 @@ func NewTickerDummy(buf int) TickerDummy {
 			// We received a new latest value
 			case latest := <-reporter:
 				ticker.latest = &latest
+			// A track was deleted, which requires finding the new latest
+			// value if it was the latest track
+			case deleted := <-deleter:
+				if ticker.latest != nil && !deleted.Before(*ticker.latest) {
+					ticker.latest = ticker.scanLatest()
+				}
 			// A user asked for the latest value so we send it
 			case publisher <- ticker.latest:
 			}
@@ -45,6 +58,31 @@ func (t *TickerDummy) Reporter(latest time.Time) {
 	t.reporter <- latest
 }
 
+// Deleted makes the ticker recompute the latest timestamp if the deleted track
+// was the latest track, and blocks until it is recomputed
+func (t *TickerDummy) Deleted(timestamp time.Time) {
+	t.deleter <- timestamp
+}
+
+// scanLatest finds the latest timestamp of the metas, which is nil if there
+// are no metas
+func (t *TickerDummy) scanLatest() *time.Time {
+	if t.metas == nil {
+		return nil
+	}
+	all, err := t.metas.GetAll()
+	if err != nil {
+		return nil
+	}
+	var latest *time.Time
+	for i := range all {
+		if latest == nil || all[i].Timestamp.After(*latest) {
+			latest = &all[i].Timestamp
+		}
+	}
+	return latest
+}
+
 // Latest returns a channel which expects send the current latest timestamp on
 // a request
 func (t *TickerDummy) Latest() *time.Time {
@@ -59,6 +97,48 @@ func (t *TickerDummy) GetReport(limit int) (rep TickerReport, err error) {
 
 // GetReportAfter returns a report after a specified time with the given limit
 func (t *TickerDummy) GetReportAfter(timestamp time.Time, limit int) (rep TickerReport, err error) {
-	err = ErrNoTracksFound
+	start := time.Now()
+
+	if t.metas == nil {
+		err = ErrNoTracksFound
+		return
+	}
+
+	// Build the report from a single snapshot of the metas, such that the
+	// latest timestamp is consistent with the tracks of the report
+	all, err := t.metas.GetAll()
+	if err != nil {
+		return
+	}
+
+	var latest time.Time
+	trackMetas := make([]TrackMeta, 0, len(all))
+	for _, meta := range all {
+		if meta.Timestamp.After(latest) {
+			latest = meta.Timestamp
+		}
+		if meta.Timestamp.After(timestamp) {
+			trackMetas = append(trackMetas, meta)
+		}
+	}
+	if len(trackMetas) < 1 {
+		err = ErrNoTracksFound
+		return
+	}
+	if limit > 0 && limit < len(trackMetas) {
+		trackMetas = trackMetas[:limit]
+	}
+
+	ids := make([]TrackID, len(trackMetas))
+	for i, meta := range trackMetas {
+		ids[i] = meta.ID
+	}
+	rep = TickerReport{
+		latest,
+		trackMetas[0].Timestamp,
+		trackMetas[len(trackMetas)-1].Timestamp,
+		ids,
+		processingMillis(start),
+	}
 	return
 }
@@ -0,0 +1,63 @@
+package igcserver
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// trackCSVHeader contains the columns of the csv export of tracks
+var trackCSVHeader = []string{
+	"id",
+	"H_date",
+	"pilot",
+	"glider",
+	"glider_id",
+	"track_length",
+	"track_src_url",
+	"max_altitude_gain",
+	"duration",
+}
+
+// trackCSVRecord converts a track meta into a record with the same columns as
+// trackCSVHeader
+func trackCSVRecord(meta TrackMeta) []string {
+	return []string{
+		strconv.FormatInt(int64(meta.ID), 10),
+		meta.Date.Format(time.RFC3339),
+		meta.Pilot,
+		meta.Glider,
+		meta.GliderID,
+		strconv.FormatFloat(meta.TrackLength, 'f', -1, 64),
+		meta.TrackSrcURL,
+		strconv.FormatInt(meta.MaxAltitudeGain, 10),
+		strconv.FormatInt(meta.Duration, 10),
+	}
+}
+
+// trackGetCSVHandler responds with the metadata of all tracks as csv, with a
+// header row followed by a row for each track
+func (server *Server) trackGetCSVHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get all tracks as csv")
+
+	metas, err := server.tracks.GetAll()
+	if err != nil {
+		logger.WithField("error", err).Error("unable to get all tracks")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	logger.WithField("count", len(metas)).Info("responding with tracks as csv")
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="tracks.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(trackCSVHeader)
+	for _, meta := range metas {
+		out.Write(trackCSVRecord(meta))
+	}
+	out.Flush()
+}
@@ -0,0 +1,120 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// trackMetaFieldNames contains the json names of all the fields of a
+	// TrackMeta, in the order they are declared
+	trackMetaFieldNames []string
+
+	// trackMetaFieldIndex maps the json names of the fields of a TrackMeta to
+	// the index of the field in the struct
+	trackMetaFieldIndex = make(map[string]int)
+)
+
+func init() {
+	typ := reflect.TypeOf(TrackMeta{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		trackMetaFieldNames = append(trackMetaFieldNames, name)
+		trackMetaFieldIndex[name] = i
+	}
+}
+
+// formatTrackMetaField formats the field of the track meta with the given json
+// name as plain text
+func formatTrackMetaField(meta TrackMeta, name string) (string, bool) {
+	i, ok := trackMetaFieldIndex[name]
+	if !ok {
+		return "", false
+	}
+	switch v := reflect.ValueOf(meta).Field(i).Interface().(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	default:
+		return "", false
+	}
+}
+
+// parseFieldList parses a comma separated list of json names of fields of a
+// TrackMeta, and returns an error naming the first unknown field
+func parseFieldList(list string) ([]string, error) {
+	fields := strings.Split(list, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+		if _, ok := trackMetaFieldIndex[fields[i]]; !ok {
+			return nil, fmt.Errorf("invalid field '%s'", fields[i])
+		}
+	}
+	return fields, nil
+}
+
+// projectJSONFields removes all keys of the encoded json object except the
+// given fields
+func projectJSONFields(body []byte, fields []string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Numbers are kept as they are instead of being converted to floats
+	dec.UseNumber()
+	var object map[string]interface{}
+	if err := dec.Decode(&object); err != nil {
+		return nil, err
+	}
+	projected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := object[field]; ok {
+			projected[field] = value
+		}
+	}
+	return json.Marshal(projected)
+}
+
+// trackGetFieldsHandler responds with the names of all the fields which can be
+// fetched using `GET /track/<id>/<field>`
+func (server *Server) trackGetFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get fields of specific track")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	if _, err := server.tracks.Get(TrackID(id)); err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	idlog.Info("responding with fields of track")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trackMetaFieldNames)
+}
@@ -0,0 +1,125 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/marni/goigc"
+	"net/http"
+	"strconv"
+)
+
+// GeoJSONPosition is a position in GeoJSON, which is ordered as longitude
+// followed by latitude (rfc 7946 3.1.1), unlike most other formats
+type GeoJSONPosition [2]float64
+
+// GeoJSONPositionFrom converts a point of an igc track into a GeoJSONPosition
+func GeoJSONPositionFrom(p igc.Point) GeoJSONPosition {
+	return GeoJSONPosition{p.Lng.Degrees(), p.Lat.Degrees()}
+}
+
+// GeoJSONGeometry is a GeoJSON geometry, where the coordinates are a single
+// position for a `Point` and a list of positions for a `LineString`
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSONFeature is a GeoJSON feature with a geometry and properties
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONFeatureCollection is a GeoJSON collection of features
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// geoJSONPoint creates a feature of the point with the given name
+func geoJSONPoint(name string, p igc.Point) GeoJSONFeature {
+	return GeoJSONFeature{
+		"Feature",
+		GeoJSONGeometry{"Point", GeoJSONPositionFrom(p)},
+		map[string]interface{}{
+			"name": name,
+			"time": p.Time.UTC(),
+		},
+	}
+}
+
+// GeoJSONFrom converts the track into a collection of a `LineString` of the
+// points of the track, followed by a `Point` of the takeoff and the landing.
+// Tracks with less than two points have no features, as a `LineString` needs
+// at least two positions.
+func GeoJSONFrom(meta TrackMeta, points []igc.Point) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{"FeatureCollection", []GeoJSONFeature{}}
+	if len(points) < 2 {
+		return collection
+	}
+	line := make([]GeoJSONPosition, len(points))
+	for i, p := range points {
+		line[i] = GeoJSONPositionFrom(p)
+	}
+	collection.Features = append(collection.Features,
+		GeoJSONFeature{
+			"Feature",
+			GeoJSONGeometry{"LineString", line},
+			map[string]interface{}{
+				"id":           meta.ID,
+				"pilot":        meta.Pilot,
+				"glider":       meta.Glider,
+				"track_length": meta.TrackLength,
+			},
+		},
+		geoJSONPoint("takeoff", points[0]),
+		geoJSONPoint("landing", points[len(points)-1]),
+	)
+	return collection
+}
+
+// trackGetMapHandler responds with the points of a track as GeoJSON, which
+// can be shown directly on web maps. The points are only available if the
+// server retains them.
+func (server *Server) trackGetMapHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get track as geojson")
+
+	if server.points == nil {
+		logger.Info("points of tracks are not retained")
+		writeJSONError(w, http.StatusNotFound, "points of tracks are not retained by this server")
+		return
+	}
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	meta, err := server.tracks.Get(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	points, ok := server.pointsOf(w, r, idlog, meta)
+	if !ok {
+		return
+	}
+
+	idlog.WithField("points", len(points)).Info("responding with track as geojson")
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(GeoJSONFrom(meta, points))
+}
@@ -0,0 +1,173 @@
+package igcserver
+
+import (
+	"encoding/xml"
+	"github.com/gorilla/mux"
+	"github.com/marni/goigc"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// trackPoints stores the parsed points of tracks in memory
+type trackPoints struct {
+	sync.RWMutex
+	data map[TrackID][]igc.Point
+}
+
+// newTrackPoints creates a new empty in-memory storage of track points
+func newTrackPoints() *trackPoints {
+	return &trackPoints{data: make(map[TrackID][]igc.Point)}
+}
+
+// Get fetches the points of a specific id if they exist
+func (points *trackPoints) Get(id TrackID) (track []igc.Point, ok bool) {
+	points.RLock()
+	defer points.RUnlock()
+	track, ok = points.data[id]
+	return
+}
+
+// Set stores the points of a specific id
+func (points *trackPoints) Set(id TrackID, track []igc.Point) {
+	points.Lock()
+	defer points.Unlock()
+	points.data[id] = track
+}
+
+// Delete removes the points of a specific id
+func (points *trackPoints) Delete(id TrackID) {
+	points.Lock()
+	defer points.Unlock()
+	delete(points.data, id)
+}
+
+// Len returns the amount of tracks with stored points
+func (points *trackPoints) Len() int {
+	points.RLock()
+	defer points.RUnlock()
+	return len(points.data)
+}
+
+// Clear removes all stored points
+func (points *trackPoints) Clear() {
+	points.Lock()
+	defer points.Unlock()
+	points.data = make(map[TrackID][]igc.Point)
+}
+
+// GPX is the root element of a GPX 1.1 document
+type GPX struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   GPXTrack `xml:"trk"`
+}
+
+// GPXTrack is a track consisting of a single segment of points
+type GPXTrack struct {
+	Name    string          `xml:"name,omitempty"`
+	Segment GPXTrackSegment `xml:"trkseg"`
+}
+
+// GPXTrackSegment is a list of points in a track
+type GPXTrackSegment struct {
+	Points []GPXTrackPoint `xml:"trkpt"`
+}
+
+// GPXTrackPoint is a single point in a track
+type GPXTrackPoint struct {
+	Lat       float64   `xml:"lat,attr"`
+	Lon       float64   `xml:"lon,attr"`
+	Elevation int64     `xml:"ele"`
+	Time      time.Time `xml:"time"`
+}
+
+// GPXFrom converts the points of a track into a GPX document
+func GPXFrom(meta TrackMeta, points []igc.Point) GPX {
+	altitude := pointAltitude(points)
+	trkpts := make([]GPXTrackPoint, len(points))
+	for i, p := range points {
+		trkpts[i] = GPXTrackPoint{
+			p.Lat.Degrees(),
+			p.Lng.Degrees(),
+			altitude(p),
+			p.Time.UTC(),
+		}
+	}
+	return GPX{
+		Version: "1.1",
+		Creator: "paragliding",
+		Track: GPXTrack{
+			Name:    meta.Pilot,
+			Segment: GPXTrackSegment{trkpts},
+		},
+	}
+}
+
+// pointsOf returns the stored points of the track, or parses them from the
+// igc file of the track if they aren't stored
+func (server *Server) pointsOf(w http.ResponseWriter, r *http.Request, logger *log.Entry, meta TrackMeta) ([]igc.Point, bool) {
+	if server.points != nil {
+		if points, ok := server.points.Get(meta.ID); ok {
+			return points, true
+		}
+	}
+	content, ok := server.rawIGC(w, r, logger, meta)
+	if !ok {
+		return nil, false
+	}
+	track, err := server.parseIGC(content)
+	if err != nil {
+		logger.WithField("error", err).Info("unable to parse igc content as track")
+		writeJSONError(w, http.StatusBadGateway, "unable to parse igc content")
+		return nil, false
+	}
+	if server.points != nil {
+		server.points.Set(meta.ID, track.Points)
+	}
+	return track.Points, true
+}
+
+// trackGetGPXHandler responds with the points of a track as a GPX 1.1
+// document
+func (server *Server) trackGetGPXHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get track as gpx")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	meta, err := server.tracks.Get(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	points, ok := server.pointsOf(w, r, idlog, meta)
+	if !ok {
+		return
+	}
+
+	idlog.WithField("points", len(points)).Info("responding with track as gpx")
+
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(GPXFrom(meta, points))
+}
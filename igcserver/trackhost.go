@@ -0,0 +1,244 @@
+package igcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// errHostNotAllowed is returned if tracks can't be fetched from a host
+	// because it isn't in the allowed hosts
+	errHostNotAllowed = errors.New("host is not allowed")
+
+	// errPrivateHost is returned if tracks can't be fetched from a host
+	// because it resolves to a private address
+	errPrivateHost = errors.New("host resolves to a private address")
+
+	// errTooManyRedirects is returned if fetching a track is redirected more
+	// than the maximum amount of times
+	errTooManyRedirects = errors.New("too many redirects")
+
+	// errRedirectLoop is returned if fetching a track is redirected to an url
+	// which has already been visited
+	errRedirectLoop = errors.New("redirect loop detected")
+)
+
+// privateNets contains the ranges of addresses which are not publicly
+// reachable, ie. private, carrier-grade NAT, loopback and link-local addresses
+var privateNets = func() (nets []*net.IPNet) {
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return
+}()
+
+// hostAllowed checks if tracks can be fetched from the host, which is the case
+// if it equals or is a subdomain of one of the allowed hosts. All hosts are
+// allowed if there are no allowed hosts.
+func hostAllowed(allowed []string, host string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, a := range allowed {
+		a = strings.ToLower(strings.Trim(a, "."))
+		if host == a || strings.HasSuffix(host, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateIP checks if the address is in one of the private ranges
+func isPrivateIP(ip net.IP) bool {
+	for _, ipnet := range privateNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvesToPrivate checks if any of the addresses which the host resolves to
+// are private
+func resolvesToPrivate(ctx context.Context, host string) (bool, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false, err
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rejectPrivateDial is used as the Control of the dialer of the client which
+// fetches tracks, such that the address which is actually connected to is
+// checked. The host may resolve to another address when it is dialed than
+// when it was checked, eg. if it is rebound in the meantime.
+func rejectPrivateDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return errPrivateHost
+	}
+	return nil
+}
+
+// isPrivateDialError checks if a request failed because rejectPrivateDial
+// refused to connect, where the error is wrapped by both the request and the
+// dial
+func isPrivateDialError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if operr, ok := err.(*net.OpError); ok {
+		err = operr.Err
+	}
+	return err == errPrivateHost
+}
+
+// guardPrivateDials makes the client reject connections to private addresses.
+// Clients with a transport other than http.Transport are left as is, as their
+// dialer can't be replaced.
+func guardPrivateDials(logger *log.Logger, client *http.Client) {
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = cloneTransport(http.DefaultTransport.(*http.Transport))
+	case *http.Transport:
+		transport = cloneTransport(t)
+	default:
+		logger.Warn("unable to check the addresses tracks are fetched from, as the client has a custom transport")
+		return
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   rejectPrivateDial,
+	}
+	transport.DialContext = dialer.DialContext
+	client.Transport = transport
+}
+
+// checkHost checks if tracks can be fetched from the host, which fails with
+// errHostNotAllowed or errPrivateHost if it can't, or with the error of
+// resolving the host
+func (server *Server) checkHost(ctx context.Context, host string) error {
+	if !hostAllowed(server.allowedHosts, host) {
+		return errHostNotAllowed
+	}
+	if server.blockPrivate {
+		private, err := resolvesToPrivate(ctx, host)
+		if err != nil {
+			return err
+		} else if private {
+			return errPrivateHost
+		}
+	}
+	return nil
+}
+
+// checkRedirect is used as the CheckRedirect of the client which fetches
+// tracks, such that the checks of the initial url also apply to every url it
+// is redirected to
+func (server *Server) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > server.maxRedirects {
+		return errTooManyRedirects
+	}
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			return errRedirectLoop
+		}
+	}
+	return server.checkHost(req.Context(), req.URL.Hostname())
+}
+
+// parseProxyURL parses the url of a proxy, which must be absolute and use one
+// of the schemes supported by http.Transport
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%s'", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("proxy url is missing a host")
+	}
+	return proxyURL, nil
+}
+
+// cloneTransport copies the settings of the transport into a new transport,
+// which doesn't share idle connections with the original. The fields are
+// copied by hand, as a transport must not be copied by value.
+func cloneTransport(t *http.Transport) *http.Transport {
+	clone := &http.Transport{
+		Proxy:                  t.Proxy,
+		DialContext:            t.DialContext,
+		Dial:                   t.Dial,
+		DialTLS:                t.DialTLS,
+		TLSHandshakeTimeout:    t.TLSHandshakeTimeout,
+		DisableKeepAlives:      t.DisableKeepAlives,
+		DisableCompression:     t.DisableCompression,
+		MaxIdleConns:           t.MaxIdleConns,
+		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        t.MaxConnsPerHost,
+		IdleConnTimeout:        t.IdleConnTimeout,
+		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
+		ExpectContinueTimeout:  t.ExpectContinueTimeout,
+		ProxyConnectHeader:     t.ProxyConnectHeader,
+		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
+	}
+	if t.TLSClientConfig != nil {
+		clone.TLSClientConfig = t.TLSClientConfig.Clone()
+	}
+	return clone
+}
+
+// newProxyClient creates a client which sends all requests through the proxy
+// at the url. If the url is invalid, the proxies of the environment are used
+// instead, the same as for the default client.
+func newProxyClient(logger *log.Logger, rawURL string) *http.Client {
+	transport := cloneTransport(http.DefaultTransport.(*http.Transport))
+	if proxyURL, err := parseProxyURL(rawURL); err != nil {
+		logger.WithFields(log.Fields{
+			"proxy": rawURL,
+			"error": err,
+		}).Error("invalid proxy url, fetching tracks without the proxy")
+	} else {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport}
+}
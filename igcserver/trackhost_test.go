@@ -0,0 +1,267 @@
+package igcserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test that hosts are allowed if they match or are subdomains of allowed hosts
+func TestHostAllowed(t *testing.T) {
+	for _, data := range []struct {
+		allowed []string
+		host    string
+		expt    bool
+	}{
+		{nil, "example.com", true},
+		{[]string{"example.com"}, "example.com", true},
+		{[]string{"example.com"}, "EXAMPLE.com.", true},
+		{[]string{"example.com"}, "files.example.com", true},
+		{[]string{".example.com"}, "files.example.com", true},
+		{[]string{"example.com"}, "badexample.com", false},
+		{[]string{"example.com"}, "example.com.evil.org", false},
+		{[]string{"files.example.com"}, "example.com", false},
+		{[]string{"example.com", "127.0.0.1"}, "127.0.0.1", true},
+		{[]string{"example.com"}, "", false},
+	} {
+		if actual := hostAllowed(data.allowed, data.host); actual != data.expt {
+			t.Errorf("expected host '%s' with allowed hosts %v to give '%t', got '%t'", data.host, data.allowed, data.expt, actual)
+		}
+	}
+}
+
+// Test that addresses in the private ranges are detected
+func TestIsPrivateIP(t *testing.T) {
+	for _, data := range []struct {
+		ip   string
+		expt bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"172.20.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"100.128.0.1", false},
+		{"2001:4860:4860::8888", false},
+	} {
+		if actual := isPrivateIP(net.ParseIP(data.ip)); actual != data.expt {
+			t.Errorf("expected '%s' to give '%t', got '%t'", data.ip, data.expt, actual)
+		}
+	}
+}
+
+// Test that POST /track rejects urls of private hosts only when configured
+func TestIgcServerPostTrackPrivateHost(t *testing.T) {
+	for _, data := range []struct {
+		block bool
+		code  int
+	}{
+		{false, 200},
+		{true, 403},
+	} {
+		server, fileserver := makeTestServers(WithPrivateHostBlocking(data.block))
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected blocking '%t' to return '%d', got '%d': %s", data.block, data.code, code, res.Body)
+		}
+		fileserver.Close()
+	}
+}
+
+// Convenience function to create a server which redirects to the igc file
+// server, where `/hop/<n>` redirects `n` times before redirecting to the file,
+// `/loop` redirects back to itself through `/loop/back` and `/localhost`
+// redirects to the file using another host name
+func makeRedirectServer(fileserverURL string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/hop/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+			if n > 0 {
+				http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+			} else {
+				http.Redirect(w, r, fileserverURL+"/test.igc", http.StatusFound)
+			}
+		case r.URL.Path == "/loop":
+			http.Redirect(w, r, "/loop/back", http.StatusFound)
+		case r.URL.Path == "/loop/back":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case r.URL.Path == "/localhost":
+			target := strings.Replace(fileserverURL, "127.0.0.1", "localhost", 1)
+			http.Redirect(w, r, target+"/test.igc", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// Test that POST /track follows redirects within the limits of the server
+func TestIgcServerPostTrackRedirect(t *testing.T) {
+	for _, data := range []struct {
+		opts []Option
+		path string
+		code int
+	}{
+		{nil, "/hop/0", 200},
+		{nil, "/hop/2", 200},
+		{[]Option{WithMaxRedirects(3)}, "/hop/2", 200},
+		{[]Option{WithMaxRedirects(2)}, "/hop/2", 400},
+		{[]Option{WithMaxRedirects(0)}, "/hop/0", 400},
+		{nil, "/hop/20", 400},
+		{nil, "/loop", 400},
+		{nil, "/localhost", 200},
+		{[]Option{WithAllowedHosts("127.0.0.1")}, "/localhost", 403},
+	} {
+		server, fileserver := makeTestServers(data.opts...)
+		redirector := makeRedirectServer(fileserver.URL)
+
+		res, _ := postTrack(&server, redirector.URL+data.path)
+
+		if code := res.Result().StatusCode; code != data.code {
+			t.Errorf("expected '%s' to return '%d', got '%d': %s", data.path, data.code, code, res.Body)
+		}
+		redirector.Close()
+		fileserver.Close()
+	}
+}
+
+// Test that the address which is connected to is checked, such that a host
+// which resolves to a public address when checked but to a private address
+// when connecting, eg. by DNS rebinding, is rejected
+func TestIgcServerFetchPrivateDial(t *testing.T) {
+	for _, data := range []struct {
+		block bool
+		code  int
+	}{
+		{false, 0},
+		{true, 403},
+	} {
+		server, fileserver := makeTestServers(WithPrivateHostBlocking(data.block))
+
+		// Fetch directly to skip the check of the host, as if it was rebound
+		_, ferr := server.downloadIGC(context.Background(), log.NewEntry(log.StandardLogger()), fileserver.URL+"/test.igc")
+		code := 0
+		if ferr != nil {
+			code = ferr.status
+		}
+		if code != data.code {
+			t.Errorf("expected fetch with blocking '%t' to give '%d', got '%d'", data.block, data.code, code)
+		}
+		fileserver.Close()
+	}
+
+	for _, data := range []struct {
+		address string
+		expt    error
+	}{
+		{"127.0.0.1:80", errPrivateHost},
+		{"[::1]:443", errPrivateHost},
+		{"100.64.1.1:80", errPrivateHost},
+		{"8.8.8.8:443", nil},
+		{"[2001:4860:4860::8888]:443", nil},
+	} {
+		if err := rejectPrivateDial("tcp", data.address, nil); err != data.expt {
+			t.Errorf("expected dialing '%s' to give '%v', got '%v'", data.address, data.expt, err)
+		}
+	}
+}
+
+// Test that redirects to private addresses are rejected when configured
+func TestCheckRedirectPrivateHost(t *testing.T) {
+	for _, data := range []struct {
+		block bool
+		expt  error
+	}{
+		{false, nil},
+		{true, errPrivateHost},
+	} {
+		server := NewServer(nil, nil, nil, nil, WithPrivateHostBlocking(data.block))
+
+		via := []*http.Request{httptest.NewRequest("GET", "http://skypolaris.org/track.igc", nil)}
+		req := httptest.NewRequest("GET", "http://127.0.0.1/track.igc", nil)
+		if err := server.checkRedirect(req, via); err != data.expt {
+			t.Errorf("expected blocking '%t' to give '%v', got '%v'", data.block, data.expt, err)
+		}
+	}
+}
+
+// Test that tracks are fetched through the proxy when no client is given, and
+// that an invalid proxy url falls back to fetching directly
+func TestIgcServerPostTrackProxy(t *testing.T) {
+	var proxied []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		// Proxied requests contain the absolute url of the target
+		if r.URL.Host != "tracks.example" {
+			http.Error(w, "unexpected target", http.StatusBadGateway)
+			return
+		}
+		http.ServeFile(w, r, "../assets/test.igc")
+	}))
+	defer proxy.Close()
+
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(2, &trackMetasMap)
+	webhooks := NewWebhooksMap()
+	server := NewServer(nil, &trackMetasMap, &ticker, &webhooks, WithProxy(proxy.URL))
+
+	body := `{"url":"http://tracks.example/test.igc"}`
+	req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("expected track to be fetched through the proxy, got '%d': %s", res.Code, res.Body)
+	}
+	if expt := []string{"http://tracks.example/test.igc"}; !cmp.Equal(proxied, expt) {
+		t.Errorf("expected proxy to receive '%v', got '%v'", expt, proxied)
+	}
+
+	for _, invalid := range []string{"ftp://proxy.example", "http://", "://proxy"} {
+		fileserver := makeIgcFileServer()
+		fileserver.Start()
+
+		trackMetasMap := NewTrackMetasMap()
+		ticker := NewTickerDummy(2, &trackMetasMap)
+		webhooks := NewWebhooksMap()
+		server := NewServer(nil, &trackMetasMap, &ticker, &webhooks, WithProxy(invalid))
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("expected invalid proxy '%s' to fall back to fetching directly, got '%d': %s", invalid, res.Code, res.Body)
+		}
+		fileserver.Close()
+	}
+}
@@ -1,18 +1,29 @@
 package igcserver
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/marni/goigc"
 	log "github.com/sirupsen/logrus"
 	"hash/fnv"
 	"io"
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -22,38 +33,337 @@ var (
 	// ErrTrackAlreadyExists is returned to request to add a track which
 	// already exists
 	ErrTrackAlreadyExists = errors.New("track already exists")
+
+	// ErrInvalidSortField is returned if tracks are sorted by a field which
+	// is not sortable
+	ErrInvalidSortField = errors.New("invalid sort field")
+
+	// ErrTrackIDCollision is returned if no free id was found for a track
+	// because the id of the track collides with too many other tracks
+	ErrTrackIDCollision = errors.New("track id collides with other tracks")
+
+	// ErrInvalidUnit is returned if a distance is converted to an unknown
+	// unit
+	ErrInvalidUnit = errors.New("invalid unit")
+
+	// ErrInvalidDateFormat is returned if a date is formatted in an unknown
+	// format
+	ErrInvalidDateFormat = errors.New("invalid date format")
+
+	// errInvalidIGC is returned if the content of a track is not a valid igc
+	// file
+	errInvalidIGC = errors.New("unable to parse igc content")
+
+	// errParseTimeout is returned if parsing the content of a track takes
+	// longer than allowed by the server
+	errParseTimeout = errors.New("parsing igc content timed out")
+
+	// errInvalidDate is returned if the date of a track is outside of the
+	// range accepted by the server
+	errInvalidDate = errors.New("track date is out of range")
+
+	// errInvalidTags is returned if the tags of a track are not accepted
+	errInvalidTags = fmt.Errorf("a track has at most %d tags, which are non-empty and at most %d characters long", maxTrackTags, maxTagLength)
+
+	// errDuplicateContent is returned if a track has the same content as an
+	// already registered track
+	errDuplicateContent = errors.New("track with same content already exists")
 )
 
 // TrackMetas is a interface for all storages containing TrackMeta
+//
+// The server only interacts with the storage through this interface, hence
+// any backend (in-memory, database or a decorator around another backend) can
+// be passed to `NewServer` without changing the handlers.
+//
+// AppendMany appends either all or none of the track metas, and returns the
+// ids of the appended track metas in order. A batch where two track metas
+// share an id or a source url is rejected with ErrTrackAlreadyExists, the same
+// as a batch containing a track which is already stored.
 type TrackMetas interface {
 	Get(id TrackID) (TrackMeta, error)
 	Append(meta TrackMeta) error
+	AppendMany(metas []TrackMeta) ([]TrackID, error)
 	GetAllIDs() ([]TrackID, error)
+	GetAll() ([]TrackMeta, error)
+	Delete(id TrackID) (TrackMeta, error)
+	Clear() (int, error)
+	Len() (int, error)
+	Update(id TrackID, update func(*TrackMeta)) (TrackMeta, error)
+	Filter(predicate func(TrackMeta) bool) ([]TrackID, error)
+	GetAllSorted(field string, desc bool) ([]TrackID, error)
+	Search(query string) ([]TrackMatch, error)
+	FindByGliderID(gliderID string) ([]TrackID, error)
+	FindByTag(tag string) ([]TrackID, error)
 }
 
 // TrackID is a unique id for a track
 type TrackID uint32
 
-// NewTrackID creates a new unique track ID
+const (
+	// maxTrackTags is the maximum amount of tags of a track
+	maxTrackTags = 16
+
+	// maxTagLength is the maximum amount of characters in a tag
+	maxTagLength = 32
+)
+
+// hasTag checks if the track meta has the exact tag
+func hasTag(meta TrackMeta, tag string) bool {
+	for _, t := range meta.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTags checks that there are at most maxTrackTags tags, and that none
+// of them are blank or longer than maxTagLength characters. The tags are
+// returned without surrounding whitespace or repeated tags.
+func validateTags(tags []string) ([]string, error) {
+	if len(tags) > maxTrackTags {
+		return nil, errInvalidTags
+	}
+	valid := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, errInvalidTags
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			valid = append(valid, tag)
+		}
+	}
+	return valid, nil
+}
+
+// maxTrackIDProbes is the maximum amount of ids tried when the id of a track
+// collides with the ids of other tracks
+const maxTrackIDProbes = 16
+
+// NewTrackID creates a new track ID. Different values may give the same id,
+// hence appendTrackMeta should be used to find a free id for a track.
 func NewTrackID(v []byte) TrackID {
 	hasher := fnv.New32()
 	hasher.Write(v)
 	return TrackID(hasher.Sum32())
 }
 
+// NewTrackIDWithSeed creates a new track ID where the seed is mixed into the
+// hash, such that deployments with different seeds give different ids for the
+// same value. An empty seed gives the same id as NewTrackID.
+func NewTrackIDWithSeed(seed string, v []byte) TrackID {
+	if seed == "" {
+		return NewTrackID(v)
+	}
+	hasher := fnv.New32()
+	hasher.Write([]byte(seed))
+	// Separate the seed from the value, such that eg. the seed "a" with the
+	// value "bc" differs from the seed "ab" with the value "c"
+	hasher.Write([]byte{0})
+	hasher.Write(v)
+	return TrackID(hasher.Sum32())
+}
+
+// checkBatchDuplicates returns ErrTrackAlreadyExists if two of the track
+// metas share an id or a source url, and otherwise the ids of the track metas
+func checkBatchDuplicates(metas []TrackMeta) ([]TrackID, error) {
+	ids := make([]TrackID, len(metas))
+	seenIDs := make(map[TrackID]bool, len(metas))
+	seenURLs := make(map[string]bool, len(metas))
+	for i, meta := range metas {
+		srcKey := trackSrcKey(meta.TrackSrcURL, meta.SrcWindow)
+		if seenIDs[meta.ID] || seenURLs[srcKey] {
+			return nil, ErrTrackAlreadyExists
+		}
+		seenIDs[meta.ID] = true
+		seenURLs[srcKey] = true
+		ids[i] = meta.ID
+	}
+	return ids, nil
+}
+
+// trackSrcKey returns the key which is unique to each track, which is the
+// source url of the track followed by the duplicate window the track was
+// registered in, if any
+func trackSrcKey(srcURL string, srcWindow int64) string {
+	if srcWindow == 0 {
+		return srcURL
+	}
+	return srcURL + "\x00" + strconv.FormatInt(srcWindow, 10)
+}
+
+// findTrackBySrcURL fetches the track meta with the given source url which
+// was registered in the given duplicate window, by probing the ids following
+// the id of the url in the same way as appendTrackMeta
+func findTrackBySrcURL(tracks TrackMetas, seed, srcURL string, srcWindow int64) (TrackMeta, error) {
+	id := NewTrackIDWithSeed(seed, []byte(trackSrcKey(srcURL, srcWindow)))
+	for i := 0; i < maxTrackIDProbes; i++ {
+		meta, err := tracks.Get(id + TrackID(i))
+		if err != nil || (meta.TrackSrcURL == srcURL && meta.SrcWindow == srcWindow) {
+			return meta, err
+		}
+	}
+	return TrackMeta{}, ErrTrackNotFound
+}
+
+// appendTrackMeta appends the track meta, and rejects it if a track with the
+// same source url already exists in the same duplicate window. If the id of
+// the track is taken by a track with another source url, the following ids
+// are probed until a free id is found. The appended track meta is returned
+// with the id it was given.
+func appendTrackMeta(tracks TrackMetas, meta TrackMeta) (TrackMeta, error) {
+	start := meta.ID
+	for meta.ID-start < maxTrackIDProbes {
+		existing, err := tracks.Get(meta.ID)
+		if err == ErrTrackNotFound {
+			err = tracks.Append(meta)
+			if err == ErrTrackAlreadyExists {
+				// Check the same id again if it was taken since it was checked,
+				// otherwise the source url is already stored
+				if _, err := tracks.Get(meta.ID); err == nil {
+					continue
+				}
+			}
+			return meta, err
+		} else if err != nil {
+			return meta, err
+		}
+		if existing.TrackSrcURL == meta.TrackSrcURL && existing.SrcWindow == meta.SrcWindow {
+			return meta, ErrTrackAlreadyExists
+		}
+		meta.ID++
+	}
+	return meta, ErrTrackIDCollision
+}
+
 // TrackMeta contains a subset of metainformation about a igc-track
 type TrackMeta struct {
-	ID          TrackID   `json:"-" bson:"id"`
-	Timestamp   time.Time `json:"-" bson:"timestamp"`
-	Date        time.Time `json:"H_date" bson:"H_date"`
-	Pilot       string    `json:"pilot" bson:"pilot"`
-	Glider      string    `json:"glider" bson:"glider"`
-	GliderID    string    `json:"glider_id" bson:"glider_id"`
-	TrackLength float64   `json:"track_length" bson:"track_length"`
-	TrackSrcURL string    `json:"track_src_url" bson:"track_src_url"`
+	ID          TrackID   `json:"-" bson:"id" xml:"-"`
+	Timestamp   time.Time `json:"-" bson:"timestamp" xml:"-"`
+	Date        time.Time `json:"H_date" bson:"H_date" xml:"H_date"`
+	Pilot       string    `json:"pilot" bson:"pilot" xml:"pilot"`
+	Glider      string    `json:"glider" bson:"glider" xml:"glider"`
+	GliderID    string    `json:"glider_id" bson:"glider_id" xml:"glider_id"`
+	TrackLength float64   `json:"track_length" bson:"track_length" xml:"track_length"`
+	TrackSrcURL string    `json:"track_src_url" bson:"track_src_url" xml:"track_src_url"`
+
+	MaxAltitudeGain int64   `json:"max_altitude_gain" bson:"max_altitude_gain" xml:"max_altitude_gain"`
+	Duration        int64   `json:"duration" bson:"duration" xml:"duration"`
+	AvgSpeed        float64 `json:"avg_speed" bson:"avg_speed" xml:"avg_speed"`
+
+	ContentHash string `json:"content_hash" bson:"content_hash" xml:"content_hash"`
+
+	BBox BoundingBox `json:"bbox" bson:"bbox" xml:"bbox"`
+
+	Tags []string `json:"tags" bson:"tags" xml:"tags>tag"`
+
+	// SrcWindow is the duplicate window the track was registered in, which is
+	// 0 unless the server has a duplicate window. It is only used to tell
+	// tracks from the same url apart, hence it isn't part of the api.
+	SrcWindow int64 `json:"-" bson:"src_window,omitempty" xml:"-"`
+}
+
+// BoundingBox is the smallest area, in degrees, which contains all the points
+// of a track
+type BoundingBox struct {
+	MinLat float64 `json:"min_lat" bson:"min_lat" xml:"min_lat"`
+	MinLon float64 `json:"min_lon" bson:"min_lon" xml:"min_lon"`
+	MaxLat float64 `json:"max_lat" bson:"max_lat" xml:"max_lat"`
+	MaxLon float64 `json:"max_lon" bson:"max_lon" xml:"max_lon"`
+}
+
+// trackSortFields contains how to compare two track metas for each of the
+// fields tracks can be sorted by. The name of each field is equal to the name
+// of the field when stored in the database.
+var trackSortFields = map[string]func(a, b TrackMeta) bool{
+	"track_length": func(a, b TrackMeta) bool {
+		return a.TrackLength < b.TrackLength
+	},
+	"H_date": func(a, b TrackMeta) bool {
+		return a.Date.Before(b.Date)
+	},
+	"timestamp": func(a, b TrackMeta) bool {
+		return a.Timestamp.Before(b.Timestamp)
+	},
+}
+
+// sortTrackMetas sorts the track metas by the given field, where ties are
+// always ordered by ascending id
+func sortTrackMetas(metas []TrackMeta, field string, desc bool) error {
+	less, ok := trackSortFields[field]
+	if !ok {
+		return ErrInvalidSortField
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		a, b := metas[i], metas[j]
+		switch {
+		case less(a, b):
+			return !desc
+		case less(b, a):
+			return desc
+		default:
+			return a.ID < b.ID
+		}
+	})
+	return nil
+}
+
+// downsamplePoints picks at most `max` evenly spaced points, which always
+// include the first and the last point, such that the length of the track
+// stays roughly the same. A max of 0 or less means that all points are kept.
+func downsamplePoints(points []igc.Point, max int) []igc.Point {
+	if max <= 0 || len(points) <= max {
+		return points
+	}
+	if max == 1 {
+		return points[:1]
+	}
+	sampled := make([]igc.Point, max)
+	for i := range sampled {
+		sampled[i] = points[i*(len(points)-1)/(max-1)]
+	}
+	return sampled
+}
+
+// parseIGC parses the igc content within the parse timeout of the server, and
+// downsamples the points of the track to the point cap of the server. The
+// parser can't be cancelled, hence it keeps running in the background until
+// it finishes if it times out.
+func (server *Server) parseIGC(content []byte) (igc.Track, error) {
+	type result struct {
+		track igc.Track
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		track, err := igc.Parse(string(content))
+		done <- result{track, err}
+	}()
+
+	var timeout <-chan time.Time
+	if server.parseTimeout > 0 {
+		timer := time.NewTimer(server.parseTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return res.track, res.err
+		}
+		res.track.Points = downsamplePoints(res.track.Points, server.maxPoints)
+		return res.track, nil
+	case <-timeout:
+		return igc.Track{}, errParseTimeout
+	}
 }
 
-// calcTotalDistance returns the total distance between the points in order
+// calcTotalDistance returns the total distance between the points in order, in
+// kilometers (the unit of igc.Point.Distance)
 func calcTotalDistance(points []igc.Point) (trackLength float64) {
 	for i := 0; i+1 < len(points); i++ {
 		trackLength += points[i].Distance(points[i+1])
@@ -61,8 +371,116 @@ func calcTotalDistance(points []igc.Point) (trackLength float64) {
 	return
 }
 
+// distanceUnits maps the supported units of distances to the amount of the
+// unit in a kilometer
+var distanceUnits = map[string]float64{
+	"km": 1,
+	"m":  1000,
+}
+
+// convertDistance converts a distance in kilometers to the given unit
+func convertDistance(km float64, unit string) (float64, error) {
+	factor, ok := distanceUnits[unit]
+	if !ok {
+		return 0, ErrInvalidUnit
+	}
+	return km * factor, nil
+}
+
+// formatDate formats the date as `rfc3339`, as `date` (eg. `2006-01-02`) or as
+// `unix` (seconds since the unix epoch)
+func formatDate(date time.Time, format string) (string, error) {
+	switch format {
+	case "rfc3339":
+		return date.Format(time.RFC3339), nil
+	case "date":
+		return date.Format("2006-01-02"), nil
+	case "unix":
+		return strconv.FormatInt(date.Unix(), 10), nil
+	default:
+		return "", ErrInvalidDateFormat
+	}
+}
+
+// pointAltitude returns a function which gives the altitude of a point, where
+// the GNSS altitude is used if any of the points have it, otherwise the
+// pressure altitude is used
+func pointAltitude(points []igc.Point) func(igc.Point) int64 {
+	for _, p := range points {
+		if p.GNSSAltitude != 0 {
+			return func(p igc.Point) int64 { return p.GNSSAltitude }
+		}
+	}
+	return func(p igc.Point) int64 { return p.PressureAltitude }
+}
+
+// calcAltitudeGain returns the sum of all the increases in altitude between
+// the points in order. The GNSS altitude is used if present, otherwise the
+// pressure altitude is used.
+func calcAltitudeGain(points []igc.Point) (gain int64) {
+	altitude := pointAltitude(points)
+	for i := 0; i+1 < len(points); i++ {
+		if diff := altitude(points[i+1]) - altitude(points[i]); diff > 0 {
+			gain += diff
+		}
+	}
+	return
+}
+
+// calcDuration returns the amount of seconds between the first and the last
+// point, or 0 if there are less than two points
+func calcDuration(points []igc.Point) int64 {
+	if len(points) < 2 {
+		return 0
+	}
+	return int64(points[len(points)-1].Time.Sub(points[0].Time) / time.Second)
+}
+
+// calcAvgSpeed returns the average speed in km/h of a track with the length in
+// kilometers and the duration in seconds, or 0 if the duration is 0
+func calcAvgSpeed(trackLength float64, duration int64) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return trackLength / (float64(duration) / 3600)
+}
+
+// calcBoundingBox returns the bounding box of the points, or a zero box if
+// there are no points
+func calcBoundingBox(points []igc.Point) (bbox BoundingBox) {
+	for i, p := range points {
+		lat, lon := p.Lat.Degrees(), p.Lng.Degrees()
+		if i == 0 {
+			bbox = BoundingBox{lat, lon, lat, lon}
+			continue
+		}
+		bbox.MinLat = math.Min(bbox.MinLat, lat)
+		bbox.MinLon = math.Min(bbox.MinLon, lon)
+		bbox.MaxLat = math.Max(bbox.MaxLat, lat)
+		bbox.MaxLon = math.Max(bbox.MaxLon, lon)
+	}
+	return
+}
+
+// calcContentHash returns a hash of the date, pilot and points of the track,
+// which is equal for tracks with the same content regardless of where they
+// were fetched from
+func calcContentHash(track igc.Track) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n", track.Date.Unix(), track.Pilot)
+	for _, p := range track.Points {
+		fmt.Fprintf(
+			h, "%d %f %f %d %d\n",
+			p.Time.UnixNano(), p.Lat.Degrees(), p.Lng.Degrees(), p.PressureAltitude, p.GNSSAltitude,
+		)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // TrackMetaFrom converts a igc.Track into a TrackMeta struct
 func TrackMetaFrom(url url.URL, track igc.Track) TrackMeta {
+	trackLength := calcTotalDistance(track.Points)
+	duration := calcDuration(track.Points)
 	return TrackMeta{
 		NewTrackID([]byte(url.String())),
 		time.Now(),
@@ -70,8 +488,15 @@ func TrackMetaFrom(url url.URL, track igc.Track) TrackMeta {
 		track.Pilot,
 		track.GliderType,
 		track.GliderID,
-		calcTotalDistance(track.Points),
+		trackLength,
 		url.String(),
+		calcAltitudeGain(track.Points),
+		duration,
+		calcAvgSpeed(trackLength, duration),
+		calcContentHash(track),
+		calcBoundingBox(track.Points),
+		nil,
+		0,
 	}
 }
 
@@ -79,11 +504,249 @@ func TrackMetaFrom(url url.URL, track igc.Track) TrackMeta {
 // TRACK API //
 // --------- //
 
-// trackRegHandler takes a request in the following structure
+// fetchError describes why fetching an igc file failed, and how to respond
+type fetchError struct {
+	status int
+	msg    string
+	// temporary is set if the fetch failed due to the remote host, such that
+	// the fetch may succeed if it is retried later
+	temporary bool
+}
+
+func (err *fetchError) Error() string {
+	return err.msg
+}
+
+// downloadIGC fetches the igc file at the url within the limits of the server
+func (server *Server) downloadIGC(ctx context.Context, logger *log.Entry, srcURL string) ([]byte, *fetchError) {
+	ctx, cancel := context.WithTimeout(ctx, server.fetchTimeout)
+	defer cancel()
+	fetchReq, err := http.NewRequest(http.MethodGet, srcURL, nil)
+	if err != nil {
+		logger.WithField("error", err).Info("unable to create request to provided url")
+		return nil, &fetchError{http.StatusBadRequest, "invalid url", false}
+	}
+	resp, err := server.httpClient.Do(fetchReq.WithContext(ctx))
+	if ctx.Err() == context.DeadlineExceeded {
+		logger.WithField("timeout", server.fetchTimeout).Info("fetching data from provided url timed out")
+		return nil, &fetchError{http.StatusGatewayTimeout, "timed out when fetching data from provided url", true}
+	} else if uerr, ok := err.(*url.Error); ok && (uerr.Err == errTooManyRedirects || uerr.Err == errRedirectLoop) {
+		logger.WithField("error", err).Info("provided url redirected too many times")
+		return nil, &fetchError{http.StatusBadRequest, fmt.Sprintf("unable to follow redirects of provided url: %s", uerr.Err), false}
+	} else if ok && (uerr.Err == errHostNotAllowed || uerr.Err == errPrivateHost) {
+		logger.WithField("error", err).Info("provided url redirected to a host which is not allowed")
+		return nil, &fetchError{http.StatusForbidden, fmt.Sprintf("provided url redirected to '%s' which is not allowed: %s", uerr.URL, uerr.Err), false}
+	} else if isPrivateDialError(err) {
+		logger.WithField("error", err).Info("provided url connected to a private address")
+		return nil, &fetchError{http.StatusForbidden, "fetching tracks from private addresses is not allowed", false}
+	} else if err != nil {
+		logger.WithField("error", err).Info("unable to fetch data from provided url")
+		return nil, &fetchError{http.StatusBadRequest, "unable to fetch data from provided url", true}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		logger.WithField("status", resp.StatusCode).Info("provided url responded with a server error")
+		return nil, &fetchError{http.StatusBadGateway, fmt.Sprintf("provided url responded with status %d", resp.StatusCode), true}
+	}
+	if server.checkContentType {
+		contentType := resp.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !strings.HasPrefix(mediaType, "text/") {
+			logger.WithField("content_type", contentType).Info("provided url did not serve plain text")
+			return nil, &fetchError{http.StatusBadRequest, fmt.Sprintf("expected igc file to be served as text, got '%s'", contentType), false}
+		}
+	}
+	// Read one byte more than allowed to be able to tell if the limit was exceeded
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, server.maxTrackSize+1))
+	if ctx.Err() == context.DeadlineExceeded {
+		logger.WithField("timeout", server.fetchTimeout).Info("reading data from provided url timed out")
+		return nil, &fetchError{http.StatusGatewayTimeout, "timed out when fetching data from provided url", true}
+	} else if err != nil {
+		logger.WithField("error", err).Error("unable to read all data from response")
+		return nil, &fetchError{http.StatusInternalServerError, "unable to read data from provided url", true}
+	}
+	if int64(len(content)) > server.maxTrackSize {
+		logger.WithField("max_size", server.maxTrackSize).Info("provided url served a too large file")
+		return nil, &fetchError{http.StatusBadRequest, fmt.Sprintf("igc file is larger than the maximum of %d bytes", server.maxTrackSize), false}
+	}
+	return content, nil
+}
+
+// acquireFetchSlot waits for a free slot to fetch an igc file within the
+// concurrency limit of the server, and returns false if no slot was freed
+// within the wait of the server or before the request was cancelled. The slot
+// has to be given back using releaseFetchSlot.
+func (server *Server) acquireFetchSlot(ctx context.Context) bool {
+	if server.fetchSlots == nil {
+		return true
+	}
+	timer := time.NewTimer(server.fetchSlotWait)
+	defer timer.Stop()
+	select {
+	case server.fetchSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releaseFetchSlot gives back a slot acquired using acquireFetchSlot
+func (server *Server) releaseFetchSlot() {
+	if server.fetchSlots != nil {
+		<-server.fetchSlots
+	}
+}
+
+// fetchIGC fetches the igc file at the url, and responds with an error if the
+// file could not be fetched within the limits of the server
+func (server *Server) fetchIGC(w http.ResponseWriter, r *http.Request, logger *log.Entry, srcURL string) ([]byte, bool) {
+	content, ferr := server.downloadIGC(r.Context(), logger, srcURL)
+	if ferr != nil {
+		writeJSONError(w, ferr.status, ferr.msg)
+		return nil, false
+	}
+	return content, true
+}
+
+// validTrackDate checks that the date is within the range accepted by the
+// server
+func (server *Server) validTrackDate(date time.Time) bool {
+	return !date.Before(server.earliestDate) && !date.After(time.Now().Add(server.maxDateAhead))
+}
+
+// duplicateSrcWindow returns the duplicate window a track registered at the
+// given time belongs to, which is 0 if tracks from the same url are rejected
+// forever
+func (server *Server) duplicateSrcWindow(at time.Time) int64 {
+	if server.duplicateWindow <= 0 || server.duplicatePolicy == DuplicateUpsert {
+		return 0
+	}
+	return at.UnixNano() / int64(server.duplicateWindow)
+}
+
+// findDuplicateTrack finds the track with the same source url which was
+// registered within the duplicate window before the given time, or with the
+// same source url at all if the server has no duplicate window. Each window
+// contains at most one track from each url, hence only the current and the
+// previous window have to be checked.
+func (server *Server) findDuplicateTrack(srcURL string, at time.Time) (TrackMeta, error) {
+	srcWindow := server.duplicateSrcWindow(at)
+	meta, err := findTrackBySrcURL(server.tracks, server.trackIDSeed, srcURL, srcWindow)
+	if err != ErrTrackNotFound || srcWindow == 0 {
+		return meta, err
+	}
+	meta, err = findTrackBySrcURL(server.tracks, server.trackIDSeed, srcURL, srcWindow-1)
+	if err == nil && at.Sub(meta.Timestamp) >= server.duplicateWindow {
+		return TrackMeta{}, ErrTrackNotFound
+	}
+	return meta, err
+}
+
+// registerTrack parses the igc content and stores it as a new track, after
+// which the ticker, webhooks and stream clients are notified of the track. If
+// the content is a duplicate of an existing track, the id of the existing
+// track is returned along with errDuplicateContent. If a track with the same
+// url exists and the duplicate policy is DuplicateUpsert, the existing track
+// is overwritten without notifying anyone. If the duplicate window has passed
+// since the url was last registered, the track is registered as a new track.
+func (server *Server) registerTrack(logger *log.Entry, srcURL url.URL, content []byte, tags []string) (TrackMeta, error) {
+	track, err := server.parseIGC(content)
+	if err == errParseTimeout {
+		logger.WithField("timeout", server.parseTimeout).Info("parsing igc content timed out")
+		return TrackMeta{}, errParseTimeout
+	} else if err != nil {
+		logger.WithField("error", err).Info("unable to parse igc content as track")
+		return TrackMeta{}, errInvalidIGC
+	}
+	if !server.validTrackDate(track.Date) {
+		logger.WithField("date", track.Date).Info("date of track is out of range")
+		return TrackMeta{}, errInvalidDate
+	}
+
+	// Create and add new trackmeta object
+	trackMeta := TrackMetaFrom(srcURL, track)
+	trackMeta.SrcWindow = server.duplicateSrcWindow(trackMeta.Timestamp)
+	trackMeta.ID = NewTrackIDWithSeed(server.trackIDSeed, []byte(trackSrcKey(trackMeta.TrackSrcURL, trackMeta.SrcWindow)))
+	trackMeta.Tags = tags
+	// The storage only rejects tracks from the same url in the same window,
+	// while a track of the previous window may still be within the duplicate
+	// window
+	if trackMeta.SrcWindow != 0 {
+		existing, err := server.findDuplicateTrack(trackMeta.TrackSrcURL, trackMeta.Timestamp)
+		if err == nil {
+			return existing, ErrTrackAlreadyExists
+		} else if err != ErrTrackNotFound {
+			return trackMeta, err
+		}
+	}
+	if server.dedupContent {
+		existing, err := server.tracks.Filter(func(meta TrackMeta) bool {
+			// A track never duplicates the track it would overwrite
+			return meta.ContentHash == trackMeta.ContentHash && meta.TrackSrcURL != trackMeta.TrackSrcURL
+		})
+		if err != nil {
+			return trackMeta, err
+		}
+		if len(existing) > 0 {
+			return TrackMeta{ID: existing[0]}, errDuplicateContent
+		}
+	}
+	trackMeta, err = appendTrackMeta(server.tracks, trackMeta)
+	if err == ErrTrackAlreadyExists && server.duplicatePolicy == DuplicateUpsert {
+		return server.overwriteTrack(logger, trackMeta, track.Points, content)
+	} else if err != nil {
+		return trackMeta, err
+	}
+	tracksRegistered.Inc()
+	if server.points != nil {
+		server.points.Set(trackMeta.ID, track.Points)
+	}
+	if server.raws != nil {
+		server.raws.Set(trackMeta.ID, content)
+	}
+
+	// Send the ticker information that we just added a track
+	server.ticker.Reporter(trackMeta.Timestamp)
+	// Trigger webhooks
+	server.webhooks.Trigger()
+	// Notify clients of the stream of new tracks
+	server.hub.BroadcastTrack(trackMeta)
+	// Publish the track to the message broker
+	server.publishTrack(logger, trackMeta)
+
+	return trackMeta, nil
+}
+
+// overwriteTrack replaces the metadata of the track with the id of trackMeta,
+// while keeping the time the existing track was registered
+func (server *Server) overwriteTrack(logger *log.Entry, trackMeta TrackMeta, points []igc.Point, content []byte) (TrackMeta, error) {
+	trackMeta, err := server.tracks.Update(trackMeta.ID, func(meta *TrackMeta) {
+		timestamp := meta.Timestamp
+		*meta = trackMeta
+		meta.Timestamp = timestamp
+	})
+	if err != nil {
+		return trackMeta, err
+	}
+	if server.points != nil {
+		server.points.Set(trackMeta.ID, points)
+	}
+	if server.raws != nil {
+		server.raws.Set(trackMeta.ID, content)
+	}
+	logger.WithField("id", trackMeta.ID).Info("overwrote existing track with same url")
+	return trackMeta, nil
+}
+
+// trackRegHandler takes a request in the following structure, where the tags
+// are optional
 //
 // ```json
 // {
-//   "url": <some-url>
+//   "url": <some-url>,
+//   "tags": [<tag1>, <tag2>, ...]
 // }
 // ```
 //
@@ -100,73 +763,140 @@ func (server *Server) trackRegHandler(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info("processing request to register track")
 
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	// Clients which leave out the content type are assumed to send json,
+	// while igc files can be uploaded directly instead of sending an url
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		switch {
+		case err == nil && mediaType == "application/json":
+		case err == nil && (mediaType == "application/octet-stream" || mediaType == "multipart/form-data"):
+			server.trackUploadHandler(w, r, logger, mediaType)
+			return
+		default:
+			logger.WithField("content_type", contentType).Info("request body is not json or an igc file")
+			writeJSONError(w, http.StatusUnsupportedMediaType, "request body must be application/json, application/octet-stream or multipart/form-data")
+			return
+		}
+	}
 
-	var req TrackRegRequest
-	if err := dec.Decode(&req); err != nil {
+	req, err := decodeTrackRegRequest(http.MaxBytesReader(w, r.Body, server.maxBodySize))
+	if err != nil {
 		logger.WithField("error", err).Info("unable to decode request body")
-		http.Error(w, "invalid json object", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	reqURL, err := url.Parse(req.URLstr)
 	if err != nil {
 		logger.WithField("error", err).Info("unable to parse url")
-		http.Error(w, "invalid url", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid url")
+		return
+	}
+	tags, err := validateTags(req.Tags)
+	if err != nil {
+		logger.WithField("tags", req.Tags).Info("invalid tags of track")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch err := server.checkHost(r.Context(), reqURL.Hostname()); err {
+	case nil:
+	case errHostNotAllowed:
+		logger.WithField("host", reqURL.Hostname()).Info("host of url is not allowed")
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("fetching tracks from host '%s' is not allowed", reqURL.Hostname()))
+		return
+	case errPrivateHost:
+		logger.WithField("host", reqURL.Hostname()).Info("host of url resolves to a private address")
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("fetching tracks from private address of host '%s' is not allowed", reqURL.Hostname()))
+		return
+	default:
+		logger.WithField("error", err).Info("unable to resolve host of url")
+		writeJSONError(w, http.StatusBadRequest, "unable to resolve host of url")
 		return
 	}
 	// Check if track already exists before requesting an external service to
 	// prevent unnecessary external calls
-	id := NewTrackID([]byte(reqURL.String()))
-	_, err = server.tracks.Get(id)
-	if err == nil {
+	_, err = server.findDuplicateTrack(reqURL.String(), time.Now())
+	if err == nil && server.duplicatePolicy != DuplicateUpsert {
 		logger.Info("request attempted to add duplicate track metadata")
-		http.Error(w, "track with same url already exists", http.StatusForbidden)
+		writeJSONError(w, http.StatusForbidden, "track with same url already exists")
 		return
 	}
-	resp, err := server.httpClient.Get(reqURL.String())
-	if err != nil {
-		logger.WithField("error", err).Info("unable to fetch data from provided url")
-		http.Error(w, "unable to fetch data from provided url", http.StatusBadRequest)
-		return
+	if server.retryQueue != nil {
+		if job, ok := server.retryQueue.Pending(reqURL.String()); ok {
+			logger.WithField("job", job).Info("track with same url is already pending")
+			writePendingTrack(w, job)
+			return
+		}
 	}
-	defer resp.Body.Close()
-	content, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.WithField("error", err).Error("unable to read all data from response")
-		http.Error(w, "unable to read data from provided url", http.StatusInternalServerError)
+	// Limit the amount of concurrent outbound connections, which protects both
+	// the server and the hosts of the files
+	if !server.acquireFetchSlot(r.Context()) {
+		logger.WithField("wait", server.fetchSlotWait).Warn("no free slot to fetch track within the wait")
+		writeJSONError(w, http.StatusServiceUnavailable, "too many tracks are being fetched, try again later")
 		return
 	}
-	track, err := igc.Parse(string(content))
-	if err != nil {
-		logger.WithField("error", err).Info("unable to parse igc content as track")
-		http.Error(w, "unable to parse igc content", http.StatusBadRequest)
+	content, ferr := server.downloadIGC(r.Context(), logger, reqURL.String())
+	server.releaseFetchSlot()
+	if ferr != nil {
+		// Retry fetches which might succeed later in the background
+		if ferr.temporary && server.retryQueue != nil {
+			if job, ok := server.retryQueue.Enqueue(*reqURL, tags); ok {
+				logger.WithField("job", job).Info("queued track to retry fetching it")
+				writePendingTrack(w, job)
+				return
+			}
+			logger.Warn("unable to queue track as the retry queue is full")
+		}
+		writeJSONError(w, ferr.status, ferr.msg)
 		return
 	}
 
-	// Create and add new trackmeta object
-	trackMeta := TrackMetaFrom(*reqURL, track)
-	err = server.tracks.Append(trackMeta)
-	if err == ErrTrackAlreadyExists {
+	trackMeta, err := server.registerTrack(logger, *reqURL, content, tags)
+	server.writeRegisteredTrack(w, logger, trackMeta, err)
+}
+
+// writeRegisteredTrack responds with the id of the registered track, or with
+// the reason registering the track failed
+func (server *Server) writeRegisteredTrack(w http.ResponseWriter, logger *log.Entry, trackMeta TrackMeta, err error) {
+	switch err {
+	case nil:
+	case errInvalidIGC:
+		writeJSONError(w, http.StatusBadRequest, "unable to parse igc content")
+		return
+	case errParseTimeout:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("parsing igc content took longer than %s", server.parseTimeout))
+		return
+	case errInvalidDate:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf(
+			"date of track must be between %s and %s",
+			server.earliestDate.Format("2006-01-02"),
+			time.Now().Add(server.maxDateAhead).Format("2006-01-02"),
+		))
+		return
+	case errDuplicateContent:
+		logger.WithField("existing", trackMeta.ID).Info("request attempted to add track with duplicate content")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "track with same content already exists",
+			"status": http.StatusForbidden,
+			"id":     trackMeta.ID,
+		})
+		return
+	case ErrTrackAlreadyExists:
 		logger.WithFields(log.Fields{
 			"trackmeta": trackMeta,
 		}).Info("request attempted to add duplicate track metadata")
-		http.Error(w, "track with same url already exists", http.StatusForbidden)
+		writeJSONError(w, http.StatusForbidden, "track with same url already exists")
 		return
-	} else if err != nil {
+	default:
 		logger.WithFields(log.Fields{
 			"trackmeta": trackMeta,
 			"error":     err,
 		}).Info("unable to add track metadata")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
 
-	// Send the ticker information that we just added a track
-	server.ticker.Reporter(trackMeta.Timestamp)
-	// Trigger webhooks
-	server.webhooks.Trigger()
-
 	result := map[string]interface{}{
 		"id": trackMeta.ID,
 	}
@@ -179,29 +909,300 @@ func (server *Server) trackRegHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// writePendingTrack responds with the id of the job retrying the fetch of the
+// track, which gives the id of the track once it is registered
+func writePendingTrack(w http.ResponseWriter, job int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":     job,
+		"pending": true,
+	})
+}
+
 // TrackRegRequest is the format of a track registration request
 type TrackRegRequest struct {
-	URLstr string `json:"url"`
+	URLstr string   `json:"url"`
+	Tags   []string `json:"tags"`
+}
+
+// decodeTrackRegRequest strictly decodes a track registration request, where
+// bodies with unknown fields, fields of the wrong type or without an url are
+// rejected with an error naming the offending field
+func decodeTrackRegRequest(body io.Reader) (req TrackRegRequest, err error) {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	if err = dec.Decode(&req); err != nil {
+		// The json package has no distinct error type for unknown fields
+		if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+			if name, uerr := strconv.Unquote(field); uerr == nil {
+				field = name
+			}
+			return req, fmt.Errorf("unknown field '%s'", field)
+		}
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return req, fmt.Errorf("invalid type of field '%s', expected %s", typeErr.Field, typeErr.Type)
+		}
+		return req, errors.New("invalid json object")
+	}
+	if req.URLstr == "" {
+		return req, errors.New("missing field 'url'")
+	}
+	return req, nil
+}
+
+// parsePagination parses the optional `limit` and `offset` query parameters of
+// a request, where a limit of 0 means that all elements should be returned
+func parsePagination(query url.Values) (limit, offset int, err error) {
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			err = errors.New("limit must be a non-negative number")
+			return
+		}
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			err = errors.New("offset must be a non-negative number")
+			return
+		}
+	}
+	return
+}
+
+// paginateIDs returns the page of ids specified by limit and offset. An offset
+// which is out of range results in an empty page.
+func paginateIDs(ids []TrackID, limit, offset int) []TrackID {
+	if offset >= len(ids) {
+		return []TrackID{}
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// errInvalidSince is returned when the `since` query parameter is not a valid
+// timestamp
+var errInvalidSince = errors.New("invalid since timestamp")
+
+// trackFilterFrom creates a predicate from the optional `pilot`, `glider` and
+// `since` query parameters, which matches tracks where ALL the given `pilot`
+// and `glider` parameters are case-insensitive substrings of the respective
+// fields, and which were added strictly after the `since` timestamp. If no
+// parameters are given, nil is returned.
+func trackFilterFrom(query url.Values) (func(TrackMeta) bool, error) {
+	pilot := strings.ToLower(query.Get("pilot"))
+	glider := strings.ToLower(query.Get("glider"))
+	var since *time.Time
+	if rawSince := query.Get("since"); rawSince != "" {
+		t, err := parseTimestamp(rawSince)
+		if err != nil {
+			return nil, errInvalidSince
+		}
+		since = &t
+	}
+	if pilot == "" && glider == "" && since == nil {
+		return nil, nil
+	}
+	return func(meta TrackMeta) bool {
+		return strings.Contains(strings.ToLower(meta.Pilot), pilot) &&
+			strings.Contains(strings.ToLower(meta.Glider), glider) &&
+			(since == nil || meta.Timestamp.After(*since))
+	}, nil
+}
+
+// keepTrackIDs returns the ids which are also present in keep, while
+// preserving the order of the ids
+func keepTrackIDs(ids []TrackID, keep []TrackID) []TrackID {
+	keepSet := make(map[TrackID]bool, len(keep))
+	for _, id := range keep {
+		keepSet[id] = true
+	}
+	kept := make([]TrackID, 0, len(keep))
+	for _, id := range ids {
+		if keepSet[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
 }
 
 // trackGetAllHandler returns all ids of registered igc files
+//
+// The ids are ordered by the time they were registered, and the optional
+// `limit` and `offset` query parameters can be used to page through them. The
+// total amount of ids is returned in the `X-Total-Count` header. The ids can
+// be filtered using the optional `pilot` and `glider` query parameters, and
+// ordered by another field using the optional `sort` and `order` parameters.
+// The optional `since` parameter, a unix timestamp in milliseconds, limits the
+// ids to the tracks added after that point in time, and the optional `tag`
+// parameter limits the ids to the tracks with that exact tag.
 func (server *Server) trackGetAllHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
 	logger.Info("processing request to get all track ids")
 
-	ids, err := server.tracks.GetAllIDs()
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		logger.WithField("error", err).Info("unable to parse pagination parameters")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sortField := r.URL.Query().Get("sort")
+	if _, ok := trackSortFields[sortField]; sortField != "" && !ok {
+		logger.WithField("sort", sortField).Info("unable to sort by field")
+		writeJSONError(w, http.StatusBadRequest, "invalid sort field")
+		return
+	}
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		logger.WithField("order", order).Info("invalid sort order")
+		writeJSONError(w, http.StatusBadRequest, "invalid sort order")
+		return
+	}
+
+	predicate, err := trackFilterFrom(r.URL.Query())
+	if err != nil {
+		logger.WithField("since", r.URL.Query().Get("since")).Info("unable to parse since timestamp")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var ids []TrackID
+	switch {
+	case sortField != "":
+		ids, err = server.tracks.GetAllSorted(sortField, order == "desc")
+		if err == nil && predicate != nil {
+			var matching []TrackID
+			if matching, err = server.tracks.Filter(predicate); err == nil {
+				ids = keepTrackIDs(ids, matching)
+			}
+		}
+	case predicate != nil:
+		ids, err = server.tracks.Filter(predicate)
+	default:
+		ids, err = server.tracks.GetAllIDs()
+	}
+	if tag := r.URL.Query().Get("tag"); err == nil && tag != "" {
+		var tagged []TrackID
+		if tagged, err = server.tracks.FindByTag(tag); err == nil {
+			ids = keepTrackIDs(ids, tagged)
+		}
+	}
 	if err != nil {
 		logger.WithField("error", err).Error("unable to respond to request of all IDs")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	total := len(ids)
+	ids = paginateIDs(ids, limit, offset)
+
+	logger.WithFields(log.Fields{
+		"ids":    ids,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	}).Info("responding to request with all ids")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	json.NewEncoder(w).Encode(ids)
+}
+
+// trackClearHandler removes all registered tracks and responds with the
+// amount of deleted tracks
+//
+// ```json
+// {
+//   "deleted": <amount of deleted tracks>
+// }
+// ```
+func (server *Server) trackClearHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to delete all tracks")
+
+	n, err := server.tracks.Clear()
+	if err != nil {
+		logger.WithField("error", err).Error("unable to delete all tracks")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
-	logger.WithField("ids", ids).Info("responding to request with all ids")
+	if server.points != nil {
+		server.points.Clear()
+	}
+	if server.raws != nil {
+		server.raws.Clear()
+	}
+	// The latest track is gone along with all other tracks
+	if server.ticker != nil {
+		if latest := server.ticker.Latest(); latest != nil {
+			server.ticker.Deleted(*latest)
+		}
+	}
+
+	result := map[string]interface{}{
+		"deleted": n,
+	}
+
+	logger.WithField("deleted", n).Info("responding with amount of deleted tracks")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// trackByGliderHandler responds with the ids of all tracks flown with the
+// glider id in the path, where the glider id has to match exactly
+func (server *Server) trackByGliderHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get tracks by glider id")
+
+	vars := mux.Vars(r)
+	gliderID, _ := vars["gliderID"]
+	glog := logger.WithField("glider_id", gliderID)
+
+	ids, err := server.tracks.FindByGliderID(gliderID)
+	if err != nil {
+		glog.WithField("error", err).Error("unable to find tracks by glider id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	glog.WithField("ids", ids).Info("responding with tracks flown with glider id")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ids)
 }
 
+// trackCountHandler responds with the amount of registered tracks
+func (server *Server) trackCountHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to count tracks")
+
+	n, err := server.tracks.Len()
+	if err != nil {
+		logger.WithField("error", err).Error("unable to count tracks")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	result := map[string]interface{}{
+		"count": n,
+	}
+
+	logger.WithField("count", n).Info("responding with amount of tracks")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
 // trackGetHandler should return the fields of a specific id
 func (server *Server) trackGetHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
@@ -214,29 +1215,214 @@ func (server *Server) trackGetHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		logger.WithField("id", idStr).Info("id must be a valid number")
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 	idlog := logger.WithField("id", id)
 	meta, err := server.tracks.Get(TrackID(id))
 	if err == ErrTrackNotFound {
 		idlog.Info("unable to find metadata of id")
-		http.Error(w, "content not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "content not found")
 		return
 	} else if err != nil {
 		idlog.WithField("error", err).Info("error when getting metadata of id")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	// The json fields can be given in another casing than snake_case
+	caseName := r.URL.Query().Get("case")
+	if caseName == "" {
+		caseName = "snake"
+	}
+	rename, ok := jsonCases[caseName]
+	if !ok {
+		idlog.WithField("case", caseName).Info("invalid casing of fields")
+		writeJSONError(w, http.StatusBadRequest, "invalid case, expected 'snake' or 'camel'")
+		return
+	}
+	// The json response can be limited to some of the fields, which are given
+	// by the same names as in `GET /track/<id>/<field>`
+	var fields []string
+	if list := r.URL.Query().Get("fields"); list != "" {
+		fields, err = parseFieldList(list)
+		if err != nil {
+			idlog.WithField("error", err).Info("unable to parse fields of projection")
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	// Respond with xml if the client prefers it, and json otherwise
+	contentType := "application/json"
+	body, err := json.Marshal(meta)
+	if err == nil && fields != nil {
+		body, err = projectJSONFields(body, fields)
+	}
+	if err == nil && server.publicURL != "" {
+		body, err = addJSONField(body, "links", server.trackLinks(meta.ID))
+	}
+	if err == nil && caseName != "snake" {
+		body, err = renameJSONKeys(body, rename)
+	}
+	if prefersXML(r) {
+		contentType = "application/xml"
+		body, err = xml.Marshal(struct {
+			XMLName xml.Name `xml:"track"`
+			TrackMeta
+		}{TrackMeta: meta})
+	}
+	if err != nil {
+		idlog.WithField("error", err).Error("unable to encode metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	// The time the track was registered is used as the time of the last
+	// modification, hence only the entity tag reflects changes made by PATCH
+	if notModified(w, r, newETag(body), meta.Timestamp) {
+		idlog.Info("responding that track meta is not modified")
 		return
 	}
 	logger.WithFields(log.Fields{
 		"trackmeta": meta,
 	}).Info("responding with track meta for given id")
 
+	w.Header().Set("Content-Type", contentType)
+	w.Write(append(body, '\n'))
+}
+
+// trackLinks returns the absolute links to the track, using the public base
+// url of the server
+func (server *Server) trackLinks(id TrackID) map[string]string {
+	return map[string]string{
+		"self": fmt.Sprintf("%s%s/track/%d", server.publicURL, server.prefix, id),
+	}
+}
+
+// addJSONField adds the key with the value to the encoded json object
+func addJSONField(body []byte, key string, value interface{}) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Numbers are kept as they are instead of being converted to floats
+	dec.UseNumber()
+	var object map[string]interface{}
+	if err := dec.Decode(&object); err != nil {
+		return nil, err
+	}
+	object[key] = value
+	return json.Marshal(object)
+}
+
+// trackGetGzipHandler responds with the metadata of a specific track as a
+// gzipped json file, which is meant to be downloaded as is
+func (server *Server) trackGetGzipHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to download specific track")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	meta, err := server.tracks.Get(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	body, err := json.Marshal(meta)
+	if err != nil {
+		idlog.WithField("error", err).Error("unable to encode metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	idlog.Info("responding with gzipped track meta")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="track-%d.json.gz"`, id))
+	if err := writeGzip(w, append(body, '\n')); err != nil {
+		idlog.WithField("error", err).Info("unable to write gzipped track meta")
+	}
+}
+
+// TrackPatchRequest is the format of a request to update a track, where only
+// the fields which are present are updated
+type TrackPatchRequest struct {
+	Pilot    *string `json:"pilot"`
+	Glider   *string `json:"glider"`
+	GliderID *string `json:"glider_id"`
+}
+
+// trackPatchHandler updates the mutable fields (`pilot`, `glider` and
+// `glider_id`) of a specific track and responds with the updated track
+func (server *Server) trackPatchHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to update specific track")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+
+	// Unknown fields are rejected, which also rejects attempts to change any
+	// of the immutable fields
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, server.maxBodySize))
+	dec.DisallowUnknownFields()
+
+	var req TrackPatchRequest
+	if err := dec.Decode(&req); err != nil {
+		idlog.WithField("error", err).Info("unable to decode request body")
+		writeJSONError(w, http.StatusBadRequest, "invalid json object")
+		return
+	}
+
+	meta, err := server.tracks.Update(TrackID(id), func(meta *TrackMeta) {
+		if req.Pilot != nil {
+			meta.Pilot = *req.Pilot
+		}
+		if req.Glider != nil {
+			meta.Glider = *req.Glider
+		}
+		if req.GliderID != nil {
+			meta.GliderID = *req.GliderID
+		}
+	})
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Info("error when updating metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	idlog.WithFields(log.Fields{
+		"trackmeta": meta,
+	}).Info("responding with updated track meta")
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(meta)
 }
 
 // trackGetFieldHandler should return the field specified in the url
+//
+// The id is always checked before the field, so that an unknown id gives 404
+// regardless of the field, while an unknown field of a known id gives 400.
 func (server *Server) trackGetFieldHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
@@ -247,7 +1433,7 @@ func (server *Server) trackGetFieldHandler(w http.ResponseWriter, r *http.Reques
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		logger.WithField("id", idStr).Info("id must be a valid number")
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 	field, _ := vars["field"]
@@ -256,36 +1442,116 @@ func (server *Server) trackGetFieldHandler(w http.ResponseWriter, r *http.Reques
 	meta, err := server.tracks.Get(TrackID(id))
 	if err == ErrTrackNotFound {
 		idlog.Info("unable to find metadata of id")
-		http.Error(w, "content not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "content not found")
 		return
 	} else if err != nil {
 		idlog.WithField("error", err).Info("error when getting metadata of id")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
 
 	flog := idlog.WithField("field", field)
+	if _, ok := trackMetaFieldIndex[field]; !ok {
+		flog.Info("unable to find field of metadata")
+		writeJSONError(w, http.StatusBadRequest, "invalid field")
+		return
+	}
+
 	switch field {
-	case "H_date":
-		flog.Info("responding with track date")
-		io.WriteString(w, meta.Date.Format(time.RFC3339))
-	case "pilot":
-		flog.Info("responding with track pilot")
-		io.WriteString(w, meta.Pilot)
-	case "glider":
-		flog.Info("responding with track glider")
-		io.WriteString(w, meta.Glider)
-	case "glider_id":
-		flog.Info("responding with track glider id")
-		io.WriteString(w, meta.GliderID)
 	case "track_length":
-		flog.Info("responding with track length")
-		io.WriteString(w, strconv.FormatFloat(meta.TrackLength, 'f', -1, 64))
-	case "track_src_url":
-		flog.Info("responding with track src url")
-		io.WriteString(w, meta.TrackSrcURL)
+		// The track length is stored in kilometers, but can be converted to
+		// another unit using the `unit` query parameter
+		unit := r.URL.Query().Get("unit")
+		if unit == "" {
+			unit = "km"
+		}
+		length, err := convertDistance(meta.TrackLength, unit)
+		if err != nil {
+			flog.WithField("unit", unit).Info("invalid unit of track length")
+			writeJSONError(w, http.StatusBadRequest, "invalid unit, expected 'km' or 'm'")
+			return
+		}
+		flog.WithField("unit", unit).Info("responding with track length")
+		io.WriteString(w, strconv.FormatFloat(length, 'f', -1, 64))
+	case "H_date":
+		// The date is formatted as specified in RFC3339 by default, but can be
+		// formatted differently using the `format` query parameter
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "rfc3339"
+		}
+		date, err := formatDate(meta.Date, format)
+		if err != nil {
+			flog.WithField("format", format).Info("invalid format of date")
+			writeJSONError(w, http.StatusBadRequest, "invalid format, expected 'rfc3339', 'date' or 'unix'")
+			return
+		}
+		flog.WithField("format", format).Info("responding with date of track")
+		io.WriteString(w, date)
+	case "bbox":
+		flog.Info("responding with bounding box of track")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta.BBox)
+	case "tags":
+		tags := meta.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		flog.Info("responding with tags of track")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
 	default:
-		flog.Info("unable to find field of metadata")
-		http.Error(w, "invalid field", http.StatusBadRequest)
+		value, _ := formatTrackMetaField(meta, field)
+		flog.Info("responding with field of track")
+		io.WriteString(w, value)
+	}
+}
+
+// trackDeleteHandler removes the track with the given id and responds with the
+// metadata of the deleted track
+func (server *Server) trackDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to delete specific track")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	meta, err := server.tracks.Delete(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Info("error when deleting metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+	server.forgetTrack(meta)
+	idlog.WithFields(log.Fields{
+		"trackmeta": meta,
+	}).Info("responding with deleted track meta")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// forgetTrack removes everything the server keeps about a track which has been
+// removed from the storage, ie. its points and raw content
+func (server *Server) forgetTrack(meta TrackMeta) {
+	if server.points != nil {
+		server.points.Delete(meta.ID)
+	}
+	if server.raws != nil {
+		server.raws.Delete(meta.ID)
+	}
+	if server.ticker != nil {
+		server.ticker.Deleted(meta.Timestamp)
 	}
 }
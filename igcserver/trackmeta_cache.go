@@ -0,0 +1,242 @@
+package igcserver
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Make sure that TrackMetasCache can be used as a storage backend for the
+// server
+var _ TrackMetas = (*TrackMetasCache)(nil)
+
+// Make sure that the server is told about track metas evicted by the store
+// wrapped by TrackMetasCache
+var _ evictingTrackMetas = (*TrackMetasCache)(nil)
+
+// TrackMetasCache wraps any storage of TrackMeta and keeps the most recently
+// used track metas in memory, evicting the least recently used track meta
+// when the cache is full
+type TrackMetasCache struct {
+	sync.Mutex
+	store   TrackMetas
+	size    int
+	order   *list.List
+	entries map[TrackID]*list.Element
+
+	// generation is incremented on every change to the store, such that
+	// track metas which may have been changed while they were fetched from
+	// the store are not cached
+	generation uint64
+}
+
+// NewTrackMetasCache creates a new cache of the given size around the store
+func NewTrackMetasCache(store TrackMetas, size int) TrackMetasCache {
+	return TrackMetasCache{
+		sync.Mutex{},
+		store,
+		size,
+		list.New(),
+		make(map[TrackID]*list.Element),
+		0,
+	}
+}
+
+// lookup returns the cached track meta of the id and marks it as recently
+// used. Must be called while holding the lock.
+func (cache *TrackMetasCache) lookup(id TrackID) (meta TrackMeta, ok bool) {
+	elem, ok := cache.entries[id]
+	if ok {
+		cache.order.MoveToFront(elem)
+		meta = elem.Value.(TrackMeta)
+	}
+	return
+}
+
+// put caches the track meta and evicts the least recently used track meta if
+// the cache is full. Must be called while holding the lock.
+func (cache *TrackMetasCache) put(meta TrackMeta) {
+	if cache.size < 1 {
+		return
+	}
+	if elem, ok := cache.entries[meta.ID]; ok {
+		elem.Value = meta
+		cache.order.MoveToFront(elem)
+		return
+	}
+	cache.entries[meta.ID] = cache.order.PushFront(meta)
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(TrackMeta).ID)
+	}
+}
+
+// remove removes the track meta of the id from the cache. Must be called
+// while holding the lock.
+func (cache *TrackMetasCache) remove(id TrackID) {
+	if elem, ok := cache.entries[id]; ok {
+		cache.order.Remove(elem)
+		delete(cache.entries, id)
+	}
+}
+
+// OnEvict sets a function which is called with every track meta which is
+// evicted by the store, if the store evicts track metas by itself. Evicted
+// track metas are removed from the cache before the function is called.
+func (cache *TrackMetasCache) OnEvict(onEvict func(TrackMeta)) {
+	evicting, ok := cache.store.(evictingTrackMetas)
+	if !ok {
+		return
+	}
+	evicting.OnEvict(func(meta TrackMeta) {
+		cache.Lock()
+		cache.remove(meta.ID)
+		cache.generation++
+		cache.Unlock()
+		onEvict(meta)
+	})
+}
+
+// Get fetches the track meta of a specific id from the cache, or from the
+// store if it is not cached
+func (cache *TrackMetasCache) Get(id TrackID) (meta TrackMeta, err error) {
+	cache.Lock()
+	meta, ok := cache.lookup(id)
+	generation := cache.generation
+	cache.Unlock()
+	if ok {
+		return
+	}
+
+	meta, err = cache.store.Get(id)
+	if err == nil {
+		cache.Lock()
+		if cache.generation == generation {
+			cache.put(meta)
+		}
+		cache.Unlock()
+	}
+	return
+}
+
+// Append appends a track meta to the store and caches it
+func (cache *TrackMetasCache) Append(meta TrackMeta) (err error) {
+	cache.Lock()
+	generation := cache.generation
+	cache.Unlock()
+
+	err = cache.store.Append(meta)
+	if err == nil {
+		cache.Lock()
+		if cache.generation == generation {
+			cache.put(meta)
+		}
+		cache.generation++
+		cache.Unlock()
+	}
+	return
+}
+
+// AppendMany appends the track metas to the store and caches them
+func (cache *TrackMetasCache) AppendMany(metas []TrackMeta) (ids []TrackID, err error) {
+	cache.Lock()
+	generation := cache.generation
+	cache.Unlock()
+
+	ids, err = cache.store.AppendMany(metas)
+	if err == nil {
+		cache.Lock()
+		if cache.generation == generation {
+			for _, meta := range metas {
+				cache.put(meta)
+			}
+		}
+		cache.generation++
+		cache.Unlock()
+	}
+	return
+}
+
+// GetAllIDs fetches all the stored ids from the store
+func (cache *TrackMetasCache) GetAllIDs() ([]TrackID, error) {
+	return cache.store.GetAllIDs()
+}
+
+// GetAll fetches all the stored track metas from the store
+func (cache *TrackMetasCache) GetAll() ([]TrackMeta, error) {
+	return cache.store.GetAll()
+}
+
+// Delete removes the track meta of a specific id from both the store and the
+// cache
+func (cache *TrackMetasCache) Delete(id TrackID) (meta TrackMeta, err error) {
+	meta, err = cache.store.Delete(id)
+	cache.Lock()
+	cache.remove(id)
+	cache.generation++
+	cache.Unlock()
+	return
+}
+
+// Clear removes all track metas from both the store and the cache
+func (cache *TrackMetasCache) Clear() (n int, err error) {
+	n, err = cache.store.Clear()
+	cache.Lock()
+	cache.order.Init()
+	cache.entries = make(map[TrackID]*list.Element)
+	cache.generation++
+	cache.Unlock()
+	return
+}
+
+// Len returns the amount of track metas in the store
+func (cache *TrackMetasCache) Len() (int, error) {
+	return cache.store.Len()
+}
+
+// Update applies the update to the track meta in the store and caches the
+// updated meta
+func (cache *TrackMetasCache) Update(id TrackID, update func(*TrackMeta)) (meta TrackMeta, err error) {
+	cache.Lock()
+	generation := cache.generation
+	cache.Unlock()
+
+	meta, err = cache.store.Update(id, update)
+	cache.Lock()
+	if err == nil && cache.generation == generation {
+		cache.put(meta)
+	} else {
+		cache.remove(id)
+	}
+	cache.generation++
+	cache.Unlock()
+	return
+}
+
+// Filter fetches the ids of all track metas in the store matching the
+// predicate
+func (cache *TrackMetasCache) Filter(predicate func(TrackMeta) bool) ([]TrackID, error) {
+	return cache.store.Filter(predicate)
+}
+
+// GetAllSorted fetches all the ids in the store ordered by the given field
+func (cache *TrackMetasCache) GetAllSorted(field string, desc bool) ([]TrackID, error) {
+	return cache.store.GetAllSorted(field, desc)
+}
+
+// Search finds the tracks in the store matching the query
+func (cache *TrackMetasCache) Search(query string) ([]TrackMatch, error) {
+	return cache.store.Search(query)
+}
+
+// FindByGliderID fetches the ids of all track metas in the store with the
+// exact glider id
+func (cache *TrackMetasCache) FindByGliderID(gliderID string) ([]TrackID, error) {
+	return cache.store.FindByGliderID(gliderID)
+}
+
+// FindByTag fetches the ids of all track metas in the store with the exact
+// tag
+func (cache *TrackMetasCache) FindByTag(tag string) ([]TrackID, error) {
+	return cache.store.FindByTag(tag)
+}
@@ -0,0 +1,198 @@
+package igcserver
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowTrackMetas wraps a TrackMetasMap and counts (and optionally delays)
+// every lookup to simulate a database-backed storage
+type slowTrackMetas struct {
+	*TrackMetasMap
+	delay time.Duration
+	gets  int64
+}
+
+// Get fetches the track meta of a specific id after the delay
+func (metas *slowTrackMetas) Get(id TrackID) (TrackMeta, error) {
+	atomic.AddInt64(&metas.gets, 1)
+	time.Sleep(metas.delay)
+	return metas.TrackMetasMap.Get(id)
+}
+
+// Convenience function to create a cache around a slow storage
+func makeTrackMetasCache(size int, delay time.Duration) (*TrackMetasCache, *slowTrackMetas) {
+	trackMetasMap := NewTrackMetasMap()
+	store := &slowTrackMetas{&trackMetasMap, delay, 0}
+	cache := NewTrackMetasCache(store, size)
+	return &cache, store
+}
+
+// Test that the least recently used track meta is evicted when the cache is
+// full
+func TestTrackMetasCacheEviction(t *testing.T) {
+	cache, store := makeTrackMetasCache(2, 0)
+
+	metas := []TrackMeta{{ID: 1}, {ID: 2}, {ID: 3}}
+	for _, meta := range metas[:2] {
+		if err := cache.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	// Use the first meta so that the second becomes the least recently used
+	cache.Get(1)
+	if err := cache.Append(metas[2]); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	for _, id := range []TrackID{1, 3} {
+		if _, err := cache.Get(id); err != nil {
+			t.Fatalf("unable to get metadata: %s", err)
+		}
+	}
+	if store.gets != 0 {
+		t.Errorf("expected recently used metas to be cached, store was used '%d' times", store.gets)
+	}
+	if _, err := cache.Get(2); err != nil {
+		t.Fatalf("unable to get evicted metadata: %s", err)
+	}
+	if store.gets != 1 {
+		t.Errorf("expected evicted meta to be fetched from store once, store was used '%d' times", store.gets)
+	}
+}
+
+// Test that deleted and updated track metas are not served stale from cache
+func TestTrackMetasCacheInvalidation(t *testing.T) {
+	cache, _ := makeTrackMetasCache(10, 0)
+
+	meta := TrackMeta{ID: 1, Pilot: "John"}
+	if err := cache.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	if _, err := cache.Update(meta.ID, func(meta *TrackMeta) { meta.Pilot = "Jane" }); err != nil {
+		t.Fatalf("unable to update metadata: %s", err)
+	}
+	if got, _ := cache.Get(meta.ID); got.Pilot != "Jane" {
+		t.Errorf("expected cached meta to be updated, got pilot '%s'", got.Pilot)
+	}
+	if _, err := cache.Delete(meta.ID); err != nil {
+		t.Fatalf("unable to delete metadata: %s", err)
+	}
+	if _, err := cache.Get(meta.ID); err != ErrTrackNotFound {
+		t.Errorf("expected deleted meta to be missing, got '%v'", err)
+	}
+}
+
+// Test that track metas evicted by the store are reported through the cache
+// and are no longer served from the cache
+func TestTrackMetasCacheStoreEviction(t *testing.T) {
+	trackMetasMap := NewTrackMetasMapWithCapacity(1)
+	cache := NewTrackMetasCache(&trackMetasMap, 10)
+	var evicted []TrackID
+	cache.OnEvict(func(meta TrackMeta) {
+		evicted = append(evicted, meta.ID)
+	})
+
+	for _, meta := range []TrackMeta{{ID: 1}, {ID: 2}} {
+		if err := cache.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != 1 {
+		t.Errorf("expected eviction of '[1]' to be reported, got '%d'", evicted)
+	}
+	if _, err := cache.Get(1); err != ErrTrackNotFound {
+		t.Errorf("expected evicted meta to be missing, got '%v'", err)
+	}
+}
+
+// Test that the cache never serves stale track metas when the store is changed
+// concurrently
+func TestTrackMetasCacheConcurrentAccess(t *testing.T) {
+	cache, _ := makeTrackMetasCache(8, 10*time.Microsecond)
+	stressTrackMetas(t, cache)
+}
+
+// pausedTrackMetas wraps a TrackMetasMap and pauses every lookup after the
+// track meta is read until it is resumed
+type pausedTrackMetas struct {
+	*TrackMetasMap
+	read   chan struct{}
+	resume chan struct{}
+}
+
+// Get fetches the track meta of a specific id, and returns it when resumed
+func (metas *pausedTrackMetas) Get(id TrackID) (TrackMeta, error) {
+	meta, err := metas.TrackMetasMap.Get(id)
+	metas.read <- struct{}{}
+	<-metas.resume
+	return meta, err
+}
+
+// Test that a track meta which is deleted or updated while it is fetched from
+// the store is not cached
+func TestTrackMetasCacheChangedWhileFetched(t *testing.T) {
+	for name, change := range map[string]func(TrackMetas){
+		"delete": func(metas TrackMetas) { metas.Delete(1) },
+		"update": func(metas TrackMetas) {
+			metas.Update(1, func(meta *TrackMeta) { meta.Pilot = "Jane" })
+		},
+	} {
+		trackMetasMap := NewTrackMetasMap()
+		store := &pausedTrackMetas{&trackMetasMap, make(chan struct{}), make(chan struct{})}
+		cache := NewTrackMetasCache(store, 10)
+		if err := trackMetasMap.Append(TrackMeta{ID: 1, Pilot: "John"}); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			cache.Get(1)
+			close(done)
+		}()
+		<-store.read
+		change(&cache)
+		close(store.resume)
+		<-done
+
+		cache.Lock()
+		cached, ok := cache.lookup(1)
+		cache.Unlock()
+		want, err := trackMetasMap.Get(1)
+		if ok && (err != nil || cached.Pilot != want.Pilot) {
+			t.Errorf("expected '%s' while fetching to not leave a stale meta in the cache, got '%v'", name, cached)
+		}
+	}
+}
+
+// Benchmark `Get` on a slow storage through the cache
+func BenchmarkTrackMetasCacheGet(b *testing.B) {
+	cache, _ := makeTrackMetasCache(100, 50*time.Microsecond)
+	benchmarkTrackMetasGet(b, cache)
+}
+
+// Benchmark `Get` on a slow storage without a cache
+func BenchmarkTrackMetasUncachedGet(b *testing.B) {
+	_, store := makeTrackMetasCache(0, 50*time.Microsecond)
+	benchmarkTrackMetasGet(b, store)
+}
+
+// benchmarkTrackMetasGet repeatedly gets a small set of track metas from
+// multiple goroutines
+func benchmarkTrackMetasGet(b *testing.B, metas TrackMetas) {
+	const metaCount = 50
+	for i := 0; i < metaCount; i++ {
+		if err := metas.Append(TrackMeta{ID: TrackID(i)}); err != nil {
+			b.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			metas.Get(TrackID(i % metaCount))
+			i++
+		}
+	})
+}
@@ -3,25 +3,61 @@ package igcserver
 import (
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	trackCollection = "igctracks"
 )
 
+// Make sure that TrackMetasDB can be used as a storage backend for the server
+var _ TrackMetas = (*TrackMetasDB)(nil)
+
 // TrackMetasDB contains a map to many TrackMeta objects which are protected
 // by a RWMutex and indexed by a unique id
 type TrackMetasDB struct {
 	session *mgo.Session
 }
 
-// NewTrackMetasDB creates a new mutex and mapping from ID to TrackMeta
+// NewTrackMetasDB creates a new database-aware storage of TrackMeta
+//
+// Unique indexes are ensured on both the id and the source url of a track,
+// along with the duplicate window it was registered in, so that duplicates
+// are rejected by the database itself.
 func NewTrackMetasDB(session *mgo.Session) TrackMetasDB {
+	conn := session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	// The source url alone was unique before tracks could be registered
+	// again after a duplicate window
+	if err := tracks.DropIndex("track_src_url"); err == nil {
+		log.Info("dropped unique index on source url of track collection")
+	}
+
+	for _, key := range [][]string{{"id"}, {"track_src_url", "src_window"}} {
+		err := tracks.EnsureIndex(mgo.Index{
+			Key:    key,
+			Unique: true,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key":   key,
+				"error": err,
+			}).Warn("unable to ensure unique index on track collection")
+		}
+	}
+
 	return TrackMetasDB{
 		session,
 	}
 }
 
+// Ping checks that the database is reachable
+func (metas *TrackMetasDB) Ping() error {
+	return metas.session.Ping()
+}
+
 // Get fetches the track meta of a specific id if it exists
 func (metas *TrackMetasDB) Get(id TrackID) (meta TrackMeta, err error) {
 	conn := metas.session.Copy()
@@ -41,25 +77,169 @@ func (metas *TrackMetasDB) Append(meta TrackMeta) (err error) {
 	defer conn.Close()
 	tracks := conn.DB("").C(trackCollection)
 
-	n, err := tracks.Find(bson.M{"id": meta.ID}).Count()
-	if err == nil {
-		if n == 0 {
-			err = tracks.Insert(meta)
-		} else if n > 0 {
-			err = ErrTrackAlreadyExists
+	err = tracks.Insert(meta)
+	if mgo.IsDup(err) {
+		err = ErrTrackAlreadyExists
+	}
+	return
+}
+
+// AppendMany appends all the track metas or none of them. The database has no
+// transactions, hence the appended track metas are removed again if one of
+// them fails, and other readers may see a part of the batch in the meantime.
+func (metas *TrackMetasDB) AppendMany(trackMetas []TrackMeta) (ids []TrackID, err error) {
+	ids, err = checkBatchDuplicates(trackMetas)
+	if err != nil {
+		return
+	}
+
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	for i, meta := range trackMetas {
+		if err = tracks.Insert(meta); err != nil {
+			if _, rerr := tracks.RemoveAll(bson.M{"id": bson.M{"$in": ids[:i]}}); rerr != nil {
+				log.WithFields(log.Fields{
+					"ids":   ids[:i],
+					"error": rerr,
+				}).Error("unable to remove the appended part of a failed batch")
+			}
+			if mgo.IsDup(err) {
+				err = ErrTrackAlreadyExists
+			}
+			return nil, err
 		}
 	}
 	return
 }
 
-// GetAllIDs fetches all the stored ids
+// GetAllIDs fetches all the stored ids ordered by the time they were added
 func (metas *TrackMetasDB) GetAllIDs() (ids []TrackID, err error) {
 	conn := metas.session.Copy()
 	defer conn.Close()
 	tracks := conn.DB("").C(trackCollection)
 
 	var trackMetas []TrackMeta
-	err = tracks.Find(nil).All(&trackMetas)
+	err = tracks.
+		Find(nil).
+		Select(bson.M{"id": 1}).
+		Sort("timestamp", "id").
+		All(&trackMetas)
+	if err == nil {
+		ids = make([]TrackID, len(trackMetas))
+		for i, v := range trackMetas {
+			ids[i] = v.ID
+		}
+	}
+	return
+}
+
+// GetAll fetches all the stored track metas ordered by the time they were
+// added
+func (metas *TrackMetasDB) GetAll() (trackMetas []TrackMeta, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	trackMetas = make([]TrackMeta, 0)
+	err = tracks.Find(nil).Sort("timestamp", "id").All(&trackMetas)
+	return
+}
+
+// Delete removes the track meta of a specific id and returns the removed meta
+func (metas *TrackMetasDB) Delete(id TrackID) (meta TrackMeta, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	err = tracks.Find(bson.M{"id": id}).One(&meta)
+	if err == mgo.ErrNotFound {
+		err = ErrTrackNotFound
+	} else if err == nil {
+		err = tracks.Remove(bson.M{"id": id})
+	}
+	return
+}
+
+// Clear removes all stored track metas and returns the amount removed
+func (metas *TrackMetasDB) Clear() (n int, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	info, err := tracks.RemoveAll(nil)
+	if err == nil {
+		n = info.Removed
+	}
+	return
+}
+
+// Len returns the amount of stored track metas
+func (metas *TrackMetasDB) Len() (int, error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	return tracks.Count()
+}
+
+// Update applies the update to the track meta of a specific id and returns
+// the updated meta
+func (metas *TrackMetasDB) Update(id TrackID, update func(*TrackMeta)) (meta TrackMeta, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	err = tracks.Find(bson.M{"id": id}).One(&meta)
+	if err == mgo.ErrNotFound {
+		err = ErrTrackNotFound
+	} else if err == nil {
+		update(&meta)
+		err = tracks.Update(bson.M{"id": id}, meta)
+	}
+	return
+}
+
+// Filter fetches the ids of all track metas matching the predicate, ordered by
+// the time they were added
+func (metas *TrackMetasDB) Filter(predicate func(TrackMeta) bool) (ids []TrackID, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	iter := tracks.Find(nil).Sort("timestamp", "id").Iter()
+	ids = make([]TrackID, 0)
+	var meta TrackMeta
+	for iter.Next(&meta) {
+		if predicate(meta) {
+			ids = append(ids, meta.ID)
+		}
+	}
+	err = iter.Close()
+	return
+}
+
+// GetAllSorted fetches all the stored ids ordered by the given field
+func (metas *TrackMetasDB) GetAllSorted(field string, desc bool) (ids []TrackID, err error) {
+	if _, ok := trackSortFields[field]; !ok {
+		err = ErrInvalidSortField
+		return
+	}
+	if desc {
+		field = "-" + field
+	}
+
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	var trackMetas []TrackMeta
+	err = tracks.
+		Find(nil).
+		Select(bson.M{"id": 1}).
+		Sort(field, "id").
+		All(&trackMetas)
 	if err == nil {
 		ids = make([]TrackID, len(trackMetas))
 		for i, v := range trackMetas {
@@ -68,3 +248,61 @@ func (metas *TrackMetasDB) GetAllIDs() (ids []TrackID, err error) {
 	}
 	return
 }
+
+// FindByGliderID fetches the ids of all track metas with the exact glider id,
+// ordered by the time they were added
+func (metas *TrackMetasDB) FindByGliderID(gliderID string) (ids []TrackID, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	var trackMetas []TrackMeta
+	err = tracks.
+		Find(bson.M{"glider_id": gliderID}).
+		Select(bson.M{"id": 1}).
+		Sort("timestamp", "id").
+		All(&trackMetas)
+	if err == nil {
+		ids = make([]TrackID, len(trackMetas))
+		for i, v := range trackMetas {
+			ids[i] = v.ID
+		}
+	}
+	return
+}
+
+// FindByTag fetches the ids of all track metas with the exact tag, ordered by
+// the time they were added
+func (metas *TrackMetasDB) FindByTag(tag string) (ids []TrackID, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	var trackMetas []TrackMeta
+	err = tracks.
+		Find(bson.M{"tags": tag}).
+		Select(bson.M{"id": 1}).
+		Sort("timestamp", "id").
+		All(&trackMetas)
+	if err == nil {
+		ids = make([]TrackID, len(trackMetas))
+		for i, v := range trackMetas {
+			ids[i] = v.ID
+		}
+	}
+	return
+}
+
+// Search finds the tracks matching the query, ordered by relevance
+func (metas *TrackMetasDB) Search(query string) (matches []TrackMatch, err error) {
+	conn := metas.session.Copy()
+	defer conn.Close()
+	tracks := conn.DB("").C(trackCollection)
+
+	var trackMetas []TrackMeta
+	err = tracks.Find(nil).Sort("timestamp", "id").All(&trackMetas)
+	if err == nil {
+		matches = searchTrackMetas(trackMetas, query)
+	}
+	return
+}
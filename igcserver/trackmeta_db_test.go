@@ -0,0 +1,100 @@
+package igcserver
+
+import (
+	"fmt"
+	"github.com/globalsign/mgo"
+	"github.com/google/go-cmp/cmp"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+// Convenience function to create a mongo storage in a database of its own,
+// which has to be dropped with `dropTestDB` when the test is done. The test
+// is skipped unless the envvar `MONGODB_URI` is set.
+func makeTestTrackMetasDB(t *testing.T) (TrackMetasDB, *mgo.Session) {
+	mongoURL, ok := os.LookupEnv("MONGODB_URI")
+	if !ok {
+		t.Skip("envvar 'MONGODB_URI' is not set")
+	}
+	info, err := mgo.ParseURL(mongoURL)
+	if err != nil {
+		t.Fatalf("unable to parse mongo db url: %s", err)
+	}
+	info.Database = fmt.Sprintf("paragliding_test_%d", rand.Int63())
+	info.Timeout = 5 * time.Second
+
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		t.Fatalf("unable to connect to mongo db: %s", err)
+	}
+	return NewTrackMetasDB(session), session
+}
+
+// Convenience function to drop the database of a test and close the session
+func dropTestDB(session *mgo.Session) {
+	session.DB("").DropDatabase()
+	session.Close()
+}
+
+// Test that unique indexes are ensured on the id and on the source url along
+// with the duplicate window of tracks
+func TestTrackMetasDBIndexes(t *testing.T) {
+	_, session := makeTestTrackMetasDB(t)
+	defer dropTestDB(session)
+
+	indexes, err := session.DB("").C(trackCollection).Indexes()
+	if err != nil {
+		t.Fatalf("unable to get indexes: %s", err)
+	}
+	unique := make([][]string, 0, len(indexes))
+	for _, index := range indexes {
+		if index.Unique {
+			unique = append(unique, index.Key)
+		}
+	}
+	expt := [][]string{{"id"}, {"track_src_url", "src_window"}}
+	if !cmp.Equal(unique, expt) {
+		t.Errorf("expected unique indexes to be '%v', got '%v'", expt, unique)
+	}
+}
+
+// Test that tracks violating the unique indexes are rejected as already
+// existing
+func TestTrackMetasDBAppendDuplicate(t *testing.T) {
+	metas, session := makeTestTrackMetasDB(t)
+	defer dropTestDB(session)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	meta := testTrackMetas[0]
+	if err := metas.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	sameID := testTrackMetas[1]
+	sameID.ID = meta.ID
+	sameURL := testTrackMetas[1]
+	sameURL.TrackSrcURL = meta.TrackSrcURL
+	for _, data := range []struct {
+		name string
+		meta TrackMeta
+	}{
+		{"same track", meta},
+		{"same id", sameID},
+		{"same source url", sameURL},
+	} {
+		if err := metas.Append(data.meta); err != ErrTrackAlreadyExists {
+			t.Errorf("expected track with %s to be rejected, got '%v'", data.name, err)
+		}
+	}
+
+	// The same source url is accepted in another duplicate window
+	sameURL.SrcWindow = meta.SrcWindow + 1
+	if err := metas.Append(sameURL); err != nil {
+		t.Errorf("expected track with same source url in another window to be added, got '%v'", err)
+	}
+	if n, _ := metas.Len(); n != 2 {
+		t.Errorf("expected '2' tracks to be stored, got '%d'", n)
+	}
+}
@@ -0,0 +1,273 @@
+package igcserver
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+// Make sure that TrackMetasMap can be used as a storage backend for the server
+var _ TrackMetas = (*TrackMetasMap)(nil)
+
+// evictingTrackMetas is a storage which evicts track metas by itself, and
+// reports the evicted track metas to the server
+type evictingTrackMetas interface {
+	OnEvict(func(TrackMeta))
+}
+
+// Make sure that the server is told about track metas evicted by TrackMetasMap
+var _ evictingTrackMetas = (*TrackMetasMap)(nil)
+
+// TrackMetasMap contains a map to many TrackMeta objects which are protected
+// by a RWMutex and indexed by a unique id
+//
+// If the map has a capacity, the track meta which was inserted first is
+// evicted when a track meta is appended to a full map.
+type TrackMetasMap struct {
+	sync.RWMutex
+	data     map[TrackID]TrackMeta
+	capacity int
+	order    *list.List
+	elems    map[TrackID]*list.Element
+	onEvict  func(TrackMeta)
+}
+
+// NewTrackMetasMap creates a new mutex and mapping from ID to TrackMeta
+func NewTrackMetasMap() TrackMetasMap {
+	return NewTrackMetasMapWithCapacity(0)
+}
+
+// NewTrackMetasMapWithCapacity creates a new mapping from ID to TrackMeta
+// which keeps at most `capacity` track metas, where a capacity of 0 means
+// that the amount is unlimited
+func NewTrackMetasMapWithCapacity(capacity int) TrackMetasMap {
+	return TrackMetasMap{
+		sync.RWMutex{},
+		make(map[TrackID]TrackMeta),
+		capacity,
+		list.New(),
+		make(map[TrackID]*list.Element),
+		nil,
+	}
+}
+
+// OnEvict sets a function which is called with every track meta which is
+// evicted to make room for another. The function is called after the lock is
+// released, hence it may use the map.
+func (metas *TrackMetasMap) OnEvict(onEvict func(TrackMeta)) {
+	metas.Lock()
+	defer metas.Unlock()
+	metas.onEvict = onEvict
+}
+
+// evicted passes the evicted track metas to the eviction function, if any.
+// Must be called without holding the lock.
+func (metas *TrackMetasMap) evicted(evicted []TrackMeta) {
+	metas.RLock()
+	onEvict := metas.onEvict
+	metas.RUnlock()
+	if onEvict == nil {
+		return
+	}
+	for _, meta := range evicted {
+		onEvict(meta)
+	}
+}
+
+// Get fetches the track meta of a specific id if it exists
+func (metas *TrackMetasMap) Get(id TrackID) (meta TrackMeta, err error) {
+	metas.RLock()
+	defer metas.RUnlock()
+	meta, ok := metas.data[id]
+	if !ok {
+		err = ErrTrackNotFound
+	}
+	return
+}
+
+// Append appends a track meta and returns the given id
+func (metas *TrackMetasMap) Append(meta TrackMeta) (err error) {
+	metas.Lock()
+	if _, exists := metas.data[meta.ID]; exists {
+		metas.Unlock()
+		return ErrTrackAlreadyExists
+	}
+	evicted := metas.insert(meta, nil)
+	metas.Unlock()
+	metas.evicted(evicted)
+	return
+}
+
+// AppendMany appends all the track metas or none of them, while only locking
+// the storage once
+func (metas *TrackMetasMap) AppendMany(trackMetas []TrackMeta) ([]TrackID, error) {
+	ids, err := checkBatchDuplicates(trackMetas)
+	if err != nil {
+		return nil, err
+	}
+	metas.Lock()
+	for _, meta := range trackMetas {
+		if _, exists := metas.data[meta.ID]; exists {
+			metas.Unlock()
+			return nil, ErrTrackAlreadyExists
+		}
+	}
+	var evicted []TrackMeta
+	for _, meta := range trackMetas {
+		evicted = metas.insert(meta, evicted)
+	}
+	metas.Unlock()
+	metas.evicted(evicted)
+	return ids, nil
+}
+
+// insert stores the track meta, and evicts the track meta which was inserted
+// first if the map is full, appending it to `evicted`. Must be called while
+// holding the lock.
+func (metas *TrackMetasMap) insert(meta TrackMeta, evicted []TrackMeta) []TrackMeta {
+	if metas.capacity > 0 && len(metas.data) >= metas.capacity {
+		oldest := metas.order.Front().Value.(TrackID)
+		evicted = append(evicted, metas.data[oldest])
+		metas.remove(oldest)
+	}
+	metas.data[meta.ID] = meta
+	metas.elems[meta.ID] = metas.order.PushBack(meta.ID)
+	return evicted
+}
+
+// remove removes the track meta of the id from both the map and the insertion
+// order. Must be called while holding the lock.
+func (metas *TrackMetasMap) remove(id TrackID) {
+	delete(metas.data, id)
+	if elem, ok := metas.elems[id]; ok {
+		metas.order.Remove(elem)
+		delete(metas.elems, id)
+	}
+}
+
+// GetAllIDs fetches all the stored ids ordered by the time they were added
+func (metas *TrackMetasMap) GetAllIDs() (ids []TrackID, err error) {
+	return metas.Filter(func(TrackMeta) bool { return true })
+}
+
+// GetAll fetches all the stored track metas ordered by the time they were
+// added, while only locking the storage once
+func (metas *TrackMetasMap) GetAll() ([]TrackMeta, error) {
+	return metas.filterMetas(func(TrackMeta) bool { return true }), nil
+}
+
+// filterMetas copies all track metas matching the predicate, ordered by the
+// time they were added
+func (metas *TrackMetasMap) filterMetas(predicate func(TrackMeta) bool) []TrackMeta {
+	metas.RLock()
+	defer metas.RUnlock()
+	sorted := make([]TrackMeta, 0, len(metas.data))
+	for _, meta := range metas.data {
+		if predicate(meta) {
+			sorted = append(sorted, meta)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Timestamp.Equal(sorted[j].Timestamp) {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	return sorted
+}
+
+// Filter fetches the ids of all track metas matching the predicate, ordered by
+// the time they were added
+func (metas *TrackMetasMap) Filter(predicate func(TrackMeta) bool) (ids []TrackID, err error) {
+	sorted := metas.filterMetas(predicate)
+	ids = make([]TrackID, len(sorted))
+	for i, meta := range sorted {
+		ids[i] = meta.ID
+	}
+	return
+}
+
+// Delete removes the track meta of a specific id and returns the removed meta
+func (metas *TrackMetasMap) Delete(id TrackID) (meta TrackMeta, err error) {
+	metas.Lock()
+	defer metas.Unlock()
+	meta, ok := metas.data[id]
+	if ok {
+		metas.remove(id)
+	} else {
+		err = ErrTrackNotFound
+	}
+	return
+}
+
+// Clear removes all stored track metas and returns the amount removed
+func (metas *TrackMetasMap) Clear() (n int, err error) {
+	metas.Lock()
+	defer metas.Unlock()
+	n = len(metas.data)
+	metas.data = make(map[TrackID]TrackMeta)
+	metas.order.Init()
+	metas.elems = make(map[TrackID]*list.Element)
+	return
+}
+
+// Len returns the amount of stored track metas
+func (metas *TrackMetasMap) Len() (int, error) {
+	metas.RLock()
+	defer metas.RUnlock()
+	return len(metas.data), nil
+}
+
+// Update applies the update to the track meta of a specific id and returns
+// the updated meta
+func (metas *TrackMetasMap) Update(id TrackID, update func(*TrackMeta)) (meta TrackMeta, err error) {
+	metas.Lock()
+	defer metas.Unlock()
+	meta, ok := metas.data[id]
+	if ok {
+		update(&meta)
+		metas.data[id] = meta
+	} else {
+		err = ErrTrackNotFound
+	}
+	return
+}
+
+// Search finds the tracks matching the query, ordered by relevance
+func (metas *TrackMetasMap) Search(query string) ([]TrackMatch, error) {
+	all, err := metas.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return searchTrackMetas(all, query), nil
+}
+
+// FindByGliderID fetches the ids of all track metas with the exact glider id,
+// ordered by the time they were added
+func (metas *TrackMetasMap) FindByGliderID(gliderID string) ([]TrackID, error) {
+	return metas.Filter(func(meta TrackMeta) bool { return meta.GliderID == gliderID })
+}
+
+// FindByTag fetches the ids of all track metas with the exact tag, ordered by
+// the time they were added
+func (metas *TrackMetasMap) FindByTag(tag string) ([]TrackID, error) {
+	return metas.Filter(func(meta TrackMeta) bool { return hasTag(meta, tag) })
+}
+
+// GetAllSorted fetches all the stored ids ordered by the given field
+func (metas *TrackMetasMap) GetAllSorted(field string, desc bool) (ids []TrackID, err error) {
+	metas.RLock()
+	defer metas.RUnlock()
+	sorted := make([]TrackMeta, 0, len(metas.data))
+	for _, meta := range metas.data {
+		sorted = append(sorted, meta)
+	}
+	if err = sortTrackMetas(sorted, field, desc); err != nil {
+		return
+	}
+	ids = make([]TrackID, len(sorted))
+	for i, meta := range sorted {
+		ids[i] = meta.ID
+	}
+	return
+}
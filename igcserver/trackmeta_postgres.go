@@ -0,0 +1,411 @@
+package igcserver
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	// Also registers the postgres driver for `database/sql`
+	"github.com/lib/pq"
+)
+
+// postgresMigrations contains the sql needed to create the tables used by
+// TrackMetasPostgres, in the order they have to be applied
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS tracks (
+		id            BIGINT PRIMARY KEY,
+		timestamp     TIMESTAMPTZ NOT NULL,
+		h_date        TIMESTAMPTZ NOT NULL,
+		pilot         TEXT NOT NULL,
+		glider        TEXT NOT NULL,
+		glider_id     TEXT NOT NULL,
+		track_length  DOUBLE PRECISION NOT NULL,
+		track_src_url TEXT NOT NULL UNIQUE
+	)`,
+	`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS max_altitude_gain BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS duration BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks
+		ADD COLUMN IF NOT EXISTS bbox_min_lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bbox_min_lon DOUBLE PRECISION NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bbox_max_lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bbox_max_lon DOUBLE PRECISION NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS avg_speed DOUBLE PRECISION NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS tags TEXT[]`,
+	`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS src_window BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks DROP CONSTRAINT IF EXISTS tracks_track_src_url_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS tracks_src_key ON tracks (track_src_url, src_window)`,
+	`CREATE INDEX IF NOT EXISTS tracks_timestamp ON tracks (timestamp)`,
+}
+
+// postgresSortColumns maps the fields tracks can be sorted by to the column
+// containing the field
+var postgresSortColumns = map[string]string{
+	"track_length": "track_length",
+	"H_date":       "h_date",
+	"timestamp":    "timestamp",
+}
+
+// postgresTrackColumns contains the columns of the `tracks` table, in the same
+// order as the fields returned by postgresTrackFields
+var postgresTrackColumns = []string{
+	"id",
+	"timestamp",
+	"h_date",
+	"pilot",
+	"glider",
+	"glider_id",
+	"track_length",
+	"track_src_url",
+	"max_altitude_gain",
+	"duration",
+	"avg_speed",
+	"content_hash",
+	"bbox_min_lat",
+	"bbox_min_lon",
+	"bbox_max_lat",
+	"bbox_max_lon",
+	"tags",
+	"src_window",
+}
+
+// postgresTrackFields returns pointers to the fields of the track meta in the
+// same order as postgresTrackColumns
+func postgresTrackFields(meta *TrackMeta) []interface{} {
+	return []interface{}{
+		&meta.ID,
+		&meta.Timestamp,
+		&meta.Date,
+		&meta.Pilot,
+		&meta.Glider,
+		&meta.GliderID,
+		&meta.TrackLength,
+		&meta.TrackSrcURL,
+		&meta.MaxAltitudeGain,
+		&meta.Duration,
+		&meta.AvgSpeed,
+		&meta.ContentHash,
+		&meta.BBox.MinLat,
+		&meta.BBox.MinLon,
+		&meta.BBox.MaxLat,
+		&meta.BBox.MaxLon,
+		pq.Array(&meta.Tags),
+		&meta.SrcWindow,
+	}
+}
+
+var (
+	// postgresSelectColumns is the list of all columns of a track
+	postgresSelectColumns = strings.Join(postgresTrackColumns, ", ")
+
+	// postgresInsertValues is the list of placeholders for all columns of a
+	// track
+	postgresInsertValues string
+
+	// postgresUpdateValues sets all columns, except the id, of a track to
+	// their placeholders
+	postgresUpdateValues string
+)
+
+func init() {
+	placeholders := make([]string, len(postgresTrackColumns))
+	updates := make([]string, 0, len(postgresTrackColumns)-1)
+	for i, column := range postgresTrackColumns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		if column != "id" {
+			updates = append(updates, fmt.Sprintf("%s = $%d", column, i+1))
+		}
+	}
+	postgresInsertValues = strings.Join(placeholders, ", ")
+	postgresUpdateValues = strings.Join(updates, ", ")
+}
+
+// Make sure that TrackMetasPostgres can be used as a storage backend for the
+// server
+var _ TrackMetas = (*TrackMetasPostgres)(nil)
+
+// TrackMetasPostgres stores TrackMeta objects in the `tracks` table of a
+// PostgreSQL database
+type TrackMetasPostgres struct {
+	db *sql.DB
+}
+
+// NewTrackMetasPostgres creates a new PostgreSQL-aware storage of TrackMeta
+// using an already opened database
+func NewTrackMetasPostgres(db *sql.DB) TrackMetasPostgres {
+	return TrackMetasPostgres{
+		db,
+	}
+}
+
+// OpenTrackMetasPostgres connects to the PostgreSQL database given by the dsn
+// and applies the migrations needed to store track metas
+func OpenTrackMetasPostgres(dsn string) (metas TrackMetasPostgres, err error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return
+	}
+	metas = NewTrackMetasPostgres(db)
+	err = metas.Migrate()
+	return
+}
+
+// Migrate creates the tables used to store track metas if they don't exist
+func (metas *TrackMetasPostgres) Migrate() error {
+	for _, migration := range postgresMigrations {
+		if _, err := metas.db.Exec(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping checks that the database is reachable
+func (metas *TrackMetasPostgres) Ping() error {
+	return metas.db.Ping()
+}
+
+// scanTrackMeta scans a row containing all the columns of a track
+func scanTrackMeta(row interface {
+	Scan(dest ...interface{}) error
+}) (meta TrackMeta, err error) {
+	err = row.Scan(postgresTrackFields(&meta)...)
+	return
+}
+
+// queryTrackIDs fetches the ids of all the rows returned by the query
+func (metas *TrackMetasPostgres) queryTrackIDs(query string, args ...interface{}) (ids []TrackID, err error) {
+	rows, err := metas.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ids = make([]TrackID, 0)
+	for rows.Next() {
+		var id TrackID
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	return
+}
+
+// Get fetches the track meta of a specific id if it exists
+func (metas *TrackMetasPostgres) Get(id TrackID) (meta TrackMeta, err error) {
+	row := metas.db.QueryRow("SELECT "+postgresSelectColumns+" FROM tracks WHERE id = $1", id)
+	meta, err = scanTrackMeta(row)
+	if err == sql.ErrNoRows {
+		err = ErrTrackNotFound
+	}
+	return
+}
+
+// postgresInsertTrack inserts a track unless it conflicts with a stored track,
+// either by id or by source url, in which case nothing is inserted
+var postgresInsertTrack = "INSERT INTO tracks (" + postgresSelectColumns + ") VALUES (" + postgresInsertValues + ") " +
+	"ON CONFLICT DO NOTHING"
+
+// Append appends a track meta, and rejects the track meta if a track with the
+// same id or source url already exists
+func (metas *TrackMetasPostgres) Append(meta TrackMeta) (err error) {
+	res, err := metas.db.Exec(postgresInsertTrack, postgresTrackFields(&meta)...)
+	if err != nil {
+		return
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrTrackAlreadyExists
+	}
+	return
+}
+
+// AppendMany appends all the track metas within a single transaction, which is
+// rolled back if any of them fails
+func (metas *TrackMetasPostgres) AppendMany(trackMetas []TrackMeta) (ids []TrackID, err error) {
+	ids, err = checkBatchDuplicates(trackMetas)
+	if err != nil {
+		return
+	}
+
+	tx, err := metas.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			ids = nil
+		}
+	}()
+
+	for _, meta := range trackMetas {
+		var res sql.Result
+		res, err = tx.Exec(postgresInsertTrack, postgresTrackFields(&meta)...)
+		if err != nil {
+			return
+		}
+		var n int64
+		if n, err = res.RowsAffected(); err != nil {
+			return
+		}
+		if n == 0 {
+			err = ErrTrackAlreadyExists
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
+
+// GetAllIDs fetches all the stored ids ordered by the time they were added
+func (metas *TrackMetasPostgres) GetAllIDs() ([]TrackID, error) {
+	return metas.queryTrackIDs("SELECT id FROM tracks ORDER BY timestamp, id")
+}
+
+// GetAll fetches all the stored track metas ordered by the time they were
+// added
+func (metas *TrackMetasPostgres) GetAll() (all []TrackMeta, err error) {
+	rows, err := metas.db.Query("SELECT " + postgresSelectColumns + " FROM tracks ORDER BY timestamp, id")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	all = make([]TrackMeta, 0)
+	for rows.Next() {
+		var meta TrackMeta
+		if meta, err = scanTrackMeta(rows); err != nil {
+			return
+		}
+		all = append(all, meta)
+	}
+	err = rows.Err()
+	return
+}
+
+// Delete removes the track meta of a specific id and returns the removed meta
+func (metas *TrackMetasPostgres) Delete(id TrackID) (meta TrackMeta, err error) {
+	row := metas.db.QueryRow("DELETE FROM tracks WHERE id = $1 RETURNING "+postgresSelectColumns, id)
+	meta, err = scanTrackMeta(row)
+	if err == sql.ErrNoRows {
+		err = ErrTrackNotFound
+	}
+	return
+}
+
+// Clear removes all stored track metas and returns the amount removed
+func (metas *TrackMetasPostgres) Clear() (n int, err error) {
+	res, err := metas.db.Exec("DELETE FROM tracks")
+	if err != nil {
+		return
+	}
+	removed, err := res.RowsAffected()
+	n = int(removed)
+	return
+}
+
+// Len returns the amount of stored track metas
+func (metas *TrackMetasPostgres) Len() (n int, err error) {
+	err = metas.db.QueryRow("SELECT COUNT(*) FROM tracks").Scan(&n)
+	return
+}
+
+// Latest returns the latest timestamp of the stored track metas, which is nil
+// if there are no track metas
+func (metas *TrackMetasPostgres) Latest() (*time.Time, error) {
+	var latest pq.NullTime
+	if err := metas.db.QueryRow("SELECT MAX(timestamp) FROM tracks").Scan(&latest); err != nil {
+		return nil, err
+	}
+	if !latest.Valid {
+		return nil, nil
+	}
+	return &latest.Time, nil
+}
+
+// Update applies the update to the track meta of a specific id and returns
+// the updated meta
+func (metas *TrackMetasPostgres) Update(id TrackID, update func(*TrackMeta)) (meta TrackMeta, err error) {
+	tx, err := metas.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	row := tx.QueryRow("SELECT "+postgresSelectColumns+" FROM tracks WHERE id = $1 FOR UPDATE", id)
+	meta, err = scanTrackMeta(row)
+	if err == sql.ErrNoRows {
+		err = ErrTrackNotFound
+		return
+	} else if err != nil {
+		return
+	}
+
+	update(&meta)
+	_, err = tx.Exec(
+		"UPDATE tracks SET "+postgresUpdateValues+" WHERE id = $1",
+		postgresTrackFields(&meta)...,
+	)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// Filter fetches the ids of all track metas matching the predicate, ordered by
+// the time they were added
+func (metas *TrackMetasPostgres) Filter(predicate func(TrackMeta) bool) (ids []TrackID, err error) {
+	all, err := metas.GetAll()
+	if err != nil {
+		return
+	}
+	ids = make([]TrackID, 0)
+	for _, meta := range all {
+		if predicate(meta) {
+			ids = append(ids, meta.ID)
+		}
+	}
+	return
+}
+
+// GetAllSorted fetches all the stored ids ordered by the given field
+func (metas *TrackMetasPostgres) GetAllSorted(field string, desc bool) ([]TrackID, error) {
+	column, ok := postgresSortColumns[field]
+	if !ok {
+		return nil, ErrInvalidSortField
+	}
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+	return metas.queryTrackIDs(fmt.Sprintf("SELECT id FROM tracks ORDER BY %s %s, id", column, order))
+}
+
+// Search finds the tracks matching the query, ordered by relevance
+func (metas *TrackMetasPostgres) Search(query string) ([]TrackMatch, error) {
+	all, err := metas.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return searchTrackMetas(all, query), nil
+}
+
+// FindByGliderID fetches the ids of all track metas with the exact glider id,
+// ordered by the time they were added
+func (metas *TrackMetasPostgres) FindByGliderID(gliderID string) ([]TrackID, error) {
+	return metas.queryTrackIDs("SELECT id FROM tracks WHERE glider_id = $1 ORDER BY timestamp, id", gliderID)
+}
+
+// FindByTag fetches the ids of all track metas with the exact tag, ordered by
+// the time they were added
+func (metas *TrackMetasPostgres) FindByTag(tag string) ([]TrackID, error) {
+	return metas.queryTrackIDs("SELECT id FROM tracks WHERE $1 = ANY(tags) ORDER BY timestamp, id", tag)
+}
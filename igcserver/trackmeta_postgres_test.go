@@ -0,0 +1,283 @@
+package igcserver
+
+import (
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/go-cmp/cmp"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Convenience function to create a postgres storage backed by a mock database
+func makeMockTrackMetasPostgres(t *testing.T) (TrackMetasPostgres, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create mock database: %s", err)
+	}
+	return NewTrackMetasPostgres(db), mock
+}
+
+// Convenience function to convert a track meta into a row of the mock database
+func makePostgresTrackRow(meta TrackMeta) []driver.Value {
+	fields := postgresTrackFields(&meta)
+	values := make([]driver.Value, len(fields))
+	for i, field := range fields {
+		values[i], _ = driver.DefaultParameterConverter.ConvertValue(field)
+	}
+	return values
+}
+
+// Test that all queries of the storage are expected
+func assertExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled database expectations: %s", err)
+	}
+}
+
+// Test that appended track metas are inserted and that duplicates are rejected
+func TestTrackMetasPostgresAppend(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	meta := makeIGCTestData("localhost")[0]
+	insert := "^" + regexp.QuoteMeta(postgresInsertTrack) + "$"
+
+	mock.ExpectExec(insert).
+		WithArgs(makePostgresTrackRow(meta)...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := metas.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	// Nothing is inserted on conflict with an existing source url
+	mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := metas.Append(meta); err != ErrTrackAlreadyExists {
+		t.Fatalf("expected duplicate track to be rejected, got '%v'", err)
+	}
+
+	// The same goes for a conflict with the id of another track, such that a
+	// concurrent insert is probed again by appendTrackMeta
+	other := meta
+	other.TrackSrcURL += "/other"
+	mock.ExpectExec(insert).
+		WithArgs(makePostgresTrackRow(other)...).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := metas.Append(other); err != ErrTrackAlreadyExists {
+		t.Fatalf("expected track with taken id to be rejected, got '%v'", err)
+	}
+	if !strings.HasSuffix(postgresInsertTrack, "ON CONFLICT DO NOTHING") {
+		t.Errorf("expected insert to ignore conflicts on any constraint, got '%s'", postgresInsertTrack)
+	}
+}
+
+// Test that a batch is appended within a transaction, which is rolled back if
+// a track in the middle of the batch is rejected
+func TestTrackMetasPostgresAppendMany(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	testTrackMetas := makeIGCTestData("localhost")
+	insert := "^" + regexp.QuoteMeta(postgresInsertTrack) + "$"
+
+	mock.ExpectBegin()
+	for _, meta := range testTrackMetas {
+		mock.ExpectExec(insert).
+			WithArgs(makePostgresTrackRow(meta)...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+	ids, err := metas.AppendMany(testTrackMetas)
+	if err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+	if len(ids) != 2 || ids[0] != testTrackMetas[0].ID || ids[1] != testTrackMetas[1].ID {
+		t.Errorf("expected appended ids to be the ids of the batch, got '%d'", ids)
+	}
+
+	// Nothing is inserted on conflict with an existing source url
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if ids, err := metas.AppendMany(testTrackMetas); err != ErrTrackAlreadyExists || ids != nil {
+		t.Fatalf("expected batch with duplicate track to be rejected, got '%v' and '%v'", ids, err)
+	}
+
+	// Duplicates within the batch are rejected before the database is used
+	if _, err := metas.AppendMany([]TrackMeta{testTrackMetas[0], testTrackMetas[0]}); err != ErrTrackAlreadyExists {
+		t.Fatalf("expected batch with the same track twice to be rejected, got '%v'", err)
+	}
+}
+
+// Test that stored track metas are found and that unknown ids are rejected
+func TestTrackMetasPostgresGet(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	meta := makeIGCTestData("localhost")[0]
+	query := regexp.QuoteMeta("SELECT " + postgresSelectColumns + " FROM tracks WHERE id = $1")
+
+	mock.ExpectQuery(query).
+		WithArgs(meta.ID).
+		WillReturnRows(sqlmock.NewRows(postgresTrackColumns).AddRow(makePostgresTrackRow(meta)...))
+	got, err := metas.Get(meta.ID)
+	if err != nil {
+		t.Fatalf("unable to get metadata: %s", err)
+	}
+	if got.ID != meta.ID || got.Pilot != meta.Pilot || got.TrackSrcURL != meta.TrackSrcURL {
+		t.Errorf("returned track was not equal to stored track, expected '%v' but got '%v'", meta, got)
+	}
+
+	mock.ExpectQuery(query).WithArgs(TrackID(1232)).WillReturnRows(sqlmock.NewRows(postgresTrackColumns))
+	if _, err := metas.Get(1232); err != ErrTrackNotFound {
+		t.Fatalf("expected unknown id to be missing, got '%v'", err)
+	}
+}
+
+// Test that ids are fetched in order and that deleting unknown ids fails
+func TestTrackMetasPostgresGetAllIDsAndDelete(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tracks ORDER BY timestamp, id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(1))
+	ids, err := metas.GetAllIDs()
+	if err != nil {
+		t.Fatalf("unable to get all ids: %s", err)
+	}
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 1 {
+		t.Errorf("expected ids to be '[2 1]', got '%d'", ids)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tracks ORDER BY track_length DESC, id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if _, err := metas.GetAllSorted("track_length", true); err != nil {
+		t.Fatalf("unable to get sorted ids: %s", err)
+	}
+	if _, err := metas.GetAllSorted("pilot", true); err != ErrInvalidSortField {
+		t.Errorf("expected sorting by an unknown field to fail, got '%v'", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM tracks WHERE id = $1")).
+		WithArgs(TrackID(1232)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if _, err := metas.Delete(1232); err != ErrTrackNotFound {
+		t.Fatalf("expected deleting unknown id to fail, got '%v'", err)
+	}
+}
+
+// Test that the latest timestamp is queried from the database, and that no
+// tracks give no latest timestamp
+func TestTrackMetasPostgresLatest(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	query := regexp.QuoteMeta("SELECT MAX(timestamp) FROM tracks")
+	stamp := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(stamp))
+	if latest, err := metas.Latest(); err != nil || latest == nil || !latest.Equal(stamp) {
+		t.Errorf("expected latest timestamp to be '%s', got '%v' and '%v'", stamp, latest, err)
+	}
+
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	if latest, err := metas.Latest(); err != nil || latest != nil {
+		t.Errorf("expected no latest timestamp without tracks, got '%v' and '%v'", latest, err)
+	}
+}
+
+// Test that tracks are found by their glider id in the query
+func TestTrackMetasPostgresFindByGliderID(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tracks WHERE glider_id = $1 ORDER BY timestamp, id")).
+		WithArgs("MGI2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1232))
+	ids, err := metas.FindByGliderID("MGI2")
+	if err != nil {
+		t.Fatalf("unable to find tracks by glider id: %s", err)
+	}
+	if len(ids) != 1 || ids[0] != 1232 {
+		t.Errorf("expected ids to be '[1232]', got '%d'", ids)
+	}
+}
+
+// Test that tracks are found by their tags within the database
+func TestTrackMetasPostgresFindByTag(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tracks WHERE $1 = ANY(tags) ORDER BY timestamp, id")).
+		WithArgs("xc").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1232))
+	ids, err := metas.FindByTag("xc")
+	if err != nil {
+		t.Fatalf("unable to find tracks by tag: %s", err)
+	}
+	if len(ids) != 1 || ids[0] != 1232 {
+		t.Errorf("expected ids to be '[1232]', got '%d'", ids)
+	}
+}
+
+// Test that all track metas are fetched using a single query
+func TestTrackMetasPostgresGetAll(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	rows := sqlmock.NewRows(postgresTrackColumns)
+	testTrackMetas := makeIGCTestData("localhost")
+	for i := range testTrackMetas {
+		testTrackMetas[i].Timestamp = time.Date(2018, 10, 15, 12, i, 0, 0, time.UTC)
+		testTrackMetas[i].Date = testTrackMetas[i].Timestamp
+		rows.AddRow(makePostgresTrackRow(testTrackMetas[i])...)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + postgresSelectColumns + " FROM tracks ORDER BY timestamp, id")).
+		WillReturnRows(rows)
+
+	all, err := metas.GetAll()
+	if err != nil {
+		t.Fatalf("unable to get all metadata: %s", err)
+	}
+	if !cmp.Equal(all, testTrackMetas) {
+		t.Errorf("expected all track metas to be '%v', got '%v'", testTrackMetas, all)
+	}
+}
+
+// Test that updates are applied within a transaction
+func TestTrackMetasPostgresUpdate(t *testing.T) {
+	metas, mock := makeMockTrackMetasPostgres(t)
+	defer assertExpectations(t, mock)
+
+	meta := makeIGCTestData("localhost")[0]
+	meta.Timestamp = time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE")).
+		WithArgs(meta.ID).
+		WillReturnRows(sqlmock.NewRows(postgresTrackColumns).AddRow(makePostgresTrackRow(meta)...))
+	updated := meta
+	updated.Pilot = "Jasmine"
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE tracks SET")).
+		WithArgs(makePostgresTrackRow(updated)...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	got, err := metas.Update(meta.ID, func(meta *TrackMeta) {
+		meta.Pilot = "Jasmine"
+	})
+	if err != nil {
+		t.Fatalf("unable to update metadata: %s", err)
+	}
+	if got.Pilot != "Jasmine" {
+		t.Errorf("expected updated pilot to be 'Jasmine', got '%s'", got.Pilot)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE")).WillReturnRows(sqlmock.NewRows(postgresTrackColumns))
+	mock.ExpectRollback()
+	if _, err := metas.Update(1232, func(*TrackMeta) {}); err != ErrTrackNotFound {
+		t.Fatalf("expected updating unknown id to fail, got '%v'", err)
+	}
+}
@@ -0,0 +1,527 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	// redisTrackKeyPrefix is the prefix of the keys of the hashes containing
+	// the fields of each track, which are followed by the id of the track
+	redisTrackKeyPrefix = "paragliding:track:"
+
+	// redisTrackIDsKey is the key of the set of the ids of all tracks
+	redisTrackIDsKey = "paragliding:track_ids"
+
+	// redisTrackURLsKey is the key of the hash which maps the source url of
+	// each track, along with the duplicate window it was registered in, to
+	// its id
+	redisTrackURLsKey = "paragliding:track_urls"
+
+	// redisTrackTimestampsKey is the key of the sorted set of the ids of all
+	// tracks, which are scored by the timestamp of each track in microseconds
+	redisTrackTimestampsKey = "paragliding:track_timestamps"
+
+	// redisMaxTxRetries is the maximum amount of times an optimistic
+	// transaction is retried when the watched keys were changed by another
+	// client
+	redisMaxTxRetries = 16
+)
+
+// redisAppendScript appends one or more tracks, but only if none of the ids or
+// source urls are taken. KEYS contains the set of ids, the url index and the
+// sorted set of timestamps, followed by the key of each track, while ARGV
+// contains the id, the key of the source url as given by trackSrcKey, the
+// score of the timestamp and the amount of fields of each track, followed by
+// its fields and values.
+var redisAppendScript = redis.NewScript(`
+local tracks = {}
+local pos = 1
+for i = 4, #KEYS do
+	local id, url, score, n = ARGV[pos], ARGV[pos + 1], ARGV[pos + 2], tonumber(ARGV[pos + 3])
+	if redis.call('EXISTS', KEYS[i]) == 1 or redis.call('HEXISTS', KEYS[2], url) == 1 then
+		return 0
+	end
+	tracks[#tracks + 1] = {KEYS[i], id, url, score, pos + 4, n}
+	pos = pos + 4 + 2 * n
+end
+for _, track in ipairs(tracks) do
+	local key, id, url, score, first, n = unpack(track)
+	for j = first, first + 2 * n - 1, 2 do
+		redis.call('HSET', key, ARGV[j], ARGV[j + 1])
+	end
+	redis.call('SADD', KEYS[1], id)
+	redis.call('HSET', KEYS[2], url, id)
+	redis.call('ZADD', KEYS[3], score, id)
+end
+return 1
+`)
+
+// redisDeleteScript removes the track with the key KEYS[4] and id ARGV[1] from
+// the set of ids KEYS[1], the url index KEYS[2] and the sorted set of
+// timestamps KEYS[3], and returns the fields of the removed track. The url
+// index is keyed in the same way as trackSrcKey.
+var redisDeleteScript = redis.NewScript(`
+local fields = redis.call('HGETALL', KEYS[4])
+if #fields == 0 then
+	return fields
+end
+local url, window = nil, '0'
+for i = 1, #fields, 2 do
+	if fields[i] == 'track_src_url' then
+		url = fields[i + 1]
+	elseif fields[i] == 'src_window' then
+		window = fields[i + 1]
+	end
+end
+if url ~= nil then
+	if window ~= '0' then
+		url = url .. '\0' .. window
+	end
+	redis.call('HDEL', KEYS[2], url)
+end
+redis.call('DEL', KEYS[4])
+redis.call('SREM', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+return fields
+`)
+
+// redisClearScript removes all tracks in the set of ids KEYS[1], where ARGV[1]
+// is the prefix of the keys of the tracks, along with the url index KEYS[2]
+// and the sorted set of timestamps KEYS[3], and returns the amount of removed
+// tracks
+var redisClearScript = redis.NewScript(`
+local ids = redis.call('SMEMBERS', KEYS[1])
+for _, id in ipairs(ids) do
+	redis.call('DEL', ARGV[1] .. id)
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+return #ids
+`)
+
+// redisTrackKey returns the key of the hash containing the fields of the track
+func redisTrackKey(id TrackID) string {
+	return redisTrackKeyPrefix + strconv.FormatUint(uint64(id), 10)
+}
+
+// redisTimestampScore returns the score of the timestamp of a track in the
+// sorted set of timestamps. Microseconds are used as they fit exactly in the
+// float of the score, unlike nanoseconds, which also overflow for the zero
+// time.
+func redisTimestampScore(timestamp time.Time) float64 {
+	return float64(timestamp.Unix()*1e6 + int64(timestamp.Nanosecond()/1e3))
+}
+
+// redisTrackFields returns pointers to the fields of the track meta, keyed by
+// the name of the field in the hash of the track
+func redisTrackFields(meta *TrackMeta) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                &meta.ID,
+		"timestamp":         &meta.Timestamp,
+		"H_date":            &meta.Date,
+		"pilot":             &meta.Pilot,
+		"glider":            &meta.Glider,
+		"glider_id":         &meta.GliderID,
+		"track_length":      &meta.TrackLength,
+		"track_src_url":     &meta.TrackSrcURL,
+		"max_altitude_gain": &meta.MaxAltitudeGain,
+		"duration":          &meta.Duration,
+		"avg_speed":         &meta.AvgSpeed,
+		"content_hash":      &meta.ContentHash,
+		"bbox_min_lat":      &meta.BBox.MinLat,
+		"bbox_min_lon":      &meta.BBox.MinLon,
+		"bbox_max_lat":      &meta.BBox.MaxLat,
+		"bbox_max_lon":      &meta.BBox.MaxLon,
+		"tags":              &meta.Tags,
+		"src_window":        &meta.SrcWindow,
+	}
+}
+
+// encodeRedisTrack converts the track meta into the fields of its hash
+func encodeRedisTrack(meta TrackMeta) map[string]interface{} {
+	hash := make(map[string]interface{})
+	for name, field := range redisTrackFields(&meta) {
+		switch v := field.(type) {
+		case *TrackID:
+			hash[name] = strconv.FormatUint(uint64(*v), 10)
+		case *time.Time:
+			hash[name] = v.Format(time.RFC3339Nano)
+		case *string:
+			hash[name] = *v
+		case *int64:
+			hash[name] = strconv.FormatInt(*v, 10)
+		case *float64:
+			hash[name] = strconv.FormatFloat(*v, 'g', -1, 64)
+		case *[]string:
+			tags, _ := json.Marshal(*v)
+			hash[name] = string(tags)
+		}
+	}
+	return hash
+}
+
+// decodeRedisTrack converts the fields of the hash of a track into a track
+// meta, where missing fields are left as their zero value
+func decodeRedisTrack(hash map[string]string) (meta TrackMeta, err error) {
+	for name, field := range redisTrackFields(&meta) {
+		value, ok := hash[name]
+		if !ok {
+			continue
+		}
+		switch v := field.(type) {
+		case *TrackID:
+			var id uint64
+			id, err = strconv.ParseUint(value, 10, 32)
+			*v = TrackID(id)
+		case *time.Time:
+			*v, err = time.Parse(time.RFC3339Nano, value)
+		case *string:
+			*v = value
+		case *int64:
+			*v, err = strconv.ParseInt(value, 10, 64)
+		case *float64:
+			*v, err = strconv.ParseFloat(value, 64)
+		case *[]string:
+			err = json.Unmarshal([]byte(value), v)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid field '%s' of track: %s", name, err)
+			return
+		}
+	}
+	return
+}
+
+// getRedisTrack fetches and decodes the hash of the track with the given key
+func getRedisTrack(c redis.Cmdable, key string) (TrackMeta, error) {
+	hash, err := c.HGetAll(key).Result()
+	if err != nil {
+		return TrackMeta{}, err
+	}
+	if len(hash) == 0 {
+		return TrackMeta{}, ErrTrackNotFound
+	}
+	return decodeRedisTrack(hash)
+}
+
+// Make sure that TrackMetasRedis can be used as a storage backend for the
+// server
+var _ TrackMetas = (*TrackMetasRedis)(nil)
+
+// TrackMetasRedis stores TrackMeta objects in Redis, such that several
+// instances of the server can share the same tracks
+//
+// Each track is stored as a hash keyed by its id, while a set contains the ids
+// of all tracks and a hash maps the source url of each track to its id.
+// Tracks are appended and removed using scripts, which keeps the keys
+// consistent and rejects duplicates even if several instances append the same
+// track at once.
+type TrackMetasRedis struct {
+	client *redis.Client
+}
+
+// NewTrackMetasRedis creates a new Redis-aware storage of TrackMeta using an
+// already created client
+func NewTrackMetasRedis(client *redis.Client) TrackMetasRedis {
+	return TrackMetasRedis{
+		client,
+	}
+}
+
+// OpenTrackMetasRedis connects to the Redis server given by the url, eg.
+// `redis://:password@localhost:6379/0`, and checks that it is reachable
+func OpenTrackMetasRedis(url string) (metas TrackMetasRedis, err error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return
+	}
+	metas = NewTrackMetasRedis(redis.NewClient(opts))
+	err = metas.Ping()
+	return
+}
+
+// Ping checks that the Redis server is reachable
+func (metas *TrackMetasRedis) Ping() error {
+	return metas.client.Ping().Err()
+}
+
+// Get fetches the track meta of a specific id if it exists
+func (metas *TrackMetasRedis) Get(id TrackID) (TrackMeta, error) {
+	return getRedisTrack(metas.client, redisTrackKey(id))
+}
+
+// Append appends a track meta, and rejects the track meta if a track with the
+// same id or source url already exists
+func (metas *TrackMetasRedis) Append(meta TrackMeta) error {
+	return metas.appendTracks([]TrackMeta{meta})
+}
+
+// AppendMany appends all the track metas or none of them, as they are
+// appended by a single script
+func (metas *TrackMetasRedis) AppendMany(trackMetas []TrackMeta) ([]TrackID, error) {
+	ids, err := checkBatchDuplicates(trackMetas)
+	if err != nil {
+		return nil, err
+	}
+	if err := metas.appendTracks(trackMetas); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// appendTracks runs redisAppendScript with the given track metas
+func (metas *TrackMetasRedis) appendTracks(trackMetas []TrackMeta) error {
+	keys := []string{redisTrackIDsKey, redisTrackURLsKey, redisTrackTimestampsKey}
+	var args []interface{}
+	for _, meta := range trackMetas {
+		hash := encodeRedisTrack(meta)
+		keys = append(keys, redisTrackKey(meta.ID))
+		args = append(args, hash["id"], trackSrcKey(meta.TrackSrcURL, meta.SrcWindow), redisTimestampScore(meta.Timestamp), len(hash))
+		for name, value := range hash {
+			args = append(args, name, value)
+		}
+	}
+	appended, err := redisAppendScript.Run(metas.client, keys, args...).Int64()
+	if err != nil {
+		return err
+	}
+	if appended == 0 {
+		return ErrTrackAlreadyExists
+	}
+	return nil
+}
+
+// GetAllIDs fetches all the stored ids ordered by the time they were added
+func (metas *TrackMetasRedis) GetAllIDs() ([]TrackID, error) {
+	all, err := metas.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]TrackID, len(all))
+	for i, meta := range all {
+		ids[i] = meta.ID
+	}
+	return ids, nil
+}
+
+// GetAll fetches all the stored track metas ordered by the time they were
+// added
+func (metas *TrackMetasRedis) GetAll() ([]TrackMeta, error) {
+	ids, err := metas.client.SMembers(redisTrackIDsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	_, err = metas.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(redisTrackKeyPrefix + id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]TrackMeta, 0, len(ids))
+	for _, cmd := range cmds {
+		// Tracks removed since the ids were fetched are skipped
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		meta, err := decodeRedisTrack(cmd.Val())
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, meta)
+	}
+	if err := sortTrackMetas(all, "timestamp", false); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// Delete removes the track meta of a specific id and returns the removed meta
+func (metas *TrackMetasRedis) Delete(id TrackID) (TrackMeta, error) {
+	keys := []string{redisTrackIDsKey, redisTrackURLsKey, redisTrackTimestampsKey, redisTrackKey(id)}
+	res, err := redisDeleteScript.Run(metas.client, keys, uint64(id)).Result()
+	if err != nil {
+		return TrackMeta{}, err
+	}
+	fields, _ := res.([]interface{})
+	if len(fields) == 0 {
+		return TrackMeta{}, ErrTrackNotFound
+	}
+	hash := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, _ := fields[i].(string)
+		hash[name], _ = fields[i+1].(string)
+	}
+	return decodeRedisTrack(hash)
+}
+
+// Clear removes all stored track metas and returns the amount removed
+func (metas *TrackMetasRedis) Clear() (int, error) {
+	keys := []string{redisTrackIDsKey, redisTrackURLsKey, redisTrackTimestampsKey}
+	n, err := redisClearScript.Run(metas.client, keys, redisTrackKeyPrefix).Int64()
+	return int(n), err
+}
+
+// Len returns the amount of stored track metas
+func (metas *TrackMetasRedis) Len() (int, error) {
+	n, err := metas.client.SCard(redisTrackIDsKey).Result()
+	return int(n), err
+}
+
+// Latest returns the latest timestamp of the stored track metas, which is nil
+// if there are no track metas. Tracks with the same score are all fetched, as
+// their timestamps may differ by less than a microsecond.
+func (metas *TrackMetasRedis) Latest() (*time.Time, error) {
+	top, err := metas.client.ZRevRangeWithScores(redisTrackTimestampsKey, 0, 0).Result()
+	if err != nil || len(top) == 0 {
+		return nil, err
+	}
+	score := strconv.FormatFloat(top[0].Score, 'f', -1, 64)
+	ids, err := metas.client.ZRangeByScore(redisTrackTimestampsKey, redis.ZRangeBy{
+		Min: score,
+		Max: score,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringCmd, len(ids))
+	_, err = metas.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGet(redisTrackKeyPrefix+id, "timestamp")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var latest *time.Time
+	for _, cmd := range cmds {
+		// Tracks removed since the ids were fetched are skipped
+		if cmd.Err() == redis.Nil {
+			continue
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, cmd.Val())
+		if err != nil {
+			return nil, fmt.Errorf("invalid field 'timestamp' of track: %s", err)
+		}
+		if latest == nil || timestamp.After(*latest) {
+			latest = &timestamp
+		}
+	}
+	return latest, nil
+}
+
+// Update applies the update to the track meta of a specific id and returns
+// the updated meta. The update is retried if the track or the url index is
+// changed by another client meanwhile, and changing the source url to the url
+// of another track is rejected with ErrTrackAlreadyExists.
+func (metas *TrackMetasRedis) Update(id TrackID, update func(*TrackMeta)) (meta TrackMeta, err error) {
+	key := redisTrackKey(id)
+	for i := 0; i < redisMaxTxRetries; i++ {
+		err = metas.client.Watch(func(tx *redis.Tx) error {
+			var err error
+			meta, err = getRedisTrack(tx, key)
+			if err != nil {
+				return err
+			}
+			srcKey := trackSrcKey(meta.TrackSrcURL, meta.SrcWindow)
+			update(&meta)
+			newSrcKey := trackSrcKey(meta.TrackSrcURL, meta.SrcWindow)
+			if newSrcKey != srcKey {
+				taken, err := tx.HExists(redisTrackURLsKey, newSrcKey).Result()
+				if err != nil {
+					return err
+				} else if taken {
+					return ErrTrackAlreadyExists
+				}
+			}
+			_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+				pipe.HMSet(key, encodeRedisTrack(meta))
+				pipe.ZAdd(redisTrackTimestampsKey, redis.Z{
+					Score:  redisTimestampScore(meta.Timestamp),
+					Member: uint64(meta.ID),
+				})
+				if newSrcKey != srcKey {
+					pipe.HDel(redisTrackURLsKey, srcKey)
+					pipe.HSet(redisTrackURLsKey, newSrcKey, uint64(meta.ID))
+				}
+				return nil
+			})
+			return err
+		}, key, redisTrackURLsKey)
+		if err != redis.TxFailedErr {
+			return
+		}
+	}
+	return
+}
+
+// Filter fetches the ids of all track metas matching the predicate, ordered by
+// the time they were added
+func (metas *TrackMetasRedis) Filter(predicate func(TrackMeta) bool) ([]TrackID, error) {
+	all, err := metas.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]TrackID, 0)
+	for _, meta := range all {
+		if predicate(meta) {
+			ids = append(ids, meta.ID)
+		}
+	}
+	return ids, nil
+}
+
+// GetAllSorted fetches all the stored ids ordered by the given field
+func (metas *TrackMetasRedis) GetAllSorted(field string, desc bool) ([]TrackID, error) {
+	if _, ok := trackSortFields[field]; !ok {
+		return nil, ErrInvalidSortField
+	}
+	all, err := metas.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if err := sortTrackMetas(all, field, desc); err != nil {
+		return nil, err
+	}
+	ids := make([]TrackID, len(all))
+	for i, meta := range all {
+		ids[i] = meta.ID
+	}
+	return ids, nil
+}
+
+// Search finds the tracks matching the query, ordered by relevance
+func (metas *TrackMetasRedis) Search(query string) ([]TrackMatch, error) {
+	all, err := metas.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return searchTrackMetas(all, query), nil
+}
+
+// FindByGliderID fetches the ids of all track metas with the exact glider id,
+// ordered by the time they were added
+func (metas *TrackMetasRedis) FindByGliderID(gliderID string) ([]TrackID, error) {
+	return metas.Filter(func(meta TrackMeta) bool {
+		return meta.GliderID == gliderID
+	})
+}
+
+// FindByTag fetches the ids of all track metas with the exact tag, ordered by
+// the time they were added
+func (metas *TrackMetasRedis) FindByTag(tag string) ([]TrackID, error) {
+	return metas.Filter(func(meta TrackMeta) bool {
+		return hasTag(meta, tag)
+	})
+}
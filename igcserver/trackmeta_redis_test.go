@@ -0,0 +1,403 @@
+package igcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Convenience function to create a redis storage backed by an in-memory redis
+// server, which has to be closed when the test is done
+func makeMiniTrackMetasRedis(t *testing.T) (TrackMetasRedis, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unable to start in-memory redis server: %s", err)
+	}
+	return NewTrackMetasRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()})), mr
+}
+
+// Test that appended track metas are stored as is and that duplicates by id
+// or url are rejected
+func TestTrackMetasRedisAppend(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	for _, meta := range testTrackMetas {
+		got, err := metas.Get(meta.ID)
+		if err != nil {
+			t.Fatalf("unable to get metadata: %s", err)
+		}
+		if !cmp.Equal(got, meta) {
+			t.Errorf("unexpected metadata of '%d', expected '%v' but got '%v'", meta.ID, meta, got)
+		}
+	}
+	if _, err := metas.Get(TrackID(1)); err != ErrTrackNotFound {
+		t.Errorf("expected unknown id to give ErrTrackNotFound, got '%v'", err)
+	}
+
+	aladin := testTrackMetas[0]
+	for _, dup := range []TrackMeta{
+		{ID: aladin.ID, TrackSrcURL: "http://a.com/fresh.igc"},
+		{ID: 1232, TrackSrcURL: aladin.TrackSrcURL},
+	} {
+		if err := metas.Append(dup); err != ErrTrackAlreadyExists {
+			t.Errorf("expected duplicate '%v' to be rejected, got '%v'", dup, err)
+		}
+	}
+	if n, _ := metas.Len(); n != len(testTrackMetas) {
+		t.Errorf("expected '%d' tracks, got '%d'", len(testTrackMetas), n)
+	}
+
+	// A track is stored as a hash keyed by its id
+	if pilot := mr.HGet(redisTrackKey(aladin.ID), "pilot"); pilot != aladin.Pilot {
+		t.Errorf("expected pilot '%s' in hash of track, got '%s'", aladin.Pilot, pilot)
+	}
+}
+
+// Test that several servers sharing the same redis server only accept one of
+// several tracks with the same url appended at once
+func TestTrackMetasRedisAppendShared(t *testing.T) {
+	_, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	const instances = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			metas := NewTrackMetasRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+			errs <- metas.Append(TrackMeta{ID: TrackID(i), TrackSrcURL: "http://a.com/same.igc"})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	appended := 0
+	for err := range errs {
+		if err == nil {
+			appended++
+		} else if err != ErrTrackAlreadyExists {
+			t.Errorf("expected duplicate to be rejected, got '%v'", err)
+		}
+	}
+	if appended != 1 {
+		t.Errorf("expected exactly one track to be appended, got '%d'", appended)
+	}
+}
+
+// Test that a batch is appended as a whole or not at all
+func TestTrackMetasRedisAppendMany(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	if _, err := metas.AppendMany(testTrackMetas); err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+
+	fresh := TrackMeta{ID: 1232, TrackSrcURL: "http://a.com/fresh.igc"}
+	other := TrackMeta{ID: 1233, TrackSrcURL: "http://a.com/other.igc"}
+	if _, err := metas.AppendMany([]TrackMeta{fresh, testTrackMetas[1]}); err != ErrTrackAlreadyExists {
+		t.Errorf("expected batch with existing track to be rejected, got '%v'", err)
+	}
+	if _, err := metas.Get(fresh.ID); err != ErrTrackNotFound {
+		t.Errorf("expected rejected batch to not be appended, got '%v'", err)
+	}
+
+	ids, err := metas.AppendMany([]TrackMeta{fresh, other})
+	if err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+	if expt := []TrackID{fresh.ID, other.ID}; !cmp.Equal(ids, expt) {
+		t.Errorf("expected appended ids to be '%v', got '%v'", expt, ids)
+	}
+	if n, _ := metas.Len(); n != len(testTrackMetas)+2 {
+		t.Errorf("expected batch to be appended, got '%d' tracks", n)
+	}
+}
+
+// Test that all ids are ordered by the time they were added, and that the
+// other ways of listing tracks use the stored tracks
+func TestTrackMetasRedisGetAll(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	for _, meta := range []TrackMeta{
+		{ID: 30, Timestamp: start.Add(time.Second), GliderID: "A", TrackLength: 10, TrackSrcURL: "http://a.com/30.igc"},
+		{ID: 10, Timestamp: start.Add(2 * time.Second), GliderID: "B", TrackLength: 30, TrackSrcURL: "http://a.com/10.igc"},
+		{ID: 20, Timestamp: start, GliderID: "A", TrackLength: 20, TrackSrcURL: "http://a.com/20.igc"},
+	} {
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	ids, err := metas.GetAllIDs()
+	if err != nil {
+		t.Fatalf("unable to get all ids: %s", err)
+	}
+	if expt := []TrackID{20, 30, 10}; !cmp.Equal(ids, expt) {
+		t.Errorf("expected ids '%v', got '%v'", expt, ids)
+	}
+
+	ids, err = metas.GetAllSorted("track_length", true)
+	if err != nil {
+		t.Fatalf("unable to get sorted ids: %s", err)
+	}
+	if expt := []TrackID{10, 20, 30}; !cmp.Equal(ids, expt) {
+		t.Errorf("expected sorted ids '%v', got '%v'", expt, ids)
+	}
+	if _, err := metas.GetAllSorted("pilot", false); err != ErrInvalidSortField {
+		t.Errorf("expected invalid sort field to be rejected, got '%v'", err)
+	}
+
+	ids, err = metas.FindByGliderID("A")
+	if err != nil {
+		t.Fatalf("unable to find ids by glider id: %s", err)
+	}
+	if expt := []TrackID{20, 30}; !cmp.Equal(ids, expt) {
+		t.Errorf("expected ids of glider 'A' to be '%v', got '%v'", expt, ids)
+	}
+}
+
+// Test that tracks from the same url are only rejected within the same
+// duplicate window, and that deleting a track frees the url in its window
+func TestTrackMetasRedisSrcWindow(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	srcURL := "http://a.com/live.igc"
+	for _, data := range []struct {
+		meta TrackMeta
+		err  error
+	}{
+		{TrackMeta{ID: 1, TrackSrcURL: srcURL}, nil},
+		{TrackMeta{ID: 2, TrackSrcURL: srcURL, SrcWindow: 7}, nil},
+		{TrackMeta{ID: 3, TrackSrcURL: srcURL, SrcWindow: 8}, nil},
+		{TrackMeta{ID: 4, TrackSrcURL: srcURL, SrcWindow: 7}, ErrTrackAlreadyExists},
+		{TrackMeta{ID: 5, TrackSrcURL: srcURL}, ErrTrackAlreadyExists},
+	} {
+		if err := metas.Append(data.meta); err != data.err {
+			t.Errorf("expected appending '%v' to give '%v', got '%v'", data.meta, data.err, err)
+		}
+	}
+
+	deleted, err := metas.Delete(2)
+	if err != nil {
+		t.Fatalf("unable to delete metadata: %s", err)
+	}
+	if deleted.SrcWindow != 7 || deleted.TrackSrcURL != srcURL {
+		t.Errorf("expected deleted track to keep its url and window, got '%v'", deleted)
+	}
+	if err := metas.Append(TrackMeta{ID: 4, TrackSrcURL: srcURL, SrcWindow: 7}); err != nil {
+		t.Errorf("expected url of deleted track to be free in its window, got '%v'", err)
+	}
+	if err := metas.Append(TrackMeta{ID: 6, TrackSrcURL: srcURL, SrcWindow: 8}); err != ErrTrackAlreadyExists {
+		t.Errorf("expected url to still be taken in other windows, got '%v'", err)
+	}
+}
+
+// Test that deleting a track frees its url, and that clearing removes all keys
+func TestTrackMetasRedisDelete(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	if _, err := metas.AppendMany(testTrackMetas); err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+
+	aladin := testTrackMetas[0]
+	deleted, err := metas.Delete(aladin.ID)
+	if err != nil {
+		t.Fatalf("unable to delete metadata: %s", err)
+	}
+	if !cmp.Equal(deleted, aladin) {
+		t.Errorf("expected deleted metadata to be '%v', got '%v'", aladin, deleted)
+	}
+	if _, err := metas.Delete(aladin.ID); err != ErrTrackNotFound {
+		t.Errorf("expected deleting twice to give ErrTrackNotFound, got '%v'", err)
+	}
+	if err := metas.Append(TrackMeta{ID: 1232, TrackSrcURL: aladin.TrackSrcURL}); err != nil {
+		t.Errorf("expected url of deleted track to be free, got '%v'", err)
+	}
+
+	n, err := metas.Clear()
+	if err != nil {
+		t.Fatalf("unable to clear metadata: %s", err)
+	}
+	if n != len(testTrackMetas) {
+		t.Errorf("expected '%d' tracks to be cleared, got '%d'", len(testTrackMetas), n)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected no keys after clearing, got '%v'", keys)
+	}
+}
+
+// Test that updates are applied, and that the url index follows the url of
+// the track
+func TestTrackMetasRedisUpdate(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	if _, err := metas.AppendMany(testTrackMetas); err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+	aladin, john := testTrackMetas[0], testTrackMetas[1]
+
+	if _, err := metas.Update(aladin.ID, func(meta *TrackMeta) { meta.TrackSrcURL = john.TrackSrcURL }); err != ErrTrackAlreadyExists {
+		t.Errorf("expected update to url of another track to be rejected, got '%v'", err)
+	}
+
+	moved := "http://a.com/moved.igc"
+	updated, err := metas.Update(aladin.ID, func(meta *TrackMeta) {
+		meta.Pilot = "Jasmine"
+		meta.TrackSrcURL = moved
+	})
+	if err != nil {
+		t.Fatalf("unable to update metadata: %s", err)
+	}
+	if got, _ := metas.Get(aladin.ID); !cmp.Equal(got, updated) || got.Pilot != "Jasmine" {
+		t.Errorf("expected stored metadata to be updated, got '%v'", got)
+	}
+	if err := metas.Append(TrackMeta{ID: 1232, TrackSrcURL: moved}); err != ErrTrackAlreadyExists {
+		t.Errorf("expected new url of track to be taken, got '%v'", err)
+	}
+	if err := metas.Append(TrackMeta{ID: 1233, TrackSrcURL: aladin.TrackSrcURL}); err != nil {
+		t.Errorf("expected old url of track to be free, got '%v'", err)
+	}
+
+	if _, err := metas.Update(TrackID(1), func(*TrackMeta) {}); err != ErrTrackNotFound {
+		t.Errorf("expected update of unknown id to give ErrTrackNotFound, got '%v'", err)
+	}
+}
+
+// Test that the latest timestamp follows the tracks which are appended,
+// updated and deleted, also when timestamps differ by less than the
+// microseconds of the sorted set
+func TestTrackMetasRedisLatest(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+
+	if latest, err := metas.Latest(); err != nil || latest != nil {
+		t.Errorf("expected no latest timestamp without tracks, got '%v' and '%v'", latest, err)
+	}
+
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	for i, offset := range []time.Duration{0, 2*time.Second + time.Nanosecond, 2 * time.Second, time.Second} {
+		meta := TrackMeta{
+			ID:          TrackID(i + 1),
+			Timestamp:   start.Add(offset),
+			TrackSrcURL: fmt.Sprintf("http://a.com/%d.igc", i),
+		}
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	if latest, err := metas.Latest(); err != nil || latest == nil || !latest.Equal(start.Add(2*time.Second+time.Nanosecond)) {
+		t.Errorf("expected latest timestamp to be '%s', got '%v' and '%v'", start.Add(2*time.Second+time.Nanosecond), latest, err)
+	}
+
+	if _, err := metas.Delete(2); err != nil {
+		t.Fatalf("unable to delete metadata: %s", err)
+	}
+	if latest, err := metas.Latest(); err != nil || latest == nil || !latest.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected latest timestamp after deleting the latest track to be '%s', got '%v' and '%v'", start.Add(2*time.Second), latest, err)
+	}
+
+	moved := start.Add(time.Hour)
+	if _, err := metas.Update(1, func(meta *TrackMeta) { meta.Timestamp = moved }); err != nil {
+		t.Fatalf("unable to update metadata: %s", err)
+	}
+	if latest, err := metas.Latest(); err != nil || latest == nil || !latest.Equal(moved) {
+		t.Errorf("expected latest timestamp after updating a track to be '%s', got '%v' and '%v'", moved, latest, err)
+	}
+
+	if _, err := metas.Clear(); err != nil {
+		t.Fatalf("unable to clear metadata: %s", err)
+	}
+	if latest, err := metas.Latest(); err != nil || latest != nil {
+		t.Errorf("expected no latest timestamp after clearing, got '%v' and '%v'", latest, err)
+	}
+}
+
+// Test that the track metas are consistent when accessed concurrently
+func TestTrackMetasRedisConcurrentAccess(t *testing.T) {
+	metas, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+	stressTrackMetas(t, &metas)
+}
+
+// Test that servers sharing the same redis server see the tracks registered by
+// each other in the ticker and the clock
+func TestTrackMetasRedisSharedTicker(t *testing.T) {
+	_, mr := makeMiniTrackMetasRedis(t)
+	defer mr.Close()
+	fileserver := makeIgcFileServer()
+	fileserver.Start()
+	defer fileserver.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	makeInstance := func() (Server, *TickerStore) {
+		metas := NewTrackMetasRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		ticker := NewTickerStore(&metas)
+		webhooks := NewWebhooksMap()
+		return NewServer(fileserver.Client(), &metas, &ticker, &webhooks), &ticker
+	}
+	serverA, _ := makeInstance()
+	serverB, tickerB := makeInstance()
+
+	clockB := NewClockWithSinks(receiver.Client(), tickerB, time.Hour, ClockSink{ClockSinkGeneric, receiver.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clockB.Start(ctx)
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	serverA.ServeHTTP(res, req)
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+
+	req = httptest.NewRequest("GET", "/ticker", nil)
+	res = httptest.NewRecorder()
+	serverB.ServeHTTP(res, req)
+	var report TickerReport
+	if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if !cmp.Equal(report.Tracks, []TrackID{data["id"]}) {
+		t.Errorf("expected ticker of other instance to report '%d', got '%d'", data["id"], report.Tracks)
+	}
+	if latest := tickerB.Latest(); latest == nil || !latest.Equal(report.Latest) {
+		t.Errorf("expected latest timestamp of other instance to be '%s', got '%v'", report.Latest, latest)
+	}
+
+	if result := clockB.Tick(); !result.Fired || result.NewTracks != 1 {
+		t.Errorf("expected clock of other instance to fire with one new track, got '%v'", result)
+	}
+}
@@ -1,9 +1,16 @@
 package igcserver
 
 import (
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/marni/goigc"
 	"math/rand"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // Test that all returned ids from 'Append' are found when using 'Get'
@@ -86,7 +93,7 @@ func TestTrackMetasGetConcurr(t *testing.T) {
 		wg.Add(1)
 		go func(metas *TrackMetasMap, id TrackID) {
 			if _, err := metas.Get(id); err != nil {
-				t.Fatalf("didn't find id '%d' in result of 'GetAllIDs'", id)
+				t.Errorf("didn't find id '%d' in result of 'GetAllIDs'", id)
 			}
 			wg.Done()
 		}(&metas, pureID)
@@ -132,48 +139,595 @@ func TestTrackMetasGetAllIDs(t *testing.T) {
 	}
 }
 
-// TrackMetasMap contains a map to many TrackMeta objects which are protected
-// by a RWMutex and indexed by a unique id
-type TrackMetasMap struct {
-	sync.RWMutex
-	data map[TrackID]TrackMeta
+// Test that 'GetAll' returns all track metas ordered by the time they were
+// added
+func TestTrackMetasGetAll(t *testing.T) {
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	metas := NewTrackMetasMap()
+	for i, id := range []TrackID{3, 1, 2} {
+		meta := TrackMeta{ID: id, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	all, err := metas.GetAll()
+	if err != nil {
+		t.Fatalf("unable to get all metadata: %s", err)
+	}
+	ids, _ := metas.GetAllIDs()
+	if len(all) != len(ids) {
+		t.Fatalf("expected '%d' track metas, got '%d'", len(ids), len(all))
+	}
+	for i, meta := range all {
+		if meta.ID != ids[i] {
+			t.Errorf("expected track meta '%d' to have id '%d', got '%d'", i, ids[i], meta.ID)
+		}
+	}
+}
+
+// Benchmark fetching all track metas using a single 'GetAll'
+func BenchmarkTrackMetasGetAll(b *testing.B) {
+	metas := makeBenchmarkTrackMetas(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		metas.GetAll()
+	}
+}
+
+// Benchmark fetching all track metas using 'GetAllIDs' followed by a 'Get' for
+// every id
+func BenchmarkTrackMetasGetEach(b *testing.B) {
+	metas := makeBenchmarkTrackMetas(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ids, _ := metas.GetAllIDs()
+		for _, id := range ids {
+			metas.Get(id)
+		}
+	}
+}
+
+// Convenience function to create a storage with many track metas
+func makeBenchmarkTrackMetas(b *testing.B) *TrackMetasMap {
+	const metaCount = 1000
+	metas := NewTrackMetasMap()
+	for i := 0; i < metaCount; i++ {
+		if err := metas.Append(TrackMeta{ID: TrackID(i), Timestamp: time.Now()}); err != nil {
+			b.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+	return &metas
+}
+
+// Test that the storage stays consistent under concurrent appends, updates,
+// deletes and reads, which should be run using `-race`
+func TestTrackMetasConcurrentAccess(t *testing.T) {
+	metas := NewTrackMetasMap()
+	stressTrackMetas(t, &metas)
+}
+
+// stressTrackMetas changes and reads a small set of track metas from multiple
+// goroutines, and checks that all snapshots of the track metas are
+// consistent
+func stressTrackMetas(t *testing.T, metas TrackMetas) {
+	const (
+		idCount    = 16
+		workers    = 8
+		iterations = 500
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < iterations; i++ {
+				id := TrackID(r.Intn(idCount))
+				switch r.Intn(6) {
+				case 0:
+					metas.Append(TrackMeta{ID: id, Timestamp: time.Now(), Pilot: "John", TrackSrcURL: fmt.Sprintf("http://a.com/%d.igc", id)})
+				case 1:
+					metas.Delete(id)
+				case 2:
+					metas.Update(id, func(meta *TrackMeta) { meta.Pilot = "Jane" })
+				case 3:
+					if meta, err := metas.Get(id); err == nil && meta.ID != id {
+						t.Errorf("expected track meta of '%d', got '%d'", id, meta.ID)
+					}
+				case 4:
+					all, err := metas.GetAll()
+					if err != nil {
+						t.Errorf("unable to get all metadata: %s", err)
+						continue
+					}
+					seen := make(map[TrackID]bool)
+					for _, meta := range all {
+						if seen[meta.ID] {
+							t.Errorf("expected id '%d' to be returned once", meta.ID)
+						}
+						seen[meta.ID] = true
+					}
+				case 5:
+					metas.Filter(func(meta TrackMeta) bool { return meta.Pilot == "Jane" })
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every stored track meta should be found, and nothing else
+	all, err := metas.GetAll()
+	if err != nil {
+		t.Fatalf("unable to get all metadata: %s", err)
+	}
+	stored := make(map[TrackID]TrackMeta)
+	for _, meta := range all {
+		stored[meta.ID] = meta
+	}
+	for id := TrackID(0); id < idCount; id++ {
+		meta, err := metas.Get(id)
+		expt, ok := stored[id]
+		if ok && (err != nil || meta.Pilot != expt.Pilot) {
+			t.Errorf("expected '%d' to be '%v', got '%v' (%v)", id, expt, meta, err)
+		} else if !ok && err != ErrTrackNotFound {
+			t.Errorf("expected '%d' to be missing, got '%v'", id, meta)
+		}
+	}
+}
+
+// Test that the track meta which was inserted first is evicted when a track
+// meta is appended to a full map
+func TestTrackMetasMapCapacity(t *testing.T) {
+	metas := NewTrackMetasMapWithCapacity(2)
+
+	// Insertion order decides what is evicted, not the timestamp
+	now := time.Now()
+	for i, id := range []TrackID{1, 2, 3} {
+		meta := TrackMeta{ID: id, Timestamp: now.Add(-time.Duration(i) * time.Minute)}
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	if _, err := metas.Get(1); err != ErrTrackNotFound {
+		t.Errorf("expected first meta to be evicted, got '%v'", err)
+	}
+	for _, id := range []TrackID{2, 3} {
+		if _, err := metas.Get(id); err != nil {
+			t.Errorf("expected meta '%d' to be kept, got '%v'", id, err)
+		}
+	}
+	if n, _ := metas.Len(); n != 2 {
+		t.Errorf("expected '2' metas to be stored, got '%d'", n)
+	}
+
+	// Deleted metas free up capacity without evicting other metas
+	if _, err := metas.Delete(3); err != nil {
+		t.Fatalf("unable to delete metadata: %s", err)
+	}
+	if err := metas.Append(TrackMeta{ID: 4}); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+	for _, id := range []TrackID{2, 4} {
+		if _, err := metas.Get(id); err != nil {
+			t.Errorf("expected meta '%d' to be kept, got '%v'", id, err)
+		}
+	}
+}
+
+// Test that deleted track metas are removed and that unknown ids are rejected
+func TestTrackMetasDelete(t *testing.T) {
+	meta := TrackMeta{
+		ID: NewTrackID([]byte("to-be-deleted")),
+	}
+
+	metas := NewTrackMetasMap()
+	if err := metas.Append(meta); err != nil {
+		t.Fatalf("unable to add metadata: %s", err)
+	}
+
+	deleted, err := metas.Delete(meta.ID)
+	if err != nil {
+		t.Fatalf("unable to delete metadata which was just inserted: %s", err)
+	}
+	if deleted.ID != meta.ID {
+		t.Fatalf("id of removed metadata was not equal to the id specified when deleting")
+	}
+	if _, err := metas.Get(meta.ID); err != ErrTrackNotFound {
+		t.Fatalf("expected deleted metadata to be missing, got error '%v'", err)
+	}
+	if _, err := metas.Delete(meta.ID); err != ErrTrackNotFound {
+		t.Fatalf("expected deleting metadata twice to fail, got error '%v'", err)
+	}
+}
+
+// Test that tracks are found by their exact tags
+func TestTrackMetasFindByTag(t *testing.T) {
+	metas := NewTrackMetasMap()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	for _, data := range []struct {
+		tag  string
+		expt []TrackID
+	}{
+		{"xc", []TrackID{testTrackMetas[0].ID}},
+		{"magic", []TrackID{testTrackMetas[0].ID}},
+		{"XC", []TrackID{}},
+		{"x", []TrackID{}},
+		{"", []TrackID{}},
+	} {
+		ids, err := metas.FindByTag(data.tag)
+		if err != nil {
+			t.Fatalf("unable to find tracks by tag: %s", err)
+		}
+		if !cmp.Equal(ids, data.expt) {
+			t.Errorf("expected tag '%s' to give '%v', got '%v'", data.tag, data.expt, ids)
+		}
+	}
+}
+
+// Test that tags are trimmed and deduplicated, and that invalid tags are
+// rejected
+func TestValidateTags(t *testing.T) {
+	tags, err := validateTags([]string{" xc", "comp2024", "xc ", "Xc"})
+	if err != nil {
+		t.Fatalf("expected tags to be valid, got '%s'", err)
+	}
+	if expt := []string{"xc", "comp2024", "Xc"}; !cmp.Equal(tags, expt) {
+		t.Errorf("expected tags to be '%v', got '%v'", expt, tags)
+	}
+	if tags, err := validateTags(nil); err != nil || len(tags) != 0 {
+		t.Errorf("expected no tags to be valid, got '%v' (%v)", tags, err)
+	}
+
+	for _, invalid := range [][]string{
+		{""},
+		{"xc", "\t"},
+		{strings.Repeat("å", maxTagLength+1)},
+		make([]string, maxTrackTags+1),
+	} {
+		if _, err := validateTags(invalid); err != errInvalidTags {
+			t.Errorf("expected tags '%q' to be rejected, got '%v'", invalid, err)
+		}
+	}
+	// The length of tags is counted in characters rather than bytes
+	if _, err := validateTags([]string{strings.Repeat("å", maxTagLength)}); err != nil {
+		t.Errorf("expected tag of %d characters to be valid, got '%s'", maxTagLength, err)
+	}
 }
 
-// NewTrackMetasMap creates a new mutex and mapping from ID to TrackMeta
-func NewTrackMetasMap() TrackMetasMap {
-	return TrackMetasMap{sync.RWMutex{}, make(map[TrackID]TrackMeta)}
+// Test that tracks are found by their exact glider id
+func TestTrackMetasFindByGliderID(t *testing.T) {
+	metas := NewTrackMetasMap()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	for _, meta := range testTrackMetas {
+		if err := metas.Append(meta); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+	}
+
+	for _, data := range []struct {
+		gliderID string
+		expt     []TrackID
+	}{
+		{"MGI2", []TrackID{testTrackMetas[0].ID}},
+		{"BG7", []TrackID{testTrackMetas[1].ID}},
+		{"mgi2", []TrackID{}},
+		{"MGI", []TrackID{}},
+		{"", []TrackID{}},
+	} {
+		ids, err := metas.FindByGliderID(data.gliderID)
+		if err != nil {
+			t.Fatalf("unable to find tracks by glider id: %s", err)
+		}
+		if !cmp.Equal(ids, data.expt) {
+			t.Errorf("expected glider id '%s' to give '%v', got '%v'", data.gliderID, data.expt, ids)
+		}
+	}
+}
+
+// Test that a batch of track metas is appended as a whole or not at all
+func TestTrackMetasAppendMany(t *testing.T) {
+	metas := NewTrackMetasMap()
+
+	testTrackMetas := makeIGCTestData("localhost")
+	ids, err := metas.AppendMany(testTrackMetas)
+	if err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+	expt := []TrackID{testTrackMetas[0].ID, testTrackMetas[1].ID}
+	if !cmp.Equal(ids, expt) {
+		t.Errorf("expected appended ids to be '%v', got '%v'", expt, ids)
+	}
+
+	fresh := TrackMeta{ID: 1232, TrackSrcURL: "http://a.com/fresh.igc"}
+	other := TrackMeta{ID: 1233, TrackSrcURL: "http://a.com/other.igc"}
+	for _, batch := range [][]TrackMeta{
+		// The last track fails as it is already stored
+		{fresh, testTrackMetas[1]},
+		// The tracks share an id or a source url within the batch
+		{fresh, TrackMeta{ID: fresh.ID, TrackSrcURL: other.TrackSrcURL}},
+		{fresh, TrackMeta{ID: other.ID, TrackSrcURL: fresh.TrackSrcURL}},
+	} {
+		if _, err := metas.AppendMany(batch); err != ErrTrackAlreadyExists {
+			t.Errorf("expected batch '%v' to be rejected, got '%v'", batch, err)
+		}
+		if n, _ := metas.Len(); n != len(testTrackMetas) {
+			t.Errorf("expected rejected batch to be rolled back, got '%d' tracks", n)
+		}
+	}
+
+	if _, err := metas.AppendMany([]TrackMeta{fresh, other}); err != nil {
+		t.Fatalf("unable to add batch of metadata: %s", err)
+	}
+	if n, _ := metas.Len(); n != len(testTrackMetas)+2 {
+		t.Errorf("expected batch to be appended, got '%d' tracks", n)
+	}
+}
+
+// Test that tracks with colliding ids are given the next free id
+func TestAppendTrackMetaCollision(t *testing.T) {
+	metas := NewTrackMetasMap()
+
+	first := TrackMeta{ID: 1232, TrackSrcURL: "http://a.com/first.igc"}
+	second := TrackMeta{ID: 1232, TrackSrcURL: "http://b.com/second.igc"}
+
+	first, err := appendTrackMeta(&metas, first)
+	if err != nil {
+		t.Fatalf("unable to add first track: %s", err)
+	}
+	second, err = appendTrackMeta(&metas, second)
+	if err != nil {
+		t.Fatalf("unable to add colliding track: %s", err)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("expected colliding tracks to get different ids, both got '%d'", first.ID)
+	}
+
+	for _, meta := range []TrackMeta{first, second} {
+		got, err := metas.Get(meta.ID)
+		if err != nil {
+			t.Fatalf("unable to get track '%d': %s", meta.ID, err)
+		}
+		if got.TrackSrcURL != meta.TrackSrcURL {
+			t.Errorf("expected track '%d' to have url '%s', got '%s'", meta.ID, meta.TrackSrcURL, got.TrackSrcURL)
+		}
+	}
+
+	// A track with the same url should still be a duplicate, even if it is
+	// stored with a probed id
+	if _, err := appendTrackMeta(&metas, TrackMeta{ID: 1232, TrackSrcURL: second.TrackSrcURL}); err != ErrTrackAlreadyExists {
+		t.Errorf("expected track with same url to be rejected, got '%v'", err)
+	}
+}
+
+// Test that the seed of track ids changes the ids, while an empty seed gives
+// the same ids as without a seed
+func TestNewTrackIDWithSeed(t *testing.T) {
+	url := []byte("http://skypolaris.org/wp-content/uploads/IGS%20Files/Madrid%20to%20Jerez.igc")
+
+	if id, expt := NewTrackIDWithSeed("", url), NewTrackID(url); id != expt {
+		t.Errorf("expected empty seed to give the id '%d', got '%d'", expt, id)
+	}
+	first := NewTrackIDWithSeed("first", url)
+	second := NewTrackIDWithSeed("second", url)
+	if first == second || first == NewTrackID(url) {
+		t.Errorf("expected different seeds to give different ids, got '%d' and '%d'", first, second)
+	}
+	if again := NewTrackIDWithSeed("first", url); again != first {
+		t.Errorf("expected the same seed to give the same id '%d', got '%d'", first, again)
+	}
+}
+
+// Test that points are downsampled to evenly spaced points including the
+// first and the last point
+func TestDownsamplePoints(t *testing.T) {
+	points := make([]igc.Point, 10)
+	for i := range points {
+		points[i] = igc.NewPointFromLatLng(float64(i), 0)
+	}
+
+	for _, data := range []struct {
+		max  int
+		expt []int
+	}{
+		{0, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{10, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{20, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{4, []int{0, 3, 6, 9}},
+		{3, []int{0, 4, 9}},
+		{2, []int{0, 9}},
+		{1, []int{0}},
+	} {
+		sampled := downsamplePoints(points, data.max)
+		if len(sampled) != len(data.expt) {
+			t.Errorf("expected a max of '%d' to give '%d' points, got '%d'", data.max, len(data.expt), len(sampled))
+			continue
+		}
+		for i, j := range data.expt {
+			if sampled[i].Lat != points[j].Lat {
+				t.Errorf("expected point %d with a max of '%d' to be point '%d', got '%v'", i, data.max, j, sampled[i])
+			}
+		}
+	}
+}
+
+// Test that only increases in altitude are summed up
+func TestCalcAltitudeGain(t *testing.T) {
+	makePoints := func(gnss bool, altitudes ...int64) []igc.Point {
+		points := make([]igc.Point, len(altitudes))
+		for i, altitude := range altitudes {
+			points[i] = igc.NewPoint()
+			if gnss {
+				points[i].GNSSAltitude = altitude
+			} else {
+				points[i].PressureAltitude = altitude
+			}
+		}
+		return points
+	}
+
+	for _, data := range []struct {
+		points []igc.Point
+		expt   int64
+	}{
+		{nil, 0},
+		{makePoints(true, 100), 0},
+		{makePoints(true, 0, 0, 0), 0},
+		{makePoints(true, 100, 200, 150, 300), 250},
+		{makePoints(false, 100, 200, 150, 300), 250},
+		{makePoints(true, 500, 400, 300), 0},
+	} {
+		if gain := calcAltitudeGain(data.points); gain != data.expt {
+			t.Errorf("expected altitude gain to be '%d', got '%d'", data.expt, gain)
+		}
+	}
+}
+
+// Test that the duration spans from the first to the last point
+func TestCalcDuration(t *testing.T) {
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	makePoints := func(offsets ...time.Duration) []igc.Point {
+		points := make([]igc.Point, len(offsets))
+		for i, offset := range offsets {
+			points[i] = igc.NewPoint()
+			points[i].Time = start.Add(offset)
+		}
+		return points
+	}
+
+	for _, data := range []struct {
+		points []igc.Point
+		expt   int64
+	}{
+		{nil, 0},
+		{makePoints(time.Minute), 0},
+		{makePoints(0, time.Minute), 60},
+		{makePoints(0, time.Minute, 90*time.Minute+500*time.Millisecond), 5400},
+	} {
+		if duration := calcDuration(data.points); duration != data.expt {
+			t.Errorf("expected duration to be '%d', got '%d'", data.expt, duration)
+		}
+	}
 }
 
-// Get fetches the track meta of a specific id if it exists
-func (metas *TrackMetasMap) Get(id TrackID) (meta TrackMeta, err error) {
-	metas.RLock()
-	defer metas.RUnlock()
-	meta, ok := metas.data[id]
-	if !ok {
-		err = ErrTrackNotFound
+// Test that the average speed is the length divided by the duration in hours
+func TestCalcAvgSpeed(t *testing.T) {
+	for _, data := range []struct {
+		trackLength float64
+		duration    int64
+		expt        float64
+	}{
+		{0, 0, 0},
+		{100, 0, 0},
+		{1200, 5400, 800},
+		{36, 3600, 36},
+		{1, 60, 60},
+	} {
+		if speed := calcAvgSpeed(data.trackLength, data.duration); speed != data.expt {
+			t.Errorf("expected '%v' km over '%d' s to give '%v' km/h, got '%v'", data.trackLength, data.duration, data.expt, speed)
+		}
 	}
-	return
 }
 
-// Append appends a track meta and returns the given id
-func (metas *TrackMetasMap) Append(meta TrackMeta) (err error) {
-	metas.Lock()
-	defer metas.Unlock()
-	if _, exists := metas.data[meta.ID]; exists {
-		err = ErrTrackAlreadyExists
-	} else {
-		metas.data[meta.ID] = meta
+// Test that the average speed of a track with points is calculated from its
+// length and duration
+func TestTrackMetaFromAvgSpeed(t *testing.T) {
+	start := time.Date(2018, time.October, 1, 12, 0, 0, 0, time.UTC)
+	points := []igc.Point{
+		igc.NewPointFromLatLng(60, 10),
+		igc.NewPointFromLatLng(60.1, 10),
+		igc.NewPointFromLatLng(60.2, 10),
+	}
+	for i := range points {
+		points[i].Time = start.Add(time.Duration(i) * 30 * time.Minute)
+	}
+	srcURL, _ := url.Parse("http://localhost/speed.igc")
+	meta := TrackMetaFrom(*srcURL, igc.Track{Points: points})
+
+	// 0.2 degrees of latitude is about 22.2 km, which is flown in one hour
+	if meta.Duration != 3600 {
+		t.Errorf("expected duration to be '3600', got '%d'", meta.Duration)
+	}
+	if !cmp.Equal(meta.AvgSpeed, meta.TrackLength, cmpopts.EquateApprox(1e-9, 0)) {
+		t.Errorf("expected average speed to equal the length flown in one hour '%v', got '%v'", meta.TrackLength, meta.AvgSpeed)
+	}
+	if !cmp.Equal(meta.AvgSpeed, 22.24, cmpopts.EquateApprox(0.01, 0)) {
+		t.Errorf("expected average speed to be about '22.24' km/h, got '%v'", meta.AvgSpeed)
+	}
+
+	// Tracks without duration have no speed
+	meta = TrackMetaFrom(*srcURL, igc.Track{Points: points[:1]})
+	if meta.AvgSpeed != 0 {
+		t.Errorf("expected average speed of a single point to be '0', got '%v'", meta.AvgSpeed)
 	}
-	return
 }
 
-// GetAllIDs fetches all the stored ids
-func (metas *TrackMetasMap) GetAllIDs() (ids []TrackID, err error) {
-	metas.RLock()
-	defer metas.RUnlock()
-	ids = make([]TrackID, 0, len(metas.data))
-	for id := range metas.data {
-		ids = append(ids, id)
+// Test that the bounding box contains the extremes of the points
+func TestCalcBoundingBox(t *testing.T) {
+	makePoints := func(coords ...[2]float64) []igc.Point {
+		points := make([]igc.Point, len(coords))
+		for i, coord := range coords {
+			points[i] = igc.NewPointFromLatLng(coord[0], coord[1])
+		}
+		return points
+	}
+
+	for _, data := range []struct {
+		points []igc.Point
+		expt   BoundingBox
+	}{
+		{nil, BoundingBox{}},
+		{makePoints([2]float64{60, 10}), BoundingBox{60, 10, 60, 10}},
+		{
+			makePoints([2]float64{60, 10}, [2]float64{61.5, 9}, [2]float64{59, 12.25}, [2]float64{60.5, 11}),
+			BoundingBox{59, 9, 61.5, 12.25},
+		},
+		{
+			makePoints([2]float64{-33.5, -70.25}, [2]float64{-34, -70}),
+			BoundingBox{-34, -70.25, -33.5, -70},
+		},
+	} {
+		bbox := calcBoundingBox(data.points)
+		if !cmp.Equal(bbox, data.expt, cmpopts.EquateApprox(0, 1e-9)) {
+			t.Errorf("expected bounding box to be '%v', got '%v'", data.expt, bbox)
+		}
+	}
+}
+
+// Test that sorting track metas orders ties by id
+func TestSortTrackMetasTies(t *testing.T) {
+	metas := []TrackMeta{
+		{ID: 3, TrackLength: 10},
+		{ID: 1, TrackLength: 10},
+		{ID: 2, TrackLength: 20},
+	}
+	for _, data := range []struct {
+		desc bool
+		expt []TrackID
+	}{
+		{false, []TrackID{1, 3, 2}},
+		{true, []TrackID{2, 1, 3}},
+	} {
+		if err := sortTrackMetas(metas, "track_length", data.desc); err != nil {
+			t.Fatalf("unable to sort track metas: %s", err)
+		}
+		for i, meta := range metas {
+			if meta.ID != data.expt[i] {
+				t.Errorf("expected sorted ids (desc: %t) to be '%d', got id '%d' at index '%d'", data.desc, data.expt, meta.ID, i)
+			}
+		}
+	}
+
+	if err := sortTrackMetas(metas, "pilot", false); err != ErrInvalidSortField {
+		t.Errorf("expected sorting by an unknown field to fail, got '%v'", err)
 	}
-	return
 }
@@ -0,0 +1,53 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ndjsonFlushInterval is the amount of lines written between every flush of a
+// streamed ndjson response
+const ndjsonFlushInterval = 100
+
+// ndjsonTrack is a line of the ndjson export of tracks, which includes the id
+// unlike the json of a single track
+type ndjsonTrack struct {
+	ID TrackID `json:"id"`
+	TrackMeta
+}
+
+// trackGetNDJSONHandler streams the metadata of all tracks as newline
+// delimited json, with one track per line in the order they were added. The
+// response is flushed as it is written, such that it isn't buffered as a whole.
+func (server *Server) trackGetNDJSONHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to stream all tracks as ndjson")
+
+	// The tracks are fetched as a snapshot, such that the store isn't locked
+	// while writing to a slow client
+	metas, err := server.tracks.GetAll()
+	if err != nil {
+		logger.WithField("error", err).Error("unable to get all tracks")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	logger.WithField("count", len(metas)).Info("streaming tracks as ndjson")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, meta := range metas {
+		if err := enc.Encode(ndjsonTrack{meta.ID, meta}); err != nil {
+			logger.WithField("error", err).Info("unable to stream track, aborting")
+			return
+		}
+		if flusher != nil && (i+1)%ndjsonFlushInterval == 0 {
+			flusher.Flush()
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
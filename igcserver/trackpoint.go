@@ -0,0 +1,180 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/marni/goigc"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TrackPoint is the position of a track at a specific time
+type TrackPoint struct {
+	Lat  float64   `json:"lat"`
+	Lon  float64   `json:"lon"`
+	Time time.Time `json:"time"`
+}
+
+// TrackPointFrom converts a point of an igc track into a TrackPoint
+func TrackPointFrom(p igc.Point) TrackPoint {
+	return TrackPoint{
+		p.Lat.Degrees(),
+		p.Lng.Degrees(),
+		p.Time.UTC(),
+	}
+}
+
+// TrackPointEle is a TrackPoint with the elevation of the point in meters
+type TrackPointEle struct {
+	Lat  float64   `json:"lat"`
+	Lon  float64   `json:"lon"`
+	Ele  int64     `json:"ele"`
+	Time time.Time `json:"time"`
+}
+
+// trackPointsEleFrom converts the points of an igc track into TrackPointEles,
+// where the elevation is chosen the same way as the altitude gain of a track
+func trackPointsEleFrom(points []igc.Point) []TrackPointEle {
+	altitude := pointAltitude(points)
+	converted := make([]TrackPointEle, len(points))
+	for i, p := range points {
+		converted[i] = TrackPointEle{
+			p.Lat.Degrees(),
+			p.Lng.Degrees(),
+			altitude(p),
+			p.Time.UTC(),
+		}
+	}
+	return converted
+}
+
+// paginatePoints returns the page of points specified by limit and offset. An
+// offset which is out of range results in an empty page.
+func paginatePoints(points []igc.Point, limit, offset int) []igc.Point {
+	if offset >= len(points) {
+		return []igc.Point{}
+	}
+	points = points[offset:]
+	if limit > 0 && limit < len(points) {
+		points = points[:limit]
+	}
+	return points
+}
+
+// trackGetPointsHandler responds with the page of points of a track given by
+// the optional `limit` and `offset` query parameters. The points are only
+// available if the server retains them.
+func (server *Server) trackGetPointsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get points of track")
+
+	if server.points == nil {
+		logger.Info("points of tracks are not retained")
+		writeJSONError(w, http.StatusNotFound, "points of tracks are not retained by this server")
+		return
+	}
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		idlog.WithField("error", err).Info("unable to parse pagination parameters")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	meta, err := server.tracks.Get(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	points, ok := server.pointsOf(w, r, idlog, meta)
+	if !ok {
+		return
+	}
+	page := paginatePoints(points, limit, offset)
+
+	idlog.WithFields(log.Fields{
+		"points": len(points),
+		"page":   len(page),
+	}).Info("responding with points of track")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trackPointsEleFrom(page))
+}
+
+// trackGetTakeoffHandler responds with the first point of a track
+func (server *Server) trackGetTakeoffHandler(w http.ResponseWriter, r *http.Request) {
+	server.trackGetPointHandler(w, r, "takeoff", func(points []igc.Point) igc.Point {
+		return points[0]
+	})
+}
+
+// trackGetLandingHandler responds with the last point of a track
+func (server *Server) trackGetLandingHandler(w http.ResponseWriter, r *http.Request) {
+	server.trackGetPointHandler(w, r, "landing", func(points []igc.Point) igc.Point {
+		return points[len(points)-1]
+	})
+}
+
+// trackGetPointHandler responds with the point of a track chosen by `pick`,
+// or with no content if the track has less than two points
+func (server *Server) trackGetPointHandler(w http.ResponseWriter, r *http.Request, name string, pick func([]igc.Point) igc.Point) {
+	logger := newReqLogger(r).WithField("point", name)
+
+	logger.Info("processing request to get point of track")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	meta, err := server.tracks.Get(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	points, ok := server.pointsOf(w, r, idlog, meta)
+	if !ok {
+		return
+	}
+	// A track needs to start and end at different points to have a takeoff
+	// and a landing
+	if len(points) < 2 {
+		idlog.WithField("points", len(points)).Info("track has too few points")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	point := TrackPointFrom(pick(points))
+
+	idlog.WithField(name, point).Info("responding with point of track")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(point)
+}
@@ -0,0 +1,66 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// TrackPublisher publishes a message to a message broker, eg. NATS or Kafka,
+// whenever a track is registered. The message is a TrackStreamMsg encoded as
+// json, the same as the messages of the stream of new tracks.
+type TrackPublisher interface {
+	Publish(msg []byte) error
+}
+
+// noopPublisher discards all messages, and is used when no publisher is
+// configured
+type noopPublisher struct{}
+
+// Publish discards the message
+func (noopPublisher) Publish(msg []byte) error {
+	return nil
+}
+
+// Make sure that NATSPublisher can be used to publish tracks
+var _ TrackPublisher = (*NATSPublisher)(nil)
+
+// NATSPublisher publishes messages to a subject of a NATS server
+type NATSPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSPublisher creates a publisher which publishes to the subject using
+// an already opened connection
+func NewNATSPublisher(conn *nats.Conn, subject string) NATSPublisher {
+	return NATSPublisher{
+		conn,
+		subject,
+	}
+}
+
+// Publish publishes the message to the subject of the publisher
+func (publisher *NATSPublisher) Publish(msg []byte) error {
+	return publisher.conn.Publish(publisher.subject, msg)
+}
+
+// publishTrack publishes the track meta in the background, such that an
+// unavailable broker never delays or fails the registration of the track.
+// Messages which fail to publish are logged and dropped.
+func (server *Server) publishTrack(logger *log.Entry, meta TrackMeta) {
+	msg, err := json.Marshal(TrackStreamMsg{meta.ID, meta})
+	if err != nil {
+		logger.WithField("error", err).Error("unable to encode published track message")
+		return
+	}
+	go func() {
+		if err := server.publisher.Publish(msg); err != nil {
+			trackPublishFailures.Inc()
+			logger.WithFields(log.Fields{
+				"id":    meta.ID,
+				"error": err,
+			}).Warn("unable to publish registered track")
+		}
+	}()
+}
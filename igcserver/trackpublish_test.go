@@ -0,0 +1,88 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePublisher sends all published messages to a channel, and fails to
+// publish them if err is set
+type fakePublisher struct {
+	msgs chan []byte
+	err  error
+}
+
+// Publish sends the message to the channel of the publisher
+func (publisher *fakePublisher) Publish(msg []byte) error {
+	publisher.msgs <- msg
+	return publisher.err
+}
+
+// Test that a message is published when a track is registered, and that
+// failing to publish it does not fail the registration
+func TestIgcServerPostTrackPublished(t *testing.T) {
+	for _, pubErr := range []error{nil, errors.New("broker is down")} {
+		publisher := &fakePublisher{make(chan []byte, 1), pubErr}
+		server, fileserver := makeTestServers(WithPublisher(publisher))
+		defer fileserver.Close()
+
+		body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+		req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected registration to succeed (publish error: %v), got '%d'", pubErr, res.Code)
+		}
+		var data map[string]TrackID
+		if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+			t.Errorf("received response body: '%s'", res.Body)
+			t.Fatalf("failed when trying to decode body as json")
+		}
+
+		select {
+		case msg := <-publisher.msgs:
+			var got TrackStreamMsg
+			if err := json.Unmarshal(msg, &got); err != nil {
+				t.Fatalf("unable to decode published message '%s': %s", msg, err)
+			}
+			if got.ID != data["id"] {
+				t.Errorf("expected published id to be '%d', got '%d'", data["id"], got.ID)
+			}
+			if got.Pilot != "Miguel Angel Gordillo" {
+				t.Errorf("expected published metadata of the track, got '%v'", got.TrackMeta)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a message to be published when a track is registered")
+		}
+	}
+}
+
+// Test that nothing is published when a registration fails
+func TestIgcServerPostTrackNotPublished(t *testing.T) {
+	publisher := &fakePublisher{msgs: make(chan []byte, 1)}
+	server, fileserver := makeTestServers(WithPublisher(publisher))
+	defer fileserver.Close()
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/invalid.igc")
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader([]byte(body)))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid track to be rejected, got '%d'", res.Code)
+	}
+	select {
+	case msg := <-publisher.msgs:
+		t.Errorf("expected nothing to be published, got '%s'", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
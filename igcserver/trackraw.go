@@ -0,0 +1,108 @@
+package igcserver
+
+import (
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// rawTracks stores the original igc files of tracks in memory
+type rawTracks struct {
+	sync.RWMutex
+	data map[TrackID][]byte
+}
+
+// newRawTracks creates a new empty in-memory storage of igc files
+func newRawTracks() *rawTracks {
+	return &rawTracks{data: make(map[TrackID][]byte)}
+}
+
+// Get fetches the igc file of a specific id if it exists
+func (raws *rawTracks) Get(id TrackID) (content []byte, ok bool) {
+	raws.RLock()
+	defer raws.RUnlock()
+	content, ok = raws.data[id]
+	return
+}
+
+// Set stores the igc file of a specific id
+func (raws *rawTracks) Set(id TrackID, content []byte) {
+	raws.Lock()
+	defer raws.Unlock()
+	raws.data[id] = content
+}
+
+// Delete removes the igc file of a specific id
+func (raws *rawTracks) Delete(id TrackID) {
+	raws.Lock()
+	defer raws.Unlock()
+	delete(raws.data, id)
+}
+
+// Len returns the amount of stored igc files
+func (raws *rawTracks) Len() int {
+	raws.RLock()
+	defer raws.RUnlock()
+	return len(raws.data)
+}
+
+// Clear removes all stored igc files
+func (raws *rawTracks) Clear() {
+	raws.Lock()
+	defer raws.Unlock()
+	raws.data = make(map[TrackID][]byte)
+}
+
+// rawIGC returns the stored igc file of the track, or fetches it from the
+// source url of the track if it isn't stored
+func (server *Server) rawIGC(w http.ResponseWriter, r *http.Request, logger *log.Entry, meta TrackMeta) ([]byte, bool) {
+	if server.raws != nil {
+		if content, ok := server.raws.Get(meta.ID); ok {
+			return content, true
+		}
+	}
+	logger.WithField("url", meta.TrackSrcURL).Info("fetching igc file from source url of track")
+	return server.fetchIGC(w, r, logger, meta.TrackSrcURL)
+}
+
+// trackGetRawHandler responds with the original igc file of a track. The
+// stored copy is used if raw tracks are stored, otherwise the file is fetched
+// from the source url of the track again.
+func (server *Server) trackGetRawHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get original igc file of track")
+
+	vars := mux.Vars(r)
+	idStr, _ := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.WithField("id", idStr).Info("id must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	idlog := logger.WithField("id", id)
+	meta, err := server.tracks.Get(TrackID(id))
+	if err == ErrTrackNotFound {
+		idlog.Info("unable to find metadata of id")
+		writeJSONError(w, http.StatusNotFound, "content not found")
+		return
+	} else if err != nil {
+		idlog.WithField("error", err).Error("unable to get metadata of id")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
+		return
+	}
+
+	content, ok := server.rawIGC(w, r, idlog, meta)
+	if !ok {
+		return
+	}
+
+	idlog.WithField("size", len(content)).Info("responding with original igc file")
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
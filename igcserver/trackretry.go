@@ -0,0 +1,238 @@
+package igcserver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// retryQueueSize is the maximum amount of tracks waiting to be retried, after
+// which failed fetches are responded to with an error instead
+const retryQueueSize = 64
+
+// retryResultsSize is the amount of finished retries whose outcome is kept,
+// after which the oldest outcome is forgotten
+const retryResultsSize = 256
+
+// retryJob is a track waiting to be retried, along with the tags it was
+// registered with
+type retryJob struct {
+	id     int64
+	srcURL url.URL
+	tags   []string
+}
+
+// retryResult is the outcome of a finished retry, which is the track as it
+// was registered, or the error which stopped the retries
+type retryResult struct {
+	trackMeta TrackMeta
+	err       error
+}
+
+// retryQueue keeps the tracks whose fetch failed and which are retried by a
+// background worker
+type retryQueue struct {
+	sync.Mutex
+	pending map[string]int64
+	results map[int64]retryResult
+	// finished holds the ids of the finished jobs from oldest to newest
+	finished []int64
+	lastJob  int64
+	jobs     chan retryJob
+	retries  int
+	backoff  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newRetryQueue creates a queue where each track is retried up to `retries`
+// times, where the delay before each retry starts at `backoff` and is doubled
+// for every attempt
+func newRetryQueue(retries int, backoff time.Duration) *retryQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &retryQueue{
+		pending: make(map[string]int64),
+		results: make(map[int64]retryResult),
+		jobs:    make(chan retryJob, retryQueueSize),
+		retries: retries,
+		backoff: backoff,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// Enqueue adds the track to the queue and returns the id of the job, or false
+// if the queue is full. If the track is already queued, the id of the job it
+// was queued with is returned.
+func (queue *retryQueue) Enqueue(srcURL url.URL, tags []string) (int64, bool) {
+	queue.Lock()
+	defer queue.Unlock()
+	if job, ok := queue.pending[srcURL.String()]; ok {
+		return job, true
+	}
+	select {
+	case queue.jobs <- retryJob{queue.lastJob + 1, srcURL, tags}:
+		queue.lastJob++
+		queue.pending[srcURL.String()] = queue.lastJob
+		return queue.lastJob, true
+	default:
+		return 0, false
+	}
+}
+
+// Len returns the amount of tracks waiting to be retried
+func (queue *retryQueue) Len() int {
+	queue.Lock()
+	defer queue.Unlock()
+	return len(queue.pending)
+}
+
+// Pending returns the id of the job of the track with the given url if it is
+// waiting to be retried
+func (queue *retryQueue) Pending(srcURL string) (job int64, ok bool) {
+	queue.Lock()
+	defer queue.Unlock()
+	job, ok = queue.pending[srcURL]
+	return
+}
+
+// Result returns the outcome of the job, where `done` is false if the job is
+// still waiting to be retried, and `ok` is false if the job is unknown or its
+// outcome has been forgotten
+func (queue *retryQueue) Result(job int64) (result retryResult, done bool, ok bool) {
+	queue.Lock()
+	defer queue.Unlock()
+	if result, ok = queue.results[job]; ok {
+		return result, true, true
+	}
+	for _, pending := range queue.pending {
+		if pending == job {
+			return retryResult{}, false, true
+		}
+	}
+	return retryResult{}, false, false
+}
+
+// finish removes the track from the pending tracks and keeps the outcome of
+// the job
+func (queue *retryQueue) finish(job retryJob, result retryResult) {
+	queue.Lock()
+	defer queue.Unlock()
+	delete(queue.pending, job.srcURL.String())
+	if len(queue.finished) >= retryResultsSize {
+		delete(queue.results, queue.finished[0])
+		queue.finished = queue.finished[1:]
+	}
+	queue.results[job.id] = result
+	queue.finished = append(queue.finished, job.id)
+}
+
+// Close cancels all pending retries and waits for the worker to stop
+func (queue *retryQueue) Close() {
+	queue.cancel()
+	<-queue.done
+}
+
+// retryFetches retries the tracks in the queue one by one until the queue is
+// closed
+func (server *Server) retryFetches(queue *retryQueue) {
+	defer close(queue.done)
+	for {
+		select {
+		case <-queue.ctx.Done():
+			server.logger.Info("stopping retrying fetches of tracks")
+			return
+		case job := <-queue.jobs:
+			queue.finish(job, server.retryFetch(queue, job))
+		}
+	}
+}
+
+// retryFetch fetches the igc file of the track with exponential backoff, and
+// registers the track if the fetch succeeds before the retries run out
+func (server *Server) retryFetch(queue *retryQueue, job retryJob) retryResult {
+	srcURL := job.srcURL
+	logger := server.logger.WithField("url", srcURL.String())
+
+	backoff := queue.backoff
+	var lastErr *fetchError
+	for attempt := 1; attempt <= queue.retries; attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-queue.ctx.Done():
+			timer.Stop()
+			logger.Info("cancelled retrying fetch of track")
+			return retryResult{err: &fetchError{http.StatusServiceUnavailable, "retrying fetch of track was cancelled", false}}
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		attemptlog := logger.WithField("attempt", attempt)
+		content, ferr := server.downloadIGC(queue.ctx, attemptlog, srcURL.String())
+		if ferr != nil {
+			if !ferr.temporary {
+				attemptlog.WithField("error", ferr).Info("giving up retrying fetch of track")
+				return retryResult{err: ferr}
+			}
+			lastErr = ferr
+			continue
+		}
+		trackMeta, err := server.registerTrack(attemptlog, srcURL, content, job.tags)
+		if err != nil {
+			attemptlog.WithField("error", err).Info("unable to register track after retrying fetch")
+			return retryResult{trackMeta, err}
+		}
+		attemptlog.WithField("trackmeta", trackMeta).Info("registered track after retrying fetch")
+		return retryResult{trackMeta, nil}
+	}
+	logger.WithField("retries", queue.retries).Warn("giving up retrying fetch of track after all retries failed")
+	return retryResult{err: lastErr}
+}
+
+// trackPendingHandler responds with the outcome of a track which is retried
+// in the background, in the same way as a registration would have been
+// responded to once the retries are finished
+func (server *Server) trackPendingHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to get pending track")
+
+	vars := mux.Vars(r)
+	jobStr, _ := vars["job"]
+	job, err := strconv.ParseInt(jobStr, 10, 64)
+	if err != nil {
+		logger.WithField("job", jobStr).Info("job must be a valid number")
+		writeJSONError(w, http.StatusBadRequest, "invalid job")
+		return
+	}
+	joblog := logger.WithField("job", job)
+	if server.retryQueue == nil {
+		joblog.Info("fetches of tracks are not retried")
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	result, done, ok := server.retryQueue.Result(job)
+	if !ok {
+		joblog.Info("unable to find job")
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	} else if !done {
+		joblog.Info("track of job is still pending")
+		writePendingTrack(w, job)
+		return
+	}
+	if ferr, ok := result.err.(*fetchError); ok {
+		joblog.WithField("error", ferr).Info("responding with reason retrying fetch of track failed")
+		writeJSONError(w, ferr.status, ferr.msg)
+		return
+	}
+	server.writeRegisteredTrack(w, joblog, result.trackMeta, result.err)
+}
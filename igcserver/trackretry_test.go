@@ -0,0 +1,214 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyTransport fails all requests while it is down
+type flakyTransport struct {
+	next http.RoundTripper
+	down int32
+}
+
+func (t *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&t.down) != 0 {
+		return nil, errors.New("host is down")
+	}
+	return t.next.RoundTrip(r)
+}
+
+// Convenience function to create a server which fetches tracks through a
+// transport which is initially down
+func makeFlakyTestServers(opts ...Option) (Server, *httptest.Server, *flakyTransport) {
+	igcFileServer := makeIgcFileServer()
+	igcFileServer.Start()
+
+	transport := &flakyTransport{igcFileServer.Client().Transport, 1}
+	trackMetasMap := NewTrackMetasMap()
+	ticker := NewTickerDummy(2, &trackMetasMap)
+	webhooks := NewWebhooksMap()
+	server := NewServer(&http.Client{Transport: transport}, &trackMetasMap, &ticker, &webhooks, opts...)
+	return server, igcFileServer, transport
+}
+
+// Convenience function to register a track and decode the id of the job
+// retrying it
+func postTrack(server *Server, url string) (*httptest.ResponseRecorder, int64) {
+	body, _ := json.Marshal(map[string]string{"url": url})
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader(body))
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	var result struct {
+		Job int64 `json:"job"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &result)
+	return res, result.Job
+}
+
+// Convenience function to get the status of GET /track/pending/<job> and
+// the id of the track it responds with
+func getPendingTrack(server *Server, job int64) (int, TrackID) {
+	req := httptest.NewRequest("GET", fmt.Sprintf("/track/pending/%d", job), nil)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+
+	var result struct {
+		ID TrackID `json:"id"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &result)
+	return res.Code, result.ID
+}
+
+// Convenience function to wait for the job to finish, returning the status
+// and id it finished with
+func waitPendingTrack(t *testing.T, server *Server, job int64) (int, TrackID) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, id := getPendingTrack(server, job)
+		if status != http.StatusAccepted {
+			return status, id
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job '%d' to finish", job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Convenience function to get the status of GET /track/<id>
+func getTrackStatus(server *Server, id TrackID) int {
+	req := httptest.NewRequest("GET", fmt.Sprintf("/track/%d", id), nil)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	return res.Code
+}
+
+// Test that a track is registered in the background when the remote host
+// comes back up
+func TestTrackRetryRegistersTrack(t *testing.T) {
+	server, fileserver, transport := makeFlakyTestServers(WithFetchRetries(50, time.Millisecond))
+	defer fileserver.Close()
+	defer server.Close()
+
+	res, job := postTrack(&server, fileserver.URL+"/test.igc")
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("expected status '%d', got '%d'", http.StatusAccepted, res.Code)
+	}
+	if status, _ := getPendingTrack(&server, job); status != http.StatusAccepted {
+		t.Errorf("expected pending track to give '%d', got '%d'", http.StatusAccepted, status)
+	}
+
+	// Registering the same track while it is pending gives the same job
+	res, again := postTrack(&server, fileserver.URL+"/test.igc")
+	if res.Code != http.StatusAccepted || again != job {
+		t.Errorf("expected pending track to give '%d' with job '%d', got '%d' with job '%d'", http.StatusAccepted, job, res.Code, again)
+	}
+
+	atomic.StoreInt32(&transport.down, 0)
+
+	status, id := waitPendingTrack(t, &server, job)
+	if status != http.StatusOK {
+		t.Fatalf("expected job to finish with '%d', got '%d'", http.StatusOK, status)
+	}
+	if status := getTrackStatus(&server, id); status != http.StatusOK {
+		t.Errorf("expected registered track '%d' to give '%d', got '%d'", id, http.StatusOK, status)
+	}
+}
+
+// Test that the job gives the id of the track the content was deduplicated
+// to, which is not the id the track would have gotten
+func TestTrackRetryDuplicateContent(t *testing.T) {
+	server, fileserver, transport := makeFlakyTestServers(WithFetchRetries(50, time.Millisecond), WithContentDedup(true))
+	defer fileserver.Close()
+	defer server.Close()
+
+	atomic.StoreInt32(&transport.down, 0)
+	body, _ := json.Marshal(map[string]string{"url": fileserver.URL + "/copy.igc"})
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader(body))
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	var existing struct {
+		ID TrackID `json:"id"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &existing)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status '%d', got '%d'", http.StatusOK, res.Code)
+	}
+
+	atomic.StoreInt32(&transport.down, 1)
+	res, job := postTrack(&server, fileserver.URL+"/test.igc")
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("expected status '%d', got '%d'", http.StatusAccepted, res.Code)
+	}
+	atomic.StoreInt32(&transport.down, 0)
+
+	status, id := waitPendingTrack(t, &server, job)
+	if status != http.StatusForbidden || id != existing.ID {
+		t.Errorf("expected job to finish with '%d' and id '%d', got '%d' and id '%d'", http.StatusForbidden, existing.ID, status, id)
+	}
+}
+
+// Test that a track is abandoned when all retries fail
+func TestTrackRetryGivesUp(t *testing.T) {
+	server, fileserver, _ := makeFlakyTestServers(WithFetchRetries(2, time.Millisecond))
+	defer fileserver.Close()
+	defer server.Close()
+
+	res, job := postTrack(&server, fileserver.URL+"/test.igc")
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("expected status '%d', got '%d'", http.StatusAccepted, res.Code)
+	}
+
+	if status, _ := waitPendingTrack(t, &server, job); status != http.StatusBadRequest {
+		t.Errorf("expected abandoned track to give '%d', got '%d'", http.StatusBadRequest, status)
+	}
+	if _, ok := server.retryQueue.Pending(fileserver.URL + "/test.igc"); ok {
+		t.Error("expected track to stop being pending after all retries failed")
+	}
+	if status, _ := getPendingTrack(&server, job+1); status != http.StatusNotFound {
+		t.Errorf("expected unknown job to give '%d', got '%d'", http.StatusNotFound, status)
+	}
+}
+
+// Test that failed fetches are not retried unless enabled
+func TestTrackRetryDisabled(t *testing.T) {
+	server, fileserver, _ := makeFlakyTestServers()
+	defer fileserver.Close()
+
+	res, _ := postTrack(&server, fileserver.URL+"/test.igc")
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected status '%d', got '%d'", http.StatusBadRequest, res.Code)
+	}
+}
+
+// Test that closing the server cancels pending retries
+func TestTrackRetryClose(t *testing.T) {
+	server, fileserver, _ := makeFlakyTestServers(WithFetchRetries(3, time.Hour))
+	defer fileserver.Close()
+
+	res, _ := postTrack(&server, fileserver.URL+"/test.igc")
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("expected status '%d', got '%d'", http.StatusAccepted, res.Code)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected closing the server to cancel pending retries")
+	}
+}
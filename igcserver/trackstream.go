@@ -0,0 +1,138 @@
+package igcserver
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// streamBufferSize is the amount of messages which are queued for a
+	// client before new messages are dropped
+	streamBufferSize = 16
+
+	// streamWriteTimeout is the maximum duration of sending a message to a
+	// client
+	streamWriteTimeout = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	// The api is public, so connections are allowed from any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// TrackStreamMsg is sent to all connected clients when a track is registered
+type TrackStreamMsg struct {
+	ID TrackID `json:"id"`
+	TrackMeta
+}
+
+// trackHub keeps track of the clients connected to the stream of new tracks
+type trackHub struct {
+	sync.Mutex
+	clients map[chan []byte]struct{}
+	logger  *log.Logger
+}
+
+// newTrackHub creates a new hub without any clients, which logs to the logger
+func newTrackHub(logger *log.Logger) *trackHub {
+	return &trackHub{clients: make(map[chan []byte]struct{}), logger: logger}
+}
+
+// Subscribe adds a new client which receives all broadcasted messages
+func (hub *trackHub) Subscribe() chan []byte {
+	hub.Lock()
+	defer hub.Unlock()
+	client := make(chan []byte, streamBufferSize)
+	hub.clients[client] = struct{}{}
+	return client
+}
+
+// Unsubscribe removes the client and closes its channel
+func (hub *trackHub) Unsubscribe(client chan []byte) {
+	hub.Lock()
+	defer hub.Unlock()
+	if _, ok := hub.clients[client]; ok {
+		delete(hub.clients, client)
+		close(client)
+	}
+}
+
+// Len returns the amount of connected clients
+func (hub *trackHub) Len() int {
+	hub.Lock()
+	defer hub.Unlock()
+	return len(hub.clients)
+}
+
+// Broadcast sends the message to all clients without blocking, where the
+// message is dropped for clients which are too slow to keep up
+func (hub *trackHub) Broadcast(msg []byte) {
+	hub.Lock()
+	defer hub.Unlock()
+	for client := range hub.clients {
+		select {
+		case client <- msg:
+		default:
+			hub.logger.Warn("dropping message to slow track stream client")
+		}
+	}
+}
+
+// BroadcastTrack sends the track meta to all clients
+func (hub *trackHub) BroadcastTrack(meta TrackMeta) {
+	msg, err := json.Marshal(TrackStreamMsg{meta.ID, meta})
+	if err != nil {
+		hub.logger.WithField("error", err).Error("unable to encode track stream message")
+		return
+	}
+	hub.Broadcast(msg)
+}
+
+// trackStreamHandler upgrades the request to a websocket, which receives a
+// message with the metadata of every track registered while it is connected
+func (server *Server) trackStreamHandler(w http.ResponseWriter, r *http.Request) {
+	logger := newReqLogger(r)
+
+	logger.Info("processing request to stream new tracks")
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded with an error
+		logger.WithField("error", err).Info("unable to upgrade to websocket")
+		return
+	}
+	client := server.hub.Subscribe()
+
+	// Send messages until the client is unsubscribed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range client {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				logger.WithField("error", err).Info("unable to send message to track stream client")
+				// Closing the connection makes the reader below stop
+				conn.Close()
+				return
+			}
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}()
+
+	// Read until the connection is closed, which is needed to process control
+	// messages from the client
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			logger.WithField("error", err).Info("track stream client disconnected")
+			break
+		}
+	}
+
+	server.hub.Unsubscribe(client)
+	<-done
+	conn.Close()
+}
@@ -0,0 +1,87 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Convenience function to wait until the hub has the expected amount of clients
+func waitForClients(t *testing.T, hub *trackHub, expt int) {
+	deadline := time.Now().Add(time.Second)
+	for hub.Len() != expt {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected hub to have '%d' clients, got '%d'", expt, hub.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test that connected clients receive new tracks and are removed when they
+// disconnect
+func TestTrackStreamBroadcastsNewTracks(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	apiserver := httptest.NewServer(&server)
+	defer apiserver.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(apiserver.URL, "http") + "/ws/tracks"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("unable to connect to track stream: %s", err)
+	}
+	defer conn.Close()
+	waitForClients(t, server.hub, 1)
+
+	body := fmt.Sprintf("{\"url\":\"%s\"}", fileserver.URL+"/test.igc")
+	res, err := apiserver.Client().Post(apiserver.URL+"/track", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatalf("unable to register track: %s", err)
+	}
+	var data map[string]TrackID
+	json.NewDecoder(res.Body).Decode(&data)
+	res.Body.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg TrackStreamMsg
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("unable to read message from track stream: %s", err)
+	}
+	if msg.ID != data["id"] || msg.Pilot == "" {
+		t.Errorf("expected message about track '%d', got '%v'", data["id"], msg)
+	}
+
+	conn.Close()
+	waitForClients(t, server.hub, 0)
+}
+
+// Test that broadcasting never blocks on clients which don't receive messages
+func TestTrackHubBroadcastNonBlocking(t *testing.T) {
+	hub := newTrackHub(log.StandardLogger())
+	client := hub.Subscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*streamBufferSize; i++ {
+			hub.Broadcast([]byte("msg"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("broadcasting blocked on a slow client")
+	}
+	if len(client) != streamBufferSize {
+		t.Errorf("expected '%d' queued messages, got '%d'", streamBufferSize, len(client))
+	}
+	hub.Unsubscribe(client)
+}
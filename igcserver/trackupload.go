@@ -0,0 +1,109 @@
+package igcserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// uploadScheme is the scheme of the synthetic source urls of uploaded
+	// tracks, eg. `upload://<sha256 of the file>`
+	uploadScheme = "upload"
+
+	// uploadFormField is the name of the field which contains the igc file in
+	// multipart uploads
+	uploadFormField = "file"
+)
+
+var (
+	// errUploadTooLarge is returned if an uploaded igc file is larger than
+	// the maximum size of tracks
+	errUploadTooLarge = errors.New("uploaded igc file is too large")
+	// errUploadMissingFile is returned if a multipart upload has no file
+	errUploadMissingFile = errors.New("missing igc file in field 'file'")
+)
+
+// uploadSrcURL returns the synthetic source url of an uploaded igc file,
+// which is derived from the content such that uploading the same file twice
+// gives the same url
+func uploadSrcURL(content []byte) url.URL {
+	hash := sha256.Sum256(content)
+	return url.URL{Scheme: uploadScheme, Host: hex.EncodeToString(hash[:])}
+}
+
+// readLimited reads at most max bytes, and fails if there are more
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > max {
+		return nil, errUploadTooLarge
+	}
+	return content, nil
+}
+
+// readUpload reads the igc file of the request, which is either the entire
+// body or the `file` field of a multipart form
+func (server *Server) readUpload(r *http.Request, mediaType string) ([]byte, error) {
+	if mediaType != "multipart/form-data" {
+		return readLimited(r.Body, server.maxTrackSize)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errUploadMissingFile
+		} else if err != nil {
+			return nil, err
+		}
+		if part.FormName() == uploadFormField {
+			return readLimited(part, server.maxTrackSize)
+		}
+	}
+}
+
+// trackUploadHandler registers the igc file uploaded in the request, which is
+// either sent as `application/octet-stream` or as the `file` field of a
+// `multipart/form-data` form. Uploading a file which is already registered
+// is rejected with 403 and the id of the registered track.
+func (server *Server) trackUploadHandler(w http.ResponseWriter, r *http.Request, logger *log.Entry, mediaType string) {
+	logger = logger.WithField("media_type", mediaType)
+	logger.Info("processing upload of igc file")
+
+	content, err := server.readUpload(r, mediaType)
+	switch err {
+	case nil:
+	case errUploadTooLarge:
+		logger.WithField("max_size", server.maxTrackSize).Info("uploaded igc file is too large")
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("igc file is larger than the maximum of %d bytes", server.maxTrackSize))
+		return
+	case errUploadMissingFile:
+		logger.Info("multipart upload is missing the igc file")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	default:
+		logger.WithField("error", err).Info("unable to read uploaded igc file")
+		writeJSONError(w, http.StatusBadRequest, "unable to read uploaded igc file")
+		return
+	}
+
+	srcURL := uploadSrcURL(content)
+	trackMeta, err := server.registerTrack(logger.WithField("url", srcURL.String()), srcURL, content, nil)
+	// The url is derived from the content, hence an existing url means that
+	// the same file was uploaded before
+	if err == ErrTrackAlreadyExists {
+		err = errDuplicateContent
+	}
+	server.writeRegisteredTrack(w, logger, trackMeta, err)
+}
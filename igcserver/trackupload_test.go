@@ -0,0 +1,131 @@
+package igcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Convenience function to create a multipart form with the content as the
+// given field, returning the body and its content type
+func makeMultipartUpload(t *testing.T, field string, content []byte) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreateFormFile(field, "track.igc")
+	if err != nil {
+		t.Fatalf("unable to create multipart form: %s", err)
+	}
+	part.Write(content)
+	mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+// Test that an igc file can be uploaded directly, and that uploading the
+// same file again is rejected with the id of the registered track
+func TestIgcServerUploadTrack(t *testing.T) {
+	server, fileserver := makeTestServers()
+	defer fileserver.Close()
+
+	content, err := ioutil.ReadFile("../assets/test.igc")
+	if err != nil {
+		t.Fatalf("unable to read test track: %s", err)
+	}
+
+	req := httptest.NewRequest("POST", "/track", bytes.NewReader(content))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected upload to return 200, got '%d': %s", code, res.Body)
+	}
+	var data map[string]TrackID
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	id := data["id"]
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/track/%d", id), nil)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 200 {
+		t.Fatalf("expected uploaded track to be registered, got '%d'", code)
+	}
+	var meta TrackMeta
+	if err := json.Unmarshal(res.Body.Bytes(), &meta); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if meta.Pilot != "Miguel Angel Gordillo" {
+		t.Errorf("expected pilot of uploaded track to be 'Miguel Angel Gordillo', got '%s'", meta.Pilot)
+	}
+	if expt := uploadSrcURL(content); meta.TrackSrcURL != expt.String() || !strings.HasPrefix(meta.TrackSrcURL, "upload://") {
+		t.Errorf("expected source url of uploaded track to be '%s', got '%s'", expt.String(), meta.TrackSrcURL)
+	}
+
+	// Uploading the same file as a multipart form is a duplicate
+	body, contentType := makeMultipartUpload(t, "file", content)
+	req = httptest.NewRequest("POST", "/track", body)
+	req.Header.Set("Content-Type", contentType)
+	res = httptest.NewRecorder()
+
+	server.ServeHTTP(res, req)
+
+	if code := res.Result().StatusCode; code != 403 {
+		t.Fatalf("expected duplicate upload to return 403, got '%d'", code)
+	}
+	var dup map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &dup); err != nil {
+		t.Errorf("received response body: '%s'", res.Body)
+		t.Fatalf("failed when trying to decode body as json")
+	}
+	if existing, ok := dup["id"].(float64); !ok || TrackID(existing) != id {
+		t.Errorf("expected duplicate upload to report the id '%d', got '%v'", id, dup["id"])
+	}
+	if n, _ := server.tracks.Len(); n != 1 {
+		t.Errorf("expected one registered track, got '%d'", n)
+	}
+}
+
+// Test that invalid uploads are rejected
+func TestIgcServerUploadTrackBad(t *testing.T) {
+	server, fileserver := makeTestServers(WithMaxTrackSize(1 << 10))
+	defer fileserver.Close()
+
+	noFile, noFileType := makeMultipartUpload(t, "other", []byte("HFDTE020299"))
+	tooLarge, tooLargeType := makeMultipartUpload(t, "file", bytes.Repeat([]byte("B"), 2<<10))
+	for _, data := range []struct {
+		contentType string
+		body        *bytes.Buffer
+		msg         string
+	}{
+		{"application/octet-stream", bytes.NewBufferString("not an igc file"), "unable to parse igc content"},
+		{"application/octet-stream", bytes.NewBuffer(bytes.Repeat([]byte("B"), 2<<10)), "igc file is larger than the maximum of 1024 bytes"},
+		{tooLargeType, tooLarge, "igc file is larger than the maximum of 1024 bytes"},
+		{noFileType, noFile, "missing igc file in field 'file'"},
+		{"multipart/form-data", bytes.NewBufferString("--x--"), "unable to read uploaded igc file"},
+	} {
+		req := httptest.NewRequest("POST", "/track", data.body)
+		req.Header.Set("Content-Type", data.contentType)
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, req)
+
+		if code := res.Result().StatusCode; code != 400 {
+			t.Errorf("expected upload as '%s' to return 400, got '%d'", data.contentType, code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body["error"] != data.msg {
+			t.Errorf("expected upload as '%s' to be rejected with '%s', got '%s'", data.contentType, data.msg, res.Body)
+		}
+	}
+}
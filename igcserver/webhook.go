@@ -27,6 +27,7 @@ var (
 type Webhooks interface {
 	Trigger()
 	Get(id WebhookID) (WebhookInfo, error)
+	GetAll() ([]WebhookInfo, error)
 	Append(webhook WebhookInfo) error
 	Delete(id WebhookID) (WebhookInfo, error)
 }
@@ -36,9 +37,23 @@ type WebhookInfo struct {
 	ID            WebhookID `json:"-" bson:"id"`
 	URLstr        string    `json:"webhookURL" bson:"webhookURL"`
 	TriggerRate   uint      `json:"minTriggerValue" bson:"minTriggerValue"`
+	Secret        string    `json:"secret,omitempty" bson:"secret,omitempty"`
 	LastTriggered time.Time `json:"-" bson:"lastTriggered"`
 }
 
+// logFields describes the webhook in logs without its secret, which must
+// never be logged as it signs the messages to the webhook
+func (webhook WebhookInfo) logFields() log.Fields {
+	fields := log.Fields{
+		"webhook":         webhook.ID,
+		"minTriggerValue": webhook.TriggerRate,
+	}
+	if webhookURL, err := url.Parse(webhook.URLstr); err == nil {
+		fields["host"] = webhookURL.Host
+	}
+	return fields
+}
+
 // WebhookID is a unique id for a track
 type WebhookID uint32
 
@@ -53,54 +68,56 @@ func NewWebhookID(v []byte) WebhookID {
 // WEBHOOK API //
 // ----------- //
 
+// webhookRegHandler registers a webhook which is notified when new tracks are
+// added and responds with the id of the webhook as plain text
 func (server *Server) webhookRegHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
 	logger.Info("processing request to register webhook")
 
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, server.maxBodySize))
 	dec.DisallowUnknownFields()
 
 	webhook := WebhookInfo{TriggerRate: 1}
 	if err := dec.Decode(&webhook); err != nil {
 		logger.WithField("error", err).Info("unable to decode request body")
-		http.Error(w, "invalid json object", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid json object")
 		return
 	}
 	reqURL, err := url.Parse(webhook.URLstr)
 	if err != nil {
 		logger.WithField("error", err).Info("unable to parse url")
-		http.Error(w, "invalid url", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid url")
+		return
+	}
+	// The url has to be absolute to be able to send notifications to it
+	if (reqURL.Scheme != "http" && reqURL.Scheme != "https") || reqURL.Host == "" {
+		logger.WithField("url", reqURL).Info("url is not an absolute http url")
+		writeJSONError(w, http.StatusBadRequest, "invalid url")
 		return
 	}
 	if webhook.TriggerRate < 1 {
-		logger.WithField("error", err).Info("invalid trigger value")
-		http.Error(w, "invalid trigger value", http.StatusBadRequest)
+		logger.WithField("minTriggerValue", webhook.TriggerRate).Info("invalid trigger value")
+		writeJSONError(w, http.StatusBadRequest, "invalid trigger value")
 		return
 	}
 	webhook.ID = NewWebhookID([]byte(reqURL.String()))
 	err = server.webhooks.Append(webhook)
 	if err == ErrWebhookAlreadyExists {
-		logger.WithFields(log.Fields{
-			"webhook": webhook,
-		}).Info("request attempted to add duplicate webhook")
-		http.Error(w, "webhook already exists", http.StatusForbidden)
+		logger.WithFields(webhook.logFields()).Info("request attempted to add duplicate webhook")
+		writeJSONError(w, http.StatusForbidden, "webhook already exists")
 		return
 	} else if err != nil {
-		logger.WithFields(log.Fields{
-			"webhook": webhook,
-			"error":   err,
-		}).Info("unable to add webhook")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		logger.WithFields(webhook.logFields()).WithField("error", err).Info("unable to add webhook")
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
-	logger.WithFields(log.Fields{
-		"webhook": webhook,
-	}).Info("added webhook")
+	logger.WithFields(webhook.logFields()).Info("added webhook")
 
 	io.WriteString(w, fmt.Sprintf("%d", webhook.ID))
 }
 
+// webhookGetHandler responds with the details of a specific webhook
 func (server *Server) webhookGetHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
@@ -111,28 +128,32 @@ func (server *Server) webhookGetHandler(w http.ResponseWriter, r *http.Request)
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		logger.WithField("id", idStr).Info("id must be a valid number")
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 	idlog := logger.WithField("id", id)
 	webhook, err := server.webhooks.Get(WebhookID(id))
 	if err == ErrWebhookNotFound {
 		idlog.Info("unable to find webhook")
-		http.Error(w, "content not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "content not found")
 		return
 	} else if err != nil {
 		idlog.WithField("error", err).Info("error when getting webhook of id")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
-	logger.WithFields(log.Fields{
-		"webhook": webhook,
-	}).Info("responding with info about webhook")
+	logger.WithFields(webhook.logFields()).Info("responding with info about webhook")
+
+	// The secret is only known by the owner of the webhook, hence it is never
+	// sent back
+	webhook.Secret = ""
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(webhook)
 }
 
+// webhookDeleteHandler removes a specific webhook and responds with the details
+// of the deleted webhook
 func (server *Server) webhookDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	logger := newReqLogger(r)
 
@@ -143,23 +164,25 @@ func (server *Server) webhookDeleteHandler(w http.ResponseWriter, r *http.Reques
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		logger.WithField("id", idStr).Info("id must be a valid number")
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 	idlog := logger.WithField("id", id)
 	webhook, err := server.webhooks.Delete(WebhookID(id))
 	if err == ErrWebhookNotFound {
-		idlog.Info("unable to find metadata of id")
-		http.Error(w, "content not found", http.StatusNotFound)
+		idlog.Info("unable to find webhook")
+		writeJSONError(w, http.StatusNotFound, "content not found")
 		return
 	} else if err != nil {
 		idlog.WithField("error", err).Info("error when deleting webhook of id")
-		http.Error(w, "internal server error occurred", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error occurred")
 		return
 	}
-	idlog.WithFields(log.Fields{
-		"webhook": webhook,
-	}).Info("responding with info about deleted webhook")
+	idlog.WithFields(webhook.logFields()).Info("responding with info about deleted webhook")
+
+	// The secret is only known by the owner of the webhook, hence it is never
+	// sent back
+	webhook.Secret = ""
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(webhook)
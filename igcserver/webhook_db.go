@@ -2,12 +2,16 @@ package igcserver
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -23,6 +27,14 @@ type WebhooksDB struct {
 	trigger    chan bool
 }
 
+// WebhookRetry configures how many times delivering a message to a webhook is
+// attempted, where the delay before each retry starts at Delay and is doubled
+// for every attempt
+type WebhookRetry struct {
+	Attempts int
+	Delay    time.Duration
+}
+
 // DiscordMsg is a webhook message that can be sent to discord
 type DiscordMsg struct {
 	Content string `json:"content"`
@@ -42,9 +54,58 @@ func NewDiscordMsg(latest time.Time, ids []TrackID, processing time.Duration) Di
 	}
 }
 
-// NewWebhooksDB creates a new mutex and mapping from ID to WebhookInfo
-func NewWebhooksDB(session *mgo.Session, httpClient *http.Client) WebhooksDB {
+// webhookUpdates serializes the updates of each webhook, such that the same
+// tracks are never delivered by several updates which read the webhook before
+// either of them marked it as triggered
+type webhookUpdates struct {
+	sync.Mutex
+	running map[WebhookID]bool
+	again   map[WebhookID]bool
+}
+
+// newWebhookUpdates creates a set of webhooks which are being updated
+func newWebhookUpdates() *webhookUpdates {
+	return &webhookUpdates{
+		running: make(map[WebhookID]bool),
+		again:   make(map[WebhookID]bool),
+	}
+}
+
+// start runs the update of the webhook in the background. If the webhook is
+// already being updated, eg. while a delivery is retried, the update is
+// instead run once more after the running update is done, as tracks may have
+// been added after it read them. Any amount of triggers in the meantime are
+// coalesced into that one update.
+func (updates *webhookUpdates) start(id WebhookID, update func()) {
+	updates.Lock()
+	defer updates.Unlock()
+	if updates.running[id] {
+		updates.again[id] = true
+		return
+	}
+	updates.running[id] = true
+	go func() {
+		for {
+			update()
+			updates.Lock()
+			if !updates.again[id] {
+				delete(updates.running, id)
+				updates.Unlock()
+				return
+			}
+			delete(updates.again, id)
+			updates.Unlock()
+		}
+	}()
+}
+
+// NewWebhooksDB creates a new mutex and mapping from ID to WebhookInfo, where
+// failed deliveries to webhooks are retried in the background as configured.
+// New tracks are read through the ticker, hence the tracks don't have to be
+// stored in the same database as the webhooks.
+func NewWebhooksDB(session *mgo.Session, ticker Ticker, httpClient *http.Client, retry WebhookRetry) WebhooksDB {
 	trigger := make(chan bool)
+	updates := newWebhookUpdates()
 
 	go func() {
 		conn := session.Copy()
@@ -54,8 +115,10 @@ func NewWebhooksDB(session *mgo.Session, httpClient *http.Client) WebhooksDB {
 			iter := conn.DB("").C(webhookCollection).Find(nil).Iter()
 			var webhook WebhookInfo
 			for iter.Next(&webhook) {
-				log.WithField("webhook", webhook).Info("checking if update is needed for webhook")
-				go updateWebhook(session.Copy(), httpClient, webhook)
+				id := webhook.ID
+				updates.start(id, func() {
+					updateWebhookDB(session, ticker, httpClient, retry, id)
+				})
 			}
 			iter.Close()
 		}
@@ -68,42 +131,129 @@ func NewWebhooksDB(session *mgo.Session, httpClient *http.Client) WebhooksDB {
 	}
 }
 
-func updateWebhook(conn *mgo.Session, httpClient *http.Client, webhook WebhookInfo) {
+// signWebhookMsg computes the signature of a message sent to a webhook, which
+// is the hex encoded HMAC-SHA256 of the body using the secret of the webhook
+func signWebhookMsg(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhookMsg posts the message to the given url as json, and returns an
+// error if the request failed or the receiver did not accept the message. If
+// the secret is not empty, the message is signed in the `X-Signature` header.
+func sendWebhookMsg(httpClient *http.Client, url, secret string, msg interface{}) error {
+	b := new(bytes.Buffer)
+	if err := json.NewEncoder(b).Encode(msg); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Signature", signWebhookMsg(secret, b.Bytes()))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		webhookDeliveries.WithLabelValues("failure").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		webhookDeliveries.WithLabelValues("failure").Inc()
+		return fmt.Errorf("webhook responded with status '%s'", resp.Status)
+	}
+	webhookDeliveries.WithLabelValues("success").Inc()
+	return nil
+}
+
+// deliverWebhookMsg sends the message to the webhook, and retries with
+// exponential backoff until it is delivered or the attempts run out. It blocks
+// until the message is delivered or given up, hence it should be called in
+// the background.
+func deliverWebhookMsg(httpClient *http.Client, url, secret string, msg DiscordMsg, retry WebhookRetry, logger *log.Entry) (err error) {
+	delay := retry.Delay
+	for attempt := 1; ; attempt++ {
+		if err = sendWebhookMsg(httpClient, url, secret, msg); err == nil {
+			return nil
+		}
+		if attempt >= retry.Attempts {
+			break
+		}
+		logger.WithFields(log.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+			"error":   err,
+		}).Info("retrying delivery to webhook")
+		time.Sleep(delay)
+		delay *= 2
+	}
+	webhookDeliveryFailures.Inc()
+	return err
+}
+
+// updateWebhookDB reads the webhook of the id from the database and updates
+// it, where the webhook is read anew for every update to get the time it was
+// last triggered
+func updateWebhookDB(session *mgo.Session, ticker Ticker, httpClient *http.Client, retry WebhookRetry, id WebhookID) {
+	conn := session.Copy()
 	defer conn.Close()
+	webhooks := conn.DB("").C(webhookCollection)
+
+	var webhook WebhookInfo
+	if err := webhooks.Find(bson.M{"id": id}).One(&webhook); err == mgo.ErrNotFound {
+		log.WithField("webhook", id).Info("webhook was deleted before it was updated")
+		return
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"webhook": id,
+			"error":   err,
+		}).Error("unable to get webhook to update")
+		return
+	}
+	log.WithFields(webhook.logFields()).Info("checking if update is needed for webhook")
+	updateWebhook(ticker, httpClient, retry, webhook, func(webhook WebhookInfo) error {
+		return webhooks.Update(bson.M{"id": webhook.ID}, webhook)
+	})
+}
 
+// updateWebhook notifies the webhook if enough tracks have been added since
+// it was last triggered, and stores the webhook as triggered. The webhook is
+// only marked as triggered if the notification was delivered, so that failed
+// notifications are retried on the next trigger.
+func updateWebhook(ticker Ticker, httpClient *http.Client, retry WebhookRetry, webhook WebhookInfo, store func(WebhookInfo) error) {
 	start := time.Now()
 
-	var trackMetas []TrackMeta
-	err := conn.DB("").C(trackCollection).
-		Find(bson.M{"timestamp": bson.M{"$gt": webhook.LastTriggered}}).
-		Sort("timestamp").
-		All(&trackMetas)
+	report, err := ticker.GetReportAfter(webhook.LastTriggered, 0)
 
-	weblog := log.WithField("webhook", webhook)
+	weblog := log.WithFields(webhook.logFields())
 
-	if err != nil {
+	if err != nil && err != ErrNoTracksFound {
 		weblog.WithField("error", err).Error("unable to get track metas after given timestamp")
+		return
 	}
 
-	if len(trackMetas) >= int(webhook.TriggerRate) {
-		laststamp := trackMetas[len(trackMetas)-1].Timestamp
-		ids := make([]TrackID, len(trackMetas))
-		for i, meta := range trackMetas {
-			ids[i] = meta.ID
-		}
+	if ids := report.Tracks; len(ids) >= int(webhook.TriggerRate) && len(ids) > 0 {
+		laststamp := report.End
 		processing := time.Since(start)
 		msg := NewDiscordMsg(laststamp, ids, processing)
 
-		b := new(bytes.Buffer)
-		json.NewEncoder(b).Encode(msg)
-
 		weblog.WithField("msg", msg).Info("sending update to webhook")
-		httpClient.Post(webhook.URLstr, "application/json", b)
+		if err := deliverWebhookMsg(httpClient, webhook.URLstr, webhook.Secret, msg, retry, weblog); err != nil {
+			weblog.WithField("error", err).Warn("unable to deliver update to webhook after all attempts")
+			return
+		}
 
 		// Update last triggered for current webhook
 		webhook.LastTriggered = laststamp
-		err = conn.DB("").C(webhookCollection).
-			Update(bson.M{"id": webhook.ID}, webhook)
+		if err := store(webhook); err != nil {
+			weblog.WithField("error", err).Error("unable to update last triggered timestamp of webhook")
+		}
 	} else {
 		weblog.Info("update not needed for webhook")
 	}
@@ -127,6 +277,16 @@ func (db *WebhooksDB) Get(id WebhookID) (webhook WebhookInfo, err error) {
 	return
 }
 
+// GetAll fetches all webhooks
+func (db *WebhooksDB) GetAll() (all []WebhookInfo, err error) {
+	conn := db.session.Copy()
+	defer conn.Close()
+	webhooks := conn.DB("").C(webhookCollection)
+
+	err = webhooks.Find(nil).Sort("id").All(&all)
+	return
+}
+
 // Append appends a track webhook and returns the given id
 func (db *WebhooksDB) Append(webhook WebhookInfo) (err error) {
 	conn := db.session.Copy()
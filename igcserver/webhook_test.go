@@ -1,9 +1,19 @@
 package igcserver
 
 import (
+	"encoding/json"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // Test that all returned ids from 'Append' are found when using 'Get'
@@ -54,7 +64,7 @@ func TestWebhooksGetConcurr(t *testing.T) {
 		wg.Add(1)
 		go func(webhooks *WebhooksMap, id WebhookID) {
 			if _, err := webhooks.Get(id); err != nil {
-				t.Fatalf("didn't find id '%d' in result of 'Get'", id)
+				t.Errorf("didn't find id '%d' in result of 'Get'", id)
 			}
 			wg.Done()
 		}(&webhooks, pureID)
@@ -103,6 +113,34 @@ func TestWebhookDeletionOfNonexisting(t *testing.T) {
 	}
 }
 
+// Test that webhook messages are delivered and that failures are reported
+func TestSendWebhookMsg(t *testing.T) {
+	received := make(chan DiscordMsg, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failing" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var msg DiscordMsg
+		json.NewDecoder(r.Body).Decode(&msg)
+		received <- msg
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	msg := NewDiscordMsg(time.Now(), []TrackID{1, 2}, time.Millisecond)
+	if err := sendWebhookMsg(receiver.Client(), receiver.URL+"/hook", "", msg); err != nil {
+		t.Fatalf("unable to deliver message to webhook: %s", err)
+	}
+	if got := <-received; got != msg {
+		t.Errorf("expected webhook to receive '%v', got '%v'", msg, got)
+	}
+
+	if err := sendWebhookMsg(receiver.Client(), receiver.URL+"/failing", "", msg); err == nil {
+		t.Errorf("expected delivery to a failing webhook to return an error")
+	}
+}
+
 // WebhooksMap contains a map to many WebhookInfo objects which are protected
 // by a RWMutex and indexed by a unique id
 type WebhooksMap struct {
@@ -136,6 +174,18 @@ func (db *WebhooksMap) Get(id WebhookID) (webhook WebhookInfo, err error) {
 	return
 }
 
+// GetAll fetches all webhooks sorted by id
+func (db *WebhooksMap) GetAll() ([]WebhookInfo, error) {
+	db.RLock()
+	defer db.RUnlock()
+	all := make([]WebhookInfo, 0, len(db.data))
+	for _, webhook := range db.data {
+		all = append(all, webhook)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, nil
+}
+
 // Append appends a webhook and returns the given id
 func (db *WebhooksMap) Append(webhook WebhookInfo) (err error) {
 	db.Lock()
@@ -150,8 +200,8 @@ func (db *WebhooksMap) Append(webhook WebhookInfo) (err error) {
 
 // Delete removes a webhook
 func (db *WebhooksMap) Delete(id WebhookID) (webhook WebhookInfo, err error) {
-	db.RLock()
-	defer db.RUnlock()
+	db.Lock()
+	defer db.Unlock()
 	webhook, ok := db.data[id]
 	if ok {
 		delete(db.data, id)
@@ -160,3 +210,132 @@ func (db *WebhooksMap) Delete(id WebhookID) (webhook WebhookInfo, err error) {
 	}
 	return
 }
+
+// Test that deliveries to webhooks are retried until they succeed or the
+// attempts run out
+func TestDeliverWebhookMsgRetry(t *testing.T) {
+	for _, data := range []struct {
+		attempts int
+		expt     bool
+		requests int32
+		failures float64
+	}{
+		{3, true, 3, 0},
+		{4, true, 3, 0},
+		{2, false, 2, 1},
+		{0, false, 1, 1},
+	} {
+		// The receiver fails twice before accepting messages
+		var requests int32
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= 2 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			}
+		}))
+
+		var before dto.Metric
+		webhookDeliveryFailures.Write(&before)
+
+		retry := WebhookRetry{data.attempts, time.Millisecond}
+		err := deliverWebhookMsg(receiver.Client(), receiver.URL, "", DiscordMsg{"msg"}, retry, log.NewEntry(log.StandardLogger()))
+		if delivered := err == nil; delivered != data.expt {
+			t.Errorf("expected delivery with '%d' attempts to give '%t', got error '%v'", data.attempts, data.expt, err)
+		}
+		if n := atomic.LoadInt32(&requests); n != data.requests {
+			t.Errorf("expected delivery with '%d' attempts to send '%d' requests, got '%d'", data.attempts, data.requests, n)
+		}
+
+		var after dto.Metric
+		webhookDeliveryFailures.Write(&after)
+		if failures := after.GetCounter().GetValue() - before.GetCounter().GetValue(); failures != data.failures {
+			t.Errorf("expected delivery with '%d' attempts to count '%v' failures, got '%v'", data.attempts, data.failures, failures)
+		}
+		receiver.Close()
+	}
+}
+
+// Test that updates of a webhook triggered while a delivery to it is retried
+// are coalesced, such that every track is only delivered once
+func TestWebhookUpdatesSerialized(t *testing.T) {
+	// The receiver holds the first request until released and then fails it,
+	// while it records the bodies of the accepted messages
+	release := make(chan struct{})
+	var requests int32
+	var mu sync.Mutex
+	var delivered []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var msg DiscordMsg
+		json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		delivered = append(delivered, msg.Content)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	metas := NewTrackMetasMap()
+	ticker := NewTickerStore(&metas)
+	retry := WebhookRetry{3, time.Millisecond}
+
+	const id = WebhookID(1)
+	var stored sync.Mutex
+	webhook := WebhookInfo{ID: id, URLstr: receiver.URL, TriggerRate: 1}
+	update := func() {
+		stored.Lock()
+		current := webhook
+		stored.Unlock()
+		updateWebhook(&ticker, receiver.Client(), retry, current, func(updated WebhookInfo) error {
+			stored.Lock()
+			webhook = updated
+			stored.Unlock()
+			return nil
+		})
+	}
+
+	updates := newWebhookUpdates()
+	now := time.Now()
+	trackIDs := []TrackID{1001, 1002}
+	for i, trackID := range trackIDs {
+		if err := metas.Append(TrackMeta{ID: trackID, Timestamp: now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("unable to add metadata: %s", err)
+		}
+		updates.start(id, update)
+		updates.start(id, update)
+
+		// Wait for the first delivery to be held by the receiver
+		for i == 0 && atomic.LoadInt32(&requests) < 1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		updates.Lock()
+		running := updates.running[id]
+		updates.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected updates of webhook to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, trackID := range trackIDs {
+		n := 0
+		for _, content := range delivered {
+			n += strings.Count(content, fmt.Sprintf("%d", trackID))
+		}
+		if n != 1 {
+			t.Errorf("expected track '%d' to be delivered once, got '%d' times in %q", trackID, n, delivered)
+		}
+	}
+}
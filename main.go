@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/barskern/paragliding/igcserver"
 	"github.com/globalsign/mgo"
+	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -22,6 +29,11 @@ func main() {
 		}
 	}
 
+	// Log as json, eg. to be collected by a log aggregator, if configured
+	if os.Getenv("LOG_FORMAT") == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
 	// Get port from env
 	port, ok := os.LookupEnv("PORT")
 	if !ok {
@@ -33,12 +45,26 @@ func main() {
 		log.Fatal("unable to get required envvar 'MONGODB_URI'")
 	}
 
+	mongoInfo, err := mgo.ParseURL(mongoURL)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"uri":   mongoURL,
+			"error": err,
+		}).Fatal("unable to parse mongo db url")
+	}
+	// Get optional mongodb database name from env, which overrides the
+	// database given in the url
+	if mongoDatabase, ok := os.LookupEnv("MONGODB_DATABASE"); ok {
+		mongoInfo.Database = mongoDatabase
+	}
+
 	log.WithFields(log.Fields{
 		"port":     port,
+		"database": mongoInfo.Database,
 		"logLevel": log.GetLevel(),
 	}).Info("initializing server")
 
-	mongoSession, err := mgo.Dial(mongoURL)
+	mongoSession, err := mgo.DialWithInfo(mongoInfo)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"uri":   mongoURL,
@@ -50,30 +76,387 @@ func main() {
 	httpClient := http.Client{}
 
 	// Create a track metas abstraction which will connect to mongodb to store
-	// all igctracks
-	trackMetas := igcserver.NewTrackMetasDB(mongoSession.Copy())
+	// all igctracks, unless tracks are stored in redis, postgresql or memory,
+	// in which case the ticker reads the tracks through the storage
+	var trackMetas igcserver.TrackMetas
+	var ticker igcserver.Ticker
+	var pingTracks func() error
+	redisURL, useRedis := os.LookupEnv("REDIS_URL")
+	databaseURL, usePostgres := os.LookupEnv("DATABASE_URL")
+	capacityStr, useMemory := os.LookupEnv("MEMORY_TRACK_CAPACITY")
+	switch {
+	case useRedis:
+		redisMetas, err := igcserver.OpenTrackMetasRedis(redisURL)
+		if err != nil {
+			log.WithField("error", err).Fatal("unable to connect to redis")
+		}
+		storeTicker := igcserver.NewTickerStore(&redisMetas)
+		trackMetas, ticker, pingTracks = &redisMetas, &storeTicker, redisMetas.Ping
+	case usePostgres:
+		postgresMetas, err := igcserver.OpenTrackMetasPostgres(databaseURL)
+		if err != nil {
+			log.WithField("error", err).Fatal("unable to connect to postgresql")
+		}
+		storeTicker := igcserver.NewTickerStore(&postgresMetas)
+		trackMetas, ticker, pingTracks = &postgresMetas, &storeTicker, postgresMetas.Ping
+	case useMemory:
+		capacity, err := strconv.Atoi(capacityStr)
+		if err != nil || capacity < 0 {
+			log.WithFields(log.Fields{
+				"capacity": capacityStr,
+				"error":    err,
+			}).Fatal("unable to parse capacity of tracks in memory")
+		}
+		memoryMetas := igcserver.NewTrackMetasMapWithCapacity(capacity)
+		storeTicker := igcserver.NewTickerStore(&memoryMetas)
+		trackMetas, ticker, pingTracks = &memoryMetas, &storeTicker, func() error { return nil }
+	default:
+		mongoMetas := igcserver.NewTrackMetasDB(mongoSession.Copy())
+		// Make simple ticker for database
+		mongoTicker := igcserver.NewTickerDB(mongoSession.Copy(), 10)
+		trackMetas, ticker, pingTracks = &mongoMetas, &mongoTicker, mongoMetas.Ping
+	}
+
+	// Keep the most recently used tracks in memory in front of the storage if
+	// configured, while the ticker still reads the tracks through the storage
+	if cacheSizeStr, ok := os.LookupEnv("TRACK_CACHE_SIZE"); ok {
+		cacheSize, err := strconv.Atoi(cacheSizeStr)
+		if err != nil || cacheSize < 0 {
+			log.WithFields(log.Fields{
+				"size":  cacheSizeStr,
+				"error": err,
+			}).Fatal("unable to parse size of track cache")
+		}
+		trackCache := igcserver.NewTrackMetasCache(trackMetas, cacheSize)
+		trackMetas = &trackCache
+	}
+
+	// Retry delivering messages to webhooks as configured, where the messages
+	// are only attempted once by default
+	webhookRetry := igcserver.WebhookRetry{Attempts: 1, Delay: time.Second}
+	if attemptsStr, ok := os.LookupEnv("WEBHOOK_ATTEMPTS"); ok {
+		webhookRetry.Attempts, err = strconv.Atoi(attemptsStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"attempts": attemptsStr,
+				"error":    err,
+			}).Fatal("unable to parse amount of webhook attempts")
+		}
+	}
+	if delayStr, ok := os.LookupEnv("WEBHOOK_RETRY_DELAY"); ok {
+		webhookRetry.Delay, err = time.ParseDuration(delayStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"delay": delayStr,
+				"error": err,
+			}).Fatal("unable to parse webhook retry delay")
+		}
+	}
 
 	// Create a webhooks abstraction which will connect to a mongodb to store
 	// all webhooks
-	webhooks := igcserver.NewWebhooksDB(mongoSession.Copy(), &httpClient)
+	webhooks := igcserver.NewWebhooksDB(mongoSession.Copy(), ticker, &httpClient, webhookRetry)
 
-	// Make simple ticker for database
-	ticker := igcserver.NewTickerDB(mongoSession.Copy(), 10)
+	// Mount the api under the same prefix as the routes below. The server logs
+	// to the standard logger, which has the level and format configured above.
+	opts := []igcserver.Option{
+		igcserver.WithPrefix("/paragliding/api"),
+	}
+
+	// Link to tracks using the public url of the server if configured
+	if publicURL, ok := os.LookupEnv("PUBLIC_URL"); ok {
+		opts = append(opts, igcserver.WithPublicURL(publicURL))
+	}
+
+	// Keep the original igc files in memory if configured
+	if storeRaw, ok := os.LookupEnv("STORE_RAW_TRACKS"); ok {
+		opts = append(opts, igcserver.WithRawTrackStorage(storeRaw == "true"))
+	}
+
+	// Keep the parsed points of tracks in memory unless disabled
+	if retainPoints, ok := os.LookupEnv("RETAIN_TRACK_POINTS"); ok {
+		opts = append(opts, igcserver.WithPointRetention(retainPoints != "false"))
+	}
+
+	// Require api keys for requests which modify the server if configured
+	if keys, ok := os.LookupEnv("API_KEYS"); ok {
+		opts = append(opts, igcserver.WithAPIKeys(strings.Split(keys, ",")...))
+	}
+
+	// Accept basic auth for requests which modify the server if configured
+	if username, ok := os.LookupEnv("BASIC_AUTH_USERNAME"); ok {
+		opts = append(opts, igcserver.WithBasicAuth(username, os.Getenv("BASIC_AUTH_PASSWORD")))
+	}
+	opts = append(opts, igcserver.WithReadAuth(os.Getenv("API_KEYS_LOCK_READS") == "true"))
+
+	// Start in read-only mode, eg. during maintenance, if configured
+	if readOnly, ok := os.LookupEnv("READ_ONLY"); ok {
+		opts = append(opts, igcserver.WithReadOnly(readOnly == "true"))
+	}
+
+	// Mix a seed into the ids of tracks to make them unique to the deployment
+	if seed, ok := os.LookupEnv("TRACK_ID_SEED"); ok {
+		opts = append(opts, igcserver.WithTrackIDSeed(seed))
+	}
+
+	// Reject tracks with the same content as existing tracks if configured
+	if dedup, ok := os.LookupEnv("DEDUP_CONTENT"); ok {
+		opts = append(opts, igcserver.WithContentDedup(dedup == "true"))
+	}
+
+	// Publish registered tracks to a NATS subject if configured, where the
+	// server keeps running without publishing if NATS is unavailable
+	var natsConn *nats.Conn
+	if natsURL, ok := os.LookupEnv("NATS_URL"); ok {
+		subject, ok := os.LookupEnv("NATS_SUBJECT")
+		if !ok {
+			subject = "paragliding.tracks"
+		}
+		natsConn, err = nats.Connect(natsURL, nats.MaxReconnects(-1))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"url":   natsURL,
+				"error": err,
+			}).Error("unable to connect to nats, registered tracks will not be published")
+		} else {
+			publisher := igcserver.NewNATSPublisher(natsConn, subject)
+			opts = append(opts, igcserver.WithPublisher(&publisher))
+		}
+	}
+
+	// Overwrite tracks registered from the same url again if configured
+	if policy, ok := os.LookupEnv("DUPLICATE_POLICY"); ok {
+		switch p := igcserver.DuplicatePolicy(policy); p {
+		case igcserver.DuplicateReject, igcserver.DuplicateUpsert:
+			opts = append(opts, igcserver.WithDuplicatePolicy(p))
+		default:
+			log.WithField("policy", policy).Fatal("unknown duplicate policy, expected 'reject' or 'upsert'")
+		}
+	}
+
+	// Only reject tracks from the same url within a window if configured
+	if windowStr, ok := os.LookupEnv("DUPLICATE_WINDOW"); ok {
+		window, err := time.ParseDuration(windowStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"window": windowStr,
+				"error":  err,
+			}).Fatal("unable to parse duplicate window")
+		}
+		opts = append(opts, igcserver.WithDuplicateWindow(window))
+	}
+
+	// Retry fetching tracks in the background if configured
+	if retriesStr, ok := os.LookupEnv("FETCH_RETRIES"); ok {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"retries": retriesStr,
+				"error":   err,
+			}).Fatal("unable to parse amount of fetch retries")
+		}
+		backoff := time.Second
+		if backoffStr, ok := os.LookupEnv("FETCH_RETRY_BACKOFF"); ok {
+			backoff, err = time.ParseDuration(backoffStr)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"backoff": backoffStr,
+					"error":   err,
+				}).Fatal("unable to parse fetch retry backoff")
+			}
+		}
+		opts = append(opts, igcserver.WithFetchRetries(retries, backoff))
+	}
+
+	// Limit the duration of parsing tracks if configured
+	if timeoutStr, ok := os.LookupEnv("PARSE_TIMEOUT"); ok {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"timeout": timeoutStr,
+				"error":   err,
+			}).Fatal("unable to parse parse timeout")
+		}
+		opts = append(opts, igcserver.WithParseTimeout(timeout))
+	}
+
+	// Downsample tracks with more points than the cap if configured
+	if maxStr, ok := os.LookupEnv("MAX_TRACK_POINTS"); ok {
+		max, err := strconv.Atoi(maxStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"max":   maxStr,
+				"error": err,
+			}).Fatal("unable to parse max track points")
+		}
+		opts = append(opts, igcserver.WithMaxTrackPoints(max))
+	}
+
+	// Limit the amount of tracks fetched at the same time if configured
+	if limitStr, ok := os.LookupEnv("FETCH_CONCURRENCY"); ok {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"limit": limitStr,
+				"error": err,
+			}).Fatal("unable to parse fetch concurrency")
+		}
+		wait := 5 * time.Second
+		if waitStr, ok := os.LookupEnv("FETCH_CONCURRENCY_WAIT"); ok {
+			wait, err = time.ParseDuration(waitStr)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"wait":  waitStr,
+					"error": err,
+				}).Fatal("unable to parse fetch concurrency wait")
+			}
+		}
+		opts = append(opts, igcserver.WithFetchConcurrency(limit, wait))
+	}
+
+	// Only fetch tracks from the given comma-separated hosts if configured
+	if hosts, ok := os.LookupEnv("ALLOWED_TRACK_HOSTS"); ok {
+		opts = append(opts, igcserver.WithAllowedHosts(strings.Split(hosts, ",")...))
+	}
+
+	// Reject tracks hosted on private addresses if configured
+	if block, ok := os.LookupEnv("BLOCK_PRIVATE_HOSTS"); ok {
+		opts = append(opts, igcserver.WithPrivateHostBlocking(block == "true"))
+	}
+
+	// Allow creating expiring share links of tracks if configured
+	if shareSecret, ok := os.LookupEnv("SHARE_SECRET"); ok && shareSecret != "" {
+		opts = append(opts, igcserver.WithShareSecret(shareSecret))
+	}
+
+	// Override the timeouts of the http server if configured
+	readTimeout, writeTimeout, idleTimeout := 15*time.Second, 60*time.Second, 120*time.Second
+	for _, timeout := range []struct {
+		env   string
+		value *time.Duration
+	}{
+		{"SERVER_READ_TIMEOUT", &readTimeout},
+		{"SERVER_WRITE_TIMEOUT", &writeTimeout},
+		{"SERVER_IDLE_TIMEOUT", &idleTimeout},
+	} {
+		if timeoutStr, ok := os.LookupEnv(timeout.env); ok {
+			*timeout.value, err = time.ParseDuration(timeoutStr)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"timeout": timeoutStr,
+					"error":   err,
+				}).Fatalf("unable to parse %s", strings.ToLower(timeout.env))
+			}
+		}
+	}
+	opts = append(opts, igcserver.WithServerTimeouts(readTimeout, writeTimeout, idleTimeout))
+
+	// Fetch tracks through a HTTP proxy if configured, where the server then
+	// builds its own client for fetching tracks
+	fetchClient := &httpClient
+	if proxyURL, ok := os.LookupEnv("TRACK_PROXY_URL"); ok {
+		fetchClient = nil
+		opts = append(opts, igcserver.WithProxy(proxyURL))
+	}
+
+	// Allow browsers to call the api from the given comma-separated origins
+	var allowedOrigins []string
+	if origins, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		allowedOrigins = strings.Split(origins, ",")
+		opts = append(opts, igcserver.WithAllowedOrigins(allowedOrigins...))
+	}
+	if credentials, ok := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); ok {
+		if err := igcserver.ValidateCORS(allowedOrigins, credentials == "true"); err != nil {
+			log.WithField("error", err).Fatal("invalid cors configuration")
+		}
+		opts = append(opts, igcserver.WithCORSCredentials(credentials == "true"))
+	}
+	if maxAgeStr, ok := os.LookupEnv("CORS_MAX_AGE"); ok {
+		maxAge, err := time.ParseDuration(maxAgeStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"max_age": maxAgeStr,
+				"error":   err,
+			}).Fatal("unable to parse cors max age")
+		}
+		opts = append(opts, igcserver.WithCORSMaxAge(maxAge))
+	}
+
+	// Start a clock which posts summaries of new tracks to webhooks if
+	// configured, where `CLOCK_SINKS` is a comma-separated list of
+	// `<type>=<url>` and `CLOCK_WEBHOOK_URL` is a single discord webhook
+	var clockSinks []igcserver.ClockSink
+	if clockURL, ok := os.LookupEnv("CLOCK_WEBHOOK_URL"); ok {
+		clockSinks = append(clockSinks, igcserver.ClockSink{Type: igcserver.ClockSinkDiscord, URL: clockURL})
+	}
+	if sinksStr, ok := os.LookupEnv("CLOCK_SINKS"); ok {
+		for _, sinkStr := range strings.Split(sinksStr, ",") {
+			parts := strings.SplitN(sinkStr, "=", 2)
+			if len(parts) != 2 {
+				log.WithField("sink", sinkStr).Fatal("clock sink must be formatted as '<type>=<url>'")
+			}
+			sinkType := igcserver.ClockSinkType(strings.TrimSpace(parts[0]))
+			switch sinkType {
+			case igcserver.ClockSinkDiscord, igcserver.ClockSinkSlack, igcserver.ClockSinkGeneric:
+			default:
+				log.WithField("type", sinkType).Fatal("unknown clock sink type, expected 'discord', 'slack' or 'generic'")
+			}
+			clockSinks = append(clockSinks, igcserver.ClockSink{Type: sinkType, URL: strings.TrimSpace(parts[1])})
+		}
+	}
+	if len(clockSinks) > 0 {
+		interval := 10 * time.Minute
+		if intervalStr, ok := os.LookupEnv("CLOCK_INTERVAL"); ok {
+			interval, err = time.ParseDuration(intervalStr)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"interval": intervalStr,
+					"error":    err,
+				}).Fatal("unable to parse clock interval")
+			}
+		}
+		log.WithFields(log.Fields{
+			"interval": interval,
+			"sinks":    len(clockSinks),
+		}).Info("starting clock")
+		opts = append(opts, igcserver.WithClockSinks(interval, clockSinks...))
+	}
 
 	// Create a new server which encompasses all routing and server state
-	server := igcserver.NewServer(&httpClient, &trackMetas, &ticker, &webhooks)
+	server := igcserver.NewServer(fetchClient, trackMetas, ticker, &webhooks, opts...)
+
+	// Probes used to check the health of the service, which are kept outside
+	// of the api
+	http.HandleFunc("/health", igcserver.HealthHandler)
+	http.Handle("/ready", igcserver.ReadyHandler(pingTracks))
 
-	// Route all requests to `paragliding/api/` to the server and remove prefix
-	http.Handle("/paragliding/api/", http.StripPrefix("/paragliding/api", &server))
+	// Route all requests to `paragliding/api/` to the server
+	http.Handle("/paragliding/api/", &server)
 	http.Handle("/paragliding", http.RedirectHandler("/paragliding/api/", http.StatusMovedPermanently))
 
+	// Shut down gracefully when the process is interrupted or terminated
+	httpServer := server.NewHTTPServer(":"+port, http.DefaultServeMux)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.WithField("signal", sig).Info("shutting down server")
+		httpServer.Shutdown(context.Background())
+	}()
+
 	// This function will block the current thread
-	err = http.ListenAndServe(":"+port, nil)
+	err = httpServer.ListenAndServe()
 
+	server.Close()
 	mongoSession.Close()
+	if natsConn != nil {
+		natsConn.Close()
+	}
 
 	// We will only get to this statement if the server unexpectedly crashes
-	log.WithFields(log.Fields{
-		"error": err,
-	}).Fatal("server error occurred")
+	if err != http.ErrServerClosed {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("server error occurred")
+	}
 }